@@ -918,6 +918,96 @@ func (lc *Client) QueryDNS(ctx context.Context, name string, queryType string) (
 	return res.Bytes, res.Resolvers, nil
 }
 
+// DNSCache returns the contents of the internal DNS forwarder's cache of
+// upstream responses.
+func (lc *Client) DNSCache(ctx context.Context) (*apitype.DNSCacheStatus, error) {
+	if !buildfeatures.HasDNS {
+		return nil, feature.ErrUnavailable
+	}
+	body, err := lc.get200(ctx, "/localapi/v0/dns-cache")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.DNSCacheStatus](body)
+}
+
+// FlushDNSCache removes all entries from the internal DNS forwarder's cache
+// of upstream responses.
+func (lc *Client) FlushDNSCache(ctx context.Context) error {
+	if !buildfeatures.HasDNS {
+		return feature.ErrUnavailable
+	}
+	_, err := lc.send(ctx, "POST", "/localapi/v0/dns-cache", http.StatusNoContent, nil)
+	return err
+}
+
+// DNSQueryLog returns the recent queries answered by the internal DNS
+// resolver, if its query log is enabled.
+func (lc *Client) DNSQueryLog(ctx context.Context) (*apitype.DNSQueryLog, error) {
+	if !buildfeatures.HasDNS {
+		return nil, feature.ErrUnavailable
+	}
+	body, err := lc.get200(ctx, "/localapi/v0/dns-log")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.DNSQueryLog](body)
+}
+
+// SetDNSQueryLogEnabled sets whether the internal DNS resolver keeps a log
+// of recent queries. Disabling it clears the log.
+func (lc *Client) SetDNSQueryLogEnabled(ctx context.Context, enabled bool) error {
+	if !buildfeatures.HasDNS {
+		return feature.ErrUnavailable
+	}
+	_, err := lc.send(ctx, "POST", "/localapi/v0/dns-log?enabled="+strconv.FormatBool(enabled), http.StatusNoContent, nil)
+	return err
+}
+
+// StreamDNSQueryLog returns an iterator of the queries answered by the
+// internal DNS resolver, starting with those in its query log (if enabled)
+// and followed by new queries as they are answered.
+// Each pair is a valid entry and a nil error, or a zero entry and a non-nil
+// error. In case of error, the iterator ends after the pair reporting the
+// error. Iteration stops if ctx ends.
+func (lc *Client) StreamDNSQueryLog(ctx context.Context) iter.Seq2[apitype.DNSQueryLogEntry, error] {
+	return func(yield func(apitype.DNSQueryLogEntry, error) bool) {
+		if !buildfeatures.HasDNS {
+			yield(apitype.DNSQueryLogEntry{}, feature.ErrUnavailable)
+			return
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET",
+			"http://"+apitype.LocalAPIHost+"/localapi/v0/dns-log?follow=true", nil)
+		if err != nil {
+			yield(apitype.DNSQueryLogEntry{}, err)
+			return
+		}
+		res, err := lc.doLocalRequestNiceError(req)
+		if err != nil {
+			yield(apitype.DNSQueryLogEntry{}, err)
+			return
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			yield(apitype.DNSQueryLogEntry{}, errors.New(res.Status))
+			return
+		}
+		dec := json.NewDecoder(bufio.NewReader(res.Body))
+		for {
+			var e apitype.DNSQueryLogEntry
+			if err := dec.Decode(&e); err == io.EOF {
+				return
+			} else if err != nil {
+				yield(apitype.DNSQueryLogEntry{}, err)
+				return
+			}
+			if !yield(e, nil) {
+				return
+			}
+		}
+	}
+}
+
 // StartLoginInteractive starts an interactive login.
 func (lc *Client) StartLoginInteractive(ctx context.Context) error {
 	_, err := lc.send(ctx, "POST", "/localapi/v0/login-interactive", http.StatusNoContent, nil)
@@ -1017,6 +1107,14 @@ func (lc *Client) CurrentDERPMap(ctx context.Context) (*tailcfg.DERPMap, error)
 	return &derpMap, nil
 }
 
+// NetcheckHistory returns the netcheck reports recorded by the Tailscale
+// daemon, oldest first, as a JSON array of netcheck.Reports. It's returned
+// undecoded so callers that don't otherwise use the netcheck package don't
+// need to link it.
+func (lc *Client) NetcheckHistory(ctx context.Context) ([]byte, error) {
+	return lc.get200(ctx, "/localapi/v0/netcheck-history")
+}
+
 // PingOpts contains options for the ping request.
 //
 // The zero value is valid, which means to use defaults.
@@ -1226,6 +1324,17 @@ func (lc *Client) DebugPeerRelaySessions(ctx context.Context) (*status.ServerSta
 	return decodeJSON[*status.ServerStatus](body)
 }
 
+// DebugPeerPaths returns the measured quality of each direct path to the
+// peer with Tailscale IP ip.
+func (lc *Client) DebugPeerPaths(ctx context.Context, ip netip.Addr) ([]ipnstate.PeerPath, error) {
+	v := url.Values{"ip": {ip.String()}}
+	body, err := lc.send(ctx, "GET", "/localapi/v0/debug-peer-path?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[[]ipnstate.PeerPath](body)
+}
+
 // StreamDebugCapture streams a pcap-formatted packet capture.
 //
 // The provided context does not determine the lifetime of the
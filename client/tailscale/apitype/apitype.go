@@ -5,6 +5,8 @@
 package apitype
 
 import (
+	"time"
+
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/util/ctxkey"
@@ -95,6 +97,63 @@ type DNSQueryResponse struct {
 	Resolvers []*dnstype.Resolver
 }
 
+// DNSCacheStatus mimics resolver.CacheStatus without forcing us to import
+// the resolver package into the CLI.
+type DNSCacheStatus struct {
+	// Entries are the live entries of the cache, from most to least
+	// recently used.
+	Entries []DNSCacheEntry
+	// Hits and Misses are the number of forwarded DNS queries that were
+	// and weren't answered from the cache since tailscaled started.
+	Hits, Misses int64
+}
+
+// DNSCacheEntry is an upstream DNS response in the quad-100 resolver's cache.
+type DNSCacheEntry struct {
+	Name  string // FQDN, with trailing dot
+	Type  string // like "A" or "AAAA"
+	Class string // like "INET"
+	Route string // suffix of the route the response came from, or "" for fallback resolvers
+	RCode string // like "Success" or "NameError"
+	Size  int    // of the response in bytes
+	TTL   int    // seconds until the entry expires
+
+	// DNSSECOK and CheckingDisabled are the DO and CD bits of the query
+	// the response was for, and Validated is whether the resolver
+	// validated its DNSSEC signatures.
+	DNSSECOK, CheckingDisabled, Validated bool `json:",omitempty"`
+}
+
+// DNSQueryLog is the query log of the quad-100 resolver.
+type DNSQueryLog struct {
+	// Enabled is whether the log keeps recent queries. Even when it
+	// doesn't, queries can be streamed as they happen.
+	Enabled bool
+	// Entries are the recent queries, from oldest to newest.
+	Entries []DNSQueryLogEntry
+}
+
+// DNSQueryLogEntry mimics resolver.QueryLogEntry without forcing us to
+// import the resolver package into the CLI.
+type DNSQueryLogEntry struct {
+	Time       time.Time
+	Name       string // FQDN, with trailing dot
+	Type       string // like "A" or "AAAA"
+	Client     string // ip:port that sent the query
+	ClientName string `json:",omitempty"` // MagicDNS name of Client, if known
+
+	// Forwarded is whether the query was forwarded upstream rather than
+	// answered locally. If so, Upstream is the resolver that answered
+	// it, if any, and Cached is whether the answer came from the cache.
+	Forwarded bool
+	Upstream  string `json:",omitempty"`
+	Cached    bool   `json:",omitempty"`
+
+	RCode   string        `json:",omitempty"` // like "Success" or "NameError"
+	Error   string        `json:",omitempty"` // error resolving the query, if any
+	Latency time.Duration // to answer the query
+}
+
 // OptionalFeatures describes which optional features are enabled in the build.
 type OptionalFeatures struct {
 	// Features is the map of optional feature names to whether they are
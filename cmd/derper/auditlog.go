@@ -0,0 +1,162 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"tailscale.com/derp/derpserver"
+)
+
+var (
+	auditLogEvents  = expvar.NewInt("counter_derper_audit_log_events")
+	auditLogDropped = expvar.NewInt("counter_derper_audit_log_dropped")
+)
+
+// auditLogQueueSize is how many events may be queued for writing before
+// newer ones are dropped.
+const auditLogQueueSize = 4096
+
+// auditLog writes the server's audit events to --audit-log as JSON lines.
+//
+// Events are queued and written by a separate goroutine, as the server
+// calls log with its locks held. If the writer falls behind, events are
+// dropped rather than slowing the server down.
+type auditLog struct {
+	w     io.Writer
+	queue chan []byte
+}
+
+// newAuditLog returns an audit log writing to the file at path, or stdout if
+// path is "-". The file is rotated when it exceeds maxSize bytes, keeping
+// maxFiles old files. If maxSize is zero, it's never rotated.
+func newAuditLog(path string, maxSize int64, maxFiles int) (*auditLog, error) {
+	al := &auditLog{
+		w:     os.Stdout,
+		queue: make(chan []byte, auditLogQueueSize),
+	}
+	if path != "-" {
+		f, err := openRotatingFile(path, maxSize, maxFiles)
+		if err != nil {
+			return nil, err
+		}
+		al.w = f
+	}
+	go al.run()
+	return al, nil
+}
+
+// log queues ev to be written. It's the derpserver.Server audit log func.
+func (al *auditLog) log(ev *derpserver.AuditEvent) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("audit log: %v", err)
+		return
+	}
+	line = append(line, '\n')
+	select {
+	case al.queue <- line:
+		auditLogEvents.Add(1)
+	default:
+		auditLogDropped.Add(1)
+	}
+}
+
+func (al *auditLog) run() {
+	var lastErr string
+	for line := range al.queue {
+		if _, err := al.w.Write(line); err != nil {
+			// Don't flood the log with the same error, such as a
+			// full disk, for every event.
+			if msg := err.Error(); msg != lastErr {
+				log.Printf("audit log: %v", err)
+				lastErr = msg
+			}
+			continue
+		}
+		lastErr = ""
+	}
+}
+
+// rotatingFile is an append-only file that's rotated when it grows past
+// maxSize: path is renamed to path.1, path.1 to path.2, and so on, up to
+// path.<maxFiles>, and a new file is started at path.
+type rotatingFile struct {
+	path     string
+	maxSize  int64 // or 0 to never rotate
+	maxFiles int
+
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = fi.Size()
+	return nil
+}
+
+// Write writes p, which is a whole line, rotating the file first if p would
+// take it over its maximum size.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, fmt.Errorf("rotating: %w", err)
+		}
+	}
+	if rf.f == nil {
+		// A previous rotation failed to reopen the file.
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		log.Printf("audit log: closing %s: %v", rf.path, err)
+	}
+	rf.f = nil
+	if rf.maxFiles <= 0 {
+		if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		for i := rf.maxFiles - 1; i > 0; i-- {
+			err := os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+			return err
+		}
+	}
+	return rf.open()
+}
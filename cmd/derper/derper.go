@@ -44,6 +44,7 @@
 	"tailscale.com/metrics"
 	"tailscale.com/net/ktimeout"
 	"tailscale.com/net/stunserver"
+	"tailscale.com/tailcfg"
 	"tailscale.com/tsweb"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
@@ -59,21 +60,26 @@
 	addr        = flag.String("a", ":443", "server HTTP/HTTPS listen address, in form \":port\", \"ip:port\", or for IPv6 \"[ip]:port\". If the IP is omitted, it defaults to all interfaces. Serves HTTPS if the port is 443 and/or -certmode is manual, otherwise HTTP.")
 	httpPort    = flag.Int("http-port", 80, "The port on which to serve HTTP. Set to -1 to disable. The listener is bound to the same IP (if any) as specified in the -a flag.")
 	stunPort    = flag.Int("stun-port", 3478, "The UDP port on which to serve STUN. The listener is bound to the same IP (if any) as specified in the -a flag.")
+	stunAltPort = flag.Int("stun-alt-port", 0, "If non-zero, a second UDP port on which to serve STUN, so clients can classify their NAT's behavior. Requests asking to change port (RFC 5780) are answered from the other port. Advertise it as the node's STUNAltPort.")
 	configPath  = flag.String("c", "", "config file path")
 	certMode    = flag.String("certmode", "letsencrypt", "mode for getting a cert. possible options: manual, letsencrypt")
 	certDir     = flag.String("certdir", tsweb.DefaultCertDir("derper-certs"), "directory to store LetsEncrypt certs, if addr's port is :443")
 	hostname    = flag.String("hostname", "derp.tailscale.com", "LetsEncrypt host name, if addr's port is :443. When --certmode=manual, this can be an IP address to avoid SNI checks")
 	runSTUN     = flag.Bool("stun", true, "whether to run a STUN server. It will bind to the same IP (if any) as the --addr flag value.")
 	runDERP     = flag.Bool("derp", true, "whether to run a DERP server. The only reason to set this false is if you're decommissioning a server but want to keep its bootstrap DNS functionality still running.")
+	quicPort    = flag.Int("quic-port", 0, "experimental: if non-zero, the UDP port on which to serve DERP over QUIC, for clients whose DERP map node has a matching QUICPort. It binds to the same IP (if any) as the --addr flag value, and requires serving TLS.")
 	flagHome    = flag.String("home", "", "what to serve at the root path. It may be left empty (the default, for a default homepage), \"blank\" for a blank page, or a URL to redirect to")
 
-	meshPSKFile     = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It must be 64 lowercase hexadecimal characters; whitespace is trimmed.")
-	meshWith        = flag.String("mesh-with", "", "optional comma-separated list of hostnames to mesh with; the server's own hostname can be in the list. If an entry contains a slash, the second part names a hostname to be used when dialing the target.")
-	secretsURL      = flag.String("secrets-url", "", "SETEC server URL for secrets retrieval of mesh key")
-	secretPrefix    = flag.String("secrets-path-prefix", "prod/derp", "setec path prefix for \""+setecMeshKeyName+"\" secret for DERP mesh key")
-	secretsCacheDir = flag.String("secrets-cache-dir", defaultSetecCacheDir(), "directory to cache setec secrets in (required if --secrets-url is set)")
-	bootstrapDNS    = flag.String("bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns")
-	unpublishedDNS  = flag.String("unpublished-bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns and not publish in the list. If an entry contains a slash, the second part names a DNS record to poll for its TXT record with a `0` to `100` value for rollout percentage.")
+	meshPSKFile         = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It must be 64 lowercase hexadecimal characters; whitespace is trimmed.")
+	meshWith            = flag.String("mesh-with", "", "optional comma-separated list of hostnames to mesh with; the server's own hostname can be in the list. If an entry contains a slash, the second part names a hostname to be used when dialing the target.")
+	meshWithFile        = flag.String("mesh-with-file", "", "optional path of a file listing more hostnames to mesh with, in the form of --mesh-with separated by commas or newlines; '#' starts a comment. It's re-read every --mesh-refresh-interval, adding and removing mesh peers to match.")
+	meshWithDNS         = flag.String("mesh-with-dns", "", "optional DNS name whose SRV record targets, and TXT records in the form of --mesh-with, list more hostnames to mesh with. It's re-resolved every --mesh-refresh-interval, adding and removing mesh peers to match.")
+	meshRefreshInterval = flag.Duration("mesh-refresh-interval", 30*time.Second, "how often to refresh the mesh peers from --mesh-with-file and --mesh-with-dns")
+	secretsURL          = flag.String("secrets-url", "", "SETEC server URL for secrets retrieval of mesh key")
+	secretPrefix        = flag.String("secrets-path-prefix", "prod/derp", "setec path prefix for \""+setecMeshKeyName+"\" secret for DERP mesh key")
+	secretsCacheDir     = flag.String("secrets-cache-dir", defaultSetecCacheDir(), "directory to cache setec secrets in (required if --secrets-url is set)")
+	bootstrapDNS        = flag.String("bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns")
+	unpublishedDNS      = flag.String("unpublished-bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns and not publish in the list. If an entry contains a slash, the second part names a DNS record to poll for its TXT record with a `0` to `100` value for rollout percentage.")
 
 	verifyClients   = flag.Bool("verify-clients", false, "verify clients to this DERP server through a local tailscaled instance.")
 	verifyClientURL = flag.String("verify-client-url", "", "if non-empty, an admission controller URL for permitting client connections; see tailcfg.DERPAdmitClientRequest")
@@ -84,6 +90,15 @@
 	acceptConnLimit = flag.Float64("accept-connection-limit", math.Inf(+1), "rate limit for accepting new connection")
 	acceptConnBurst = flag.Int("accept-connection-burst", math.MaxInt, "burst limit for accepting new connection")
 
+	clientRateLimit   = flag.Float64("client-rate-limit", 0, "if non-zero, the sustained rate in bytes per second at which each client may send packets; packets over the limit are dropped. Mesh peers aren't limited, and --verify-client-url may override it per client")
+	clientRateBurst   = flag.Int("client-rate-burst", 0, "burst size in bytes for --client-rate-limit; if zero, one second's worth")
+	clientPacketLimit = flag.Float64("client-packet-limit", 0, "if non-zero, the sustained rate in packets per second at which each client may send packets; packets over the limit are dropped")
+	clientPacketBurst = flag.Int("client-packet-burst", 0, "burst size in packets for --client-packet-limit; if zero, one second's worth")
+
+	auditLogPath     = flag.String("audit-log", "", "if non-empty, path of a file to write a JSON-lines audit log of client connects, disconnects, admission denials and mesh forwarding changes to, or \"-\" for stdout")
+	auditLogMaxSize  = flag.Int("audit-log-max-size", 100, "size in MB at which to rotate the --audit-log file; 0 disables rotation")
+	auditLogMaxFiles = flag.Int("audit-log-max-files", 5, "number of rotated --audit-log files to keep")
+
 	// tcpKeepAlive is intentionally long, to reduce battery cost. There is an L7 keepalive on a higher frequency schedule.
 	tcpKeepAlive = flag.Duration("tcp-keepalive-time", 10*time.Minute, "TCP keepalive time")
 	// tcpUserTimeout is intentionally short, so that hung connections are cleaned up promptly. DERPs should be nearby users.
@@ -181,12 +196,20 @@ func main() {
 
 	if *runSTUN {
 		ss := stunserver.New(ctx)
+		if *stunAltPort != 0 {
+			if err := ss.ListenAlt(net.JoinHostPort(listenHost, fmt.Sprint(*stunAltPort))); err != nil {
+				log.Fatalf("STUN alternate port: %v", err)
+			}
+		}
 		go ss.ListenAndServe(net.JoinHostPort(listenHost, fmt.Sprint(*stunPort)))
 	}
 
 	cfg := loadConfig()
 
 	serveTLS := tsweb.IsProd443(*addr) || *certMode == "manual"
+	if *quicPort > 0 && !serveTLS {
+		log.Fatalf("--quic-port requires serving TLS")
+	}
 
 	s := derpserver.New(cfg.PrivateKey, log.Printf)
 	s.SetVerifyClient(*verifyClients)
@@ -194,6 +217,19 @@ func main() {
 	s.SetVerifyClientURL(*verifyClientURL)
 	s.SetVerifyClientURLFailOpen(*verifyFailOpen)
 	s.SetTCPWriteTimeout(*tcpWriteTimeout)
+	s.SetClientRateLimit(tailcfg.DERPClientRateLimit{
+		BytesPerSecond:   *clientRateLimit,
+		BytesBurst:       *clientRateBurst,
+		PacketsPerSecond: *clientPacketLimit,
+		PacketsBurst:     *clientPacketBurst,
+	})
+	if *auditLogPath != "" {
+		al, err := newAuditLog(*auditLogPath, int64(*auditLogMaxSize)<<20, *auditLogMaxFiles)
+		if err != nil {
+			log.Fatalf("--audit-log: %v", err)
+		}
+		s.SetAuditLog(al.log)
+	}
 
 	var meshKey string
 	if *dev {
@@ -243,7 +279,8 @@ func main() {
 		log.Println("DERP mesh key configured")
 	}
 
-	if err := startMesh(s); err != nil {
+	mesh, err := startMesh(s)
+	if err != nil {
 		log.Fatalf("startMesh: %v", err)
 	}
 	expvar.Publish("derp", s.ExpVar())
@@ -292,6 +329,7 @@ func main() {
 		}
 	}))
 	debug.Handle("traffic", "Traffic check", http.HandlerFunc(s.ServeDebugTraffic))
+	debug.Handle("mesh", "Mesh peers", mesh)
 	debug.Handle("set-mutex-profile-fraction", "SetMutexProfileFraction", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		s := r.FormValue("rate")
 		if s == "" || r.Header.Get("Sec-Debug") != "derp" {
@@ -359,6 +397,22 @@ func main() {
 		}
 		// Disable TLS 1.0 and 1.1, which are obsolete and have security issues.
 		httpsrv.TLSConfig.MinVersion = tls.VersionTLS12
+		if *runDERP && *quicPort > 0 {
+			ep, err := listenQUIC(net.JoinHostPort(listenHost, strconv.Itoa(*quicPort)), httpsrv.TLSConfig)
+			if err != nil {
+				log.Fatalf("derper: QUIC: %v", err)
+			}
+			log.Printf("derper: serving DERP over QUIC on %v", ep.LocalAddr())
+			go func() {
+				<-ctx.Done()
+				ep.Close(ctx)
+			}()
+			go func() {
+				if err := serveQUIC(ctx, s, ep); err != nil && ctx.Err() == nil {
+					log.Fatalf("derper: QUIC: %v", err)
+				}
+			}()
+		}
 		httpsrv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.TLS != nil {
 				label := "unknown"
@@ -4,49 +4,322 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"html"
 	"log"
+	"maps"
 	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"tailscale.com/derp"
 	"tailscale.com/derp/derphttp"
 	"tailscale.com/derp/derpserver"
 	"tailscale.com/net/netmon"
+	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
 )
 
-func startMesh(s *derpserver.Server) error {
-	if *meshWith == "" {
-		return nil
+// Sources of mesh peers, as shown on the debug page.
+const (
+	meshSourceFlag = "flag"
+	meshSourceFile = "file"
+	meshSourceDNS  = "dns"
+)
+
+// startMesh starts meshing with the peers from --mesh-with, and with those
+// from --mesh-with-file and --mesh-with-dns, which are refreshed in the
+// background. The returned meshManager serves the debug page.
+func startMesh(s *derpserver.Server) (*meshManager, error) {
+	m := newMeshManager(s, *hostname)
+	if *meshWith == "" && *meshWithFile == "" && *meshWithDNS == "" {
+		return m, nil
 	}
 	if !s.HasMeshKey() {
-		return errors.New("--mesh-with requires --mesh-psk-file")
+		return nil, errors.New("--mesh-with, --mesh-with-file and --mesh-with-dns require --mesh-psk-file")
 	}
-	for _, hostTuple := range strings.Split(*meshWith, ",") {
-		if err := startMeshWithHost(s, hostTuple); err != nil {
-			return err
+	if *meshWith != "" {
+		hosts, err := parseMeshHosts(*meshWith)
+		if err != nil {
+			return nil, fmt.Errorf("--mesh-with: %w", err)
 		}
+		m.setHosts(meshSourceFlag, hosts)
 	}
-	return nil
+
+	// The initial file and DNS lookups must succeed; later failures keep
+	// the previous set of peers.
+	var refresh []func(context.Context) error
+	if *meshWithFile != "" {
+		f := func(context.Context) error {
+			hosts, err := readMeshHostsFile(*meshWithFile)
+			if err != nil {
+				return err
+			}
+			m.setHosts(meshSourceFile, hosts)
+			return nil
+		}
+		if err := f(context.Background()); err != nil {
+			return nil, fmt.Errorf("--mesh-with-file: %w", err)
+		}
+		refresh = append(refresh, f)
+	}
+	if *meshWithDNS != "" {
+		f := func(ctx context.Context) error {
+			hosts, err := lookupMeshHosts(ctx, net.DefaultResolver, *meshWithDNS)
+			if err != nil {
+				return err
+			}
+			m.setHosts(meshSourceDNS, hosts)
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+		if err := f(ctx); err != nil {
+			return nil, fmt.Errorf("--mesh-with-dns: %w", err)
+		}
+		refresh = append(refresh, f)
+	}
+	if len(refresh) > 0 {
+		go func() {
+			for {
+				time.Sleep(*meshRefreshInterval)
+				for _, f := range refresh {
+					ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+					if err := f(ctx); err != nil {
+						log.Printf("mesh: refreshing peers: %v", err)
+					}
+					cancel()
+				}
+			}
+		}()
+	}
+	return m, nil
 }
 
-func startMeshWithHost(s *derpserver.Server, hostTuple string) error {
-	var host string
-	var dialHost string
+// meshHost is a mesh peer: a hostname, and optionally a different hostname
+// to dial it at.
+type meshHost struct {
+	host     string
+	dialHost string
+}
+
+func (h meshHost) String() string {
+	if h.dialHost == h.host {
+		return h.host
+	}
+	return h.host + "/" + h.dialHost
+}
+
+// parseMeshHost parses a host tuple of the form "host" or "host/dialHost".
+func parseMeshHost(hostTuple string) (meshHost, error) {
 	hostParts := strings.Split(hostTuple, "/")
 	if len(hostParts) > 2 {
-		return fmt.Errorf("too many components in host tuple %q", hostTuple)
+		return meshHost{}, fmt.Errorf("too many components in host tuple %q", hostTuple)
 	}
-	host = hostParts[0]
+	h := meshHost{host: hostParts[0], dialHost: hostParts[0]}
 	if len(hostParts) == 2 {
-		dialHost = hostParts[1]
-	} else {
-		dialHost = hostParts[0]
+		h.dialHost = hostParts[1]
+	}
+	if h.host == "" || h.dialHost == "" {
+		return meshHost{}, fmt.Errorf("empty hostname in host tuple %q", hostTuple)
+	}
+	return h, nil
+}
+
+// parseMeshHosts parses a list of host tuples separated by commas or
+// whitespace.
+func parseMeshHosts(list string) ([]meshHost, error) {
+	var hosts []meshHost
+	for _, hostTuple := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	}) {
+		h, err := parseMeshHost(hostTuple)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// readMeshHostsFile reads the host tuples in the --mesh-with-file file at
+// path, in which "#" starts a comment.
+func readMeshHostsFile(path string) ([]meshHost, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list strings.Builder
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		list.WriteString(line)
+		list.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return parseMeshHosts(list.String())
+}
+
+// meshResolver is the subset of *net.Resolver used by lookupMeshHosts.
+type meshResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// lookupMeshHosts returns the mesh peers listed in DNS at name: the targets
+// of its SRV records, and the host tuples in its TXT records. It fails only
+// if both lookups fail.
+func lookupMeshHosts(ctx context.Context, r meshResolver, name string) ([]meshHost, error) {
+	var hosts []meshHost
+	_, srvs, srvErr := r.LookupSRV(ctx, "", "", name)
+	for _, srv := range srvs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		if host == "" {
+			continue
+		}
+		if srv.Port != 0 && srv.Port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(int(srv.Port)))
+		}
+		hosts = append(hosts, meshHost{host: host, dialHost: host})
+	}
+	txts, txtErr := r.LookupTXT(ctx, name)
+	if srvErr != nil && txtErr != nil {
+		return nil, fmt.Errorf("looking up %q: %w", name, errors.Join(srvErr, txtErr))
+	}
+	for _, txt := range txts {
+		txtHosts, err := parseMeshHosts(txt)
+		if err != nil {
+			return nil, fmt.Errorf("TXT record of %q: %w", name, err)
+		}
+		hosts = append(hosts, txtHosts...)
+	}
+	return hosts, nil
+}
+
+// meshManager runs the mesh connections to the current set of peers from
+// all sources, starting and stopping them as the sources change.
+type meshManager struct {
+	s    *derpserver.Server
+	self string // this server's hostname, which is never a peer
+
+	// startPeer starts a mesh connection to p. It's a field for tests.
+	startPeer func(p *meshPeer) error
+
+	mu      sync.Mutex
+	sources map[string][]meshHost // by meshSource*
+	peers   map[string]*meshPeer  // by meshHost.host
+}
+
+func newMeshManager(s *derpserver.Server, self string) *meshManager {
+	m := &meshManager{s: s, self: self}
+	m.startPeer = m.runPeer
+	return m
+}
+
+// meshPeer is a running mesh connection.
+type meshPeer struct {
+	meshHost
+	added time.Time
+	stop  func()
+
+	lastErr atomic.Pointer[errorAndTime] // or nil if no error yet
+
+	mu      sync.Mutex
+	present set.Set[key.NodePublic] // clients present at the peer
+}
+
+// notePresent records that the client k is present at the peer. The peer
+// may announce a client more than once.
+func (p *meshPeer) notePresent(k key.NodePublic) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.present.Add(k)
+}
+
+// noteGone records that the client k is no longer present at the peer.
+func (p *meshPeer) noteGone(k key.NodePublic) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.present.Delete(k)
+}
+
+// numPresent returns the number of clients present at the peer.
+func (p *meshPeer) numPresent() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.present)
+}
+
+type errorAndTime struct {
+	err error
+	at  time.Time
+}
+
+// meshSources are the sources of mesh peers, in order of precedence.
+var meshSources = []string{meshSourceFlag, meshSourceFile, meshSourceDNS}
+
+// setHosts sets the mesh peers from source to hosts, and starts and stops
+// mesh connections to match the peers from all sources.
+//
+// There is at most one connection per hostname, and none to m.self. If a
+// hostname is listed more than once, a tuple naming a different dial host
+// wins over a bare hostname, and otherwise the first tuple in the order of
+// meshSources wins.
+func (m *meshManager) setHosts(source string, hosts []meshHost) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mak.Set(&m.sources, source, hosts)
+
+	want := map[string]meshHost{}
+	for _, source := range meshSources {
+		for _, h := range m.sources[source] {
+			if h.host == m.self {
+				continue
+			}
+			if cur, ok := want[h.host]; !ok || (cur.dialHost == cur.host && h.dialHost != h.host) {
+				want[h.host] = h
+			}
+		}
 	}
+	for host, p := range m.peers {
+		if want[host] != p.meshHost {
+			log.Printf("mesh: removing peer %v", p.meshHost)
+			p.stop()
+			delete(m.peers, host)
+		}
+	}
+	for host, h := range want {
+		if _, ok := m.peers[host]; ok {
+			continue
+		}
+		p := &meshPeer{meshHost: h, added: time.Now(), present: set.Set[key.NodePublic]{}}
+		if err := m.startPeer(p); err != nil {
+			log.Printf("mesh: adding peer %v: %v", h, err)
+			continue
+		}
+		log.Printf("mesh: added peer %v", h)
+		mak.Set(&m.peers, host, p)
+	}
+}
 
+// runPeer starts the mesh connection to p, setting p.stop.
+func (m *meshManager) runPeer(p *meshPeer) error {
+	s := m.s
+	host, dialHost := p.host, p.dialHost
 	logf := logger.WithPrefix(log.Printf, fmt.Sprintf("mesh(%q): ", host))
 	netMon := netmon.NewStatic() // good enough for cmd/derper; no need for netns fanciness
 	c, err := derphttp.NewClient(s.PrivateKey(), "https://"+host+"/derp", logf, netMon)
@@ -71,9 +344,63 @@ func startMeshWithHost(s *derpserver.Server, hostTuple string) error {
 		})
 	}
 
-	add := func(m derp.PeerPresentMessage) { s.AddPacketForwarder(m.Key, c) }
-	remove := func(m derp.PeerGoneMessage) { s.RemovePacketForwarder(m.Peer, c) }
-	notifyError := func(err error) {}
-	go c.RunWatchConnectionLoop(context.Background(), s.PublicKey(), logf, add, remove, notifyError)
+	add := func(m derp.PeerPresentMessage) {
+		s.AddPacketForwarder(m.Key, c)
+		p.notePresent(m.Key)
+	}
+	remove := func(m derp.PeerGoneMessage) {
+		s.RemovePacketForwarder(m.Peer, c)
+		p.noteGone(m.Peer)
+	}
+	notifyError := func(err error) {
+		p.lastErr.Store(&errorAndTime{err, time.Now()})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	// On stop, the watch loop removes the forwarders it added when its
+	// connection breaks, before returning.
+	p.stop = func() {
+		cancel()
+		c.Close()
+	}
+	go c.RunWatchConnectionLoop(ctx, s.PublicKey(), logf, add, remove, notifyError)
 	return nil
 }
+
+// ServeHTTP serves the mesh topology debug page.
+func (m *meshManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<html><body><h1>Mesh peers</h1>\n")
+	if len(m.peers) == 0 {
+		fmt.Fprintf(w, "<p>No mesh peers.</p>\n")
+		return
+	}
+	fmt.Fprintf(w, "<table border=1 cellpadding=5><tr><th>Host</th><th>Dial host</th><th>Sources</th><th>Added</th><th>Clients</th><th>Last error</th></tr>\n")
+	now := time.Now()
+	for _, host := range slices.Sorted(maps.Keys(m.peers)) {
+		p := m.peers[host]
+		h := p.meshHost
+		var sources []string
+		for source, hosts := range m.sources {
+			if slices.ContainsFunc(hosts, func(h meshHost) bool { return h.host == host }) {
+				sources = append(sources, source)
+			}
+		}
+		slices.Sort(sources)
+		lastErr := "-"
+		if e := p.lastErr.Load(); e != nil {
+			lastErr = fmt.Sprintf("%v (%v ago)", e.err, now.Sub(e.at).Round(time.Second))
+		}
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%v ago</td><td>%d</td><td>%s</td></tr>\n",
+			html.EscapeString(h.host),
+			html.EscapeString(h.dialHost),
+			strings.Join(sources, ", "),
+			now.Sub(p.added).Round(time.Second),
+			p.numPresent(),
+			html.EscapeString(lastErr),
+		)
+	}
+	fmt.Fprintf(w, "</table>\n")
+}
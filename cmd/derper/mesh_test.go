@@ -0,0 +1,191 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"tailscale.com/types/key"
+	"tailscale.com/util/set"
+)
+
+func TestParseMeshHosts(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []meshHost
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "a.example", want: []meshHost{{"a.example", "a.example"}}},
+		{
+			in: "a.example,b.example/10.0.0.2\n c.example ",
+			want: []meshHost{
+				{"a.example", "a.example"},
+				{"b.example", "10.0.0.2"},
+				{"c.example", "c.example"},
+			},
+		},
+		{in: "a/b/c", wantErr: true},
+		{in: "a.example/", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMeshHosts(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseMeshHosts(%q) error = %v; wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMeshHosts(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReadMeshHostsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mesh")
+	const contents = `# region 1
+a.example
+b.example/10.0.0.2 # internal address
+`
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readMeshHostsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []meshHost{{"a.example", "a.example"}, {"b.example", "10.0.0.2"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+type fakeMeshResolver struct {
+	srv    []*net.SRV
+	txt    []string
+	srvErr error
+	txtErr error
+}
+
+func (r *fakeMeshResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return "", r.srv, r.srvErr
+}
+
+func (r *fakeMeshResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return r.txt, r.txtErr
+}
+
+func TestLookupMeshHosts(t *testing.T) {
+	errNotFound := errors.New("no such host")
+	ctx := context.Background()
+
+	r := &fakeMeshResolver{
+		srv: []*net.SRV{
+			{Target: "a.example.", Port: 443},
+			{Target: "b.example.", Port: 8443},
+		},
+		txt: []string{"c.example/10.0.0.3,d.example"},
+	}
+	got, err := lookupMeshHosts(ctx, r, "mesh.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []meshHost{
+		{"a.example", "a.example"},
+		{"b.example:8443", "b.example:8443"},
+		{"c.example", "10.0.0.3"},
+		{"d.example", "d.example"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	r = &fakeMeshResolver{srvErr: errNotFound, txt: []string{"a.example"}}
+	if got, err := lookupMeshHosts(ctx, r, "mesh.example"); err != nil || len(got) != 1 {
+		t.Errorf("TXT only: got %v, %v; want 1 host", got, err)
+	}
+
+	r = &fakeMeshResolver{srvErr: errNotFound, txtErr: errNotFound}
+	if _, err := lookupMeshHosts(ctx, r, "mesh.example"); err == nil {
+		t.Error("both lookups failed: got nil error")
+	}
+}
+
+func TestMeshManagerSetHosts(t *testing.T) {
+	m := newMeshManager(nil, "self")
+	running := map[meshHost]bool{}
+	m.startPeer = func(p *meshPeer) error {
+		running[p.meshHost] = true
+		p.stop = func() { delete(running, p.meshHost) }
+		return nil
+	}
+	check := func(want ...string) {
+		t.Helper()
+		var got []string
+		for h := range running {
+			got = append(got, h.String())
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("running peers = %q; want %q", got, want)
+		}
+	}
+
+	a, b, c := meshHost{"a", "a"}, meshHost{"b", "b"}, meshHost{"c", "c"}
+	m.setHosts(meshSourceFlag, []meshHost{a})
+	m.setHosts(meshSourceFile, []meshHost{a, b})
+	check("a", "b")
+	m.setHosts(meshSourceDNS, []meshHost{c})
+	check("a", "b", "c")
+
+	// a stays while the flag still lists it.
+	m.setHosts(meshSourceFile, nil)
+	check("a", "c")
+	m.setHosts(meshSourceDNS, []meshHost{b})
+	check("a", "b")
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/mesh", nil))
+	body := rec.Body.String()
+	for _, want := range []string{"<td>a</td>", "<td>b</td>", "<td>dns</td>", "<td>flag</td>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("debug page missing %q:\n%s", want, body)
+		}
+	}
+
+	// Each hostname gets one connection, preferring an explicit dial host,
+	// and this server's own hostname is skipped.
+	m.setHosts(meshSourceFile, []meshHost{{"a", "10.0.0.1"}, {"self", "self"}})
+	check("a/10.0.0.1", "b")
+	m.setHosts(meshSourceFile, nil)
+	check("a", "b")
+}
+
+func TestMeshPeerPresent(t *testing.T) {
+	p := &meshPeer{present: set.Set[key.NodePublic]{}}
+	k1, k2 := key.NewNode().Public(), key.NewNode().Public()
+
+	// Peers may announce a client more than once, and report clients gone
+	// that they never announced.
+	p.notePresent(k1)
+	p.notePresent(k1)
+	p.notePresent(k2)
+	if got := p.numPresent(); got != 2 {
+		t.Errorf("numPresent = %d; want 2", got)
+	}
+	p.noteGone(k1)
+	p.noteGone(k1)
+	p.noteGone(key.NewNode().Public())
+	if got := p.numPresent(); got != 1 {
+		t.Errorf("numPresent = %d; want 1", got)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"expvar"
+	"log"
+
+	"golang.org/x/net/quic"
+	"tailscale.com/derp/derpquic"
+	"tailscale.com/derp/derpserver"
+)
+
+var counterQUICAccepts = expvar.NewInt("derp_quic_accepts")
+
+// listenQUIC listens for DERP-over-QUIC connections on the UDP address addr.
+func listenQUIC(addr string, tlsConf *tls.Config) (*quic.Endpoint, error) {
+	return quic.Listen("udp", addr, derpquic.Config(tlsConf))
+}
+
+// serveQUIC serves DERP-over-QUIC connections accepted by ep until ctx is
+// done or ep is closed.
+func serveQUIC(ctx context.Context, s *derpserver.Server, ep *quic.Endpoint) error {
+	for {
+		qc, err := ep.Accept(ctx)
+		if err != nil {
+			return err
+		}
+		go handleQUIC(ctx, s, qc)
+	}
+}
+
+func handleQUIC(ctx context.Context, s *derpserver.Server, qc *quic.Conn) {
+	defer qc.Abort(nil)
+
+	// The server opens the DERP stream, so it can send its key first as it
+	// does over TCP. The client sees the stream once the key is flushed.
+	st, err := qc.NewStream(ctx)
+	if err != nil {
+		log.Printf("derp: QUIC stream from %v: %v", qc.RemoteAddr(), err)
+		return
+	}
+	counterQUICAccepts.Add(1)
+	nc := derpquic.NewConn(qc, st)
+	brw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+	s.Accept(ctx, nc, brw, qc.RemoteAddr().String())
+}
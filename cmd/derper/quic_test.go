@@ -0,0 +1,138 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/derp/derpquic"
+	"tailscale.com/derp/derpserver"
+	"tailscale.com/net/netmon"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+// newQUICTestRegion starts a DERP server serving DERP over QUIC if
+// withQUIC, and over TCP if withTCP, and returns its region. Its node's
+// QUICPort is set regardless, to a port that doesn't answer if the server
+// doesn't serve QUIC.
+func newQUICTestRegion(t *testing.T, withQUIC, withTCP bool) *tailcfg.DERPRegion {
+	cp, err := NewManualCertManager(t.TempDir(), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("NewManualCertManager: %v", err)
+	}
+	cert, err := cp.TLSConfig().GetCertificate(&tls.ClientHelloInfo{ServerName: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	ds := derpserver.New(key.NewNode(), t.Logf)
+	t.Cleanup(func() { ds.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	tlsConf := cp.TLSConfig()
+	ds.ModifyTLSConfigToAddMetaCert(tlsConf)
+
+	ep, err := listenQUIC("127.0.0.1:0", tlsConf)
+	if err != nil {
+		t.Fatalf("listenQUIC: %v", err)
+	}
+	t.Cleanup(func() { ep.Close(context.Background()) })
+	if withQUIC {
+		go serveQUIC(ctx, ds, ep)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	if withTCP {
+		mux := http.NewServeMux()
+		mux.Handle("/derp", derpserver.Handler(ds))
+		hs := &http.Server{Handler: mux, TLSConfig: tlsConf}
+		go hs.ServeTLS(ln, "", "")
+	}
+
+	return &tailcfg.DERPRegion{
+		RegionID: 900,
+		Nodes: []*tailcfg.DERPNode{{
+			Name:     "900a",
+			RegionID: 900,
+			HostName: "127.0.0.1",
+			IPv4:     "127.0.0.1",
+			CertName: fmt.Sprintf("sha256-raw:%-02x", sha256.Sum256(cert.Leaf.Raw)),
+			DERPPort: ln.Addr().(*net.TCPAddr).Port,
+			QUICPort: int(ep.LocalAddr().Port()),
+		}},
+	}
+}
+
+func newQUICTestClient(t *testing.T, reg *tailcfg.DERPRegion) (*derphttp.Client, key.NodePublic) {
+	k := key.NewNode()
+	c := derphttp.NewRegionClient(k, t.Logf, netmon.NewStatic(), func() *tailcfg.DERPRegion {
+		return reg
+	})
+	t.Cleanup(func() { c.Close() })
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	// The server registers the client before sending its ServerInfo.
+	m, err := c.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	if _, ok := m.(derp.ServerInfoMessage); !ok {
+		t.Fatalf("first message = %T; want ServerInfoMessage", m)
+	}
+	return c, k.Public()
+}
+
+func TestQUIC(t *testing.T) {
+	reg := newQUICTestRegion(t, true, false)
+	c1, _ := newQUICTestClient(t, reg)
+	c2, k2 := newQUICTestClient(t, reg)
+
+	cs, ok := c1.TLSConnectionState()
+	if !ok || cs.NegotiatedProtocol != derpquic.ALPN {
+		t.Fatalf("TLS state = %v, %v; want ALPN %q", cs, ok, derpquic.ALPN)
+	}
+
+	msg := []byte("hello over QUIC")
+	if err := c1.Send(k2, msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	for {
+		m, err := c2.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if p, ok := m.(derp.ReceivedPacket); ok {
+			if string(p.Data) != string(msg) {
+				t.Errorf("got %q; want %q", p.Data, msg)
+			}
+			break
+		}
+	}
+}
+
+func TestQUICFallbackToTCP(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits out the QUIC connect timeout")
+	}
+	reg := newQUICTestRegion(t, false, true)
+	c, _ := newQUICTestClient(t, reg)
+	cs, ok := c.TLSConnectionState()
+	if !ok || cs.NegotiatedProtocol == derpquic.ALPN {
+		t.Fatalf("TLS state = %v, %v; want TCP connection", cs, ok)
+	}
+}
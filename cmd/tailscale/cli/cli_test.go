@@ -974,6 +974,14 @@ func TestPrefFlagMapping(t *testing.T) {
 		case "AutoExitNode":
 			// Handled by tailscale {set,up} --exit-node=auto:any.
 			continue
+		case "DNSOverrides", "DNSBlocklists":
+			// Handled by the tailscale dns subcommand, we don't want a CLI
+			// flag for this.
+			continue
+		case "ValidateDNSSEC", "DNSSECExemptDomains":
+			// Set with the config file or LocalAPI, and shown by tailscale
+			// dns status; no CLI flag for this.
+			continue
 		}
 		t.Errorf("unexpected new ipn.Pref field %q is not handled by up.go (see addPrefFlagMapping and checkForAccidentalSettingReverts)", prefName)
 	}
@@ -26,6 +26,7 @@
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
@@ -332,6 +333,17 @@ func debugCmd() *ffcli.Command {
 				Exec:       runPeerEndpointChanges,
 				ShortHelp:  "Print debug information about a peer's endpoint changes",
 			},
+			{
+				Name:       "peer-path",
+				ShortUsage: "tailscale debug peer-path [--json] <hostname-or-IP>",
+				Exec:       runDebugPeerPath,
+				ShortHelp:  "Print the measured quality of each direct path to a peer",
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("peer-path")
+					fs.BoolVar(&debugPeerPathArgs.json, "json", false, "output in JSON format")
+					return fs
+				})(),
+			},
 			{
 				Name:       "dial-types",
 				ShortUsage: "tailscale debug dial-types <hostname-or-IP> <port>",
@@ -1276,6 +1288,57 @@ func runPeerEndpointChanges(ctx context.Context, args []string) error {
 	return nil
 }
 
+var debugPeerPathArgs struct {
+	json bool
+}
+
+func runDebugPeerPath(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: tailscale debug peer-path [--json] <hostname-or-IP>")
+	}
+	hostOrIP := args[0]
+	ipStr, self, err := tailscaleIPFromArg(ctx, hostOrIP)
+	if err != nil {
+		return err
+	}
+	if self {
+		return fmt.Errorf("%v is local Tailscale IP", ipStr)
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	pp, err := localClient.DebugPeerPaths(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if debugPeerPathArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(pp)
+	}
+	if len(pp) == 0 {
+		outln("no direct paths pinged recently")
+		return nil
+	}
+	tw := tabwriter.NewWriter(Stdout, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tPINGS\tLOSS\tRTT P50\tP90\tP99\tJITTER\t")
+	for _, p := range pp {
+		addr := p.Addr
+		if p.Active {
+			addr += " (active)"
+		}
+		loss := fmt.Sprintf("%.0f%%", p.LossRate*100)
+		if p.Demoted {
+			loss += " (demoted)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%v\t%v\t%v\t%v\t\n", addr, p.Pings, loss,
+			p.RTTP50.Round(time.Millisecond/10), p.RTTP90.Round(time.Millisecond/10),
+			p.RTTP99.Round(time.Millisecond/10), p.Jitter.Round(time.Millisecond/10))
+	}
+	return tw.Flush()
+}
+
 func debugControlKnobs(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return errors.New("unexpected arguments")
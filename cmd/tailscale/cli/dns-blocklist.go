@@ -0,0 +1,120 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn"
+)
+
+var dnsBlocklistCmd = &ffcli.Command{
+	Name:       "blocklist",
+	ShortUsage: "tailscale dns blocklist [add <path> | remove <path>]",
+	Exec:       runDNSBlocklistList,
+	ShortHelp:  "Manage DNS blocklist files",
+	LongHelp: strings.TrimSpace(`
+The 'tailscale dns blocklist' subcommand manages the files listing domains that
+the internal DNS forwarder (100.100.100.100) blocks on this node. Without
+arguments, it lists them.
+
+Files may be in hosts file format ("0.0.0.0 ads.example.com") or adblock list
+format ("||ads.example.com^", which also blocks subdomains). Queries for
+blocked names are answered with 0.0.0.0 or :: instead of being forwarded.
+MagicDNS names and DNS overrides are never blocked.
+
+The files are read by tailscaled, so they must be in the dns-blocklists
+directory of its state directory (for example,
+/var/lib/tailscale/dns-blocklists). They are re-read when they change.
+Blocklists only take effect when Tailscale manages this node's DNS
+settings (--accept-dns).
+`),
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "add",
+			ShortUsage: "tailscale dns blocklist add <path>",
+			ShortHelp:  "Add a DNS blocklist file",
+			Exec:       runDNSBlocklistAdd,
+		},
+		{
+			Name:       "remove",
+			ShortUsage: "tailscale dns blocklist remove <path>",
+			ShortHelp:  "Remove a DNS blocklist file",
+			Exec:       runDNSBlocklistRemove,
+		},
+	},
+}
+
+func runDNSBlocklistList(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return flag.ErrHelp
+	}
+	prefs, err := localClient.GetPrefs(ctx)
+	if err != nil {
+		return err
+	}
+	if len(prefs.DNSBlocklists) == 0 {
+		printf("No DNS blocklists.\n")
+		return nil
+	}
+	for _, path := range prefs.DNSBlocklists {
+		printf("%s\n", path)
+	}
+	return nil
+}
+
+func runDNSBlocklistAdd(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	prefs, err := localClient.GetPrefs(ctx)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(prefs.DNSBlocklists, path) {
+		return nil
+	}
+	_, err = localClient.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			DNSBlocklists: append(prefs.DNSBlocklists, path),
+		},
+		DNSBlocklistsSet: true,
+	})
+	return err
+}
+
+func runDNSBlocklistRemove(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	prefs, err := localClient.GetPrefs(ctx)
+	if err != nil {
+		return err
+	}
+	i := slices.Index(prefs.DNSBlocklists, path)
+	if i < 0 {
+		return fmt.Errorf("%s is not a DNS blocklist", path)
+	}
+	_, err = localClient.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			DNSBlocklists: slices.Delete(slices.Clone(prefs.DNSBlocklists), i, i+1),
+		},
+		DNSBlocklistsSet: true,
+	})
+	return err
+}
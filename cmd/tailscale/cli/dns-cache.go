@@ -0,0 +1,90 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var dnsCacheCmd = &ffcli.Command{
+	Name:       "cache",
+	ShortUsage: "tailscale dns cache [--flush]",
+	Exec:       runDNSCache,
+	ShortHelp:  "Print or flush the internal DNS forwarder's response cache",
+	LongHelp: strings.TrimSpace(`
+The 'tailscale dns cache' subcommand prints the responses from upstream
+resolvers that the internal DNS forwarder (100.100.100.100) has cached, along
+with how many forwarded queries were answered from the cache.
+
+Responses are cached for as long as their TTL allows, up to an hour. Negative
+responses (NXDOMAIN or no records of the requested type) are cached for up to
+five minutes. The cache is flushed whenever the DNS configuration changes.
+
+The --flush flag removes all entries from the cache.
+`),
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("cache")
+		fs.BoolVar(&dnsCacheArgs.flush, "flush", false, "remove all entries from the cache")
+		return fs
+	})(),
+}
+
+// dnsCacheArgs are the arguments for the "dns cache" subcommand.
+var dnsCacheArgs struct {
+	flush bool
+}
+
+func runDNSCache(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return flag.ErrHelp
+	}
+	if dnsCacheArgs.flush {
+		if err := localClient.FlushDNSCache(ctx); err != nil {
+			return fmt.Errorf("failed to flush DNS cache: %w", err)
+		}
+		printf("DNS cache flushed.\n")
+		return nil
+	}
+
+	st, err := localClient.DNSCache(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get DNS cache: %w", err)
+	}
+	printf("Cache hits: %d, misses: %d\n\n", st.Hits, st.Misses)
+	if len(st.Entries) == 0 {
+		printf("  (no cached responses)\n")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Name\tType\tClass\tRoute\tFlags\tRCode\tSize\tTTL")
+	fmt.Fprintln(w, "----\t----\t-----\t-----\t-----\t-----\t----\t---")
+	for _, e := range st.Entries {
+		route := e.Route
+		if route == "" {
+			route = "(fallback)"
+		}
+		var flags []string
+		if e.DNSSECOK {
+			flags = append(flags, "do")
+		}
+		if e.CheckingDisabled {
+			flags = append(flags, "cd")
+		}
+		if e.Validated {
+			flags = append(flags, "validated")
+		}
+		if len(flags) == 0 {
+			flags = append(flags, "-")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\n", e.Name, e.Type, e.Class, route, strings.Join(flags, ","), e.RCode, e.Size, e.TTL)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,126 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+var dnsLogCmd = &ffcli.Command{
+	Name:       "log",
+	ShortUsage: "tailscale dns log [--follow] [--enable | --disable]",
+	Exec:       runDNSLog,
+	ShortHelp:  "Print the queries answered by the internal DNS forwarder",
+	LongHelp: strings.TrimSpace(`
+The 'tailscale dns log' subcommand prints the DNS queries recently answered by
+the internal DNS forwarder (100.100.100.100): the name and type queried, the
+client that sent the query, whether it was answered locally or forwarded (and
+to which upstream resolver), the response code, and how long it took.
+
+The query log is disabled by default. Use --enable to keep the most recent
+queries, and --disable to clear the log and stop keeping queries.
+
+The --follow flag prints queries as they are answered, whether or not the log
+is enabled, until interrupted.
+`),
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("log")
+		fs.BoolVar(&dnsLogArgs.follow, "follow", false, "print queries as they are answered, until interrupted")
+		fs.BoolVar(&dnsLogArgs.enable, "enable", false, "keep a log of the most recent queries")
+		fs.BoolVar(&dnsLogArgs.disable, "disable", false, "clear the log and stop keeping queries")
+		return fs
+	})(),
+}
+
+// dnsLogArgs are the arguments for the "dns log" subcommand.
+var dnsLogArgs struct {
+	follow  bool
+	enable  bool
+	disable bool
+}
+
+func runDNSLog(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return flag.ErrHelp
+	}
+	if dnsLogArgs.enable && dnsLogArgs.disable {
+		return errors.New("cannot use --enable and --disable together")
+	}
+	if dnsLogArgs.enable || dnsLogArgs.disable {
+		if err := localClient.SetDNSQueryLogEnabled(ctx, dnsLogArgs.enable); err != nil {
+			return fmt.Errorf("failed to set DNS query log: %w", err)
+		}
+		if dnsLogArgs.disable {
+			printf("DNS query log disabled.\n")
+			return nil
+		}
+		printf("DNS query log enabled.\n")
+		if !dnsLogArgs.follow {
+			return nil
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Time\tName\tType\tClient\tUpstream\tRCode\tLatency")
+	fmt.Fprintln(w, "----\t----\t----\t------\t--------\t-----\t-------")
+
+	if dnsLogArgs.follow {
+		for e, err := range localClient.StreamDNSQueryLog(ctx) {
+			if err != nil {
+				return err
+			}
+			printDNSLogEntry(w, e)
+			// Flush after each entry, as entries may be minutes apart.
+			w.Flush()
+		}
+		return nil
+	}
+
+	log, err := localClient.DNSQueryLog(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get DNS query log: %w", err)
+	}
+	if !log.Enabled {
+		printf("The DNS query log is disabled. Use --enable to enable it, or --follow to\nprint queries as they are answered.\n")
+		return nil
+	}
+	for _, e := range log.Entries {
+		printDNSLogEntry(w, e)
+	}
+	return w.Flush()
+}
+
+func printDNSLogEntry(w *tabwriter.Writer, e apitype.DNSQueryLogEntry) {
+	client := e.Client
+	if e.ClientName != "" {
+		client = e.ClientName
+	}
+	var upstream string
+	switch {
+	case !e.Forwarded:
+		upstream = "(local)"
+	case e.Cached:
+		upstream = "(cache)"
+	case e.Upstream == "":
+		upstream = "(none)"
+	default:
+		upstream = e.Upstream
+	}
+	rcode := e.RCode
+	if e.Error != "" {
+		rcode = "error: " + e.Error
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%v\n",
+		e.Time.Local().Format(time.TimeOnly), e.Name, e.Type, client, upstream, rcode, e.Latency.Round(time.Millisecond))
+}
@@ -0,0 +1,138 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+var dnsOverrideCmd = &ffcli.Command{
+	Name:       "override",
+	ShortUsage: "tailscale dns override [add <name> [a|aaaa|cname|txt] <value> | remove <name> [<type>]]",
+	Exec:       runDNSOverrideList,
+	ShortHelp:  "Manage node-local DNS records",
+	LongHelp: strings.TrimSpace(`
+The 'tailscale dns override' subcommand manages DNS records that the internal
+DNS forwarder (100.100.100.100) answers on this node, before MagicDNS names and
+before forwarding queries upstream. Without arguments, it lists them.
+
+Supported record types are A, AAAA, CNAME and TXT. If the type is omitted, an A
+or AAAA record is added depending on the value. A CNAME record's target may be
+another override, a MagicDNS name or a name resolved upstream.
+
+Overrides only take effect when Tailscale manages this node's DNS settings
+(--accept-dns).
+`),
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "add",
+			ShortUsage: "tailscale dns override add <name> [a|aaaa|cname|txt] <value>",
+			ShortHelp:  "Add a node-local DNS record",
+			Exec:       runDNSOverrideAdd,
+		},
+		{
+			Name:       "remove",
+			ShortUsage: "tailscale dns override remove <name> [<type>]",
+			ShortHelp:  "Remove node-local DNS records for a name",
+			Exec:       runDNSOverrideRemove,
+		},
+	},
+}
+
+func runDNSOverrideList(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return flag.ErrHelp
+	}
+	prefs, err := localClient.GetPrefs(ctx)
+	if err != nil {
+		return err
+	}
+	if len(prefs.DNSOverrides) == 0 {
+		printf("No DNS overrides.\n")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Name\tType\tValue")
+	fmt.Fprintln(w, "----\t----\t-----")
+	for _, rec := range prefs.DNSOverrides {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", rec.Name, dnsOverrideType(rec), rec.Value)
+	}
+	return w.Flush()
+}
+
+// dnsOverrideType returns the record type of rec for display.
+func dnsOverrideType(rec tailcfg.DNSRecord) string {
+	if rec.Type != "" {
+		return rec.Type
+	}
+	if strings.Contains(rec.Value, ":") {
+		return "AAAA"
+	}
+	return "A"
+}
+
+func runDNSOverrideAdd(ctx context.Context, args []string) error {
+	var rec tailcfg.DNSRecord
+	switch len(args) {
+	case 2:
+		rec = tailcfg.DNSRecord{Name: args[0], Value: args[1]}
+	case 3:
+		rec = tailcfg.DNSRecord{Name: args[0], Type: strings.ToUpper(args[1]), Value: args[2]}
+	default:
+		return flag.ErrHelp
+	}
+	prefs, err := localClient.GetPrefs(ctx)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(prefs.DNSOverrides, rec) {
+		return nil
+	}
+	_, err = localClient.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			DNSOverrides: append(prefs.DNSOverrides, rec),
+		},
+		DNSOverridesSet: true,
+	})
+	return err
+}
+
+func runDNSOverrideRemove(ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return flag.ErrHelp
+	}
+	name := strings.TrimSuffix(args[0], ".")
+	var typ string
+	if len(args) == 2 {
+		typ = args[1]
+	}
+	prefs, err := localClient.GetPrefs(ctx)
+	if err != nil {
+		return err
+	}
+	recs := slices.DeleteFunc(slices.Clone(prefs.DNSOverrides), func(rec tailcfg.DNSRecord) bool {
+		return strings.EqualFold(strings.TrimSuffix(rec.Name, "."), name) &&
+			(typ == "" || strings.EqualFold(dnsOverrideType(rec), typ))
+	})
+	if len(recs) == len(prefs.DNSOverrides) {
+		return fmt.Errorf("no DNS override for %q", args[0])
+	}
+	_, err = localClient.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			DNSOverrides: recs,
+		},
+		DNSOverridesSet: true,
+	})
+	return err
+}
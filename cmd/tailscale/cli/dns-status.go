@@ -25,7 +25,8 @@
 The 'tailscale dns status' subcommand prints the current DNS status and
 configuration, including:
 
-- Whether the built-in DNS forwarder is enabled.
+- Whether the built-in DNS forwarder is enabled, and whether it validates
+  the DNSSEC signatures of the responses it forwards.
 
 - The MagicDNS configuration provided by the coordination server.
 
@@ -101,6 +102,15 @@ func runDNSStatus(ctx context.Context, args []string) error {
 	fmt.Print("\n")
 	fmt.Printf("Tailscale DNS: %s\n", enabledStr)
 	fmt.Print("\n")
+	if prefs.ValidateDNSSEC {
+		fmt.Println("DNSSEC validation: enabled.")
+		if len(prefs.DNSSECExemptDomains) > 0 {
+			fmt.Printf("Exempt from validation: %s\n", strings.Join(prefs.DNSSECExemptDomains, ", "))
+		}
+	} else {
+		fmt.Println("DNSSEC validation: disabled.")
+	}
+	fmt.Print("\n")
 	fmt.Println("=== MagicDNS configuration ===")
 	fmt.Print("\n")
 	fmt.Println("This is the DNS configuration provided by the coordination server to this device.")
@@ -22,14 +22,18 @@
 	ShortUsage: strings.Join([]string{
 		dnsStatusCmd.ShortUsage,
 		dnsQueryCmd.ShortUsage,
+		dnsCacheCmd.ShortUsage,
+		dnsLogCmd.ShortUsage,
+		dnsOverrideCmd.ShortUsage,
+		dnsBlocklistCmd.ShortUsage,
 	}, "\n"),
 	UsageFunc: usageFuncNoDefaultValues,
 	Subcommands: []*ffcli.Command{
 		dnsStatusCmd,
 		dnsQueryCmd,
-
-		// TODO: implement `tailscale log` here
-
-		// The above work is tracked in https://github.com/tailscale/tailscale/issues/13326
+		dnsCacheCmd,
+		dnsLogCmd,
+		dnsOverrideCmd,
+		dnsBlocklistCmd,
 	},
 }
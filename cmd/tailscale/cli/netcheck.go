@@ -6,13 +6,17 @@
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net/http"
+	"slices"
 	"sort"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
@@ -46,17 +50,24 @@
 		fs.StringVar(&netcheckArgs.format, "format", "", `output format; empty (for human-readable), "json" or "json-line"`)
 		fs.DurationVar(&netcheckArgs.every, "every", 0, "if non-zero, do an incremental report with the given frequency")
 		fs.BoolVar(&netcheckArgs.verbose, "verbose", false, "verbose logs")
+		fs.DurationVar(&netcheckArgs.mappingLifetime, "mapping-lifetime", 0, "if non-zero, also measure how long the NAT keeps idle UDP mappings, testing idle periods up to the given duration (at least 15s); this takes as long")
+		fs.BoolVar(&netcheckArgs.history, "history", false, "instead of running a netcheck, print how the reports recorded by tailscaled changed over time")
 		return fs
 	})(),
 }
 
 var netcheckArgs struct {
-	format  string
-	every   time.Duration
-	verbose bool
+	format          string
+	every           time.Duration
+	verbose         bool
+	history         bool
+	mappingLifetime time.Duration
 }
 
 func runNetcheck(ctx context.Context, args []string) error {
+	if netcheckArgs.history {
+		return runNetcheckHistory(ctx)
+	}
 	logf := logger.WithPrefix(log.Printf, "portmap: ")
 	bus := eventbus.New()
 	defer bus.Close()
@@ -109,6 +120,7 @@ func runNetcheck(ctx context.Context, args []string) error {
 			return err
 		}
 	}
+	var mappingLifetime time.Duration
 	for {
 		t0 := time.Now()
 		report, err := c.GetReport(ctx, dm, nil)
@@ -119,6 +131,19 @@ func runNetcheck(ctx context.Context, args []string) error {
 		if err != nil {
 			return fmt.Errorf("netcheck: %w", err)
 		}
+		if netcheckArgs.mappingLifetime != 0 && mappingLifetime == 0 {
+			fmt.Fprintf(Stderr, "Measuring NAT mapping lifetime for up to %v...\n", netcheckArgs.mappingLifetime)
+			mappingLifetime, err = c.MeasureMappingLifetime(ctx, dm, netcheckArgs.mappingLifetime)
+			if err != nil {
+				return fmt.Errorf("netcheck: %w", err)
+			}
+			if mappingLifetime == 0 {
+				mappingLifetime = -1 // measured; don't measure again
+			}
+		}
+		if mappingLifetime > 0 {
+			report.MappingLifetime = mappingLifetime
+		}
 		if err := printReport(dm, report); err != nil {
 			return err
 		}
@@ -168,6 +193,13 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 		printf("\t* IPv6: no, unavailable in OS\n")
 	}
 	printf("\t* MappingVariesByDestIP: %v\n", report.MappingVariesByDestIP)
+	printf("\t* NAT mapping: %v\n", natBehavior(report.NATMapping))
+	printf("\t* NAT filtering: %v\n", natBehavior(report.NATFiltering))
+	if report.MappingLifetime > 0 {
+		printf("\t* NAT mapping lifetime: at least %v\n", report.MappingLifetime)
+	} else if netcheckArgs.mappingLifetime != 0 {
+		printf("\t* NAT mapping lifetime: under 15s\n")
+	}
 	printf("\t* PortMapping: %v\n", portMapping(report))
 	if report.CaptivePortal != "" {
 		printf("\t* CaptivePortal: %v\n", report.CaptivePortal)
@@ -217,6 +249,126 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 	return nil
 }
 
+func runNetcheckHistory(ctx context.Context) error {
+	if netcheckArgs.every != 0 {
+		return errors.New("--every and --history are mutually exclusive")
+	}
+	b, err := localClient.NetcheckHistory(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	var reports []*netcheck.Report
+	if err := json.Unmarshal(b, &reports); err != nil {
+		return fmt.Errorf("invalid netcheck history JSON: %w", err)
+	}
+
+	switch netcheckArgs.format {
+	case "":
+	case "json":
+		j, err := json.MarshalIndent(reports, "", "\t")
+		if err != nil {
+			return err
+		}
+		Stdout.Write(append(j, '\n'))
+		return nil
+	case "json-line":
+		for _, r := range reports {
+			j, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			Stdout.Write(append(j, '\n'))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q", netcheckArgs.format)
+	}
+
+	if len(reports) == 0 {
+		printf("No netcheck history recorded.\n")
+		return nil
+	}
+	dm, _ := localClient.CurrentDERPMap(ctx) // only for region codes
+	regionCode := func(rid int) string {
+		if dm != nil {
+			if r, ok := dm.Regions[rid]; ok && r.RegionCode != "" {
+				return r.RegionCode
+			}
+		}
+		return fmt.Sprintf("derp%d", rid)
+	}
+
+	tw := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tUDP\tIPV4\tIPV6\tNAT\tGLOBAL IPV4\tDERP\tLATENCY\tCAPTIVE PORTAL")
+	for _, r := range reports {
+		global := "-"
+		if r.GlobalV4.IsValid() {
+			global = r.GlobalV4.String()
+		}
+		derp, latency := "-", "-"
+		if r.PreferredDERP != 0 {
+			derp = regionCode(r.PreferredDERP)
+			if d, ok := r.RegionLatency[r.PreferredDERP]; ok {
+				latency = d.Round(time.Millisecond / 10).String()
+			}
+		}
+		captive := "-"
+		if v, ok := r.CaptivePortal.Get(); ok {
+			captive = fmt.Sprint(v)
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%v\t%s\t%s\t%s\t%s\t%s\n",
+			r.Now.Local().Format(time.DateTime), r.UDP, r.IPv4, r.IPv6, natType(r),
+			global, derp, latency, captive)
+	}
+	tw.Flush()
+
+	// Summarize each region's latency over the whole history.
+	latencies := map[int][]time.Duration{}
+	for _, r := range reports {
+		for rid, d := range r.RegionLatency {
+			latencies[rid] = append(latencies[rid], d)
+		}
+	}
+	if len(latencies) == 0 {
+		return nil
+	}
+	rids := slices.Sorted(maps.Keys(latencies))
+	printf("\nDERP latency since %v:\n", reports[0].Now.Local().Format(time.DateTime))
+	tw = tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REGION\tREPORTS\tMIN\tMEDIAN\tMAX")
+	for _, rid := range rids {
+		ds := latencies[rid]
+		slices.Sort(ds)
+		round := func(d time.Duration) string { return d.Round(time.Millisecond / 10).String() }
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", regionCode(rid), len(ds),
+			round(ds[0]), round(ds[len(ds)/2]), round(ds[len(ds)-1]))
+	}
+	tw.Flush()
+	return nil
+}
+
+// natBehavior returns a description of the NAT mapping or filtering
+// behavior b.
+func natBehavior(b netcheck.NATBehavior) string {
+	if b == "" {
+		return "unknown"
+	}
+	return string(b)
+}
+
+// natType returns a short description of the type of IPv4 NAT r found.
+func natType(r *netcheck.Report) string {
+	v, ok := r.MappingVariesByDestIP.Get()
+	switch {
+	case !ok:
+		return "unknown"
+	case v:
+		return "hard"
+	default:
+		return "easy"
+	}
+}
+
 func portMapping(r *netcheck.Report) string {
 	if !buildfeatures.HasPortMapper {
 		return "binary built without portmapper support"
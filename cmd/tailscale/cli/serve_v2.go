@@ -13,8 +13,10 @@
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"math"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
@@ -70,6 +72,32 @@ func (s *serviceNameFlag) String() string {
 	return s.Value.String()
 }
 
+// headerFlag is a flag.Value for HTTP headers of the form "Name: value",
+// which may be repeated.
+type headerFlag struct {
+	Value *map[string]string
+}
+
+// Set adds the header s to the map.
+func (h *headerFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	k = strings.TrimSpace(k)
+	if !ok || k == "" || strings.ContainsAny(k, " \t") {
+		return fmt.Errorf("header %q is not of the form \"Name: value\"", s)
+	}
+	mak.Set(h.Value, http.CanonicalHeaderKey(k), strings.TrimSpace(v))
+	return nil
+}
+
+// String returns the headers, sorted by name.
+func (h *headerFlag) String() string {
+	var s []string
+	for _, k := range slices.Sorted(maps.Keys(*h.Value)) {
+		s = append(s, k+": "+(*h.Value)[k])
+	}
+	return strings.Join(s, ", ")
+}
+
 type bgBoolFlag struct {
 	Value bool
 	IsSet bool // tracks if the flag was set by the user
@@ -165,6 +193,7 @@ func (u *acceptAppCapsFlag) String() string {
 	serveTypeTCP
 	serveTypeTLSTerminatedTCP
 	serveTypeTUN
+	serveTypeUDP
 )
 
 func serveTypeFromConfString(sp conffile.ServiceProtocol) (st serveType, ok bool) {
@@ -236,9 +265,17 @@ func newServeV2Command(e *serveEnv, subcmd serveMode) *ffcli.Command {
 			if subcmd == serve {
 				fs.UintVar(&e.http, "http", 0, "Expose an HTTP server at the specified port")
 				fs.Var(&acceptAppCapsFlag{Value: &e.acceptAppCaps}, "accept-app-caps", "App capabilities to forward to the server (specify multiple capabilities with a comma-separated list)")
+				fs.UintVar(&e.udp, "udp", 0, "Expose a UDP forwarder to forward UDP packets at the specified port")
 			}
 			fs.UintVar(&e.tcp, "tcp", 0, "Expose a TCP forwarder to forward raw TCP packets at the specified port")
 			fs.UintVar(&e.tlsTerminatedTCP, "tls-terminated-tcp", 0, "Expose a TCP forwarder to forward TLS-terminated TCP packets at the specified port")
+			fs.UintVar(&e.proxyProtocol, "proxy-protocol", 0, "Send a PROXY protocol header of the specified version (1 or 2) to the target of a TCP forwarder")
+			fs.Var(&headerFlag{Value: &e.requestHeaders}, "request-header", "Header to set on requests to a proxy target, as \"Name: value\" (can be repeated; an empty value removes the header)")
+			fs.Var(&headerFlag{Value: &e.responseHeaders}, "response-header", "Header to set on responses from a proxy target, as \"Name: value\" (can be repeated; an empty value removes the header)")
+			fs.BoolVar(&e.keepPrefix, "keep-prefix", false, "Keep the --set-path mount point in request paths sent to a proxy target (default false)")
+			fs.DurationVar(&e.proxyTimeout, "proxy-timeout", 0, "Time to wait for response headers from a proxy target before failing the request")
+			fs.Float64Var(&e.rateLimit, "rate-limit", 0, "Maximum requests per second that each client may make to a proxy target")
+			fs.UintVar(&e.rateBurst, "rate-burst", 0, "Number of requests a client may make at once before --rate-limit applies")
 			fs.Var(&serviceNameFlag{Value: &e.service}, "service", "Serve for a service with distinct virtual IP instead on node itself.")
 			fs.BoolVar(&e.yes, "yes", false, "Update without interactive prompts (default false)")
 			fs.BoolVar(&e.tun, "tun", false, "Forward all traffic to the local machine (default false), only supported for services. Refer to docs for more information.")
@@ -412,6 +449,24 @@ func (e *serveEnv) runServeCombined(subcmd serveMode) execFunc {
 			fmt.Fprintf(e.stderr(), "error: %v\n\n", err)
 			return errHelpFunc(subcmd)
 		}
+		if e.hasProxyOptions() && srvType != serveTypeHTTP && srvType != serveTypeHTTPS {
+			fmt.Fprintf(e.stderr(), "error: proxy options are only supported with --http or --https\n\n")
+			return errHelpFunc(subcmd)
+		}
+		if e.proxyTimeout < 0 || e.rateLimit < 0 {
+			fmt.Fprintf(e.stderr(), "error: --proxy-timeout and --rate-limit must not be negative\n\n")
+			return errHelpFunc(subcmd)
+		}
+		if e.proxyProtocol != 0 {
+			if srvType != serveTypeTCP && srvType != serveTypeTLSTerminatedTCP {
+				fmt.Fprintf(e.stderr(), "error: --proxy-protocol is only supported with --tcp or --tls-terminated-tcp\n\n")
+				return errHelpFunc(subcmd)
+			}
+			if e.proxyProtocol != 1 && e.proxyProtocol != 2 {
+				fmt.Fprintf(e.stderr(), "error: --proxy-protocol must be 1 or 2\n\n")
+				return errHelpFunc(subcmd)
+			}
+		}
 
 		sc, err := e.lc.GetServeConfig(ctx)
 		if err != nil {
@@ -465,6 +520,9 @@ func (e *serveEnv) runServeCombined(subcmd serveMode) execFunc {
 		if !forService && srvType == serveTypeTUN {
 			return errors.New("tun mode is only supported for services")
 		}
+		if forService && srvType == serveTypeUDP {
+			return errors.New("UDP forwarding is not supported for services")
+		}
 		wantFg := !e.bg.Value && !turnOff
 		if wantFg {
 			// validate the config before creating a WatchIPNBus session
@@ -733,6 +791,7 @@ func (e *serveEnv) runServeGetConfig(ctx context.Context, args []string) (err er
 						Protocol:         conffile.ServiceProtocol(proto),
 						Destination:      host,
 						DestinationPorts: tailcfg.PortRange{First: uint16(port), Last: uint16(port)},
+						HTTP:             httpOptionsForConf(defaultHandler),
 					})
 				}
 			}
@@ -850,7 +909,9 @@ func (e *serveEnv) runServeSetConfig(ctx context.Context, args []string) (err er
 					portStr := fmt.Sprint(destPort)
 					target = fmt.Sprintf("%s://%s", ep.Protocol, net.JoinHostPort(ep.Destination, portStr))
 				}
+				e.setProxyOptions(ep.HTTP)
 				err := e.setServe(sc, name.String(), serveType, port, "/", target, false, magicDNSSuffix, nil)
+				e.setProxyOptions(nil)
 				if err != nil {
 					return fmt.Errorf("service %q: %w", name, err)
 				}
@@ -898,6 +959,18 @@ func (e *serveEnv) runServeSetConfig(ctx context.Context, args []string) (err er
 // validateConfig checks if the serve config is valid to serve the type wanted on the port.
 // dnsName is a FQDN or a serviceName (with `svc:` prefix).
 func (e *serveEnv) validateConfig(sc *ipn.ServeConfig, port uint16, wantServe serveType, svcName tailcfg.ServiceName) error {
+	if wantServe == serveTypeUDP {
+		// UDP ports don't conflict with TCP ports, only with each other.
+		for _, fg := range sc.Foreground {
+			if fg.UDP[port] != nil {
+				return errors.New("foreground already exists under this port")
+			}
+		}
+		if sc.UDP[port] != nil && !e.bg.Value {
+			return fmt.Errorf(backgroundExistsMsg, infoMap[e.subcmd].Name, wantServe.String(), port)
+		}
+		return nil
+	}
 	var tcpHandlerForPort *ipn.TCPPortHandler
 	if svcName != noService {
 		svc := sc.Services[svcName]
@@ -970,6 +1043,15 @@ func (e *serveEnv) setServe(sc *ipn.ServeConfig, dnsName string, srvType serveTy
 		if err != nil {
 			return fmt.Errorf("failed to apply TCP serve: %w", err)
 		}
+	case serveTypeUDP:
+		if e.setPath != "" {
+			return fmt.Errorf("cannot mount a path for UDP serve")
+		}
+		if err := e.applyUDPServe(sc, srvPort, target); err != nil {
+			return fmt.Errorf("failed to apply UDP serve: %w", err)
+		}
+		// Funnel only carries TCP, so leave it alone.
+		return nil
 	case serveTypeTUN:
 		// Caller checks that TUN mode is only supported for services.
 		svcName := tailcfg.ServiceName(dnsName)
@@ -1076,6 +1158,18 @@ func (e *serveEnv) messageForPort(sc *ipn.ServeConfig, st *ipnstate.Status, dnsN
 		tcpHandler = sc.TCP[srvPort]
 	}
 
+	if srvType == serveTypeUDP {
+		webConfig, tcpHandler = nil, nil
+		if h := sc.UDP[srvPort]; h != nil {
+			output.WriteString(fmt.Sprintf("|-- udp://%s:%d\n", host, srvPort))
+			for _, a := range ips {
+				ipp := net.JoinHostPort(a.String(), strconv.Itoa(int(srvPort)))
+				output.WriteString(fmt.Sprintf("|-- udp://%s\n", ipp))
+			}
+			output.WriteString(fmt.Sprintf("|--> udp://%s\n\n", h.UDPForward))
+		}
+	}
+
 	if webConfig != nil {
 		mounts := slicesx.MapKeys(webConfig.Handlers)
 		sort.Slice(mounts, func(i, j int) bool {
@@ -1092,6 +1186,9 @@ func (e *serveEnv) messageForPort(sc *ipn.ServeConfig, st *ipnstate.Status, dnsN
 		if tcpHandler.TerminateTLS != "" {
 			tlsStatus = "TLS terminated"
 		}
+		if tcpHandler.ProxyProtocol != 0 {
+			tlsStatus += fmt.Sprintf(", PROXY v%d", tcpHandler.ProxyProtocol)
+		}
 
 		output.WriteString(fmt.Sprintf("|-- tcp://%s:%d (%s)\n", host, srvPort, tlsStatus))
 		for _, a := range ips {
@@ -1157,6 +1254,15 @@ func (e *serveEnv) applyWebServe(sc *ipn.ServeConfig, dnsName string, srvPort ui
 		}
 		h.Proxy = t
 		h.AcceptAppCaps = caps
+		h.SetRequestHeaders = e.requestHeaders
+		h.SetResponseHeaders = e.responseHeaders
+		h.KeepPrefix = e.keepPrefix
+		h.Timeout.Duration = e.proxyTimeout
+		h.RateLimit = e.rateLimit
+		h.RateBurst = int(e.rateBurst)
+	}
+	if h.Proxy == "" && e.hasProxyOptions() {
+		return errors.New("proxy options are only supported for proxy targets")
 	}
 
 	// TODO: validation needs to check nested foreground configs
@@ -1170,6 +1276,44 @@ func (e *serveEnv) applyWebServe(sc *ipn.ServeConfig, dnsName string, srvPort ui
 	return nil
 }
 
+// hasProxyOptions reports whether any of the flags for HTTP proxy targets
+// are set.
+func (e *serveEnv) hasProxyOptions() bool {
+	return len(e.requestHeaders) > 0 || len(e.responseHeaders) > 0 || e.keepPrefix ||
+		e.proxyTimeout != 0 || e.rateLimit != 0 || e.rateBurst != 0
+}
+
+// setProxyOptions sets the flags for HTTP proxy targets from the options
+// of a config file endpoint, or clears them if o is nil.
+func (e *serveEnv) setProxyOptions(o *conffile.HTTPOptions) {
+	if o == nil {
+		o = new(conffile.HTTPOptions)
+	}
+	e.requestHeaders = o.SetRequestHeaders
+	e.responseHeaders = o.SetResponseHeaders
+	e.keepPrefix = o.KeepPrefix
+	e.proxyTimeout = o.Timeout.Duration
+	e.rateLimit = o.RateLimit
+	e.rateBurst = uint(max(o.RateBurst, 0))
+}
+
+// httpOptionsForConf returns the config file options for the proxy handler
+// h, or nil if it has none.
+func httpOptionsForConf(h *ipn.HTTPHandler) *conffile.HTTPOptions {
+	if len(h.SetRequestHeaders) == 0 && len(h.SetResponseHeaders) == 0 && !h.KeepPrefix &&
+		h.Timeout.Duration == 0 && h.RateLimit == 0 && h.RateBurst == 0 {
+		return nil
+	}
+	return &conffile.HTTPOptions{
+		SetRequestHeaders:  h.SetRequestHeaders,
+		SetResponseHeaders: h.SetResponseHeaders,
+		KeepPrefix:         h.KeepPrefix,
+		Timeout:            h.Timeout,
+		RateLimit:          h.RateLimit,
+		RateBurst:          h.RateBurst,
+	}
+}
+
 func (e *serveEnv) applyTCPServe(sc *ipn.ServeConfig, dnsName string, srcType serveType, srcPort uint16, target string) error {
 	var terminateTLS bool
 	switch srcType {
@@ -1197,7 +1341,23 @@ func (e *serveEnv) applyTCPServe(sc *ipn.ServeConfig, dnsName string, srcType se
 		return fmt.Errorf("cannot serve TCP; already serving web on %d for %s", srcPort, dnsName)
 	}
 
-	sc.SetTCPForwarding(srcPort, dstURL.Host, terminateTLS, dnsName)
+	sc.SetTCPForwarding(srcPort, dstURL.Host, terminateTLS, int(e.proxyProtocol), dnsName)
+
+	return nil
+}
+
+func (e *serveEnv) applyUDPServe(sc *ipn.ServeConfig, srcPort uint16, target string) error {
+	targetURL, err := ipn.ExpandProxyTargetValue(target, []string{"udp"}, "udp")
+	if err != nil {
+		return fmt.Errorf("unable to expand target: %v", err)
+	}
+
+	dstURL, err := url.Parse(targetURL)
+	if err != nil {
+		return fmt.Errorf("invalid UDP target %q: %v", target, err)
+	}
+
+	sc.SetUDPForwarding(srcPort, dstURL.Host)
 
 	return nil
 }
@@ -1232,6 +1392,11 @@ func (e *serveEnv) unsetServe(sc *ipn.ServeConfig, dnsName string, srvType serve
 		if err != nil {
 			return fmt.Errorf("failed to remove TCP serve: %w", err)
 		}
+	case serveTypeUDP:
+		err := e.removeUDPServe(sc, srvPort)
+		if err != nil {
+			return fmt.Errorf("failed to remove UDP serve: %w", err)
+		}
 	case serveTypeTUN:
 		err := e.removeTunServe(sc, dnsName)
 		if err != nil {
@@ -1252,6 +1417,7 @@ func srvTypeAndPortFromFlags(e *serveEnv) (srvType serveType, srvPort uint16, er
 		serveTypeHTTPS:            e.https,
 		serveTypeTCP:              e.tcp,
 		serveTypeTLSTerminatedTCP: e.tlsTerminatedTCP,
+		serveTypeUDP:              e.udp,
 	}
 
 	var srcTypeCount int
@@ -1457,6 +1623,19 @@ func (e *serveEnv) removeTCPServe(sc *ipn.ServeConfig, dnsName string, src uint1
 	return nil
 }
 
+// removeUDPServe removes the UDP forwarding configuration for the
+// given serving port.
+func (e *serveEnv) removeUDPServe(sc *ipn.ServeConfig, src uint16) error {
+	if sc == nil {
+		return nil
+	}
+	if !sc.IsUDPForwardingOnPort(src) {
+		return errors.New("serve config does not exist")
+	}
+	sc.RemoveUDPForwarding(src)
+	return nil
+}
+
 func (e *serveEnv) removeTunServe(sc *ipn.ServeConfig, dnsName string) error {
 	if sc == nil {
 		return nil
@@ -1505,6 +1684,8 @@ func (s serveType) String() string {
 		return "tcp"
 	case serveTypeTLSTerminatedTCP:
 		return "tls-terminated-tcp"
+	case serveTypeUDP:
+		return "udp"
 	default:
 		return "unknownServeType"
 	}
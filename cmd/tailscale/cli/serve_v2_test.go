@@ -17,12 +17,14 @@
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/views"
 )
 
@@ -402,6 +404,42 @@ type group struct {
 				},
 			}},
 		},
+		{
+			name: "proxy_options",
+			steps: []step{
+				{
+					command: cmd("serve --bg --set-path=/dash --request-header=x-dashboard-token:secret --request-header=Cookie: --response-header=X-Frame-Options:DENY --keep-prefix --proxy-timeout=30s --rate-limit=10 --rate-burst=20 3000"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/dash": {
+									Proxy:              "http://127.0.0.1:3000",
+									SetRequestHeaders:  map[string]string{"X-Dashboard-Token": "secret", "Cookie": ""},
+									SetResponseHeaders: map[string]string{"X-Frame-Options": "DENY"},
+									KeepPrefix:         true,
+									Timeout:            tstime.GoDuration{Duration: 30 * time.Second},
+									RateLimit:          10,
+									RateBurst:          20,
+								},
+							}},
+						},
+					},
+				},
+				{ // not a proxy target
+					command: cmd("serve --bg --set-path=/hi --keep-prefix text:hi"),
+					wantErr: anyErr(),
+				},
+				{ // not an HTTP server
+					command: cmd("serve --bg --tcp=5432 --proxy-timeout=1s 5432"),
+					wantErr: anyErr(),
+				},
+				{ // malformed header
+					command: cmd("serve --bg --request-header=nocolon 3000"),
+					wantErr: anyErr(),
+				},
+			},
+		},
 		{
 			name: "unknown_host_tcp",
 			steps: []step{{
@@ -464,6 +502,46 @@ type group struct {
 				},
 			},
 		},
+		{
+			name: "proxy_protocol",
+			steps: []step{
+				{
+					command: cmd("serve --tcp=5432 --proxy-protocol=2 --bg tcp://localhost:5433"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{
+							5432: {
+								TCPForward:    "localhost:5433",
+								ProxyProtocol: 2,
+							},
+						},
+					},
+				},
+				{
+					command: cmd("serve --tls-terminated-tcp=443 --proxy-protocol=1 --bg tcp://localhost:8443"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{
+							443: {
+								TCPForward:    "localhost:8443",
+								TerminateTLS:  "foo.test.ts.net",
+								ProxyProtocol: 1,
+							},
+							5432: {
+								TCPForward:    "localhost:5433",
+								ProxyProtocol: 2,
+							},
+						},
+					},
+				},
+				{ // unsupported version
+					command: cmd("serve --tcp=5432 --proxy-protocol=3 --bg tcp://localhost:5433"),
+					wantErr: anyErr(),
+				},
+				{ // only for TCP forwarders
+					command: cmd("serve --https=8443 --proxy-protocol=1 --bg localhost:3000"),
+					wantErr: anyErr(),
+				},
+			},
+		},
 		{
 			name: "tcp_off",
 			steps: []step{
@@ -488,6 +566,68 @@ type group struct {
 				},
 			},
 		},
+		{
+			name: "udp",
+			steps: []step{
+				{
+					command: cmd("serve --udp=27015 --bg 27015"),
+					want: &ipn.ServeConfig{
+						UDP: map[uint16]*ipn.UDPPortHandler{
+							27015: {UDPForward: "127.0.0.1:27015"},
+						},
+					},
+				},
+				{ // UDP and TCP ports are independent
+					command: cmd("serve --tcp=27015 --bg tcp://localhost:27016"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{
+							27015: {TCPForward: "localhost:27016"},
+						},
+						UDP: map[uint16]*ipn.UDPPortHandler{
+							27015: {UDPForward: "127.0.0.1:27015"},
+						},
+					},
+				},
+				{
+					command: cmd("serve --udp=514 --bg udp://localhost:5514"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{
+							27015: {TCPForward: "localhost:27016"},
+						},
+						UDP: map[uint16]*ipn.UDPPortHandler{
+							514:   {UDPForward: "localhost:5514"},
+							27015: {UDPForward: "127.0.0.1:27015"},
+						},
+					},
+				},
+				{ // only udp targets
+					command: cmd("serve --udp=53 --bg tcp://localhost:5353"),
+					wantErr: anyErr(),
+				},
+				{ // handler doesn't exist
+					command: cmd("serve --udp=53 off"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("serve --udp=27015 off"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{
+							27015: {TCPForward: "localhost:27016"},
+						},
+						UDP: map[uint16]*ipn.UDPPortHandler{
+							514: {UDPForward: "localhost:5514"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "udp_service",
+			steps: []step{{
+				command: cmd("serve --service=svc:foo --udp=53 5353"),
+				wantErr: anyErr(),
+			}},
+		},
 		{
 			name: "text",
 			steps: []step{{
@@ -1197,6 +1337,13 @@ func TestSrcTypeFromFlags(t *testing.T) {
 			expectedPort: 8080,
 			expectedErr:  false,
 		},
+		{
+			name:         "only udp set",
+			env:          &serveEnv{udp: 27015},
+			expectedType: serveTypeUDP,
+			expectedPort: 27015,
+			expectedErr:  false,
+		},
 		{
 			name:         "defaults to https, port 443",
 			env:          &serveEnv{},
@@ -19,6 +19,7 @@
 	"tailscale.com/feature/buildfeatures"
 	"tailscale.com/ipn"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/portmapper/portmappertype"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/safesocket"
 	"tailscale.com/tsconst"
@@ -65,6 +66,9 @@ type setArgsT struct {
 	statefulFiltering      bool
 	netfilterMode          string
 	relayServerPort        string
+	portMappings           string
+	bindInterface          string
+	bindInterfaceFallback  bool
 }
 
 func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
@@ -86,6 +90,12 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.BoolVar(&setArgs.reportPosture, "report-posture", false, "allow management plane to gather device posture information")
 	setf.BoolVar(&setArgs.runWebClient, "webclient", false, "expose the web interface for managing this node over Tailscale at port 5252")
 	setf.StringVar(&setArgs.relayServerPort, "relay-server-port", "", "UDP port number (0 will pick a random unused port) for the relay server to bind to, on all interfaces, or empty string to disable relay server functionality")
+	if buildfeatures.HasPortMapper {
+		setf.StringVar(&setArgs.portMappings, "port-mappings", "", "ports to forward from the LAN gateway to this machine using NAT-PMP, PCP or UPnP (comma-separated [EXTERNAL:]INTERNAL/PROTO, e.g. \"443:8443/tcp,51413/udp\"), or empty string to not forward any")
+	}
+
+	setf.StringVar(&setArgs.bindInterface, "bind-interface", "", "network interface to pin peer, DERP, STUN and port mapping traffic to (e.g. \"eth1\"), or empty string to use tailscaled's --bind-interface setting")
+	setf.BoolVar(&setArgs.bindInterfaceFallback, "bind-interface-fallback", false, "use other network interfaces while the --bind-interface one is down, rather than staying offline")
 
 	ffcomplete.Flag(setf, "exit-node", func(args []string) ([]string, ffcomplete.ShellCompDirective, error) {
 		st, err := localClient.Status(context.Background())
@@ -156,8 +166,10 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			AppConnector: ipn.AppConnectorPrefs{
 				Advertise: setArgs.advertiseConnector,
 			},
-			PostureChecking:     setArgs.reportPosture,
-			NoStatefulFiltering: opt.NewBool(!setArgs.statefulFiltering),
+			PostureChecking:       setArgs.reportPosture,
+			NoStatefulFiltering:   opt.NewBool(!setArgs.statefulFiltering),
+			BindInterface:         setArgs.bindInterface,
+			BindInterfaceFallback: setArgs.bindInterfaceFallback,
 		},
 	}
 
@@ -245,6 +257,13 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 		maskedPrefs.Prefs.RelayServerPort = ptr.To(int(uport))
 	}
 
+	if maskedPrefs.PortMappingsSet {
+		maskedPrefs.Prefs.PortMappings, err = parsePortMappings(setArgs.portMappings)
+		if err != nil {
+			return err
+		}
+	}
+
 	checkPrefs := curPrefs.Clone()
 	checkPrefs.ApplyEdits(maskedPrefs)
 	if err := localClient.CheckPrefs(ctx, checkPrefs); err != nil {
@@ -263,6 +282,23 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 	return nil
 }
 
+// parsePortMappings parses the comma-separated port mappings in s, as
+// accepted by the --port-mappings flag, into their canonical form.
+func parsePortMappings(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var mappings []string
+	for f := range strings.SplitSeq(s, ",") {
+		m, err := portmappertype.ParseStaticMapping(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, m.String())
+	}
+	return mappings, nil
+}
+
 // calcAdvertiseRoutesForSet returns the new value for Prefs.AdvertiseRoutes based on the
 // current value, the flags passed to "tailscale set".
 // advertiseExitNodeSet is whether the --advertise-exit-node flag was set.
@@ -889,6 +889,9 @@ func init() {
 	addPrefFlagMapping("advertise-connector", "AppConnector")
 	addPrefFlagMapping("report-posture", "PostureChecking")
 	addPrefFlagMapping("relay-server-port", "RelayServerPort")
+	addPrefFlagMapping("port-mappings", "PortMappings")
+	addPrefFlagMapping("bind-interface", "BindInterface")
+	addPrefFlagMapping("bind-interface-fallback", "BindInterfaceFallback")
 }
 
 func addPrefFlagMapping(flagName string, prefNames ...string) {
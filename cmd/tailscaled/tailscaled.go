@@ -121,6 +121,8 @@ func defaultPort() uint16 {
 	statedir            string
 	socketpath          string
 	birdSocketPath      string
+	bindInterface       string // network interface to pin sockets to
+	bindIfaceFallback   bool   // whether to use other interfaces while bindInterface is down
 	verbose             int
 	socksAddr           string // listen address for SOCKS5 server
 	httpProxyAddr       string // listen address for HTTP proxy server
@@ -194,7 +196,7 @@ func main() {
 	}
 	flag.StringVar(&args.tunname, "tun", defaultTunName(), `tunnel interface name; use "userspace-networking" (beta) to not use TUN`)
 	flag.Var(flagtype.PortValue(&args.port, defaultPort()), "port", "UDP port to listen on for WireGuard and peer-to-peer traffic; 0 means automatically select")
-	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
+	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'encfile:<path>' to encrypt the file with a key from $TS_STATE_KEYFILE or $TS_STATE_PASSPHRASE; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
 	if buildfeatures.HasTPM {
 		flag.Var(&args.encryptState, "encrypt-state", `encrypt the state file on disk; when not set encryption will be enabled if supported on this platform; uses TPM on Linux and Windows, on all other platforms this flag is not supported`)
 	}
@@ -203,6 +205,8 @@ func main() {
 	if buildfeatures.HasBird {
 		flag.StringVar(&args.birdSocketPath, "bird-socket", "", "path of the bird unix socket")
 	}
+	flag.StringVar(&args.bindInterface, "bind-interface", "", "network interface to pin peer, DERP, STUN and port mapping traffic to; can be overridden by the BindInterface pref")
+	flag.BoolVar(&args.bindIfaceFallback, "bind-interface-fallback", false, "use other network interfaces while the --bind-interface one is down, rather than staying offline")
 	flag.BoolVar(&printVersion, "version", false, "print version information and exit")
 	flag.BoolVar(&args.disableLogs, "no-logs-no-support", false, "disable log uploads; this also disables any technical support")
 	flag.StringVar(&args.confFile, "config", "", "path to config file, or 'vm:user-data' to use the VM's user-data (EC2)")
@@ -264,6 +268,14 @@ func main() {
 		log.Fatalf("--bird-socket is not supported on %s", runtime.GOOS)
 	}
 
+	if args.bindInterface != "" {
+		if !netns.CanBindInterface() {
+			log.SetFlags(0)
+			log.Fatalf("--bind-interface is not supported on %s", runtime.GOOS)
+		}
+		netns.SetBindInterface(args.bindInterface, args.bindIfaceFallback)
+	}
+
 	// Only apply a default statepath when neither have been provided, so that a
 	// user may specify only --statedir if they wish.
 	if args.statepath == "" && args.statedir == "" {
@@ -0,0 +1,180 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/client/local"
+)
+
+// parseCodeChallenge returns the PKCE (RFC 7636) code challenge in the
+// authorization request query q, or the empty string if there's none.
+func parseCodeChallenge(q url.Values) (string, error) {
+	challenge, method := q.Get("code_challenge"), q.Get("code_challenge_method")
+	if challenge == "" {
+		if method != "" {
+			return "", errors.New("tsidp: code_challenge_method without code_challenge")
+		}
+		return "", nil
+	}
+	if method != "S256" {
+		return "", errors.New("tsidp: code_challenge_method must be S256")
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(challenge); err != nil || len(b) != sha256.Size {
+		return "", errors.New("tsidp: invalid code_challenge")
+	}
+	return challenge, nil
+}
+
+// verifyCodeVerifier checks the PKCE code verifier presented to the token
+// endpoint against the S256 challenge from the authorization request. It
+// succeeds if there was no challenge.
+func verifyCodeVerifier(challenge, verifier string) error {
+	if challenge == "" {
+		return nil
+	}
+	if verifier == "" {
+		return errors.New("tsidp: code_verifier is required")
+	}
+	if len(verifier) < 43 || len(verifier) > 128 || strings.ContainsFunc(verifier, func(r rune) bool {
+		// Only the unreserved characters of RFC 3986 are allowed.
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || strings.ContainsRune("-._~", r))
+	}) {
+		return errors.New("tsidp: invalid code_verifier")
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) != 1 {
+		return errors.New("tsidp: code_verifier mismatch")
+	}
+	return nil
+}
+
+func (s *idpServer) serveRefreshTokenGrant(w http.ResponseWriter, r *http.Request) {
+	tk := r.FormValue("refresh_token")
+	if tk == "" {
+		http.Error(w, "tsidp: refresh_token is required", http.StatusBadRequest)
+		return
+	}
+	h := hashToken(tk)
+
+	s.mu.Lock()
+	rt, ok := s.refreshTokens[h]
+	var ar *authRequest
+	var clientOK bool
+	if ok {
+		ar, clientOK = s.authRequestLocked(rt)
+	}
+	s.mu.Unlock()
+	if !ok || time.Now().After(rt.ValidTill) {
+		http.Error(w, "tsidp: invalid refresh token", http.StatusBadRequest)
+		return
+	}
+	if !clientOK {
+		http.Error(w, "tsidp: client no longer exists", http.StatusBadRequest)
+		return
+	}
+	if status, err := s.authenticateRelyingParty(r, ar); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// Refresh what we know about the user, so that new tokens reflect
+	// their current node and capabilities, and stop being issued once the
+	// node is gone or has changed hands.
+	who, err := s.lc.WhoIsNodeKey(r.Context(), rt.RemoteUser.Node.Key)
+	if err != nil && !errors.Is(err, local.ErrPeerNotFound) {
+		log.Printf("Error getting WhoIs: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	gone := err != nil || who.Node.User != rt.RemoteUser.Node.User || who.Node.IsTagged()
+
+	s.mu.Lock()
+	if s.refreshTokens[h] != rt {
+		// Revoked while we were checking it.
+		s.mu.Unlock()
+		http.Error(w, "tsidp: invalid refresh token", http.StatusBadRequest)
+		return
+	}
+	if rt.Used || gone {
+		s.revokeRefreshFamilyLocked(rt.Family)
+		err := s.storeTokensLocked()
+		s.mu.Unlock()
+		if err != nil {
+			log.Printf("could not write tokens db: %v", err)
+		}
+		if gone {
+			http.Error(w, "tsidp: user's node is no longer in the tailnet", http.StatusBadRequest)
+			return
+		}
+		log.Printf("refresh token reused by client %q; revoked its tokens", rt.ClientID)
+		http.Error(w, "tsidp: refresh token already used", http.StatusBadRequest)
+		return
+	}
+	rt.Used = true
+	err = s.storeTokensLocked()
+	if err != nil {
+		rt.Used = false
+	}
+	s.mu.Unlock()
+	if err != nil {
+		log.Printf("could not write tokens db: %v", err)
+		http.Error(w, "tsidp: could not write tokens to db", http.StatusInternalServerError)
+		return
+	}
+
+	ar.remoteUser = who
+	s.issueTokens(w, ar, true)
+}
+
+func (s *idpServer) serveClientCredentialsGrant(w http.ResponseWriter, r *http.Request) {
+	if isFunnelRequest(r) {
+		http.Error(w, "tsidp: client_credentials grant is only available within the tailnet", http.StatusUnauthorized)
+		return
+	}
+	clientID, clientSecret := clientAuth(r)
+	s.mu.Lock()
+	c, ok := s.funnelClients[clientID]
+	s.mu.Unlock()
+	if !ok || c.Public || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(c.Secret)) != 1 {
+		http.Error(w, "tsidp: invalid client credentials", http.StatusUnauthorized)
+		return
+	}
+	if len(c.Tags) == 0 {
+		http.Error(w, "tsidp: client not allowed to use the client_credentials grant", http.StatusBadRequest)
+		return
+	}
+
+	who, err := s.lc.WhoIs(r.Context(), s.remoteAddr(r))
+	if err != nil {
+		log.Printf("Error getting WhoIs: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !who.Node.IsTagged() {
+		http.Error(w, "tsidp: client_credentials grant is only for tagged nodes", http.StatusForbidden)
+		return
+	}
+	if !slices.ContainsFunc(who.Node.Tags, func(tag string) bool { return slices.Contains(c.Tags, tag) }) {
+		http.Error(w, "tsidp: node has none of the client's tags", http.StatusForbidden)
+		return
+	}
+
+	s.issueTokens(w, &authRequest{
+		clientID:          c.ID,
+		funnelRP:          c,
+		remoteUser:        who,
+		clientCredentials: true,
+	}, false)
+}
@@ -0,0 +1,388 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// whoIsTestClient returns a LocalClient whose WhoIs and WhoIsNodeKey calls
+// are answered by whois, which returns nil for peers that aren't found.
+func whoIsTestClient(whois func(addr string) *apitype.WhoIsResponse) *local.Client {
+	return &local.Client{
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			rec := httptest.NewRecorder()
+			if who := whois(r.URL.Query().Get("addr")); who != nil {
+				json.NewEncoder(rec).Encode(who)
+			} else {
+				http.Error(rec, "not found", http.StatusNotFound)
+			}
+			return rec.Result(), nil
+		}),
+	}
+}
+
+// postToken posts form to s's token endpoint from remoteAddr.
+func postToken(s *idpServer, remoteAddr string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	s.serveToken(rr, req)
+	return rr
+}
+
+func decodeTokenResponse(t *testing.T, rr *httptest.ResponseRecorder) oidcTokenResponse {
+	t.Helper()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d: %s; want 200 OK", rr.Code, rr.Body.String())
+	}
+	var resp oidcTokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	return resp
+}
+
+func TestPKCE(t *testing.T) {
+	verifier := strings.Repeat("a1-._~", 8)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	parseTests := []struct {
+		name    string
+		query   url.Values
+		want    string
+		wantErr bool
+	}{
+		{name: "none", query: url.Values{}},
+		{name: "s256", query: url.Values{"code_challenge": {challenge}, "code_challenge_method": {"S256"}}, want: challenge},
+		{name: "plain", query: url.Values{"code_challenge": {verifier}, "code_challenge_method": {"plain"}}, wantErr: true},
+		{name: "no_method", query: url.Values{"code_challenge": {challenge}}, wantErr: true},
+		{name: "method_only", query: url.Values{"code_challenge_method": {"S256"}}, wantErr: true},
+		{name: "short", query: url.Values{"code_challenge": {"abc"}, "code_challenge_method": {"S256"}}, wantErr: true},
+	}
+	for _, tt := range parseTests {
+		t.Run("parse_"+tt.name, func(t *testing.T) {
+			got, err := parseCodeChallenge(tt.query)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseCodeChallenge = %q, %v; want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+	verifyTests := []struct {
+		name      string
+		challenge string
+		verifier  string
+		wantErr   bool
+	}{
+		{name: "no_challenge", verifier: "ignored"},
+		{name: "match", challenge: challenge, verifier: verifier},
+		{name: "missing", challenge: challenge, wantErr: true},
+		{name: "mismatch", challenge: challenge, verifier: strings.Repeat("b", 43), wantErr: true},
+		{name: "too_short", challenge: challenge, verifier: "abc", wantErr: true},
+		{name: "bad_chars", challenge: challenge, verifier: strings.Repeat("a", 42) + "+", wantErr: true},
+	}
+	for _, tt := range verifyTests {
+		t.Run("verify_"+tt.name, func(t *testing.T) {
+			if err := verifyCodeVerifier(tt.challenge, tt.verifier); (err != nil) != tt.wantErr {
+				t.Errorf("verifyCodeVerifier = %v; want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("public_client", func(t *testing.T) {
+		s := setupTestServer(t, true)
+		s.funnelClients["public-client"] = &funnelClient{
+			ID:          "public-client",
+			RedirectURI: "https://rp.example.com/callback",
+			Public:      true,
+		}
+
+		// Public clients can't skip PKCE.
+		req := httptest.NewRequest("GET", "/authorize?"+url.Values{
+			"client_id":    {"public-client"},
+			"redirect_uri": {"https://rp.example.com/callback"},
+		}.Encode(), nil)
+		rr := httptest.NewRecorder()
+		s.authorize(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("authorize without PKCE: got %d; want 400", rr.Code)
+		}
+
+		s.code["code"] = &authRequest{
+			clientID:      "public-client",
+			redirectURI:   "https://rp.example.com/callback",
+			remoteUser:    testRemoteUser(),
+			funnelRP:      s.funnelClients["public-client"],
+			codeChallenge: challenge,
+		}
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {"code"},
+			"redirect_uri":  {"https://rp.example.com/callback"},
+			"client_id":     {"public-client"},
+			"code_verifier": {verifier},
+		}
+		decodeTokenResponse(t, postToken(s, "127.0.0.1:12345", form))
+	})
+}
+
+// testRemoteUser returns the WhoIs response of a user's node for tests.
+func testRemoteUser() *apitype.WhoIsResponse {
+	return &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			ID:       123,
+			StableID: "n123",
+			Name:     "test-node.test.ts.net.",
+			User:     456,
+			Key:      key.NewNode().Public(),
+		},
+		UserProfile: &tailcfg.UserProfile{
+			LoginName:   "alice@example.com",
+			DisplayName: "Alice Example",
+		},
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	user := testRemoteUser()
+	nodeInTailnet := true
+	lc := whoIsTestClient(func(addr string) *apitype.WhoIsResponse {
+		if addr == user.Node.Key.String() && nodeInTailnet {
+			return user
+		}
+		return nil
+	})
+	s := setupTestServerWithClient(t, false, lc)
+	s.code["code"] = &authRequest{
+		localRP:     true,
+		clientID:    "client-id",
+		redirectURI: "https://rp.example.com/callback",
+		remoteUser:  user,
+	}
+	resp := decodeTokenResponse(t, postToken(s, "127.0.0.1:12345", url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {"code"},
+		"redirect_uri": {"https://rp.example.com/callback"},
+	}))
+	if resp.RefreshToken == "" {
+		t.Fatal("no refresh token issued")
+	}
+	if _, err := os.Stat(filepath.Join(s.rootPath, tokensFile)); err != nil {
+		t.Fatalf("refresh tokens not persisted: %v", err)
+	}
+
+	refresh := func(tk string) *httptest.ResponseRecorder {
+		return postToken(s, "127.0.0.1:12345", url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {tk},
+		})
+	}
+
+	first := resp.RefreshToken
+	resp = decodeTokenResponse(t, refresh(first))
+	second := resp.RefreshToken
+	if second == "" || second == first {
+		t.Fatalf("refresh token not rotated: got %q", second)
+	}
+	if _, ok := s.accessToken[resp.AccessToken]; !ok {
+		t.Fatal("access token from refresh not stored")
+	}
+
+	// Reusing a rotated token revokes the whole family.
+	if rr := refresh(first); rr.Code != http.StatusBadRequest {
+		t.Fatalf("reusing refresh token: got %d; want 400", rr.Code)
+	}
+	if _, ok := s.accessToken[resp.AccessToken]; ok {
+		t.Error("access token not revoked after refresh token reuse")
+	}
+	if rr := refresh(second); rr.Code != http.StatusBadRequest {
+		t.Fatalf("refresh token of revoked family: got %d; want 400", rr.Code)
+	}
+
+	// Tokens survive a restart.
+	s.code["code2"] = &authRequest{
+		localRP:     true,
+		clientID:    "client-id",
+		redirectURI: "https://rp.example.com/callback",
+		remoteUser:  user,
+	}
+	resp = decodeTokenResponse(t, postToken(s, "127.0.0.1:12345", url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {"code2"},
+		"redirect_uri": {"https://rp.example.com/callback"},
+	}))
+	s.refreshTokens = nil
+	if err := s.loadTokens(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.refreshTokens) != 1 {
+		t.Fatalf("loaded %d refresh tokens; want 1", len(s.refreshTokens))
+	}
+
+	// Tokens stop working once the user's node leaves the tailnet.
+	nodeInTailnet = false
+	if rr := refresh(resp.RefreshToken); rr.Code != http.StatusBadRequest {
+		t.Fatalf("refresh for departed node: got %d; want 400", rr.Code)
+	}
+	if len(s.refreshTokens) != 0 {
+		t.Errorf("refresh tokens for departed node not revoked")
+	}
+}
+
+func TestRefreshTokenClientDeletion(t *testing.T) {
+	s := setupTestServer(t, true)
+	ar := &authRequest{
+		clientID:    "test-client",
+		redirectURI: "https://rp.example.com/callback",
+		remoteUser:  testRemoteUser(),
+		funnelRP:    s.funnelClients["test-client"],
+	}
+	tk, err := s.issueRefreshToken(ar, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.refreshTokens) != 1 {
+		t.Fatalf("got %d refresh tokens; want 1", len(s.refreshTokens))
+	}
+
+	req := httptest.NewRequest("DELETE", "/clients/test-client", nil)
+	rr := httptest.NewRecorder()
+	s.serveClients(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("deleting client: got %d", rr.Code)
+	}
+	if len(s.refreshTokens) != 0 {
+		t.Fatalf("refresh tokens not revoked on client deletion")
+	}
+	rr = postToken(s, "127.0.0.1:12345", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tk},
+		"client_id":     {"test-client"},
+		"client_secret": {"test-secret"},
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("refresh after client deletion: got %d; want 400", rr.Code)
+	}
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	tagged := &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			ID:       789,
+			StableID: "n789",
+			Name:     "ci-runner.test.ts.net.",
+			Tags:     []string{"tag:ci"},
+		},
+		UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+	}
+	otherTag := &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			ID:   790,
+			Name: "web.test.ts.net.",
+			Tags: []string{"tag:web"},
+		},
+		UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+	}
+	lc := whoIsTestClient(func(addr string) *apitype.WhoIsResponse {
+		switch addr {
+		case "100.64.0.1:1234":
+			return tagged
+		case "100.64.0.2:1234":
+			return otherTag
+		case "100.64.0.3:1234":
+			return testRemoteUser()
+		}
+		return nil
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		clientID   string
+		secret     string
+		funnel     bool
+		wantCode   int
+	}{
+		{name: "ok", remoteAddr: "100.64.0.1:1234", clientID: "ci-client", secret: "ci-secret", wantCode: http.StatusOK},
+		{name: "bad_secret", remoteAddr: "100.64.0.1:1234", clientID: "ci-client", secret: "wrong", wantCode: http.StatusUnauthorized},
+		{name: "unknown_client", remoteAddr: "100.64.0.1:1234", clientID: "nope", secret: "ci-secret", wantCode: http.StatusUnauthorized},
+		{name: "client_without_tags", remoteAddr: "100.64.0.1:1234", clientID: "test-client", secret: "test-secret", wantCode: http.StatusBadRequest},
+		{name: "other_tag", remoteAddr: "100.64.0.2:1234", clientID: "ci-client", secret: "ci-secret", wantCode: http.StatusForbidden},
+		{name: "user_node", remoteAddr: "100.64.0.3:1234", clientID: "ci-client", secret: "ci-secret", wantCode: http.StatusForbidden},
+		{name: "funnel", remoteAddr: "100.64.0.1:1234", clientID: "ci-client", secret: "ci-secret", funnel: true, wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := setupTestServerWithClient(t, true, lc)
+			s.funnelClients["ci-client"] = &funnelClient{
+				ID:     "ci-client",
+				Secret: "ci-secret",
+				Tags:   []string{"tag:ci"},
+			}
+			req := httptest.NewRequest("POST", "/token", strings.NewReader(url.Values{
+				"grant_type": {"client_credentials"},
+			}.Encode()))
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetBasicAuth(tt.clientID, tt.secret)
+			if tt.funnel {
+				req.Header.Set("Tailscale-Funnel-Request", "true")
+			}
+			rr := httptest.NewRecorder()
+			s.serveToken(rr, req)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("got %d: %s; want %d", rr.Code, rr.Body.String(), tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			resp := decodeTokenResponse(t, rr)
+			if resp.RefreshToken != "" {
+				t.Error("refresh token issued for client_credentials grant")
+			}
+			tok, err := jwt.ParseSigned(resp.IDToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var claims map[string]any
+			if err := tok.Claims(oidcTestingPublicKey(t), &claims); err != nil {
+				t.Fatal(err)
+			}
+			if claims["sub"] != "n789" {
+				t.Errorf("sub = %v; want n789", claims["sub"])
+			}
+			if got, want := claims["tags"], []any{"tag:ci"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("tags = %v; want %v", got, want)
+			}
+			if _, ok := claims["email"]; ok {
+				t.Errorf("unexpected email claim %v", claims["email"])
+			}
+		})
+	}
+}
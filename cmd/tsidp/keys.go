@@ -0,0 +1,380 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// rsaPrivateKeyJSONWrapper is the the JSON serialization
+// format used by RSAPrivateKey.
+type rsaPrivateKeyJSONWrapper struct {
+	Key     string
+	ID      uint64
+	Created time.Time `json:",omitzero"`
+	Retired time.Time `json:",omitzero"`
+
+	// Previous are the retired keys that are still published. It is only
+	// set on the current key, which is the top level of oidcKeyFile.
+	Previous []rsaPrivateKeyJSONWrapper `json:",omitempty"`
+}
+
+type signingKey struct {
+	k       *rsa.PrivateKey
+	kid     uint64
+	created time.Time
+	retired time.Time // zero for the current key
+}
+
+// newSigningKey returns a new signing key with a random kid.
+func newSigningKey(now time.Time) *signingKey {
+	kid, k := mustGenRSAKey(2048)
+	return &signingKey{k: k, kid: kid, created: now}
+}
+
+func (sk *signingKey) jsonWrapper() rsaPrivateKeyJSONWrapper {
+	b := pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(sk.k),
+	}
+	bts := pem.EncodeToMemory(&b)
+	return rsaPrivateKeyJSONWrapper{
+		Key:     base64.URLEncoding.EncodeToString(bts),
+		ID:      sk.kid,
+		Created: sk.created,
+		Retired: sk.retired,
+	}
+}
+
+func (sk *signingKey) setFromJSONWrapper(wrapper rsaPrivateKeyJSONWrapper) error {
+	if len(wrapper.Key) == 0 {
+		return nil
+	}
+	b64dec, err := base64.URLEncoding.DecodeString(wrapper.Key)
+	if err != nil {
+		return err
+	}
+	blk, _ := pem.Decode(b64dec)
+	if blk == nil {
+		return errors.New("no PEM block in key")
+	}
+	k, err := x509.ParsePKCS1PrivateKey(blk.Bytes)
+	if err != nil {
+		return err
+	}
+	sk.k = k
+	sk.kid = wrapper.ID
+	sk.created = wrapper.Created
+	sk.retired = wrapper.Retired
+	return nil
+}
+
+func (sk *signingKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(sk.jsonWrapper())
+}
+
+func (sk *signingKey) UnmarshalJSON(b []byte) error {
+	var wrapper rsaPrivateKeyJSONWrapper
+	if err := json.Unmarshal(b, &wrapper); err != nil {
+		return err
+	}
+	return sk.setFromJSONWrapper(wrapper)
+}
+
+// signingKeys are the keys tokens are signed with. New tokens are signed
+// with the current key. Keys it replaced stay published in the JWKS for an
+// overlap window, so that relying parties can still verify the tokens they
+// signed.
+type signingKeys struct {
+	current  *signingKey
+	previous []*signingKey // most recently retired first
+}
+
+// published returns the keys to publish at now: the current key, followed
+// by the previous keys retired less than overlap ago.
+func (ks *signingKeys) published(now time.Time, overlap time.Duration) []*signingKey {
+	keys := []*signingKey{ks.current}
+	for _, sk := range ks.previous {
+		if now.Before(sk.retired.Add(overlap)) {
+			keys = append(keys, sk)
+		}
+	}
+	return keys
+}
+
+// MarshalJSON marshals ks in the format of a single signingKey, so that
+// oidcKeyFile stays readable by older versions of tsidp.
+func (ks *signingKeys) MarshalJSON() ([]byte, error) {
+	wrapper := ks.current.jsonWrapper()
+	for _, sk := range ks.previous {
+		wrapper.Previous = append(wrapper.Previous, sk.jsonWrapper())
+	}
+	return json.Marshal(wrapper)
+}
+
+func (ks *signingKeys) UnmarshalJSON(b []byte) error {
+	var wrapper rsaPrivateKeyJSONWrapper
+	if err := json.Unmarshal(b, &wrapper); err != nil {
+		return err
+	}
+	var cur signingKey
+	if err := cur.setFromJSONWrapper(wrapper); err != nil {
+		return err
+	}
+	if cur.k == nil {
+		return errors.New("no current key")
+	}
+	var previous []*signingKey
+	for _, pw := range wrapper.Previous {
+		sk := new(signingKey)
+		if err := sk.setFromJSONWrapper(pw); err != nil {
+			return err
+		}
+		if sk.k != nil {
+			previous = append(previous, sk)
+		}
+	}
+	ks.current = &cur
+	ks.previous = previous
+	return nil
+}
+
+// signingKeysLocked returns the signing keys, loading them from oidcKeyFile
+// or generating a new key on first use.
+//
+// s.keyMu must be held.
+func (s *idpServer) signingKeysLocked() (*signingKeys, error) {
+	if s.signingKeys != nil {
+		return s.signingKeys, nil
+	}
+	keyPath, err := getConfigFilePath(s.rootPath, oidcKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not get OIDC key file path: %w", err)
+	}
+	now := time.Now()
+	ks := new(signingKeys)
+	b, err := os.ReadFile(keyPath)
+	if err == nil {
+		if err := ks.UnmarshalJSON(b); err != nil {
+			log.Printf("Error unmarshaling key: %v", err)
+		}
+	}
+	switch {
+	case ks.current == nil:
+		ks = &signingKeys{current: newSigningKey(now)}
+	case ks.current.created.IsZero():
+		// The key was written before tsidp tracked key ages. Start its
+		// clock now, rather than rotating it as soon as we start.
+		ks.current.created = now
+	default:
+		s.signingKeys = ks
+		return ks, nil
+	}
+	if err := s.storeSigningKeysLocked(ks); err != nil {
+		return nil, err
+	}
+	s.signingKeys = ks
+	return ks, nil
+}
+
+// storeSigningKeysLocked writes ks to oidcKeyFile.
+//
+// s.keyMu must be held.
+func (s *idpServer) storeSigningKeysLocked(ks *signingKeys) error {
+	keyPath, err := getConfigFilePath(s.rootPath, oidcKeyFile)
+	if err != nil {
+		return fmt.Errorf("could not get OIDC key file path: %w", err)
+	}
+	b, err := ks.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("could not marshal OIDC keys: %w", err)
+	}
+	if err := os.WriteFile(keyPath, b, 0600); err != nil {
+		return fmt.Errorf("could not write OIDC keys: %w", err)
+	}
+	return nil
+}
+
+// oidcSigner returns the signer for new tokens, which uses the current
+// signing key.
+func (s *idpServer) oidcSigner() (jose.Signer, error) {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	if s.signer != nil {
+		return s.signer, nil
+	}
+	ks, err := s.signingKeysLocked()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       ks.current.k,
+	}, &jose.SignerOptions{EmbedJWK: false, ExtraHeaders: map[jose.HeaderKey]any{
+		jose.HeaderType: "JWT",
+		"kid":           fmt.Sprint(ks.current.kid),
+	}})
+	if err != nil {
+		return nil, err
+	}
+	s.signer = signer
+	return signer, nil
+}
+
+// oidcPublicKeys returns the keys that tokens we issued may be verified
+// with, current key first.
+func (s *idpServer) oidcPublicKeys() ([]*signingKey, error) {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	ks, err := s.signingKeysLocked()
+	if err != nil {
+		return nil, err
+	}
+	return ks.published(time.Now(), s.keyOverlap), nil
+}
+
+// rotateSigningKey replaces the current signing key with a new one, which
+// it returns. The old key stays published for s.keyOverlap.
+func (s *idpServer) rotateSigningKey() (*signingKey, error) {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	ks, err := s.signingKeysLocked()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	old := *ks.current
+	old.retired = now
+	nks := &signingKeys{
+		current:  newSigningKey(now),
+		previous: append([]*signingKey{&old}, ks.published(now, s.keyOverlap)[1:]...),
+	}
+	if err := s.storeSigningKeysLocked(nks); err != nil {
+		return nil, err
+	}
+	s.signingKeys = nks
+	s.signer = nil
+	log.Printf("rotated OIDC signing key %d to %d", old.kid, nks.current.kid)
+	return nks.current, nil
+}
+
+// untilKeyRotation returns how long until the current signing key is due
+// to be rotated, which is not positive if it's overdue.
+func (s *idpServer) untilKeyRotation() (time.Duration, error) {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	ks, err := s.signingKeysLocked()
+	if err != nil {
+		return 0, err
+	}
+	return time.Until(ks.current.created.Add(s.keyRotationInterval)), nil
+}
+
+// runKeyRotation rotates the signing key every s.keyRotationInterval,
+// counted from when the current key was created, until ctx is done.
+func (s *idpServer) runKeyRotation(ctx context.Context) {
+	for {
+		wait := time.Minute // before retrying after an error
+		d, err := s.untilKeyRotation()
+		switch {
+		case err != nil:
+			log.Printf("Error getting signing keys: %v", err)
+		case d > 0:
+			wait = d
+		default:
+			if _, err := s.rotateSigningKey(); err != nil {
+				log.Printf("Error rotating signing key: %v", err)
+			} else {
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// signingKeyInfo describes a signing key to admins, without its private
+// part.
+type signingKeyInfo struct {
+	ID      string    `json:"kid"`
+	Current bool      `json:"current"`
+	Created time.Time `json:"created,omitzero"`
+	Retired time.Time `json:"retired,omitzero"`
+}
+
+func newSigningKeyInfo(sk *signingKey) signingKeyInfo {
+	return signingKeyInfo{
+		ID:      fmt.Sprint(sk.kid),
+		Current: sk.retired.IsZero(),
+		Created: sk.created,
+		Retired: sk.retired,
+	}
+}
+
+// signingKeyInfos returns the published signing keys, current key first.
+func (s *idpServer) signingKeyInfos() ([]signingKeyInfo, error) {
+	keys, err := s.oidcPublicKeys()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]signingKeyInfo, 0, len(keys))
+	for _, sk := range keys {
+		infos = append(infos, newSigningKeyInfo(sk))
+	}
+	return infos, nil
+}
+
+// /keys is a privileged endpoint that allows the visitor to list and
+// rotate the signing keys, so it is only accessible over the tailnet.
+func (s *idpServer) serveKeys(w http.ResponseWriter, r *http.Request) {
+	if isFunnelRequest(r) {
+		http.Error(w, "tsidp: not found", http.StatusNotFound)
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/keys/") {
+	case "":
+		if r.Method != "GET" {
+			http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		infos, err := s.signingKeyInfos()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(infos)
+	case "rotate":
+		if r.Method != "POST" {
+			http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sk, err := s.rotateSigningKey()
+		if err != nil {
+			log.Printf("Error rotating signing key: %v", err)
+			http.Error(w, "tsidp: could not rotate signing key", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(newSigningKeyInfo(sk))
+	default:
+		http.Error(w, "tsidp: not found", http.StatusNotFound)
+	}
+}
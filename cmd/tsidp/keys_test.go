@@ -0,0 +1,202 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// tokenKeyID returns the kid header of the JWT tk.
+func tokenKeyID(t *testing.T, tk string) string {
+	t.Helper()
+	tok, err := jwt.ParseSigned(tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok.Headers[0].KeyID
+}
+
+// jwksKeyIDs returns the key IDs s publishes in its JWKS.
+func jwksKeyIDs(t *testing.T, s *idpServer) []string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	s.serveJWKS(rr, httptest.NewRequest("GET", oidcJWKSPath, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rr.Code, rr.Body.String())
+	}
+	var jwks jose.JSONWebKeySet
+	if err := json.Unmarshal(rr.Body.Bytes(), &jwks); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, k := range jwks.Keys {
+		ids = append(ids, k.KeyID)
+	}
+	return ids
+}
+
+func TestKeyRotation(t *testing.T) {
+	s := setupTestServer(t, true)
+	s.keyOverlap = time.Hour
+	oldTokens := issueTestTokens(t, s)
+	if got := tokenKeyID(t, oldTokens.IDToken); got != "1" {
+		t.Fatalf("kid before rotation = %q; want 1", got)
+	}
+
+	sk, err := s.rotateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKID := fmt.Sprint(sk.kid)
+	if got := tokenKeyID(t, issueTestTokens(t, s).IDToken); got != newKID {
+		t.Errorf("kid after rotation = %q; want %q", got, newKID)
+	}
+	if got := jwksKeyIDs(t, s); len(got) != 2 || got[0] != newKID || got[1] != "1" {
+		t.Errorf("JWKS key IDs = %q; want [%q 1]", got, newKID)
+	}
+	if _, err := s.parseIDTokenHint(oldTokens.IDToken); err != nil {
+		t.Errorf("token signed with previous key: %v", err)
+	}
+
+	// Both keys are persisted.
+	s2 := setupTestServer(t, true)
+	s2.rootPath = s.rootPath
+	s2.signingKeys = nil
+	s2.keyOverlap = time.Hour
+	if got := jwksKeyIDs(t, s2); len(got) != 2 || got[0] != newKID || got[1] != "1" {
+		t.Errorf("reloaded JWKS key IDs = %q; want [%q 1]", got, newKID)
+	}
+
+	// Once the overlap window ends, the previous key is no longer
+	// published or trusted.
+	s.signingKeys.previous[0].retired = time.Now().Add(-2 * time.Hour)
+	if got := jwksKeyIDs(t, s); len(got) != 1 || got[0] != newKID {
+		t.Errorf("JWKS key IDs after overlap = %q; want [%q]", got, newKID)
+	}
+	if _, err := s.parseIDTokenHint(oldTokens.IDToken); err == nil {
+		t.Error("token signed with expired key accepted")
+	}
+	if _, err := s.rotateSigningKey(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(s.signingKeys.previous); got != 1 {
+		t.Errorf("kept %d previous keys; want 1", got)
+	}
+}
+
+func TestSigningKeysLegacyFile(t *testing.T) {
+	s := setupTestServer(t, true)
+	s.signingKeys = nil
+	b, err := (&signingKey{k: mustGeneratePrivateKey(t), kid: 7}).MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.rootPath, oidcKeyFile), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := s.signingKeyInfos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].ID != "7" || !infos[0].Current || infos[0].Created.IsZero() {
+		t.Fatalf("got %+v; want current key 7 with a creation time", infos)
+	}
+
+	// The creation time is persisted, so restarts don't postpone rotation.
+	s2 := setupTestServer(t, true)
+	s2.rootPath = s.rootPath
+	s2.signingKeys = nil
+	infos2, err := s2.signingKeyInfos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos2) != 1 || !infos2[0].Created.Equal(infos[0].Created) {
+		t.Errorf("reloaded %+v; want %+v", infos2, infos)
+	}
+}
+
+func TestRunKeyRotation(t *testing.T) {
+	s := setupTestServer(t, true)
+	s.keyOverlap = time.Hour
+	s.keyRotationInterval = time.Hour
+	s.signingKeys.current.created = time.Now().Add(-2 * time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.runKeyRotation(ctx)
+	}()
+	for {
+		if d, err := s.untilKeyRotation(); err != nil {
+			t.Fatal(err)
+		} else if d > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if got := jwksKeyIDs(t, s); len(got) != 2 || got[1] != "1" {
+		t.Errorf("JWKS key IDs = %q; want a new key and 1", got)
+	}
+}
+
+func TestServeKeys(t *testing.T) {
+	s := setupTestServer(t, true)
+	s.keyOverlap = time.Hour
+	serve := func(method, path string, funnel bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if funnel {
+			req.Header.Set("Tailscale-Funnel-Request", "true")
+		}
+		rr := httptest.NewRecorder()
+		s.serveKeys(rr, req)
+		return rr
+	}
+
+	if rr := serve("POST", "/keys/rotate", true); rr.Code != http.StatusNotFound {
+		t.Errorf("rotate over Funnel: got %d; want 404", rr.Code)
+	}
+	if rr := serve("GET", "/keys/rotate", false); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET rotate: got %d; want 405", rr.Code)
+	}
+
+	rr := serve("POST", "/keys/rotate", false)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("rotate: got %d: %s", rr.Code, rr.Body.String())
+	}
+	var rotated signingKeyInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &rotated); err != nil {
+		t.Fatal(err)
+	}
+	if !rotated.Current || rotated.ID == "1" {
+		t.Errorf("rotated to %+v", rotated)
+	}
+
+	rr = serve("GET", "/keys/", false)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list: got %d: %s", rr.Code, rr.Body.String())
+	}
+	var infos []signingKeyInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].ID != rotated.ID || infos[1].ID != "1" || infos[1].Current || infos[1].Retired.IsZero() {
+		t.Errorf("listed %+v", infos)
+	}
+}
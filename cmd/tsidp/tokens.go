@@ -0,0 +1,607 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+	"tailscale.com/atomicfile"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/rands"
+)
+
+// tokensFile is where issued access and refresh tokens are persisted, so
+// that they survive restarts.
+const tokensFile = "oidc-tokens.json"
+
+// refreshTokenLifetime is how long a refresh token can be exchanged for new
+// tokens. Each exchange rotates it for a new one with a fresh lifetime.
+const refreshTokenLifetime = 30 * 24 * time.Hour
+
+// storedToken is the persisted state of an issued access or refresh token.
+type storedToken struct {
+	ClientID          string                 `json:"client_id"`
+	Family            string                 `json:"family,omitempty"` // refresh token family, see authRequest.refreshFamily
+	RedirectURI       string                 `json:"redirect_uri,omitempty"`
+	LocalRP           bool                   `json:"local_rp,omitempty"`
+	RPNodeID          tailcfg.NodeID         `json:"rp_node_id,omitempty"`
+	RemoteUser        *apitype.WhoIsResponse `json:"remote_user"`
+	ClientCredentials bool                   `json:"client_credentials,omitempty"`
+	ValidTill         time.Time              `json:"valid_till"`
+
+	// Used is whether a refresh token has been exchanged already. Used
+	// tokens are kept until they expire, as their reuse means the token
+	// leaked and revokes its whole family.
+	Used bool `json:"used,omitempty"`
+}
+
+// tokenStore is the format of tokensFile.
+type tokenStore struct {
+	// AccessTokens are keyed by the token itself. They're short-lived,
+	// and stored as-is so that they can be looked up again after a
+	// restart.
+	AccessTokens map[string]*storedToken `json:"access_tokens,omitempty"`
+
+	// RefreshTokens are keyed by hashToken of the token, so that the
+	// long-lived tokens can't be read back from disk.
+	RefreshTokens map[string]*storedToken `json:"refresh_tokens,omitempty"`
+}
+
+// hashToken returns the hex SHA-256 of the token tk, by which issued
+// refresh tokens are stored.
+func hashToken(tk string) string {
+	sum := sha256.Sum256([]byte(tk))
+	return hex.EncodeToString(sum[:])
+}
+
+// newStoredToken returns the state to persist for a token issued for ar,
+// valid until validTill.
+func newStoredToken(ar *authRequest, validTill time.Time) *storedToken {
+	return &storedToken{
+		ClientID:          ar.clientID,
+		Family:            ar.refreshFamily,
+		RedirectURI:       ar.redirectURI,
+		LocalRP:           ar.localRP,
+		RPNodeID:          ar.rpNodeID,
+		RemoteUser:        ar.remoteUser,
+		ClientCredentials: ar.clientCredentials,
+		ValidTill:         validTill,
+	}
+}
+
+// authRequestLocked returns the authRequest that st was issued for. It
+// reports false if that was for a registered client that no longer exists.
+// s.mu must be held.
+func (s *idpServer) authRequestLocked(st *storedToken) (_ *authRequest, ok bool) {
+	ar := &authRequest{
+		localRP:           st.LocalRP,
+		rpNodeID:          st.RPNodeID,
+		clientID:          st.ClientID,
+		redirectURI:       st.RedirectURI,
+		remoteUser:        st.RemoteUser,
+		clientCredentials: st.ClientCredentials,
+		refreshFamily:     st.Family,
+		validTill:         st.ValidTill,
+	}
+	if !s.allowInsecureRegistration || !st.LocalRP && st.RPNodeID == 0 {
+		ar.funnelRP = s.funnelClients[st.ClientID]
+		return ar, ar.funnelRP != nil
+	}
+	return ar, true
+}
+
+// issueRefreshToken returns a new refresh token for ar, in its refresh
+// token family or else a new one, and persists it.
+func (s *idpServer) issueRefreshToken(ar *authRequest, now time.Time) (string, error) {
+	if ar.refreshFamily == "" {
+		ar.refreshFamily = rands.HexString(32)
+	}
+	tk := rands.HexString(64)
+	h := hashToken(tk)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mak.Set(&s.refreshTokens, h, newStoredToken(ar, now.Add(refreshTokenLifetime)))
+	if err := s.storeTokensLocked(); err != nil {
+		delete(s.refreshTokens, h)
+		return "", err
+	}
+	return tk, nil
+}
+
+// revokeRefreshFamilyLocked revokes all refresh tokens in family, and the
+// access tokens issued along with them. s.mu must be held.
+func (s *idpServer) revokeRefreshFamilyLocked(family string) {
+	for h, rt := range s.refreshTokens {
+		if rt.Family == family {
+			delete(s.refreshTokens, h)
+		}
+	}
+	for at, ar := range s.accessToken {
+		if ar.refreshFamily == family {
+			delete(s.accessToken, at)
+		}
+	}
+}
+
+// revokeTokensLocked revokes all access and refresh tokens for which match
+// returns true, persisting the change. It reports how many it revoked.
+// s.mu must be held.
+func (s *idpServer) revokeTokensLocked(match func(clientID string, who *apitype.WhoIsResponse) bool) int {
+	n := 0
+	for at, ar := range s.accessToken {
+		if match(ar.clientID, ar.remoteUser) {
+			delete(s.accessToken, at)
+			n++
+		}
+	}
+	for h, rt := range s.refreshTokens {
+		if match(rt.ClientID, rt.RemoteUser) {
+			delete(s.refreshTokens, h)
+			n++
+		}
+	}
+	if n > 0 {
+		if err := s.storeTokensLocked(); err != nil {
+			log.Printf("could not write tokens db: %v", err)
+		}
+	}
+	return n
+}
+
+// revokeClientTokensLocked revokes all refresh and access tokens issued to
+// the client with ID clientID, such as when it's deleted. s.mu must be
+// held.
+func (s *idpServer) revokeClientTokensLocked(clientID string) {
+	s.revokeTokensLocked(func(id string, _ *apitype.WhoIsResponse) bool {
+		return id == clientID
+	})
+}
+
+// loadTokens reads the tokens persisted by storeTokensLocked, if any. It
+// must be called after the clients they were issued to are loaded. A
+// corrupt store is logged and replaced with an empty one, signing everyone
+// out rather than keeping tsidp from starting.
+func (s *idpServer) loadTokens() error {
+	path, err := getConfigFilePath(s.rootPath, tokensFile)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var ts tokenStore
+	if err := json.Unmarshal(b, &ts); err != nil {
+		log.Printf("could not parse %s, starting with no tokens: %v", path, err)
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for tk, st := range ts.AccessTokens {
+		if ar, ok := s.authRequestLocked(st); ok {
+			mak.Set(&s.accessToken, tk, ar)
+		}
+	}
+	s.refreshTokens = ts.RefreshTokens
+	return nil
+}
+
+// storeTokensLocked writes the unexpired access and refresh tokens to disk,
+// dropping expired ones. s.mu must be held while calling this.
+func (s *idpServer) storeTokensLocked() error {
+	now := time.Now()
+	ts := tokenStore{RefreshTokens: s.refreshTokens}
+	for at, ar := range s.accessToken {
+		if now.After(ar.validTill) {
+			delete(s.accessToken, at)
+			continue
+		}
+		mak.Set(&ts.AccessTokens, at, newStoredToken(ar, ar.validTill))
+	}
+	for h, rt := range s.refreshTokens {
+		if now.After(rt.ValidTill) {
+			delete(s.refreshTokens, h)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(ts); err != nil {
+		return err
+	}
+	path, err := getConfigFilePath(s.rootPath, tokensFile)
+	if err != nil {
+		return fmt.Errorf("storeTokensLocked: %v", err)
+	}
+	return atomicfile.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// lookupTokenLocked returns the state of the access or refresh token tk,
+// trying the token type hint first, as in RFC 7009 and RFC 7662. It returns
+// nil if tk isn't a valid token. s.mu must be held.
+func (s *idpServer) lookupTokenLocked(tk, hint string) (st *storedToken, refresh bool) {
+	lookupAccess := func() *storedToken {
+		if ar, ok := s.accessToken[tk]; ok && time.Now().Before(ar.validTill) {
+			return newStoredToken(ar, ar.validTill)
+		}
+		return nil
+	}
+	lookupRefresh := func() *storedToken {
+		if rt, ok := s.refreshTokens[hashToken(tk)]; ok && !rt.Used && time.Now().Before(rt.ValidTill) {
+			return rt
+		}
+		return nil
+	}
+	if hint == "refresh_token" {
+		if st := lookupRefresh(); st != nil {
+			return st, true
+		}
+		return lookupAccess(), false
+	}
+	if st := lookupAccess(); st != nil {
+		return st, false
+	}
+	if st := lookupRefresh(); st != nil {
+		return st, true
+	}
+	return nil, false
+}
+
+// authenticateTokenClient validates that the introspection request r comes
+// from a client allowed to make it, and returns the client if it carries
+// the credentials of a registered confidential client, as at the token
+// endpoint. Such clients may only introspect the tokens issued to them or
+// to clients that list them as a resource server. Other requests are only
+// allowed from within the tailnet when insecure registration is allowed,
+// and may then only introspect the tokens of the caller's own relying
+// party; for those, it returns a nil client.
+func (s *idpServer) authenticateTokenClient(r *http.Request) (*funnelClient, error) {
+	clientID, clientSecret := clientAuth(r)
+	s.mu.Lock()
+	c, ok := s.funnelClients[clientID]
+	s.mu.Unlock()
+	if ok && !c.Public && subtle.ConstantTimeCompare([]byte(clientSecret), []byte(c.Secret)) == 1 {
+		return c, nil
+	}
+	if s.allowInsecureRegistration && !isFunnelRequest(r) {
+		return nil, nil
+	}
+	return nil, errors.New("tsidp: invalid client credentials")
+}
+
+// introspectionResponse is the response of the introspection endpoint, as
+// in RFC 7662, section 2.2.
+type introspectionResponse struct {
+	Active    bool           `json:"active"`
+	ClientID  string         `json:"client_id,omitempty"`
+	TokenType string         `json:"token_type,omitempty"`
+	Exp       int64          `json:"exp,omitempty"`
+	Sub       string         `json:"sub,omitempty"`
+	Aud       string         `json:"aud,omitempty"`
+	Iss       string         `json:"iss,omitempty"`
+	UserName  string         `json:"username,omitempty"`
+	Email     string         `json:"email,omitempty"`
+	NodeID    tailcfg.NodeID `json:"nid,omitempty"`
+	Tags      []string       `json:"tags,omitempty"`
+}
+
+// serveIntrospect implements OAuth 2.0 Token Introspection (RFC 7662), for
+// resource servers to check the tokens presented to them.
+func (s *idpServer) serveIntrospect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c, err := s.authenticateTokenClient(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	tk := r.FormValue("token")
+	if tk == "" {
+		http.Error(w, "tsidp: token is required", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	st, refresh := s.lookupTokenLocked(tk, r.FormValue("token_type_hint"))
+	var ar *authRequest
+	if st != nil {
+		ar, _ = s.authRequestLocked(st)
+	}
+	s.mu.Unlock()
+	// Callers can't learn anything about the tokens of other clients, not
+	// even whether they're active.
+	if st != nil && c != nil && c.ID != st.ClientID && (ar.funnelRP == nil || !slices.Contains(ar.funnelRP.ResourceServers, c.ID)) {
+		st = nil
+	}
+	if st != nil && c == nil && ar.allowRelyingParty(r, s.lc) != nil {
+		st = nil
+	}
+
+	resp := introspectionResponse{Active: st != nil}
+	if st != nil {
+		resp.ClientID = st.ClientID
+		resp.Aud = st.ClientID
+		resp.Exp = st.ValidTill.Unix()
+		resp.Iss = s.serverURL
+		if st.LocalRP {
+			resp.Iss = s.loopbackURL
+		}
+		resp.TokenType = "Bearer"
+		if refresh {
+			resp.TokenType = "refresh_token"
+		}
+		if who := st.RemoteUser; who != nil && who.Node != nil {
+			resp.NodeID = who.Node.ID
+			if st.ClientCredentials {
+				resp.Sub = string(who.Node.StableID)
+				resp.Tags = who.Node.Tags
+			} else if who.UserProfile != nil {
+				resp.Sub = who.Node.User.String()
+				resp.Email = who.UserProfile.LoginName
+				resp.UserName, _, _ = strings.Cut(who.UserProfile.LoginName, "@")
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// serveRevoke implements OAuth 2.0 Token Revocation (RFC 7009). Revoking a
+// refresh token also revokes the rest of its family and the access tokens
+// issued with them.
+func (s *idpServer) serveRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tk := r.FormValue("token")
+	if tk == "" {
+		http.Error(w, "tsidp: token is required", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	st, refresh := s.lookupTokenLocked(tk, r.FormValue("token_type_hint"))
+	var ar *authRequest
+	if st != nil {
+		ar, _ = s.authRequestLocked(st)
+	}
+	s.mu.Unlock()
+	if st == nil {
+		// Invalid tokens need no revoking, and per RFC 7009 aren't an
+		// error.
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Only the client the token was issued to may revoke it, authenticated
+	// as it is to use the token.
+	if status, err := s.authenticateRelyingParty(r, ar); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	s.mu.Lock()
+	if refresh {
+		s.revokeRefreshFamilyLocked(st.Family)
+	} else {
+		delete(s.accessToken, tk)
+	}
+	err := s.storeTokensLocked()
+	s.mu.Unlock()
+	if err != nil {
+		log.Printf("could not write tokens db: %v", err)
+		http.Error(w, "tsidp: could not write tokens to db", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// endSessionTmpl is the page on which the visitor confirms signing out at
+// /end_session when the relying party sent no id_token_hint.
+var endSessionTmpl = template.Must(template.New("end_session").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <title>Sign out - Tailscale OIDC Identity Provider</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+  </head>
+  <body>
+    <form method="POST" action="/end_session">
+      <p>Sign out{{with .ClientName}} of {{.}}{{end}}?</p>
+      {{range $k, $v := .Fields}}<input type="hidden" name="{{$k}}" value="{{$v}}" />
+      {{end}}<button type="submit" name="confirm" value="true">Sign out</button>
+    </form>
+  </body>
+</html>
+`))
+
+// serveEndSession implements OpenID Connect RP-Initiated Logout. tsidp has
+// no login sessions of its own, as users are identified by their node, so
+// ending a session revokes the tokens issued to the user, for the client
+// named by the id_token_hint or client_id if any. The user is the subject
+// of the id_token_hint or else the visitor, who must then confirm signing
+// out by POSTing the form that a request without a hint gets, so that a
+// link or embedded image can't sign them out.
+func (s *idpServer) serveEndSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Like /authorize, this is visited by the user, who's in the tailnet.
+	if isFunnelRequest(r) {
+		http.Error(w, "tsidp: unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	clientID := r.FormValue("client_id")
+	hint := r.FormValue("id_token_hint")
+	confirmed := r.Method == "POST" && r.PostFormValue("confirm") == "true"
+	if confirmed && r.Header.Get("Sec-Fetch-Site") != "" && r.Header.Get("Sec-Fetch-Site") != "same-origin" {
+		http.Error(w, "tsidp: sign-out must be confirmed on tsidp's own page", http.StatusForbidden)
+		return
+	}
+	var userID tailcfg.UserID
+	if hint != "" {
+		claims, err := s.parseIDTokenHint(hint)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if clientID == "" && len(claims.Audience) == 1 {
+			clientID = claims.Audience[0]
+		} else if clientID != "" && !claims.Audience.Contains(clientID) {
+			http.Error(w, "tsidp: client_id doesn't match id_token_hint", http.StatusBadRequest)
+			return
+		}
+		userID = claims.UserID
+	} else {
+		who, err := s.lc.WhoIs(r.Context(), s.remoteAddr(r))
+		if err != nil {
+			log.Printf("Error getting WhoIs: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		userID = who.Node.User
+	}
+	if userID.IsZero() {
+		http.Error(w, "tsidp: no user to sign out", http.StatusBadRequest)
+		return
+	}
+
+	postLogoutRedirect := r.FormValue("post_logout_redirect_uri")
+	s.mu.Lock()
+	redirectOK := s.postLogoutRedirectAllowedLocked(clientID, postLogoutRedirect)
+	if postLogoutRedirect != "" && !redirectOK {
+		s.mu.Unlock()
+		http.Error(w, "tsidp: post_logout_redirect_uri not registered for client", http.StatusBadRequest)
+		return
+	}
+	if hint == "" && !confirmed {
+		var clientName string
+		if c, ok := s.funnelClients[clientID]; ok {
+			clientName = c.Name
+		}
+		s.mu.Unlock()
+		fields := map[string]string{}
+		for _, k := range []string{"client_id", "post_logout_redirect_uri", "state"} {
+			if v := r.FormValue(k); v != "" {
+				fields[k] = v
+			}
+		}
+		var buf bytes.Buffer
+		if err := endSessionTmpl.Execute(&buf, struct {
+			ClientName string
+			Fields     map[string]string
+		}{clientName, fields}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		buf.WriteTo(w)
+		return
+	}
+	n := s.revokeTokensLocked(func(id string, who *apitype.WhoIsResponse) bool {
+		return (clientID == "" || id == clientID) && who != nil && who.Node != nil && who.Node.User == userID && !who.Node.IsTagged()
+	})
+	s.mu.Unlock()
+	log.Printf("Ended session of user %v for client %q, revoking %d tokens", userID, clientID, n)
+
+	if postLogoutRedirect == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "You have been signed out.")
+		return
+	}
+	u, err := url.Parse(postLogoutRedirect)
+	if err != nil {
+		http.Error(w, "tsidp: invalid post_logout_redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if state := r.FormValue("state"); state != "" {
+		q := u.Query()
+		q.Set("state", state)
+		u.RawQuery = q.Encode()
+	}
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// parseIDTokenHint returns the claims of the ID token hint, which must have
+// been signed by us with a key we still publish. It may have expired.
+func (s *idpServer) parseIDTokenHint(hint string) (*tailscaleClaims, error) {
+	tok, err := jwt.ParseSigned(hint)
+	if err != nil {
+		return nil, fmt.Errorf("tsidp: invalid id_token_hint: %w", err)
+	}
+	keys, err := s.oidcPublicKeys()
+	if err != nil {
+		return nil, err
+	}
+	var sk *signingKey
+	for _, k := range keys {
+		if len(tok.Headers) > 0 && tok.Headers[0].KeyID == fmt.Sprint(k.kid) {
+			sk = k
+			break
+		}
+	}
+	if sk == nil {
+		return nil, errors.New("tsidp: id_token_hint signed with unknown key")
+	}
+	var claims tailscaleClaims
+	if err := tok.Claims(sk.k.Public(), &claims); err != nil {
+		return nil, fmt.Errorf("tsidp: invalid id_token_hint: %w", err)
+	}
+	if claims.Issuer != s.serverURL && (s.loopbackURL == "" || claims.Issuer != s.loopbackURL) {
+		return nil, errors.New("tsidp: id_token_hint from another issuer")
+	}
+	return &claims, nil
+}
+
+// postLogoutRedirectAllowedLocked reports whether /end_session may
+// redirect to uri after signing the user out of the client clientID. uri
+// must exactly match one of the post-logout redirect URIs registered for
+// the client or, for relying parties in the tailnet that aren't registered,
+// a redirect URI the client was issued tokens for. s.mu must be held.
+func (s *idpServer) postLogoutRedirectAllowedLocked(clientID, uri string) bool {
+	if uri == "" {
+		return false
+	}
+	if c, ok := s.funnelClients[clientID]; ok {
+		return slices.Contains(c.PostLogoutRedirectURIs, uri)
+	}
+	for _, ar := range s.accessToken {
+		if ar.clientID == clientID && ar.redirectURI == uri {
+			return true
+		}
+	}
+	for _, rt := range s.refreshTokens {
+		if rt.ClientID == clientID && rt.RedirectURI == uri {
+			return true
+		}
+	}
+	return false
+}
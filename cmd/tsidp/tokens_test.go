@@ -0,0 +1,383 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// issueTestTokens runs the authorization code grant for a test user with
+// the test-client set up by setupTestServer, returning the tokens issued.
+func issueTestTokens(t *testing.T, s *idpServer) oidcTokenResponse {
+	t.Helper()
+	s.code["code"] = &authRequest{
+		clientID:    "test-client",
+		redirectURI: "https://rp.example.com/callback",
+		remoteUser:  testRemoteUser(),
+		funnelRP:    s.funnelClients["test-client"],
+	}
+	return decodeTokenResponse(t, postToken(s, "127.0.0.1:12345", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"code"},
+		"redirect_uri":  {"https://rp.example.com/callback"},
+		"client_id":     {"test-client"},
+		"client_secret": {"test-secret"},
+	}))
+}
+
+// postForm posts form to handler h as the test-client if auth.
+func postForm(h http.HandlerFunc, path string, form url.Values, auth bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.RemoteAddr = "127.0.0.1:12345"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if auth {
+		req.SetBasicAuth("test-client", "test-secret")
+	}
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	return rr
+}
+
+func TestTokenPersistence(t *testing.T) {
+	s := setupTestServer(t, true)
+	tokens := issueTestTokens(t, s)
+
+	s2 := setupTestServer(t, true)
+	s2.rootPath = s.rootPath
+	if err := s2.loadTokens(); err != nil {
+		t.Fatal(err)
+	}
+	ar, ok := s2.accessToken[tokens.AccessToken]
+	if !ok {
+		t.Fatal("access token not loaded")
+	}
+	if ar.clientID != "test-client" || ar.funnelRP != s2.funnelClients["test-client"] || ar.remoteUser.UserProfile.LoginName != "alice@example.com" {
+		t.Errorf("loaded access token for %+v", ar)
+	}
+	if len(s2.refreshTokens) != 1 {
+		t.Errorf("loaded %d refresh tokens; want 1", len(s2.refreshTokens))
+	}
+
+	// Tokens of deleted clients aren't loaded.
+	s3 := setupTestServer(t, true)
+	s3.rootPath = s.rootPath
+	delete(s3.funnelClients, "test-client")
+	if err := s3.loadTokens(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s3.accessToken) != 0 {
+		t.Errorf("loaded access token of deleted client")
+	}
+}
+
+func TestLoadCorruptTokens(t *testing.T) {
+	s := setupTestServer(t, true)
+	path, err := getConfigFilePath(s.rootPath, tokensFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"access_tokens":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.loadTokens(); err != nil {
+		t.Fatalf("loading corrupt store: %v", err)
+	}
+	if len(s.accessToken) != 0 || len(s.refreshTokens) != 0 {
+		t.Errorf("loaded tokens from corrupt store")
+	}
+
+	// The next write replaces the corrupt store.
+	tokens := issueTestTokens(t, s)
+	s2 := setupTestServer(t, true)
+	s2.rootPath = s.rootPath
+	if err := s2.loadTokens(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s2.accessToken[tokens.AccessToken]; !ok {
+		t.Error("access token not loaded after rewriting corrupt store")
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	s := setupTestServer(t, true)
+	tokens := issueTestTokens(t, s)
+
+	introspect := func(tk, hint string) introspectionResponse {
+		t.Helper()
+		rr := postForm(s.serveIntrospect, "/introspect", url.Values{"token": {tk}, "token_type_hint": {hint}}, true)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp introspectionResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if rr := postForm(s.serveIntrospect, "/introspect", url.Values{"token": {tokens.AccessToken}}, false); rr.Code != http.StatusUnauthorized {
+		t.Errorf("without client credentials: got %d; want 401", rr.Code)
+	}
+
+	got := introspect(tokens.AccessToken, "")
+	if !got.Active || got.TokenType != "Bearer" || got.ClientID != "test-client" || got.Sub != "userid:456" || got.UserName != "alice" || got.NodeID != 123 {
+		t.Errorf("access token: got %+v", got)
+	}
+	got = introspect(tokens.RefreshToken, "refresh_token")
+	if !got.Active || got.TokenType != "refresh_token" {
+		t.Errorf("refresh token: got %+v", got)
+	}
+	// The hint is only a hint.
+	if got := introspect(tokens.RefreshToken, "access_token"); !got.Active {
+		t.Errorf("refresh token with wrong hint: got %+v", got)
+	}
+	if got := introspect("bogus", ""); got.Active || got.ClientID != "" {
+		t.Errorf("unknown token: got %+v", got)
+	}
+
+	// Other clients may only introspect the token once test-client lists
+	// them as a resource server.
+	s.funnelClients["api"] = &funnelClient{ID: "api", Secret: "api-secret"}
+	introspectAsAPI := func() introspectionResponse {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/introspect", strings.NewReader(url.Values{"token": {tokens.AccessToken}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("api", "api-secret")
+		rr := httptest.NewRecorder()
+		s.serveIntrospect(rr, req)
+		var resp introspectionResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%d: %v", rr.Code, err)
+		}
+		return resp
+	}
+	if got := introspectAsAPI(); got.Active || got.Sub != "" {
+		t.Errorf("other client: got %+v; want inactive", got)
+	}
+	s.funnelClients["test-client"].ResourceServers = []string{"api"}
+	if got := introspectAsAPI(); !got.Active || got.ClientID != "test-client" {
+		t.Errorf("resource server: got %+v; want active", got)
+	}
+}
+
+func TestIntrospectInsecure(t *testing.T) {
+	rpNode := testRemoteUser().Node
+	lc := whoIsTestClient(func(addr string) *apitype.WhoIsResponse {
+		switch addr {
+		case "100.64.0.1:12345":
+			return &apitype.WhoIsResponse{Node: rpNode}
+		case "100.64.0.2:12345":
+			return &apitype.WhoIsResponse{Node: &tailcfg.Node{ID: 789}}
+		}
+		return nil
+	})
+	s := setupTestServerWithClient(t, false, lc)
+	s.accessToken["rp-token"] = &authRequest{
+		clientID:   "rp",
+		rpNodeID:   rpNode.ID,
+		remoteUser: testRemoteUser(),
+		validTill:  time.Now().Add(time.Hour),
+	}
+
+	introspect := func(remoteAddr string, auth bool) introspectionResponse {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/introspect", strings.NewReader(url.Values{"token": {"rp-token"}}.Encode()))
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if auth {
+			req.SetBasicAuth("test-client", "test-secret")
+		}
+		rr := httptest.NewRecorder()
+		s.serveIntrospect(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp introspectionResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if got := introspect("100.64.0.1:12345", false); !got.Active {
+		t.Errorf("relying party without credentials: got %+v; want active", got)
+	}
+	if got := introspect("100.64.0.2:12345", false); got.Active || got.Sub != "" {
+		t.Errorf("other node without credentials: got %+v; want inactive", got)
+	}
+	if got := introspect("100.64.0.2:12345", true); got.Active {
+		t.Errorf("other node with another client's credentials: got %+v; want inactive", got)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	s := setupTestServer(t, true)
+	tokens := issueTestTokens(t, s)
+
+	// Unknown tokens aren't an error.
+	if rr := postForm(s.serveRevoke, "/revoke", url.Values{"token": {"bogus"}}, true); rr.Code != http.StatusOK {
+		t.Errorf("unknown token: got %d; want 200", rr.Code)
+	}
+	// Only the client the token was issued to can revoke it.
+	rr := postForm(s.serveRevoke, "/revoke", url.Values{
+		"token":         {tokens.AccessToken},
+		"client_id":     {"test-client"},
+		"client_secret": {"wrong"},
+	}, false)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: got %d; want 401", rr.Code)
+	}
+
+	if rr := postForm(s.serveRevoke, "/revoke", url.Values{"token": {tokens.AccessToken}}, true); rr.Code != http.StatusOK {
+		t.Fatalf("revoking access token: got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := s.accessToken[tokens.AccessToken]; ok {
+		t.Error("access token not revoked")
+	}
+	if len(s.refreshTokens) != 1 {
+		t.Error("revoking access token revoked refresh token")
+	}
+
+	tokens = issueTestTokens(t, s)
+	if rr := postForm(s.serveRevoke, "/revoke", url.Values{"token": {tokens.RefreshToken}, "token_type_hint": {"refresh_token"}}, true); rr.Code != http.StatusOK {
+		t.Fatalf("revoking refresh token: got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := s.refreshTokens[hashToken(tokens.RefreshToken)]; ok {
+		t.Error("refresh token not revoked")
+	}
+	if _, ok := s.accessToken[tokens.AccessToken]; ok {
+		t.Error("access token issued with refresh token not revoked")
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	newServer := func(t *testing.T) (*idpServer, oidcTokenResponse) {
+		s := setupTestServer(t, true)
+		s.funnelClients["test-client"].PostLogoutRedirectURIs = []string{"https://rp.example.com/signed-out"}
+		return s, issueTestTokens(t, s)
+	}
+	endSession := func(s *idpServer, q url.Values, funnel bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/end_session?"+q.Encode(), nil)
+		req.RemoteAddr = "127.0.0.1:12345"
+		if funnel {
+			req.Header.Set("Tailscale-Funnel-Request", "true")
+		}
+		rr := httptest.NewRecorder()
+		s.serveEndSession(rr, req)
+		return rr
+	}
+
+	t.Run("redirect", func(t *testing.T) {
+		s, tokens := newServer(t)
+		rr := endSession(s, url.Values{
+			"id_token_hint":            {tokens.IDToken},
+			"post_logout_redirect_uri": {"https://rp.example.com/signed-out"},
+			"state":                    {"xyz"},
+		}, false)
+		if rr.Code != http.StatusFound {
+			t.Fatalf("got %d: %s; want 302", rr.Code, rr.Body.String())
+		}
+		if got, want := rr.Header().Get("Location"), "https://rp.example.com/signed-out?state=xyz"; got != want {
+			t.Errorf("redirected to %q; want %q", got, want)
+		}
+		if len(s.accessToken) != 0 || len(s.refreshTokens) != 0 {
+			t.Errorf("tokens not revoked: %d access, %d refresh", len(s.accessToken), len(s.refreshTokens))
+		}
+	})
+
+	t.Run("unregistered_redirect", func(t *testing.T) {
+		s, tokens := newServer(t)
+		rr := endSession(s, url.Values{
+			"id_token_hint":            {tokens.IDToken},
+			"post_logout_redirect_uri": {"https://evil.example.com/"},
+		}, false)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("got %d; want 400", rr.Code)
+		}
+		if len(s.accessToken) != 1 {
+			t.Errorf("tokens revoked despite unregistered redirect")
+		}
+	})
+
+	t.Run("same_origin_redirect", func(t *testing.T) {
+		// Only registered URIs are allowed, not any on the same origin.
+		s, tokens := newServer(t)
+		rr := endSession(s, url.Values{
+			"id_token_hint":            {tokens.IDToken},
+			"post_logout_redirect_uri": {"https://rp.example.com/signed-out/../../elsewhere"},
+		}, false)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("got %d; want 400", rr.Code)
+		}
+	})
+
+	t.Run("bad_hint", func(t *testing.T) {
+		s, _ := newServer(t)
+		rr := endSession(s, url.Values{"id_token_hint": {"not.a.jwt"}}, false)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("got %d; want 400", rr.Code)
+		}
+		if len(s.accessToken) != 1 {
+			t.Errorf("tokens revoked for invalid hint")
+		}
+	})
+
+	t.Run("no_hint", func(t *testing.T) {
+		// Without an id_token_hint, the visitor must confirm signing out
+		// on a form that POSTs back.
+		lc := whoIsTestClient(func(addr string) *apitype.WhoIsResponse {
+			return testRemoteUser()
+		})
+		s := setupTestServerWithClient(t, true, lc)
+		issueTestTokens(t, s)
+		q := url.Values{"client_id": {"test-client"}, "state": {"xyz"}}
+		rr := endSession(s, q, false)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `method="POST"`) || !strings.Contains(rr.Body.String(), `value="xyz"`) {
+			t.Fatalf("GET: got %d: %s; want confirmation form", rr.Code, rr.Body.String())
+		}
+		if len(s.accessToken) != 1 {
+			t.Fatal("tokens revoked by GET without id_token_hint")
+		}
+
+		confirm := func(secFetchSite string) *httptest.ResponseRecorder {
+			q := url.Values{"client_id": {"test-client"}, "confirm": {"true"}}
+			req := httptest.NewRequest("POST", "/end_session", strings.NewReader(q.Encode()))
+			req.RemoteAddr = "127.0.0.1:12345"
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if secFetchSite != "" {
+				req.Header.Set("Sec-Fetch-Site", secFetchSite)
+			}
+			rr := httptest.NewRecorder()
+			s.serveEndSession(rr, req)
+			return rr
+		}
+		if rr := confirm("cross-site"); rr.Code != http.StatusForbidden || len(s.accessToken) != 1 {
+			t.Fatalf("cross-site POST: got %d, %d tokens left; want 403 and no revocation", rr.Code, len(s.accessToken))
+		}
+		if rr := confirm("same-origin"); rr.Code != http.StatusOK {
+			t.Fatalf("confirming POST: got %d: %s", rr.Code, rr.Body.String())
+		}
+		if len(s.accessToken) != 0 || len(s.refreshTokens) != 0 {
+			t.Errorf("tokens not revoked: %d access, %d refresh", len(s.accessToken), len(s.refreshTokens))
+		}
+	})
+
+	t.Run("funnel", func(t *testing.T) {
+		s, tokens := newServer(t)
+		rr := endSession(s, url.Values{"id_token_hint": {tokens.IDToken}}, true)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("got %d; want 401", rr.Code)
+		}
+	})
+}
@@ -13,11 +13,8 @@
 	"crypto/rsa"
 	"crypto/subtle"
 	"crypto/tls"
-	"crypto/x509"
-	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"flag"
 	"fmt"
@@ -79,6 +76,8 @@ type ctxConn struct{
 	flagFunnel                        = flag.Bool("funnel", false, "use Tailscale Funnel to make tsidp available on the public internet")
 	flagHostname                      = flag.String("hostname", "idp", "tsnet hostname to use instead of idp")
 	flagDir                           = flag.String("dir", "", "tsnet state directory; a default one will be created if not provided")
+	flagKeyRotationInterval           = flag.Duration("key-rotation-interval", 0, "if non-zero, how often to rotate the OIDC signing key")
+	flagKeyOverlap                    = flag.Duration("key-overlap", 24*time.Hour, "how long a rotated-out OIDC signing key stays published in the JWKS")
 	flagAllowInsecureRegistrationBool opt.Bool
 	flagAllowInsecureRegistration     = opt.BoolFlag{Bool: &flagAllowInsecureRegistrationBool}
 )
@@ -101,6 +100,13 @@ func main() {
 	if !envknob.UseWIPCode() {
 		log.Fatal("cmd/tsidp is a work in progress and has not been security reviewed;\nits use requires TAILSCALE_USE_WIP_CODE=1 be set in the environment for now.")
 	}
+	if *flagKeyRotationInterval < 0 {
+		log.Fatal("-key-rotation-interval must not be negative")
+	}
+	// Tokens must stay verifiable until they expire.
+	if *flagKeyOverlap < 5*time.Minute {
+		log.Fatal("-key-overlap must be at least 5m, the lifetime of ID tokens")
+	}
 
 	var (
 		lc          *local.Client
@@ -198,6 +204,8 @@ func main() {
 		localTSMode:               *flagUseLocalTailscaled,
 		rootPath:                  rootPath,
 		allowInsecureRegistration: getAllowInsecureRegistration(),
+		keyRotationInterval:       *flagKeyRotationInterval,
+		keyOverlap:                *flagKeyOverlap,
 	}
 
 	if *flagPort != 443 {
@@ -231,6 +239,13 @@ func main() {
 		log.Fatalf("could not open %s: %v", clientsFilePath, err)
 	}
 
+	if err := srv.loadTokens(); err != nil {
+		log.Fatalf("could not load tokens: %v", err)
+	}
+	if srv.keyRotationInterval != 0 {
+		go srv.runKeyRotation(ctx)
+	}
+
 	log.Printf("Running tsidp at %s ...", srv.serverURL)
 
 	if *flagLocalPort != -1 {
@@ -343,14 +358,20 @@ type idpServer struct {
 	rootPath                  string // root path, used for storing state files
 	allowInsecureRegistration bool   // If true, allow OAuth without pre-registered clients
 
-	lazyMux        lazy.SyncValue[*http.ServeMux]
-	lazySigningKey lazy.SyncValue[*signingKey]
-	lazySigner     lazy.SyncValue[jose.Signer]
+	keyRotationInterval time.Duration // if non-zero, how often to rotate the signing key
+	keyOverlap          time.Duration // how long retired signing keys stay published
+
+	lazyMux lazy.SyncValue[*http.ServeMux]
+
+	keyMu       sync.Mutex   // guards the fields below
+	signingKeys *signingKeys // nil until loaded by signingKeysLocked
+	signer      jose.Signer  // signs with signingKeys.current; nil until needed
 
 	mu            sync.Mutex               // guards the fields below
 	code          map[string]*authRequest  // keyed by random hex
 	accessToken   map[string]*authRequest  // keyed by random hex
 	funnelClients map[string]*funnelClient // keyed by client ID
+	refreshTokens map[string]*storedToken  // keyed by hashToken of the token
 }
 
 type authRequest struct {
@@ -378,9 +399,22 @@ type authRequest struct {
 	// redirectURI is the redirect_uri presented in the request.
 	redirectURI string
 
-	// remoteUser is the user who is being authenticated.
+	// remoteUser is the user who is being authenticated, or for the
+	// client_credentials grant, the tagged node requesting a token.
 	remoteUser *apitype.WhoIsResponse
 
+	// clientCredentials is true if the request is a client_credentials
+	// grant by a tagged node, so there's no user being authenticated.
+	clientCredentials bool
+
+	// codeChallenge is the S256 PKCE code_challenge presented in the
+	// request, if any.
+	codeChallenge string
+
+	// refreshFamily identifies the chain of rotated refresh tokens issued
+	// for this request, if any.
+	refreshFamily string
+
 	// validTill is the time until which the token is valid.
 	// As of 2023-11-14, it is 5 minutes.
 	// TODO: add routine to delete expired tokens.
@@ -408,6 +442,13 @@ func (ar *authRequest) allowRelyingParty(r *http.Request, lc *local.Client) erro
 			clientSecret = r.FormValue("client_secret")
 		}
 		clientIDcmp := subtle.ConstantTimeCompare([]byte(clientID), []byte(ar.funnelRP.ID))
+		if ar.funnelRP.Public {
+			// Public clients have no secret; PKCE was required instead.
+			if clientIDcmp != 1 {
+				return fmt.Errorf("tsidp: invalid client credentials")
+			}
+			return nil
+		}
 		clientSecretcmp := subtle.ConstantTimeCompare([]byte(clientSecret), []byte(ar.funnelRP.Secret))
 		if clientIDcmp != 1 || clientSecretcmp != 1 {
 			return fmt.Errorf("tsidp: invalid client credentials")
@@ -448,6 +489,12 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	codeChallenge, err := parseCodeChallenge(uq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if !s.allowInsecureRegistration {
 		// When insecure registration is NOT allowed, validate client_id exists but defer client_secret validation to token endpoint
 		// This follows RFC 6749 which specifies client authentication should occur at token endpoint, not authorization endpoint
@@ -473,6 +520,11 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if c.Public && codeChallenge == "" {
+			http.Error(w, "tsidp: public clients must use PKCE", http.StatusBadRequest)
+			return
+		}
+
 		// Get user information
 		var remoteAddr string
 		if s.localTSMode {
@@ -493,11 +545,12 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 
 		code := rands.HexString(32)
 		ar := &authRequest{
-			nonce:       uq.Get("nonce"),
-			remoteUser:  who,
-			redirectURI: redirectURI,
-			clientID:    clientID,
-			funnelRP:    c, // Store the validated client
+			nonce:         uq.Get("nonce"),
+			remoteUser:    who,
+			redirectURI:   redirectURI,
+			clientID:      clientID,
+			funnelRP:      c, // Store the validated client
+			codeChallenge: codeChallenge,
 		}
 
 		s.mu.Lock()
@@ -540,10 +593,11 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 
 	code := rands.HexString(32)
 	ar := &authRequest{
-		nonce:       uq.Get("nonce"),
-		remoteUser:  who,
-		redirectURI: redirectURI,
-		clientID:    clientID,
+		nonce:         uq.Get("nonce"),
+		remoteUser:    who,
+		redirectURI:   redirectURI,
+		clientID:      clientID,
+		codeChallenge: codeChallenge,
 	}
 
 	if r.URL.Path == "/authorize/funnel" {
@@ -558,6 +612,10 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "tsidp: redirect_uri mismatch", http.StatusBadRequest)
 			return
 		}
+		if c.Public && codeChallenge == "" {
+			http.Error(w, "tsidp: public clients must use PKCE", http.StatusBadRequest)
+			return
+		}
 		ar.funnelRP = c
 	} else if r.URL.Path == "/authorize/localhost" {
 		ar.localRP = true
@@ -604,7 +662,11 @@ func (s *idpServer) newMux() *http.ServeMux {
 	}
 	mux.HandleFunc("/userinfo", s.serveUserInfo)
 	mux.HandleFunc("/token", s.serveToken)
+	mux.HandleFunc("/introspect", s.serveIntrospect)
+	mux.HandleFunc("/revoke", s.serveRevoke)
+	mux.HandleFunc("/end_session", s.serveEndSession)
 	mux.HandleFunc("/clients/", s.serveClients)
+	mux.HandleFunc("/keys/", s.serveKeys)
 	mux.HandleFunc("/", s.handleUI)
 	return mux
 }
@@ -846,10 +908,19 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if r.FormValue("grant_type") != "authorization_code" {
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		s.serveAuthorizationCodeGrant(w, r)
+	case "refresh_token":
+		s.serveRefreshTokenGrant(w, r)
+	case "client_credentials":
+		s.serveClientCredentialsGrant(w, r)
+	default:
 		http.Error(w, "tsidp: grant_type not supported", http.StatusBadRequest)
-		return
 	}
+}
+
+func (s *idpServer) serveAuthorizationCodeGrant(w http.ResponseWriter, r *http.Request) {
 	code := r.FormValue("code")
 	if code == "" {
 		http.Error(w, "tsidp: code is required", http.StatusBadRequest)
@@ -866,61 +937,88 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !s.allowInsecureRegistration {
-		// When insecure registration is NOT allowed, always validate client credentials regardless of request source
-		clientID := r.FormValue("client_id")
-		clientSecret := r.FormValue("client_secret")
-
-		// Try basic auth if form values are empty
-		if clientID == "" || clientSecret == "" {
-			if basicClientID, basicClientSecret, ok := r.BasicAuth(); ok {
-				if clientID == "" {
-					clientID = basicClientID
-				}
-				if clientSecret == "" {
-					clientSecret = basicClientSecret
-				}
-			}
-		}
-
-		if clientID == "" || clientSecret == "" {
-			http.Error(w, "tsidp: client credentials required in when insecure registration is not allowed", http.StatusUnauthorized)
-			return
-		}
-
-		// Validate against the stored auth request
-		if ar.clientID != clientID {
-			http.Error(w, "tsidp: client_id mismatch", http.StatusBadRequest)
-			return
-		}
+	if status, err := s.authenticateRelyingParty(r, ar); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
 
-		// Validate client credentials against stored clients
-		if ar.funnelRP == nil {
-			http.Error(w, "tsidp: no client information found", http.StatusBadRequest)
-			return
-		}
+	if ar.redirectURI != r.FormValue("redirect_uri") {
+		http.Error(w, "tsidp: redirect_uri mismatch", http.StatusBadRequest)
+		return
+	}
+	if err := verifyCodeVerifier(ar.codeChallenge, r.FormValue("code_verifier")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.issueTokens(w, ar, true)
+}
 
-		clientIDcmp := subtle.ConstantTimeCompare([]byte(clientID), []byte(ar.funnelRP.ID))
-		clientSecretcmp := subtle.ConstantTimeCompare([]byte(clientSecret), []byte(ar.funnelRP.Secret))
-		if clientIDcmp != 1 || clientSecretcmp != 1 {
-			http.Error(w, "tsidp: invalid client credentials", http.StatusUnauthorized)
-			return
-		}
-	} else {
+// authenticateRelyingParty validates that the token request r comes from
+// the relying party that ar was issued to. On failure, it returns the HTTP
+// status code to reply with.
+func (s *idpServer) authenticateRelyingParty(r *http.Request, ar *authRequest) (status int, err error) {
+	if s.allowInsecureRegistration {
 		// Original behavior when insecure registration is allowed
 		// Only checks ClientID and Client Secret when over funnel.
 		// Local connections are allowed and tailnet connections only check matching nodeIDs.
 		if err := ar.allowRelyingParty(r, s.lc); err != nil {
 			log.Printf("Error allowing relying party: %v", err)
-			http.Error(w, err.Error(), http.StatusForbidden)
-			return
+			return http.StatusForbidden, err
 		}
+		return http.StatusOK, nil
 	}
 
-	if ar.redirectURI != r.FormValue("redirect_uri") {
-		http.Error(w, "tsidp: redirect_uri mismatch", http.StatusBadRequest)
-		return
+	// When insecure registration is NOT allowed, always validate client credentials regardless of request source
+	clientID, clientSecret := clientAuth(r)
+	public := ar.funnelRP != nil && ar.funnelRP.Public
+	if clientID == "" || (clientSecret == "" && !public) {
+		return http.StatusUnauthorized, errors.New("tsidp: client credentials required in when insecure registration is not allowed")
+	}
+
+	// Validate against the stored auth request
+	if ar.clientID != clientID {
+		return http.StatusBadRequest, errors.New("tsidp: client_id mismatch")
+	}
+
+	// Validate client credentials against stored clients
+	if ar.funnelRP == nil {
+		return http.StatusBadRequest, errors.New("tsidp: no client information found")
+	}
+
+	clientIDcmp := subtle.ConstantTimeCompare([]byte(clientID), []byte(ar.funnelRP.ID))
+	clientSecretcmp := 1 // public clients have no secret; PKCE was required instead
+	if !public {
+		clientSecretcmp = subtle.ConstantTimeCompare([]byte(clientSecret), []byte(ar.funnelRP.Secret))
+	}
+	if clientIDcmp != 1 || clientSecretcmp != 1 {
+		return http.StatusUnauthorized, errors.New("tsidp: invalid client credentials")
 	}
+	return http.StatusOK, nil
+}
+
+// clientAuth returns the client ID and secret of the token request r, from
+// its form values or else its HTTP Basic authentication.
+func clientAuth(r *http.Request) (clientID, clientSecret string) {
+	clientID = r.FormValue("client_id")
+	clientSecret = r.FormValue("client_secret")
+
+	// Try basic auth if form values are empty
+	if clientID == "" || clientSecret == "" {
+		if basicClientID, basicClientSecret, ok := r.BasicAuth(); ok {
+			if clientID == "" {
+				clientID = basicClientID
+			}
+			if clientSecret == "" {
+				clientSecret = basicClientSecret
+			}
+		}
+	}
+	return clientID, clientSecret
+}
+
+// issueTokens replies to the token request for ar with new ID and access
+// tokens, and a new refresh token if issueRefresh.
+func (s *idpServer) issueTokens(w http.ResponseWriter, ar *authRequest, issueRefresh bool) {
 	signer, err := s.oidcSigner()
 	if err != nil {
 		log.Printf("Error getting signer: %v", err)
@@ -933,8 +1031,12 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 	// TODO(maisem): not sure if this is the right thing to do
 	userName, _, _ := strings.Cut(ar.remoteUser.UserProfile.LoginName, "@")
 	n := who.Node.View()
-	if n.IsTagged() {
-		http.Error(w, "tsidp: tagged nodes not supported", http.StatusBadRequest)
+	if n.IsTagged() != ar.clientCredentials {
+		if ar.clientCredentials {
+			http.Error(w, "tsidp: client_credentials grant is only for tagged nodes", http.StatusBadRequest)
+		} else {
+			http.Error(w, "tsidp: tagged nodes not supported", http.StatusBadRequest)
+		}
 		return
 	}
 
@@ -963,6 +1065,14 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 	if ar.localRP {
 		tsClaims.Issuer = s.loopbackURL
 	}
+	if ar.clientCredentials {
+		// There's no user, so the subject is the node itself.
+		tsClaims.Subject = string(n.StableID())
+		tsClaims.UserID = 0
+		tsClaims.Email = ""
+		tsClaims.UserName = ""
+		tsClaims.Tags = n.Tags().AsSlice()
+	}
 
 	rules, err := tailcfg.UnmarshalCapJSON[capRule](who.CapMap, tailcfg.PeerCapabilityTsIDP)
 	if err != nil {
@@ -986,18 +1096,33 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var rt string
+	if issueRefresh {
+		rt, err = s.issueRefreshToken(ar, now)
+		if err != nil {
+			log.Printf("Error issuing refresh token: %v", err)
+			http.Error(w, "tsidp: could not issue refresh token", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	at := rands.HexString(32)
 	s.mu.Lock()
 	ar.validTill = now.Add(5 * time.Minute)
 	mak.Set(&s.accessToken, at, ar)
+	if err := s.storeTokensLocked(); err != nil {
+		// The token still works until we restart.
+		log.Printf("could not write tokens db: %v", err)
+	}
 	s.mu.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(oidcTokenResponse{
-		AccessToken: at,
-		TokenType:   "Bearer",
-		ExpiresIn:   5 * 60,
-		IDToken:     token,
+		AccessToken:  at,
+		TokenType:    "Bearer",
+		ExpiresIn:    5 * 60,
+		IDToken:      token,
+		RefreshToken: rt,
 	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -1016,76 +1141,30 @@ type oidcTokenResponse struct {
 	oidcConfigPath = "/.well-known/openid-configuration"
 )
 
-func (s *idpServer) oidcSigner() (jose.Signer, error) {
-	return s.lazySigner.GetErr(func() (jose.Signer, error) {
-		sk, err := s.oidcPrivateKey()
-		if err != nil {
-			return nil, err
-		}
-		return jose.NewSigner(jose.SigningKey{
-			Algorithm: jose.RS256,
-			Key:       sk.k,
-		}, &jose.SignerOptions{EmbedJWK: false, ExtraHeaders: map[jose.HeaderKey]any{
-			jose.HeaderType: "JWT",
-			"kid":           fmt.Sprint(sk.kid),
-		}})
-	})
-}
-
-func (s *idpServer) oidcPrivateKey() (*signingKey, error) {
-	return s.lazySigningKey.GetErr(func() (*signingKey, error) {
-		keyPath, err := getConfigFilePath(s.rootPath, oidcKeyFile)
-		if err != nil {
-			return nil, fmt.Errorf("could not get OIDC key file path: %w", err)
-		}
-		var sk signingKey
-		b, err := os.ReadFile(keyPath)
-		if err == nil {
-			if err := sk.UnmarshalJSON(b); err == nil {
-				return &sk, nil
-			} else {
-				log.Printf("Error unmarshaling key: %v", err)
-			}
-		}
-		id, k := mustGenRSAKey(2048)
-		sk.k = k
-		sk.kid = id
-		b, err = sk.MarshalJSON()
-		if err != nil {
-			log.Fatalf("Error marshaling key: %v", err)
-		}
-		if err := os.WriteFile(keyPath, b, 0600); err != nil {
-			log.Fatalf("Error writing key: %v", err)
-		}
-		return &sk, nil
-	})
-}
-
 func (s *idpServer) serveJWKS(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != oidcJWKSPath {
 		http.Error(w, "tsidp: not found", http.StatusNotFound)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	sk, err := s.oidcPrivateKey()
+	keys, err := s.oidcPublicKeys()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	// TODO(maisem): maybe only marshal this once and reuse?
-	// TODO(maisem): implement key rotation.
+	var jwks jose.JSONWebKeySet
+	for _, sk := range keys {
+		jwks.Keys = append(jwks.Keys, jose.JSONWebKey{
+			Key:       sk.k.Public(),
+			Algorithm: string(jose.RS256),
+			Use:       "sig",
+			KeyID:     fmt.Sprint(sk.kid),
+		})
+	}
 	je := json.NewEncoder(w)
 	je.SetIndent("", "  ")
-	if err := je.Encode(jose.JSONWebKeySet{
-		Keys: []jose.JSONWebKey{
-			{
-				Key:       sk.k.Public(),
-				Algorithm: string(jose.RS256),
-				Use:       "sig",
-				KeyID:     fmt.Sprint(sk.kid),
-			},
-		},
-	}); err != nil {
+	if err := je.Encode(jwks); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
@@ -1097,12 +1176,17 @@ type openIDProviderMetadata struct {
 	AuthorizationEndpoint            string              `json:"authorization_endpoint,omitempty"`
 	TokenEndpoint                    string              `json:"token_endpoint,omitempty"`
 	UserInfoEndpoint                 string              `json:"userinfo_endpoint,omitempty"`
+	IntrospectionEndpoint            string              `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint               string              `json:"revocation_endpoint,omitempty"`
+	EndSessionEndpoint               string              `json:"end_session_endpoint,omitempty"`
 	JWKS_URI                         string              `json:"jwks_uri"`
 	ScopesSupported                  views.Slice[string] `json:"scopes_supported"`
 	ResponseTypesSupported           views.Slice[string] `json:"response_types_supported"`
 	SubjectTypesSupported            views.Slice[string] `json:"subject_types_supported"`
 	ClaimsSupported                  views.Slice[string] `json:"claims_supported"`
 	IDTokenSigningAlgValuesSupported views.Slice[string] `json:"id_token_signing_alg_values_supported"`
+	GrantTypesSupported              views.Slice[string] `json:"grant_types_supported,omitempty"`
+	CodeChallengeMethodsSupported    views.Slice[string] `json:"code_challenge_methods_supported,omitempty"`
 	// TODO(maisem): maybe add other fields?
 	// Currently we fill out the REQUIRED fields, scopes_supported and claims_supported.
 }
@@ -1115,6 +1199,7 @@ type tailscaleClaims struct {
 	NodeID     tailcfg.NodeID            `json:"nid"`             // the stable node ID
 	NodeName   string                    `json:"node"`            // name of the node
 	Tailnet    string                    `json:"tailnet"`         // tailnet (like tail-scale.ts.net)
+	Tags       []string                  `json:"tags,omitempty"`  // tags of the node, for client_credentials tokens
 
 	// Email is the "emailish" value with an '@' sign. It might not be a valid email.
 	Email  string         `json:"email,omitempty"` // user emailish (like "alice@github" or "bob@example.com")
@@ -1148,6 +1233,13 @@ type tailscaleClaims struct {
 	// The algo used for signing. The OpenID spec says "The algorithm RS256 MUST be included."
 	// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
 	openIDSupportedSigningAlgos = views.SliceOf([]string{string(jose.RS256)})
+
+	// The OAuth 2.0 grants the token endpoint supports.
+	openIDSupportedGrantTypes = views.SliceOf([]string{"authorization_code", "refresh_token", "client_credentials"})
+
+	// The PKCE methods supported. The "plain" method isn't, as it offers no
+	// protection if the authorization request leaks.
+	openIDSupportedCodeChallengeMethods = views.SliceOf([]string{"S256"})
 )
 
 func (s *idpServer) serveOpenIDConfig(w http.ResponseWriter, r *http.Request) {
@@ -1210,11 +1302,16 @@ func (s *idpServer) serveOpenIDConfig(w http.ResponseWriter, r *http.Request) {
 		JWKS_URI:                         rpEndpoint + oidcJWKSPath,
 		UserInfoEndpoint:                 rpEndpoint + "/userinfo",
 		TokenEndpoint:                    rpEndpoint + "/token",
+		IntrospectionEndpoint:            rpEndpoint + "/introspect",
+		RevocationEndpoint:               rpEndpoint + "/revoke",
+		EndSessionEndpoint:               s.serverURL + "/end_session",
 		ScopesSupported:                  openIDSupportedScopes,
 		ResponseTypesSupported:           openIDSupportedReponseTypes,
 		SubjectTypesSupported:            openIDSupportedSubjectTypes,
 		ClaimsSupported:                  openIDSupportedClaims,
 		IDTokenSigningAlgValuesSupported: openIDSupportedSigningAlgos,
+		GrantTypesSupported:              openIDSupportedGrantTypes,
+		CodeChallengeMethodsSupported:    openIDSupportedCodeChallengeMethods,
 	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -1227,6 +1324,23 @@ type funnelClient struct {
 	Secret      string `json:"client_secret,omitempty"`
 	Name        string `json:"name,omitempty"`
 	RedirectURI string `json:"redirect_uri"`
+
+	// PostLogoutRedirectURIs are the URIs that /end_session may redirect
+	// the user to after signing them out of this client.
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris,omitempty"`
+
+	// ResourceServers are the IDs of the other registered clients that may
+	// introspect the tokens issued to this client, as the resource servers
+	// it presents them to.
+	ResourceServers []string `json:"resource_servers,omitempty"`
+
+	// Public is whether the client can't keep a secret, like a single-page
+	// app or CLI tool. Public clients have no secret and must use PKCE.
+	Public bool `json:"public,omitempty"`
+
+	// Tags are the tags of the nodes that may use this client to get tokens
+	// for themselves with the client_credentials grant.
+	Tags []string `json:"tags,omitempty"`
 }
 
 // /clients is a privileged endpoint that allows the visitor to create new
@@ -1262,10 +1376,14 @@ func (s *idpServer) serveClients(w http.ResponseWriter, r *http.Request) {
 		s.serveDeleteClient(w, r, path)
 	case "GET":
 		json.NewEncoder(w).Encode(&funnelClient{
-			ID:          c.ID,
-			Name:        c.Name,
-			Secret:      "",
-			RedirectURI: c.RedirectURI,
+			ID:                     c.ID,
+			Name:                   c.Name,
+			Secret:                 "",
+			RedirectURI:            c.RedirectURI,
+			Public:                 c.Public,
+			Tags:                   c.Tags,
+			PostLogoutRedirectURIs: c.PostLogoutRedirectURIs,
+			ResourceServers:        c.ResourceServers,
 		})
 	default:
 		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
@@ -1277,21 +1395,56 @@ func (s *idpServer) serveNewClient(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	var tags []string
+	if v := r.FormValue("tags"); v != "" {
+		tags = strings.Split(v, ",")
+		for _, tag := range tags {
+			if err := tailcfg.CheckTag(tag); err != nil {
+				http.Error(w, fmt.Sprintf("tsidp: invalid tag %q: %v", tag, err), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	public := r.FormValue("public") == "true"
+	if public && len(tags) > 0 {
+		http.Error(w, "tsidp: public clients can't use the client_credentials grant", http.StatusBadRequest)
+		return
+	}
 	redirectURI := r.FormValue("redirect_uri")
-	if redirectURI == "" {
+	if redirectURI == "" && len(tags) == 0 {
 		http.Error(w, "tsidp: must provide redirect_uri", http.StatusBadRequest)
 		return
 	}
+	postLogoutRedirectURIs := r.Form["post_logout_redirect_uri"]
+	for _, u := range postLogoutRedirectURIs {
+		if errMsg := validateRedirectURI(u); errMsg != "" {
+			http.Error(w, fmt.Sprintf("tsidp: invalid post_logout_redirect_uri %q: %s", u, errMsg), http.StatusBadRequest)
+			return
+		}
+	}
 	clientID := rands.HexString(32)
-	clientSecret := rands.HexString(64)
+	var clientSecret string
+	if !public {
+		clientSecret = rands.HexString(64)
+	}
 	newClient := funnelClient{
-		ID:          clientID,
-		Secret:      clientSecret,
-		Name:        r.FormValue("name"),
-		RedirectURI: redirectURI,
+		ID:                     clientID,
+		Secret:                 clientSecret,
+		Name:                   r.FormValue("name"),
+		RedirectURI:            redirectURI,
+		Public:                 public,
+		Tags:                   tags,
+		PostLogoutRedirectURIs: postLogoutRedirectURIs,
+		ResourceServers:        r.Form["resource_server"],
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	for _, id := range newClient.ResourceServers {
+		if c, ok := s.funnelClients[id]; !ok || c.Public {
+			http.Error(w, fmt.Sprintf("tsidp: resource_server %q is not a registered confidential client", id), http.StatusBadRequest)
+			return
+		}
+	}
 	mak.Set(&s.funnelClients, clientID, &newClient)
 	if err := s.storeFunnelClientsLocked(); err != nil {
 		log.Printf("could not write funnel clients db: %v", err)
@@ -1313,10 +1466,14 @@ func (s *idpServer) serveGetClientsList(w http.ResponseWriter, r *http.Request)
 	redactedClients := make([]funnelClient, 0, len(s.funnelClients))
 	for _, c := range s.funnelClients {
 		redactedClients = append(redactedClients, funnelClient{
-			ID:          c.ID,
-			Name:        c.Name,
-			Secret:      "",
-			RedirectURI: c.RedirectURI,
+			ID:                     c.ID,
+			Name:                   c.Name,
+			Secret:                 "",
+			RedirectURI:            c.RedirectURI,
+			Public:                 c.Public,
+			Tags:                   c.Tags,
+			PostLogoutRedirectURIs: c.PostLogoutRedirectURIs,
+			ResourceServers:        c.ResourceServers,
 		})
 	}
 	s.mu.Unlock()
@@ -1348,6 +1505,7 @@ func (s *idpServer) serveDeleteClient(w http.ResponseWriter, r *http.Request, cl
 		s.funnelClients[clientID] = deleted
 		return
 	}
+	s.revokeClientTokensLocked(clientID)
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -1403,52 +1561,6 @@ func readUint64(r io.Reader) (uint64, error) {
 	}
 }
 
-// rsaPrivateKeyJSONWrapper is the the JSON serialization
-// format used by RSAPrivateKey.
-type rsaPrivateKeyJSONWrapper struct {
-	Key string
-	ID  uint64
-}
-
-type signingKey struct {
-	k   *rsa.PrivateKey
-	kid uint64
-}
-
-func (sk *signingKey) MarshalJSON() ([]byte, error) {
-	b := pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(sk.k),
-	}
-	bts := pem.EncodeToMemory(&b)
-	return json.Marshal(rsaPrivateKeyJSONWrapper{
-		Key: base64.URLEncoding.EncodeToString(bts),
-		ID:  sk.kid,
-	})
-}
-
-func (sk *signingKey) UnmarshalJSON(b []byte) error {
-	var wrapper rsaPrivateKeyJSONWrapper
-	if err := json.Unmarshal(b, &wrapper); err != nil {
-		return err
-	}
-	if len(wrapper.Key) == 0 {
-		return nil
-	}
-	b64dec, err := base64.URLEncoding.DecodeString(wrapper.Key)
-	if err != nil {
-		return err
-	}
-	blk, _ := pem.Decode(b64dec)
-	k, err := x509.ParsePKCS1PrivateKey(blk.Bytes)
-	if err != nil {
-		return err
-	}
-	sk.k = k
-	sk.kid = wrapper.ID
-	return nil
-}
-
 // parseID takes a string input and returns a typed IntID T and true, or a zero
 // value and false if the input is unhandled syntax or out of a valid range.
 func parseID[T ~int64](input string) (_ T, ok bool) {
@@ -1465,6 +1577,16 @@ func parseID[T ~int64](input string) (_ T, ok bool) {
 	return T(i), true
 }
 
+// remoteAddr returns the address of the client that sent r.
+func (s *idpServer) remoteAddr(r *http.Request) string {
+	if s.localTSMode {
+		// in local tailscaled mode, the local tailscaled is forwarding us
+		// HTTP requests, so r.RemoteAddr is our own address.
+		return r.Header.Get("X-Forwarded-For")
+	}
+	return r.RemoteAddr
+}
+
 // isFunnelRequest checks if an HTTP request is coming over Tailscale Funnel.
 func isFunnelRequest(r *http.Request) bool {
 	// If we're funneling through the local tailscaled, it will set this HTTP
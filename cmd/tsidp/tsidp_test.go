@@ -37,7 +37,6 @@
 	"testing"
 	"time"
 
-	"gopkg.in/square/go-jose.v2"
 	"gopkg.in/square/go-jose.v2/jwt"
 	"tailscale.com/client/local"
 	"tailscale.com/client/tailscale/apitype"
@@ -94,16 +93,6 @@ func mustMarshalJSON(t *testing.T, v any) tailcfg.RawMessage {
 	privateKeyMu sync.Mutex
 )
 
-func oidcTestingSigner(t *testing.T) jose.Signer {
-	t.Helper()
-	privKey := mustGeneratePrivateKey(t)
-	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: privKey}, nil)
-	if err != nil {
-		t.Fatalf("failed to create signer: %v", err)
-	}
-	return sig
-}
-
 func oidcTestingPublicKey(t *testing.T) *rsa.PublicKey {
 	t.Helper()
 	privKey := mustGeneratePrivateKey(t)
@@ -1117,8 +1106,8 @@ func setupTestServerWithClient(t *testing.T, strictMode bool, lc *local.Client)
 		RedirectURI: "https://rp.example.com/callback",
 	}
 
-	// Inject a working signer for token tests
-	srv.lazySigner.Set(oidcTestingSigner(t))
+	// Inject a working signing key for token tests
+	srv.signingKeys = &signingKeys{current: &signingKey{k: mustGeneratePrivateKey(t), kid: 1}}
 
 	return srv
 }
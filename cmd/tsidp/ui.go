@@ -48,6 +48,9 @@ func (s *idpServer) handleUI(w http.ResponseWriter, r *http.Request) {
 	case "/new":
 		s.handleNewClient(w, r)
 		return
+	case "/rotate-key":
+		s.handleRotateKey(w, r)
+		return
 	case "/style.css":
 		http.ServeContent(w, r, "ui-style.css", processStart, strings.NewReader(styleCSS))
 		return
@@ -81,14 +84,33 @@ func (s *idpServer) handleClientsList(w http.ResponseWriter, r *http.Request) {
 		return clients[i].ID < clients[j].ID
 	})
 
+	keys, err := s.signingKeyInfos()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	var buf bytes.Buffer
-	if err := listTmpl.Execute(&buf, clients); err != nil {
+	if err := listTmpl.Execute(&buf, listDisplayData{Clients: clients, Keys: keys}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	buf.WriteTo(w)
 }
 
+func (s *idpServer) handleRotateKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := s.rotateSigningKey(); err != nil {
+		log.Printf("Error rotating signing key: %v", err)
+		http.Error(w, "Failed to rotate signing key", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
 func (s *idpServer) handleNewClient(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		if err := s.renderClientForm(w, clientDisplayData{IsNew: true}); err != nil {
@@ -185,6 +207,9 @@ func (s *idpServer) handleEditClient(w http.ResponseWriter, r *http.Request) {
 			s.mu.Lock()
 			delete(s.funnelClients, clientID)
 			err := s.storeFunnelClientsLocked()
+			if err == nil {
+				s.revokeClientTokensLocked(clientID)
+			}
 			s.mu.Unlock()
 
 			if err != nil {
@@ -256,6 +281,11 @@ func (s *idpServer) handleEditClient(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
+type listDisplayData struct {
+	Clients []clientDisplayData
+	Keys    []signingKeyInfo
+}
+
 type clientDisplayData struct {
 	ID          string
 	Name        string
@@ -107,6 +107,14 @@ type Knobs struct {
 	// of queued netmap.NetworkMap between the controlclient and LocalBackend.
 	// See tailscale/tailscale#14768.
 	DisableSkipStatusQueue atomic.Bool
+
+	// MultipathDuplicate is whether magicsock should also send each packet
+	// to a peer over a second path, when one is available.
+	MultipathDuplicate atomic.Bool
+
+	// MultipathStripe is whether magicsock should alternate packets to a
+	// peer between two paths, when a second one is available.
+	MultipathStripe atomic.Bool
 }
 
 // UpdateFromNodeAttributes updates k (if non-nil) based on the provided self
@@ -137,6 +145,8 @@ func (k *Knobs) UpdateFromNodeAttributes(capMap tailcfg.NodeCapMap) {
 		disableLocalDNSOverrideViaNRPT       = has(tailcfg.NodeAttrDisableLocalDNSOverrideViaNRPT)
 		disableCaptivePortalDetection        = has(tailcfg.NodeAttrDisableCaptivePortalDetection)
 		disableSkipStatusQueue               = has(tailcfg.NodeAttrDisableSkipStatusQueue)
+		multipathDuplicate                   = has(tailcfg.NodeAttrMultipathDuplicate)
+		multipathStripe                      = has(tailcfg.NodeAttrMultipathStripe)
 	)
 
 	if has(tailcfg.NodeAttrOneCGNATEnable) {
@@ -163,6 +173,8 @@ func (k *Knobs) UpdateFromNodeAttributes(capMap tailcfg.NodeCapMap) {
 	k.DisableLocalDNSOverrideViaNRPT.Store(disableLocalDNSOverrideViaNRPT)
 	k.DisableCaptivePortalDetection.Store(disableCaptivePortalDetection)
 	k.DisableSkipStatusQueue.Store(disableSkipStatusQueue)
+	k.MultipathDuplicate.Store(multipathDuplicate)
+	k.MultipathStripe.Store(multipathStripe)
 
 	// If both attributes are present, then "enable" should win.  This reflects
 	// the history of seamless key renewal.
@@ -46,6 +46,7 @@
 	"tailscale.com/tstime"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
 )
 
 // Client is a DERP-over-HTTP client.
@@ -103,6 +104,7 @@ type Client struct {
 	tlsState     *tls.ConnectionState
 	pingOut      map[derp.PingMessage]chan<- bool // chan to send to on pong
 	clock        tstime.Clock
+	quicBackoff  map[string]quicBackoff // by DERPNode.Name, for nodes QUIC failed with
 }
 
 // ConnectedState describes the state of a derphttp Client.
@@ -340,7 +342,8 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 	}
 	c.atomicState.Store(ConnectedState{Connecting: true})
 	defer func() {
-		if err != nil {
+		// c.closed may be set while mu is released for QUIC.
+		if err != nil && !c.closed {
 			c.atomicState.Store(ConnectedState{Connecting: false})
 		}
 	}()
@@ -386,6 +389,36 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 		}
 	}()
 
+	if node := c.quicNodeLocked(reg); node != nil {
+		// Don't hold mu while dialing, which may take all of
+		// quicConnectTimeout if UDP is blocked.
+		canAckPings := c.canAckPings
+		c.mu.Unlock()
+		derpClient, nc, tlsState, err := c.connectQUIC(ctx, caller, node, canAckPings)
+		c.mu.Lock()
+		if c.closed || c.client != nil {
+			// Closed, or connected by another caller, while dialing.
+			if err == nil {
+				go nc.Close()
+			}
+			if c.closed {
+				return nil, 0, ErrClientClosed
+			}
+			return c.client, c.connGen, nil
+		}
+		if err == nil {
+			if err = c.startQUICLocked(derpClient, nc, tlsState); err != nil {
+				go nc.Close()
+			}
+		}
+		if err == nil {
+			delete(c.quicBackoff, node.Name)
+			return c.client, c.connGen, nil
+		}
+		c.noteQUICFailureLocked(node)
+		c.logf("%s: QUIC to derp-%d (%v) failed, falling back to TCP: %v", caller, reg.RegionID, node.Name, err)
+	}
+
 	var node *tailcfg.DERPNode // nil when using c.url to dial
 	var idealNodeInRegion bool
 	switch {
@@ -584,6 +617,135 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 	return c.client, c.connGen, nil
 }
 
+// dialQUICFunc is non-nil (set by derphttp_quic.go's init) when
+// DERP-over-QUIC support is compiled in. It returns a connection to node n's
+// DERP stream and its TLS state.
+var dialQUICFunc func(c *Client, ctx context.Context, n *tailcfg.DERPNode) (net.Conn, *tls.ConnectionState, error)
+
+var debugDisableDERPQUIC = envknob.RegisterBool("TS_DEBUG_DERP_DISABLE_QUIC")
+
+// quicConnectTimeout is how long to try connecting over QUIC before falling
+// back to TCP, leaving time for the TCP attempt within the overall connect
+// timeout. UDP may be silently blocked, so this is often all the time a
+// failed attempt takes.
+const quicConnectTimeout = 3 * time.Second
+
+// Connecting to a node over QUIC isn't retried for minQUICBackoff after it
+// fails, doubling with each consecutive failure up to maxQUICBackoff, so
+// that clients behind networks that block UDP don't wait out
+// quicConnectTimeout on every reconnect.
+const (
+	minQUICBackoff = time.Minute
+	maxQUICBackoff = time.Hour
+)
+
+// quicBackoff is the state of a node that connecting to over QUIC failed
+// with.
+type quicBackoff struct {
+	failures int       // consecutive
+	until    time.Time // when to try QUIC again
+}
+
+// noteQUICFailureLocked records that connecting to node over QUIC failed.
+// c.mu must be held.
+func (c *Client) noteQUICFailureLocked(node *tailcfg.DERPNode) {
+	b := c.quicBackoff[node.Name]
+	b.failures++
+	d := maxQUICBackoff
+	if b.failures <= 6 {
+		d = min(minQUICBackoff<<(b.failures-1), maxQUICBackoff)
+	}
+	b.until = c.clock.Now().Add(d)
+	mak.Set(&c.quicBackoff, node.Name, b)
+}
+
+// quicNodeLocked returns the node of reg to connect to over QUIC, or nil
+// to connect over TCP (or WebSockets) as usual. reg is nil when using
+// c.url. c.mu must be held.
+func (c *Client) quicNodeLocked(reg *tailcfg.DERPRegion) *tailcfg.DERPNode {
+	if reg == nil || dialQUICFunc == nil || debugDisableDERPQUIC() || useWebsockets() || !c.useHTTPS() {
+		return nil
+	}
+	for _, n := range reg.Nodes {
+		if n.STUNOnly {
+			continue
+		}
+		if n.QUICPort != 0 {
+			if b, ok := c.quicBackoff[n.Name]; ok && c.clock.Now().Before(b.until) {
+				return nil
+			}
+			return n
+		}
+		// Only try QUIC with the first DERP node, as it's the one
+		// dialRegion connects to over TCP unless it's down.
+		break
+	}
+	return nil
+}
+
+// connectQUIC connects to node over QUIC, up to the DERP handshake. It's
+// called by connect without c.mu held; connect finishes setting up the
+// connection with startQUICLocked.
+func (c *Client) connectQUIC(ctx context.Context, caller string, node *tailcfg.DERPNode, canAckPings bool) (_ *derp.Client, _ net.Conn, _ *tls.ConnectionState, err error) {
+	ctx, cancel := context.WithTimeout(ctx, quicConnectTimeout)
+	defer cancel()
+
+	c.logf("%s: connecting to derp-%d (%v) over QUIC", caller, node.RegionID, node.Name)
+	nc, tlsState, err := dialQUICFunc(c, ctx, node)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			go nc.Close()
+		}
+	}()
+	// Bound the DERP handshake by ctx too.
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	defer stop()
+
+	brw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+	derpClient, err := derp.NewClient(c.privateKey, nc, brw, c.logf,
+		derp.MeshKey(c.MeshKey),
+		derp.CanAckPings(canAckPings),
+		derp.IsProber(c.IsProber),
+	)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if c.WatchConnectionChanges {
+		if err := derpClient.WatchConnectionChanges(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if !stop() {
+		return nil, nil, nil, ctx.Err()
+	}
+	return derpClient, nc, tlsState, nil
+}
+
+// startQUICLocked makes derpClient, connected over QUIC by connectQUIC,
+// the current connection. c.mu must be held.
+func (c *Client) startQUICLocked(derpClient *derp.Client, nc net.Conn, tlsState *tls.ConnectionState) error {
+	if c.preferred {
+		if err := derpClient.NotePreferred(true); err != nil {
+			return err
+		}
+	}
+	c.serverPubKey = derpClient.ServerPublicKey()
+	c.client = derpClient
+	c.netConn = nc
+	c.tlsState = tlsState
+	c.connGen++
+
+	localAddr, _ := c.client.LocalAddr()
+	c.atomicState.Store(ConnectedState{
+		Connected: true,
+		LocalAddr: localAddr,
+	})
+	return nil
+}
+
 // SetURLDialer sets the dialer to use for dialing URLs.
 // This dialer is only use for clients created with NewClient, not NewRegionClient.
 // If unset or nil, the default dialer is used.
@@ -648,6 +810,12 @@ func (c *Client) dialRegion(ctx context.Context, reg *tailcfg.DERPRegion) (net.C
 }
 
 func (c *Client) tlsClient(nc net.Conn, node *tailcfg.DERPNode) *tls.Conn {
+	return tls.Client(nc, c.tlsConfig(node))
+}
+
+// tlsConfig returns the TLS config for connecting to node, which may be nil
+// when using c.url.
+func (c *Client) tlsConfig(node *tailcfg.DERPNode) *tls.Config {
 	tlsConf := tlsdial.Config(c.HealthTracker, c.TLSConfig)
 	// node is allowed to be nil here, tlsServerName falls back to using the URL
 	// if node is nil.
@@ -665,7 +833,7 @@ func (c *Client) tlsClient(nc net.Conn, node *tailcfg.DERPNode) *tls.Conn {
 			}
 		}
 	}
-	return tls.Client(nc, tlsConf)
+	return tlsConf
 }
 
 // DialRegionTLS returns a TLS connection to a DERP node in the given region.
@@ -0,0 +1,105 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_derpquic
+
+package derphttp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/quic"
+	"tailscale.com/derp/derpquic"
+	"tailscale.com/net/netns"
+	"tailscale.com/tailcfg"
+)
+
+func init() {
+	dialQUICFunc = dialQUIC
+}
+
+// quicConn is a DERP-over-QUIC connection along with the QUIC endpoint
+// that only it uses.
+type quicConn struct {
+	*derpquic.Conn
+	ep *quic.Endpoint
+}
+
+func (c quicConn) Close() error {
+	c.Conn.Close()
+	go func() {
+		// Give the peer a moment to acknowledge the close, but
+		// don't wait out the whole drain period.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		c.ep.Close(ctx)
+	}()
+	return nil
+}
+
+// dialQUIC returns a DERP-over-QUIC connection to node n, once the server
+// has opened its DERP stream.
+func dialQUIC(c *Client, ctx context.Context, n *tailcfg.DERPNode) (net.Conn, *tls.ConnectionState, error) {
+	host, err := c.quicDialHost(ctx, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	pc, err := netns.Listener(c.logf, c.netMon).ListenPacket(ctx, "udp", ":0")
+	if err != nil {
+		return nil, nil, err
+	}
+	ep, err := quic.NewEndpoint(pc, nil)
+	if err != nil {
+		pc.Close()
+		return nil, nil, err
+	}
+	conf := derpquic.Config(c.tlsConfig(n))
+	conf.MaxBidiRemoteStreams = 1 // the DERP stream
+	qc, err := ep.Dial(ctx, "udp", net.JoinHostPort(host, strconv.Itoa(n.QUICPort)), conf)
+	if err != nil {
+		ep.Close(context.Background())
+		return nil, nil, err
+	}
+	st, err := qc.AcceptStream(ctx)
+	if err != nil {
+		qc.Abort(nil)
+		ep.Close(context.Background())
+		return nil, nil, err
+	}
+	nc := quicConn{derpquic.NewConn(qc, st), ep}
+	tlsState := nc.ConnectionState()
+	return nc, &tlsState, nil
+}
+
+// quicDialHost returns the IP address or hostname to dial n at over QUIC.
+// Unlike dialNode, it doesn't race IPv4 and IPv6; it dials IPv6 only if
+// it's preferred or there's no IPv4 address.
+func (c *Client) quicDialHost(ctx context.Context, n *tailcfg.DERPNode) (string, error) {
+	ip4, _ := netip.ParseAddr(n.IPv4)
+	ip6, _ := netip.ParseAddr(n.IPv6)
+	switch {
+	case ip6.Is6() && (c.preferIPv6() || !ip4.Is4()):
+		return ip6.String(), nil
+	case ip4.Is4():
+		return ip4.String(), nil
+	case n.IPv4 != "" && n.IPv6 != "":
+		return "", errors.New("no IPv4 or IPv6 address to dial")
+	}
+	if c.DNSCache != nil {
+		ip, ip6, _, err := c.DNSCache.LookupIP(ctx, n.HostName)
+		if err != nil {
+			return "", err
+		}
+		if ip6.IsValid() && (c.preferIPv6() || !ip.IsValid()) {
+			return ip6.String(), nil
+		}
+		return ip.String(), nil
+	}
+	return n.HostName, nil
+}
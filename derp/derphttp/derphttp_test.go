@@ -629,3 +629,64 @@ func TestURLDial(t *testing.T) {
 		t.Fatalf("rc.Connect: %v", err)
 	}
 }
+
+func TestQUICBackoff(t *testing.T) {
+	var c *derphttp.Client
+	dials := 0
+	derphttp.SetTestHookDialQUIC(t, func(ctx context.Context, n *tailcfg.DERPNode) (net.Conn, *tls.ConnectionState, error) {
+		dials++
+		// The client's mutex must not be held while dialing.
+		done := make(chan struct{})
+		go func() {
+			c.TLSConnectionState()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Error("dialing QUIC with Client.mu held")
+		}
+		return nil, nil, errors.New("UDP blocked")
+	})
+
+	// Nothing listens on the TCP port either, so connecting fails fast.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	reg := &tailcfg.DERPRegion{
+		RegionID: 900,
+		Nodes: []*tailcfg.DERPNode{{
+			Name:     "900a",
+			RegionID: 900,
+			HostName: "127.0.0.1",
+			IPv4:     "127.0.0.1",
+			DERPPort: port,
+			QUICPort: 1,
+		}},
+	}
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	c = derphttp.NewRegionClient(key.NewNode(), t.Logf, netmon.NewStatic(), func() *tailcfg.DERPRegion { return reg })
+	c.SetClock(clock)
+	defer c.Close()
+
+	connect := func(wantDials int) {
+		t.Helper()
+		if err := c.Connect(context.Background()); err == nil {
+			t.Fatal("Connect succeeded")
+		}
+		if dials != wantDials {
+			t.Fatalf("dialed QUIC %d times; want %d", dials, wantDials)
+		}
+	}
+	connect(1)
+	connect(1) // backing off
+	clock.Advance(time.Minute)
+	connect(2)
+	clock.Advance(time.Minute)
+	connect(2) // backoff doubled
+	clock.Advance(time.Minute)
+	connect(3)
+}
@@ -3,6 +3,16 @@
 
 package derphttp
 
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+)
+
 func SetTestHookWatchLookConnectResult(f func(connectError error, wasSelfConnect bool) (keepRunning bool)) {
 	testHookWatchLookConnectResult = f
 }
@@ -22,3 +32,17 @@ func (c *Client) BreakConnection(brokenClient *Client) {
 }
 
 var RetryInterval = &retryInterval
+
+// SetTestHookDialQUIC makes clients connect to DERP nodes over QUIC with f
+// for the rest of the test.
+func SetTestHookDialQUIC(t testing.TB, f func(ctx context.Context, n *tailcfg.DERPNode) (net.Conn, *tls.ConnectionState, error)) {
+	old := dialQUICFunc
+	dialQUICFunc = func(_ *Client, ctx context.Context, n *tailcfg.DERPNode) (net.Conn, *tls.ConnectionState, error) {
+		return f(ctx, n)
+	}
+	t.Cleanup(func() { dialQUICFunc = old })
+}
+
+func (c *Client) SetClock(clock tstime.Clock) {
+	c.clock = clock
+}
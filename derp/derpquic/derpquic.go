@@ -0,0 +1,155 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package derpquic contains the parts of the experimental DERP-over-QUIC
+// transport shared by the DERP client and server.
+//
+// A DERP-over-QUIC connection carries the usual DERP frames, unchanged, on
+// a single bidirectional QUIC stream opened by the server, so the server
+// can send its key first as it does over TCP. There's no HTTP upgrade
+// exchange; the TLS ALPN protocol identifies the connection as DERP.
+package derpquic
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/quic"
+)
+
+// ALPN is the TLS ALPN protocol ID of DERP-over-QUIC connections.
+const ALPN = "tailscale-derp"
+
+// The QUIC idle timeout and keep-alive period of DERP connections. The
+// server's DERP keep-alive frames are too infrequent to keep the QUIC
+// connection, or NAT mappings along its path, alive on their own.
+const (
+	idleTimeout     = 60 * time.Second
+	keepAlivePeriod = 15 * time.Second
+)
+
+// Config returns the QUIC config for a DERP connection using tlsConf, which
+// it clones and sets the ALPN protocol of. The peer that doesn't open the
+// DERP stream, the client, should set MaxBidiRemoteStreams.
+func Config(tlsConf *tls.Config) *quic.Config {
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{ALPN}
+	tlsConf.MinVersion = tls.VersionTLS13 // required by QUIC
+	return &quic.Config{
+		TLSConfig:            tlsConf,
+		MaxBidiRemoteStreams: -1, // none
+		MaxUniRemoteStreams:  -1, // none
+		MaxIdleTimeout:       idleTimeout,
+		KeepAlivePeriod:      keepAlivePeriod,
+	}
+}
+
+// Conn is a derp.Conn over a DERP-over-QUIC stream.
+//
+// As with quic.Stream, reads and writes are each not safe for concurrent
+// use, and a read or write deadline must only be set by the goroutine doing
+// reads or writes respectively, or when none are in progress.
+type Conn struct {
+	qc *quic.Conn
+	st *quic.Stream
+
+	mu          sync.Mutex
+	cancelRead  context.CancelFunc // or nil
+	cancelWrite context.CancelFunc // or nil
+}
+
+// NewConn returns a Conn for the DERP stream st of the QUIC connection qc.
+// Closing it closes qc.
+func NewConn(qc *quic.Conn, st *quic.Stream) *Conn {
+	return &Conn{qc: qc, st: st}
+}
+
+// Read reads from the DERP stream.
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.st.Read(p)
+}
+
+// Write writes p to the DERP stream and flushes it. Callers are expected to
+// do their own buffering, as the derp package does.
+func (c *Conn) Write(p []byte) (int, error) {
+	n, err := c.st.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.st.Flush()
+}
+
+// Close closes the QUIC connection, without waiting for the peer to
+// acknowledge it.
+func (c *Conn) Close() error {
+	c.qc.Abort(nil)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancelRead != nil {
+		c.cancelRead()
+	}
+	if c.cancelWrite != nil {
+		c.cancelWrite()
+	}
+	return nil
+}
+
+// LocalAddr returns the local UDP address of the QUIC connection.
+func (c *Conn) LocalAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(c.qc.LocalAddr())
+}
+
+// RemoteAddr returns the remote UDP address of the QUIC connection.
+func (c *Conn) RemoteAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(c.qc.RemoteAddr())
+}
+
+// ConnectionState returns the TLS state of the QUIC connection.
+func (c *Conn) ConnectionState() tls.ConnectionState {
+	return c.qc.ConnectionState()
+}
+
+// SetDeadline sets the read and write deadlines, with the semantics of
+// net.Conn.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	c.SetWriteDeadline(t)
+	return nil
+}
+
+// SetReadDeadline sets the read deadline, with the semantics of net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctx := deadlineContext(t, &c.cancelRead)
+	c.st.SetReadContext(ctx)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline, with the semantics of net.Conn.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctx := deadlineContext(t, &c.cancelWrite)
+	c.st.SetWriteContext(ctx)
+	return nil
+}
+
+// deadlineContext returns a context that expires at t, or never if t is
+// zero, canceling the previous deadline's context in *cancel and replacing
+// it with the new one's.
+func deadlineContext(t time.Time, cancel *context.CancelFunc) context.Context {
+	if *cancel != nil {
+		(*cancel)()
+		*cancel = nil
+	}
+	if t.IsZero() {
+		return context.Background()
+	}
+	ctx, cancelFunc := context.WithDeadline(context.Background(), t)
+	*cancel = cancelFunc
+	return ctx
+}
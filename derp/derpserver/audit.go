@@ -0,0 +1,99 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derpserver
+
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/types/key"
+)
+
+// AuditEventType is the type of an AuditEvent.
+type AuditEventType string
+
+const (
+	// AuditConnect is a client connection that was admitted.
+	AuditConnect AuditEventType = "connect"
+
+	// AuditDisconnect is the close of an admitted client connection. Its
+	// event has the connection's duration and traffic totals.
+	AuditDisconnect AuditEventType = "disconnect"
+
+	// AuditAdmissionDenied is a client connection that was rejected by
+	// --verify-clients or the admission controller.
+	AuditAdmissionDenied AuditEventType = "admission_denied"
+
+	// AuditMeshForwardAdd and AuditMeshForwardRemove are a mesh peer
+	// starting and stopping forwarding packets for a client connected to
+	// it.
+	AuditMeshForwardAdd    AuditEventType = "mesh_forward_add"
+	AuditMeshForwardRemove AuditEventType = "mesh_forward_remove"
+)
+
+// AuditEvent is an event in the server's audit log of client connections.
+// See Server.SetAuditLog.
+type AuditEvent struct {
+	Time time.Time      `json:"time"`
+	Type AuditEventType `json:"type"`
+
+	// Node is the client's public key. For mesh forward events, it's the
+	// key of the client connected to the mesh peer.
+	Node key.NodePublic `json:"node,omitzero"`
+
+	// Addr is the client's IP address and port, if known. It's unset for
+	// mesh forward events.
+	Addr netip.AddrPort `json:"addr,omitzero"`
+
+	// ConnNum is the server's sequence number of the connection, for
+	// matching connect and disconnect events.
+	ConnNum int64 `json:"conn,omitempty"`
+
+	// Mesh is whether the client is a mesh peer.
+	Mesh bool `json:"mesh,omitempty"`
+
+	// Reason is why the client was denied admission.
+	Reason string `json:"reason,omitempty"`
+
+	// Via describes the mesh peer forwarding packets, for mesh forward
+	// events.
+	Via string `json:"via,omitempty"`
+
+	// The following fields are set for disconnect events.
+	DurationSecs float64 `json:"duration_secs,omitempty"` // how long the client was connected
+	BytesRecv    int64   `json:"bytes_recv,omitempty"`    // packet bytes received from the client
+	BytesSent    int64   `json:"bytes_sent,omitempty"`    // packet bytes sent to the client
+	PacketsRecv  int64   `json:"packets_recv,omitempty"`  // packets received from the client
+	PacketsSent  int64   `json:"packets_sent,omitempty"`  // packets sent to the client
+}
+
+// SetAuditLog sets a func to call with each audit log event, such as client
+// connections, disconnections and admission denials.
+//
+// The func is called synchronously, sometimes with internal locks held, so
+// it must not block or call back into the Server. It must not retain ev.
+//
+// It must be called before serving begins.
+func (s *Server) SetAuditLog(f func(ev *AuditEvent)) {
+	s.auditLog = f
+}
+
+// audit records ev in the audit log, if any, filling in its time.
+func (s *Server) audit(ev AuditEvent) {
+	if s.auditLog == nil {
+		return
+	}
+	ev.Time = s.clock.Now()
+	s.auditLog(&ev)
+}
+
+// auditMeshForward records a mesh forward event of type typ for fwd
+// forwarding packets to dst.
+func (s *Server) auditMeshForward(typ AuditEventType, dst key.NodePublic, fwd PacketForwarder) {
+	if s.auditLog == nil {
+		// Avoid the String call.
+		return
+	}
+	s.audit(AuditEvent{Type: typ, Node: dst, Via: fwd.String()})
+}
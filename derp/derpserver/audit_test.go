@@ -0,0 +1,188 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derpserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+)
+
+// auditEvents returns a channel of the audit events logged by s.
+func auditEvents(s *Server) chan AuditEvent {
+	ch := make(chan AuditEvent, 16)
+	s.SetAuditLog(func(ev *AuditEvent) { ch <- *ev })
+	return ch
+}
+
+func nextAuditEvent(t *testing.T, ch chan AuditEvent) AuditEvent {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for audit event")
+		panic("unreachable")
+	}
+}
+
+// dialTestServer connects a derp.Client with key k to s over TCP.
+func dialTestServer(t *testing.T, s *Server, k key.NodePrivate) (*derp.Client, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	connOut, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { connOut.Close() })
+	connIn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { connIn.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	brwServer := bufio.NewReadWriter(bufio.NewReader(connIn), bufio.NewWriter(connIn))
+	go s.Accept(ctx, connIn, brwServer, connOut.LocalAddr().String())
+
+	brw := bufio.NewReadWriter(bufio.NewReader(connOut), bufio.NewWriter(connOut))
+	c, err := derp.NewClient(k, connOut, brw, logger.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, connOut
+}
+
+func TestAuditConnectDisconnect(t *testing.T) {
+	s := New(key.NewNode(), logger.Discard)
+	defer s.Close()
+	events := auditEvents(s)
+
+	k := key.NewNode()
+	c, conn := dialTestServer(t, s, k)
+
+	ev := nextAuditEvent(t, events)
+	if ev.Type != AuditConnect || ev.Node != k.Public() || ev.ConnNum == 0 || !ev.Addr.IsValid() {
+		t.Fatalf("got %+v; want connect of %v", ev, k.Public())
+	}
+	connNum := ev.ConnNum
+
+	// Send a packet to ourselves, so it's counted both ways.
+	msg := []byte("hello")
+	if err := c.Send(k.Public(), msg); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		m, err := c.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := m.(derp.ReceivedPacket); ok {
+			break
+		}
+	}
+	conn.Close()
+
+	ev = nextAuditEvent(t, events)
+	if ev.Type != AuditDisconnect || ev.ConnNum != connNum {
+		t.Fatalf("got %+v; want disconnect of conn %d", ev, connNum)
+	}
+	if ev.PacketsRecv != 1 || ev.PacketsSent != 1 || ev.BytesRecv != int64(len(msg)) || ev.BytesSent != int64(len(msg)) {
+		t.Errorf("disconnect totals = %+v; want 1 packet of %d bytes each way", ev, len(msg))
+	}
+	if ev.Time.IsZero() {
+		t.Error("event time not set")
+	}
+
+	j, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"type":"disconnect"`, `"node":"nodekey:`, `"bytes_sent":5`} {
+		if !strings.Contains(string(j), want) {
+			t.Errorf("JSON %s missing %s", j, want)
+		}
+	}
+}
+
+func TestAuditAdmissionDenied(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(tailcfg.DERPAdmitClientResponse{Allow: false})
+	}))
+	defer ts.Close()
+
+	s := New(key.NewNode(), logger.Discard)
+	defer s.Close()
+	s.SetVerifyClientURL(ts.URL)
+	events := auditEvents(s)
+
+	k := key.NewNode()
+	dialTestServer(t, s, k)
+	ev := nextAuditEvent(t, events)
+	if ev.Type != AuditAdmissionDenied || ev.Node != k.Public() || ev.Reason == "" {
+		t.Fatalf("got %+v; want admission denied of %v with reason", ev, k.Public())
+	}
+}
+
+type namedFwd string
+
+func (namedFwd) ForwardPacket(key.NodePublic, key.NodePublic, []byte) error {
+	panic("not called in tests")
+}
+
+func (f namedFwd) String() string { return string(f) }
+
+func TestAuditMeshForward(t *testing.T) {
+	s := New(key.NewNode(), logger.Discard)
+	defer s.Close()
+	events := auditEvents(s)
+	want := func(typ AuditEventType, via namedFwd) {
+		t.Helper()
+		ev := nextAuditEvent(t, events)
+		if ev.Type != typ || ev.Via != string(via) || ev.Node != pubAll(1) {
+			t.Errorf("got %+v; want %v via %v", ev, typ, via)
+		}
+	}
+
+	u := pubAll(1)
+	a, b, c := namedFwd("a"), namedFwd("b"), namedFwd("c")
+	s.AddPacketForwarder(u, a)
+	want(AuditMeshForwardAdd, a)
+	s.AddPacketForwarder(u, a) // dup; not logged
+	s.AddPacketForwarder(u, b)
+	want(AuditMeshForwardAdd, b)
+	s.AddPacketForwarder(u, c)
+	want(AuditMeshForwardAdd, c)
+	s.AddPacketForwarder(u, c) // dup in set; not logged
+
+	s.RemovePacketForwarder(u, namedFwd("unknown")) // not logged
+	s.RemovePacketForwarder(u, b)
+	want(AuditMeshForwardRemove, b)
+	s.RemovePacketForwarder(u, a)
+	want(AuditMeshForwardRemove, a)
+	s.RemovePacketForwarder(u, a) // already gone; not logged
+	s.RemovePacketForwarder(u, c)
+	want(AuditMeshForwardRemove, c)
+
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event %+v", ev)
+	default:
+	}
+}
@@ -177,6 +177,13 @@ type Server struct {
 	verifyClientsURL         string
 	verifyClientsURLFailOpen bool
 
+	// clientRateLimit is the default limit on the packets each non-mesh
+	// client may send. The admission controller may override it per
+	// client.
+	clientRateLimit tailcfg.DERPClientRateLimit
+
+	auditLog func(*AuditEvent) // or nil
+
 	mu       sync.Mutex
 	closed   bool
 	netConns map[derp.Conn]chan struct{} // chan is closed when conn closes
@@ -438,6 +445,7 @@ func genDroppedCounters() {
 		dropReasonQueueTail,
 		dropReasonWriteError,
 		dropReasonDupClient,
+		dropReasonRateLimited,
 	}
 
 	for _, dr := range dropReasons {
@@ -488,6 +496,16 @@ func (s *Server) SetVerifyClientURLFailOpen(v bool) {
 	s.verifyClientsURLFailOpen = v
 }
 
+// SetClientRateLimit sets the default limit on the rate at which each client
+// may send packets through the server. Packets over the limit are dropped.
+// Mesh peers aren't limited, and the --verify-client-url admission
+// controller may override the limit per client.
+//
+// It must be called before serving begins.
+func (s *Server) SetClientRateLimit(l tailcfg.DERPClientRateLimit) {
+	s.clientRateLimit = l
+}
+
 // SetTailscaledSocketPath sets the unix socket path to use to talk to
 // tailscaled if client verification is enabled.
 //
@@ -922,7 +940,15 @@ func (s *Server) accept(ctx context.Context, nc derp.Conn, brw *bufio.ReadWriter
 	}
 
 	remoteIPPort, _ := netip.ParseAddrPort(remoteAddr)
-	if err := s.verifyClient(ctx, clientKey, clientInfo, remoteIPPort.Addr()); err != nil {
+	rateLimit, err := s.verifyClient(ctx, clientKey, clientInfo, remoteIPPort.Addr())
+	if err != nil {
+		s.audit(AuditEvent{
+			Type:    AuditAdmissionDenied,
+			Node:    clientKey,
+			Addr:    remoteIPPort,
+			ConnNum: connNum,
+			Reason:  err.Error(),
+		})
 		return fmt.Errorf("client %v rejected: %v", clientKey, err)
 	}
 
@@ -951,6 +977,9 @@ func (s *Server) accept(ctx context.Context, nc derp.Conn, brw *bufio.ReadWriter
 		isNotIdealConn: IdealNodeContextKey.Value(ctx) != "",
 		peerGoneLim:    rate.NewLimiter(rate.Every(time.Second), 3),
 	}
+	if !c.canMesh {
+		c.sendLim = newClientLimiter(rateLimit)
+	}
 
 	if c.canMesh {
 		c.meshUpdate = make(chan struct{}, 1) // must be buffered; >1 is fine but wasteful
@@ -968,7 +997,31 @@ func (s *Server) accept(ctx context.Context, nc derp.Conn, brw *bufio.ReadWriter
 	s.registerClient(c)
 	defer s.unregisterClient(c)
 
-	err = s.sendServerInfo(c.bw, clientKey)
+	s.audit(AuditEvent{
+		Type:    AuditConnect,
+		Node:    c.key,
+		Addr:    c.remoteIPPort,
+		ConnNum: c.connNum,
+		Mesh:    c.canMesh,
+	})
+	defer func() {
+		// c.run has returned by now, so its counters are no longer
+		// being written.
+		s.audit(AuditEvent{
+			Type:         AuditDisconnect,
+			Node:         c.key,
+			Addr:         c.remoteIPPort,
+			ConnNum:      c.connNum,
+			Mesh:         c.canMesh,
+			DurationSecs: s.clock.Since(c.connectedAt).Seconds(),
+			BytesRecv:    c.bytesRecv,
+			BytesSent:    c.bytesSent,
+			PacketsRecv:  c.packetsRecv,
+			PacketsSent:  c.packetsSent,
+		})
+	}()
+
+	err = s.sendServerInfo(c.bw, clientKey, c.sendLim)
 	if err != nil {
 		return fmt.Errorf("send server info: %v", err)
 	}
@@ -1141,6 +1194,8 @@ func (c *sclient) handleFrameForwardPacket(ft derp.FrameType, fl uint32) error {
 	if err != nil {
 		return fmt.Errorf("client %v: recvForwardPacket: %v", c.key, err)
 	}
+	c.packetsRecv++
+	c.bytesRecv += int64(len(contents))
 	s.packetsForwardedIn.Add(1)
 
 	var dstLen int
@@ -1181,6 +1236,13 @@ func (c *sclient) handleFrameSendPacket(ft derp.FrameType, fl uint32) error {
 	if err != nil {
 		return fmt.Errorf("client %v: recvPacket: %v", c.key, err)
 	}
+	c.packetsRecv++
+	c.bytesRecv += int64(len(contents))
+	if !c.sendLim.allow(s.clock.Now(), len(contents)) {
+		s.recordDrop(contents, c.key, dstKey, dropReasonRateLimited)
+		c.debugLogf("SendPacket for %s, dropping over rate limit", dstKey.ShortString())
+		return nil
+	}
 
 	var fwd PacketForwarder
 	var dstLen int
@@ -1249,6 +1311,7 @@ type dropReasonKindLabels struct {
 	dropReasonQueueTail        dropReason = "queue_tail"          // destination queue is full, dropped packet at queue tail
 	dropReasonWriteError       dropReason = "write_error"         // OS write() failed
 	dropReasonDupClient        dropReason = "dup_client"          // the public key is connected 2+ times (active/active, fighting)
+	dropReasonRateLimited      dropReason = "rate_limited"        // the sending client exceeded its rate limit
 )
 
 func (s *Server) recordDrop(packetBytes []byte, srcKey, dstKey key.NodePublic, reason dropReason) {
@@ -1368,26 +1431,30 @@ func (s *Server) isMeshPeer(info *derp.ClientInfo) bool {
 
 // verifyClient checks whether the client is allowed to connect to the derper,
 // depending on how & whether the server's been configured to verify.
-func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, info *derp.ClientInfo, clientIP netip.Addr) error {
+//
+// If so, it returns the rate limit to apply to the client: the server's
+// default, unless overridden by the admission controller.
+func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, info *derp.ClientInfo, clientIP netip.Addr) (rateLimit tailcfg.DERPClientRateLimit, err error) {
+	rateLimit = s.clientRateLimit
 	if s.isMeshPeer(info) {
 		// Trusted mesh peer. No need to verify further. In fact, verifying
 		// further wouldn't work: it's not part of the tailnet so tailscaled and
 		// likely the admission control URL wouldn't know about it.
-		return nil
+		return rateLimit, nil
 	}
 
 	// tailscaled-based verification:
 	if s.verifyClientsLocalTailscaled {
 		_, err := s.localClient.WhoIsNodeKey(ctx, clientKey)
 		if err == local.ErrPeerNotFound {
-			return fmt.Errorf("peer %v not authorized (not found in local tailscaled)", clientKey)
+			return rateLimit, fmt.Errorf("peer %v not authorized (not found in local tailscaled)", clientKey)
 		}
 		if err != nil {
 			if strings.Contains(err.Error(), "invalid 'addr' parameter") {
 				// Issue 12617
-				return errors.New("tailscaled version is too old (out of sync with derper binary)")
+				return rateLimit, errors.New("tailscaled version is too old (out of sync with derper binary)")
 			}
-			return fmt.Errorf("failed to query local tailscaled status for %v: %w", clientKey, err)
+			return rateLimit, fmt.Errorf("failed to query local tailscaled status for %v: %w", clientKey, err)
 		}
 	}
 
@@ -1401,34 +1468,36 @@ func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, inf
 			Source:     clientIP,
 		})
 		if err != nil {
-			return err
+			return rateLimit, err
 		}
 		req, err := http.NewRequestWithContext(ctx, "POST", s.verifyClientsURL, bytes.NewReader(jreq))
 		if err != nil {
-			return err
+			return rateLimit, err
 		}
 		res, err := http.DefaultClient.Do(req)
 		if err != nil {
 			if s.verifyClientsURLFailOpen {
 				s.logf("admission controller unreachable; allowing client %v", clientKey)
-				return nil
+				return rateLimit, nil
 			}
-			return err
+			return rateLimit, err
 		}
 		defer res.Body.Close()
 		if res.StatusCode != 200 {
-			return fmt.Errorf("admission controller: %v", res.Status)
+			return rateLimit, fmt.Errorf("admission controller: %v", res.Status)
 		}
 		var jres tailcfg.DERPAdmitClientResponse
 		if err := json.NewDecoder(io.LimitReader(res.Body, 4<<10)).Decode(&jres); err != nil {
-			return err
+			return rateLimit, err
 		}
 		if !jres.Allow {
-			return fmt.Errorf("admission controller: %v/%v not allowed", clientKey, clientIP)
+			return rateLimit, fmt.Errorf("admission controller: %v/%v not allowed", clientKey, clientIP)
+		}
+		if jres.RateLimit != nil {
+			rateLimit = *jres.RateLimit
 		}
-		// TODO(bradfitz): add policy for configurable bandwidth rate per client?
 	}
-	return nil
+	return rateLimit, nil
 }
 
 func (s *Server) sendServerKey(lw *lazyBufioWriter) error {
@@ -1502,8 +1571,10 @@ func (s *Server) noteClientActivity(c *sclient) {
 
 type ServerInfo = derp.ServerInfo
 
-func (s *Server) sendServerInfo(bw *lazyBufioWriter, clientKey key.NodePublic) error {
-	msg, err := json.Marshal(ServerInfo{Version: derp.ProtocolVersion})
+func (s *Server) sendServerInfo(bw *lazyBufioWriter, clientKey key.NodePublic, lim *clientLimiter) error {
+	si := ServerInfo{Version: derp.ProtocolVersion}
+	si.TokenBucketBytesPerSecond, si.TokenBucketBytesBurst = lim.serverInfoLimits()
+	msg, err := json.Marshal(si)
 	if err != nil {
 		return err
 	}
@@ -1655,6 +1726,15 @@ type sclient struct {
 	// client that it's trying to establish a direct connection
 	// through us with a peer we have no record of.
 	peerGoneLim *rate.Limiter
+
+	// sendLim, if non-nil, limits the packets the client may send.
+	// It's only used by the run loop.
+	sendLim *clientLimiter
+
+	// Traffic totals for the audit log. The recv ones are owned by the
+	// run loop and the sent ones by sendLoop.
+	packetsRecv, bytesRecv int64
+	packetsSent, bytesSent int64
 }
 
 func (c *sclient) presentFlags() derp.PeerPresentFlags {
@@ -1989,6 +2069,8 @@ func (c *sclient) sendPacket(srcKey key.NodePublic, contents []byte) (err error)
 		} else {
 			c.s.packetsSent.Add(1)
 			c.s.bytesSent.Add(int64(len(contents)))
+			c.packetsSent++
+			c.bytesSent += int64(len(contents))
 		}
 		c.debugLogf("sendPacket from %s: %v", srcKey.ShortString(), err)
 	}()
@@ -2030,27 +2112,30 @@ func (c *sclient) noteSendFromSrc(src key.NodePublic) {
 func (s *Server) AddPacketForwarder(dst key.NodePublic, fwd PacketForwarder) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if prev, ok := s.clientsMesh[dst]; ok {
-		if prev == fwd {
-			// Duplicate registration of same forwarder. Ignore.
-			return
-		}
-		if m, ok := prev.(*multiForwarder); ok {
-			if _, ok := m.all[fwd]; ok {
-				// Duplicate registration of same forwarder in set; ignore.
-				return
-			}
-			m.add(fwd)
+	prev, ok := s.clientsMesh[dst]
+	if ok && prev == fwd {
+		// Duplicate registration of same forwarder. Ignore.
+		return
+	}
+	m, isMulti := prev.(*multiForwarder)
+	if isMulti {
+		if _, ok := m.all[fwd]; ok {
+			// Duplicate registration of same forwarder in set; ignore.
 			return
 		}
-		if prev != nil {
-			// Otherwise, the existing value is not a set,
-			// not a dup, and not local-only (nil) so make
-			// it a set. `prev` existed first, so will have higher
-			// priority.
-			fwd = newMultiForwarder(prev, fwd)
-			s.multiForwarderCreated.Add(1)
-		}
+	}
+	s.auditMeshForward(AuditMeshForwardAdd, dst, fwd)
+	if isMulti {
+		m.add(fwd)
+		return
+	}
+	if prev != nil {
+		// Otherwise, the existing value is not a set,
+		// not a dup, and not local-only (nil) so make
+		// it a set. `prev` existed first, so will have higher
+		// priority.
+		fwd = newMultiForwarder(prev, fwd)
+		s.multiForwarderCreated.Add(1)
 	}
 	s.clientsMesh[dst] = fwd
 }
@@ -2068,6 +2153,9 @@ func (s *Server) RemovePacketForwarder(dst key.NodePublic, fwd PacketForwarder)
 		if len(m.all) < 2 {
 			panic("unexpected")
 		}
+		if _, ok := m.all[fwd]; ok {
+			s.auditMeshForward(AuditMeshForwardRemove, dst, fwd)
+		}
 		if remain, isLast := m.deleteLocked(fwd); isLast {
 			// If fwd was in m and we no longer need to be a
 			// multiForwarder, replace the entry with the
@@ -2087,6 +2175,7 @@ func (s *Server) RemovePacketForwarder(dst key.NodePublic, fwd PacketForwarder)
 		return
 	}
 
+	s.auditMeshForward(AuditMeshForwardRemove, dst, fwd)
 	if _, isLocal := s.clients[dst]; isLocal {
 		s.clientsMesh[dst] = nil
 	} else {
@@ -2281,7 +2370,7 @@ func (s *Server) checkVerifyClientsLocalTailscaled() error {
 		IsProber: true,
 	}
 	clientIP := netip.IPv6Loopback()
-	if err := s.verifyClient(ctx, status.Self.PublicKey, info, clientIP); err != nil {
+	if _, err := s.verifyClient(ctx, status.Self.PublicKey, info, clientIP); err != nil {
 		return fmt.Errorf("verifyClient for self nodekey: %w", err)
 	}
 	return nil
@@ -0,0 +1,78 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derpserver
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+	"tailscale.com/derp"
+	"tailscale.com/tailcfg"
+)
+
+// clientLimiter enforces a tailcfg.DERPClientRateLimit on the packets sent by
+// a client. It's only used by the client's read loop and isn't safe for
+// concurrent use.
+type clientLimiter struct {
+	bytes   *rate.Limiter // or nil if bytes aren't limited
+	packets *rate.Limiter // or nil if packets aren't limited
+}
+
+// newClientLimiter returns a limiter for l, or nil if l doesn't limit
+// anything.
+func newClientLimiter(l tailcfg.DERPClientRateLimit) *clientLimiter {
+	if l.IsZero() {
+		return nil
+	}
+	cl := new(clientLimiter)
+	if l.BytesPerSecond > 0 {
+		burst := l.BytesBurst
+		if burst <= 0 {
+			burst = int(min(math.Ceil(l.BytesPerSecond), math.MaxInt32))
+		}
+		// A burst smaller than a packet would drop every packet of
+		// that size.
+		burst = max(burst, derp.MaxPacketSize)
+		cl.bytes = rate.NewLimiter(rate.Limit(l.BytesPerSecond), burst)
+	}
+	if l.PacketsPerSecond > 0 {
+		burst := l.PacketsBurst
+		if burst <= 0 {
+			burst = int(min(math.Ceil(l.PacketsPerSecond), math.MaxInt32))
+		}
+		cl.packets = rate.NewLimiter(rate.Limit(l.PacketsPerSecond), burst)
+	}
+	return cl
+}
+
+// allow reports whether a packet of n bytes may be sent at now, and if so
+// consumes its tokens. A nil limiter allows everything.
+func (cl *clientLimiter) allow(now time.Time, n int) bool {
+	if cl == nil {
+		return true
+	}
+	// Check the bytes before consuming a packet token, so a dropped packet
+	// doesn't count against either limit.
+	if cl.bytes != nil && cl.bytes.TokensAt(now) < float64(n) {
+		return false
+	}
+	if cl.packets != nil && !cl.packets.AllowN(now, 1) {
+		return false
+	}
+	if cl.bytes != nil {
+		cl.bytes.AllowN(now, n)
+	}
+	return true
+}
+
+// serverInfoLimits returns the byte token bucket parameters to advertise to
+// the client in its ServerInfo, so cooperating clients can stay under the
+// limit rather than have their packets dropped by the server.
+func (cl *clientLimiter) serverInfoLimits() (bytesPerSecond, bytesBurst int) {
+	if cl == nil || cl.bytes == nil {
+		return 0, 0
+	}
+	return int(cl.bytes.Limit()), cl.bytes.Burst()
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestClientLimiter(t *testing.T) {
+	if cl := newClientLimiter(tailcfg.DERPClientRateLimit{}); cl != nil {
+		t.Fatalf("zero limit: got limiter %+v, want nil", cl)
+	}
+	var nilLimiter *clientLimiter
+	if !nilLimiter.allow(time.Now(), derp.MaxPacketSize) {
+		t.Error("nil limiter disallowed packet")
+	}
+
+	now := time.Unix(1700000000, 0)
+
+	t.Run("bytes", func(t *testing.T) {
+		cl := newClientLimiter(tailcfg.DERPClientRateLimit{BytesPerSecond: 1000, BytesBurst: 100})
+		if got, want := cl.bytes.Burst(), derp.MaxPacketSize; got != want {
+			t.Errorf("burst = %d; want raised to %d", got, want)
+		}
+		if !cl.allow(now, derp.MaxPacketSize) {
+			t.Fatal("first packet disallowed")
+		}
+		if cl.allow(now, 1) {
+			t.Fatal("packet allowed with empty bucket")
+		}
+		if !cl.allow(now.Add(time.Second), 1000) {
+			t.Fatal("packet disallowed after refill")
+		}
+		if bps, burst := cl.serverInfoLimits(); bps != 1000 || burst != derp.MaxPacketSize {
+			t.Errorf("serverInfoLimits = %d, %d; want 1000, %d", bps, burst, derp.MaxPacketSize)
+		}
+	})
+
+	t.Run("packets", func(t *testing.T) {
+		cl := newClientLimiter(tailcfg.DERPClientRateLimit{PacketsPerSecond: 10})
+		for i := range 10 {
+			if !cl.allow(now, 1) {
+				t.Fatalf("packet %d disallowed within burst", i)
+			}
+		}
+		if cl.allow(now, 1) {
+			t.Fatal("packet allowed over burst")
+		}
+		if !cl.allow(now.Add(100*time.Millisecond), 1) {
+			t.Fatal("packet disallowed after refill")
+		}
+		if bps, burst := cl.serverInfoLimits(); bps != 0 || burst != 0 {
+			t.Errorf("serverInfoLimits = %d, %d; want 0, 0", bps, burst)
+		}
+	})
+
+	t.Run("both", func(t *testing.T) {
+		cl := newClientLimiter(tailcfg.DERPClientRateLimit{
+			BytesPerSecond:   derp.MaxPacketSize,
+			PacketsPerSecond: 1,
+			PacketsBurst:     2,
+		})
+		if !cl.allow(now, derp.MaxPacketSize) {
+			t.Fatal("first packet disallowed")
+		}
+		// Dropped for lack of bytes; mustn't consume a packet token.
+		if cl.allow(now, 1) {
+			t.Fatal("packet allowed with no bytes left")
+		}
+		if !cl.allow(now.Add(time.Millisecond), 10) {
+			t.Fatal("second packet disallowed")
+		}
+		if cl.allow(now.Add(2*time.Millisecond), 10) {
+			t.Fatal("third packet allowed over packet burst")
+		}
+	})
+}
+
+func TestVerifyClientRateLimit(t *testing.T) {
+	override := &tailcfg.DERPClientRateLimit{BytesPerSecond: 5000}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req tailcfg.DERPAdmitClientRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res := tailcfg.DERPAdmitClientResponse{Allow: true}
+		if req.Source == netip.MustParseAddr("100.64.0.2") {
+			res.RateLimit = override
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer ts.Close()
+
+	s := New(key.NewNode(), t.Logf)
+	defer s.Close()
+	def := tailcfg.DERPClientRateLimit{BytesPerSecond: 1000, PacketsPerSecond: 10}
+	s.SetClientRateLimit(def)
+	s.SetVerifyClientURL(ts.URL)
+
+	ctx := context.Background()
+	clientKey := key.NewNode().Public()
+	got, err := s.verifyClient(ctx, clientKey, nil, netip.MustParseAddr("100.64.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != def {
+		t.Errorf("default client limit = %+v; want %+v", got, def)
+	}
+	got, err = s.verifyClient(ctx, clientKey, nil, netip.MustParseAddr("100.64.0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != *override {
+		t.Errorf("overridden client limit = %+v; want %+v", got, *override)
+	}
+}
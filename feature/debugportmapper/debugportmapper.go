@@ -102,6 +102,17 @@ func serveDebugPortmap(h *localapi.Handler, w http.ResponseWriter, r *http.Reque
 		logLock.Unlock()
 	}()
 
+	for _, st := range h.LocalBackend().StaticPortMappings() {
+		switch {
+		case st.Type != "":
+			logf("static mapping %v: %v over %s, good until %v", st.StaticMapping, st.External, st.Type, st.GoodUntil.Format(time.RFC3339))
+		case st.Err != "":
+			logf("static mapping %v: %s", st.StaticMapping, st.Err)
+		default:
+			logf("static mapping %v: pending", st.StaticMapping)
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), dur)
 	defer cancel()
 
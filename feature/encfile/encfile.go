@@ -0,0 +1,307 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package encfile implements an ipn.StateStore that encrypts the state file
+// with a key derived from a keyfile or passphrase, for devices where a TPM
+// isn't available to tailscaled.
+//
+// The key source is read from the environment: $TS_STATE_KEYFILE names a file
+// whose contents are the key material, and $TS_STATE_PASSPHRASE is a
+// passphrase. Exactly one of them must be set. The passphrase is removed from
+// the environment once read, so that tailscaled's child processes don't
+// inherit it.
+package encfile
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"tailscale.com/atomicfile"
+	"tailscale.com/feature"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store"
+	"tailscale.com/paths"
+	"tailscale.com/types/logger"
+)
+
+func init() {
+	feature.Register("encfile")
+	store.Register(store.EncFilePrefix, newStore)
+}
+
+const (
+	// keyfileEnv and passphraseEnv are the environment variables providing
+	// the key material. They're deliberately not read via envknob, which
+	// logs the values of the knobs it knows about.
+	keyfileEnv    = "TS_STATE_KEYFILE"
+	passphraseEnv = "TS_STATE_PASSPHRASE"
+
+	// minKeyfileSize is the minimum size of a keyfile, in bytes.
+	minKeyfileSize = 16
+)
+
+// Key derivation functions, as recorded in the state file.
+const (
+	kdfHKDF   = "hkdf-sha256" // for keyfiles, which are assumed to be random
+	kdfArgon2 = "argon2id"    // for passphrases
+)
+
+// fileVersion is the current version of the state file format.
+const fileVersion = 1
+
+// encryptedFile is the on-disk format of the state file. The set of JSON keys
+// must match the one in package store, which uses it to detect files that
+// need migration.
+type encryptedFile struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`   // kdfHKDF or kdfArgon2
+	Salt    []byte `json:"salt"`  // KDF salt, fixed for the life of the file
+	Nonce   []byte `json:"nonce"` // XChaCha20-Poly1305 nonce, new for each write
+	Data    []byte `json:"data"`  // the sealed JSON map of state keys to values
+}
+
+// keySource is key material from the environment, along with the KDF to
+// derive the encryption key from it.
+type keySource struct {
+	kdf    string
+	secret []byte
+}
+
+// envPassphrase is the value of $TS_STATE_PASSPHRASE, which is removed from the
+// environment once read, so that child processes such as SSH sessions don't
+// inherit it.
+var envPassphrase struct {
+	mu  sync.Mutex
+	val string
+}
+
+// passphraseFromEnv returns the passphrase set in the environment, if any,
+// removing it from the environment. Later calls return the same passphrase,
+// unless the environment variable is set again.
+func passphraseFromEnv() string {
+	envPassphrase.mu.Lock()
+	defer envPassphrase.mu.Unlock()
+	if v, ok := os.LookupEnv(passphraseEnv); ok {
+		envPassphrase.val = v
+		os.Unsetenv(passphraseEnv)
+	}
+	return envPassphrase.val
+}
+
+// keySourceFromEnv returns the key source configured in the environment.
+func keySourceFromEnv() (*keySource, error) {
+	keyfile, passphrase := os.Getenv(keyfileEnv), passphraseFromEnv()
+	switch {
+	case keyfile != "" && passphrase != "":
+		return nil, fmt.Errorf("only one of $%s and $%s may be set", keyfileEnv, passphraseEnv)
+	case keyfile != "":
+		secret, err := os.ReadFile(keyfile)
+		if err != nil {
+			return nil, fmt.Errorf("reading keyfile: %w", err)
+		}
+		if len(secret) < minKeyfileSize {
+			return nil, fmt.Errorf("keyfile %q is too short; must be at least %d bytes", keyfile, minKeyfileSize)
+		}
+		return &keySource{kdf: kdfHKDF, secret: secret}, nil
+	case passphrase != "":
+		return &keySource{kdf: kdfArgon2, secret: []byte(passphrase)}, nil
+	}
+	return nil, fmt.Errorf("encrypted state files require $%s or $%s to be set", keyfileEnv, passphraseEnv)
+}
+
+// deriveKey returns the encryption key for the given salt.
+func (ks *keySource) deriveKey(salt []byte) ([]byte, error) {
+	switch ks.kdf {
+	case kdfHKDF:
+		key := make([]byte, chacha20poly1305.KeySize)
+		r := hkdf.New(sha256.New, ks.secret, salt, []byte("tailscale state file"))
+		if _, err := io.ReadFull(r, key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	case kdfArgon2:
+		// The RFC 9106 recommended parameters for memory-constrained
+		// environments. This only runs once, when tailscaled starts.
+		return argon2.IDKey(ks.secret, salt, 3, 64*1024, 4, chacha20poly1305.KeySize), nil
+	}
+	return nil, fmt.Errorf("unknown key derivation function %q", ks.kdf)
+}
+
+// describeKDF returns a description of the key source of a file encrypted
+// with kdf, for error messages.
+func describeKDF(kdf string) string {
+	switch kdf {
+	case kdfHKDF:
+		return "a keyfile ($" + keyfileEnv + ")"
+	case kdfArgon2:
+		return "a passphrase ($" + passphraseEnv + ")"
+	}
+	return fmt.Sprintf("unknown key derivation function %q", kdf)
+}
+
+func newStore(logf logger.Logf, path string) (ipn.StateStore, error) {
+	path = strings.TrimPrefix(path, store.EncFilePrefix)
+	if err := paths.MkStateDir(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+	ks, err := keySourceFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to open %q: %w", path, err)
+		}
+		logf("encfile.newStore: initializing state file")
+
+		salt := make([]byte, 16)
+		// crypto/rand.Read never returns an error.
+		rand.Read(salt)
+		s, err := openStore(path, ks, salt)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.writeEncrypted(); err != nil {
+			return nil, fmt.Errorf("failed to write initial state file: %w", err)
+		}
+		return s, nil
+	}
+
+	// State file exists, decrypt and parse it.
+	var ef encryptedFile
+	if err := json.Unmarshal(bs, &ef); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state file: %w", err)
+	}
+	if ef.Version != fileVersion || len(ef.Salt) == 0 || len(ef.Data) == 0 {
+		return nil, fmt.Errorf("state file %q is not encrypted or is corrupt", path)
+	}
+	if len(ef.Nonce) != chacha20poly1305.NonceSizeX {
+		return nil, fmt.Errorf("nonce should be %d bytes long, got %d", chacha20poly1305.NonceSizeX, len(ef.Nonce))
+	}
+	if ef.KDF != ks.kdf {
+		return nil, fmt.Errorf("state file %q was encrypted with %s, not %s", path, describeKDF(ef.KDF), describeKDF(ks.kdf))
+	}
+	s, err := openStore(path, ks, ef.Salt)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.aead.Open(nil, ef.Nonce, ef.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt state file %q; wrong key?", path)
+	}
+	if err := json.Unmarshal(data, &s.cache); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	return s, nil
+}
+
+// openStore returns an encStore with an empty cache for the file at path,
+// encrypted with the key derived from ks and salt.
+func openStore(path string, ks *keySource, salt []byte) (*encStore, error) {
+	key, err := ks.deriveKey(salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encStore{
+		path:  path,
+		kdf:   ks.kdf,
+		salt:  salt,
+		aead:  aead,
+		cache: make(map[ipn.StateKey][]byte),
+	}, nil
+}
+
+// encStore is an ipn.StateStore that stores the state in a file encrypted
+// with XChaCha20-Poly1305, using a key derived from a keyfile or passphrase.
+type encStore struct {
+	ipn.EncryptedStateStore
+
+	path string
+	kdf  string
+	salt []byte
+	aead cipher.AEAD
+
+	mu    sync.RWMutex
+	cache map[ipn.StateKey][]byte
+}
+
+func (s *encStore) String() string { return fmt.Sprintf("encfile.encStore(%q)", s.path) }
+
+func (s *encStore) ReadState(k ipn.StateKey) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.cache[k]
+	if !ok {
+		return nil, ipn.ErrStateNotExist
+	}
+	return bytes.Clone(v), nil
+}
+
+func (s *encStore) WriteState(k ipn.StateKey, bs []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bytes.Equal(s.cache[k], bs) {
+		return nil
+	}
+	s.cache[k] = bytes.Clone(bs)
+
+	return s.writeEncrypted()
+}
+
+// writeEncrypted encrypts the cache with a fresh nonce and writes it to disk.
+// s.mu must be held, or s not yet shared.
+func (s *encStore) writeEncrypted() error {
+	bs, err := json.Marshal(s.cache)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	// crypto/rand.Read never returns an error.
+	rand.Read(nonce)
+	buf, err := json.Marshal(encryptedFile{
+		Version: fileVersion,
+		KDF:     s.kdf,
+		Salt:    s.salt,
+		Nonce:   nonce,
+		Data:    s.aead.Seal(nil, nonce, bs, nil),
+	})
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(s.path, buf, 0600)
+}
+
+func (s *encStore) All() iter.Seq2[ipn.StateKey, []byte] {
+	return func(yield func(ipn.StateKey, []byte) bool) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for k, v := range s.cache {
+			if !yield(k, v) {
+				break
+			}
+		}
+	}
+}
+
+// Ensure encStore implements store.ExportableStore for migration to/from
+// store.FileStore.
+var _ store.ExportableStore = (*encStore)(nil)
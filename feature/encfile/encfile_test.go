@@ -0,0 +1,219 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package encfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store"
+)
+
+// setKeyfile sets $TS_STATE_KEYFILE to a new keyfile containing key.
+func setKeyfile(t *testing.T, key string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte(key), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(keyfileEnv, path)
+	t.Setenv(passphraseEnv, "")
+}
+
+func setPassphrase(t *testing.T, passphrase string) {
+	t.Setenv(keyfileEnv, "")
+	t.Setenv(passphraseEnv, passphrase)
+}
+
+func TestStore(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		setKey func(t *testing.T)
+	}{
+		{"keyfile", func(t *testing.T) { setKeyfile(t, "0123456789abcdef0123456789abcdef") }},
+		{"passphrase", func(t *testing.T) { setPassphrase(t, "correct horse battery staple") }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setKey(t)
+			path := filepath.Join(t.TempDir(), "state")
+			s, err := newStore(t.Logf, store.EncFilePrefix+path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.ReadState("k1"); !errors.Is(err, ipn.ErrStateNotExist) {
+				t.Errorf("ReadState(k1) = %v, want %v", err, ipn.ErrStateNotExist)
+			}
+			want := map[ipn.StateKey][]byte{
+				"k1": []byte("v1"),
+				"k2": []byte("v2"),
+			}
+			for k, v := range want {
+				if err := s.WriteState(k, v); err != nil {
+					t.Fatalf("WriteState(%q): %v", k, err)
+				}
+			}
+
+			buf, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(buf, []byte("v1")) {
+				t.Errorf("state file contains plaintext value: %s", buf)
+			}
+
+			s, err = newStore(t.Logf, store.EncFilePrefix+path)
+			if err != nil {
+				t.Fatalf("reopening store: %v", err)
+			}
+			got := maps.Collect(s.(store.ExportableStore).All())
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("unexpected content after reopening (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPassphraseNotInherited(t *testing.T) {
+	setPassphrase(t, "correct horse battery staple")
+	path := filepath.Join(t.TempDir(), "state")
+	if _, err := newStore(t.Logf, store.EncFilePrefix+path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := os.LookupEnv(passphraseEnv); ok {
+		t.Errorf("$%s still set after opening the store", passphraseEnv)
+	}
+	// The store can still be reopened with the passphrase read before.
+	if _, err := newStore(t.Logf, store.EncFilePrefix+path); err != nil {
+		t.Errorf("reopening store: %v", err)
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	setKeyfile(t, "0123456789abcdef0123456789abcdef")
+	if _, err := newStore(t.Logf, store.EncFilePrefix+path); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		setKey  func(t *testing.T)
+		wantErr string
+	}{
+		{
+			name:    "other-keyfile",
+			setKey:  func(t *testing.T) { setKeyfile(t, "fedcba9876543210fedcba9876543210") },
+			wantErr: "wrong key",
+		},
+		{
+			name:    "passphrase",
+			setKey:  func(t *testing.T) { setPassphrase(t, "hunter2") },
+			wantErr: "was encrypted with a keyfile",
+		},
+		{
+			name:    "short-keyfile",
+			setKey:  func(t *testing.T) { setKeyfile(t, "short") },
+			wantErr: "too short",
+		},
+		{
+			name:    "none",
+			setKey:  func(t *testing.T) { setPassphrase(t, "") },
+			wantErr: "require",
+		},
+		{
+			name: "both",
+			setKey: func(t *testing.T) {
+				setKeyfile(t, "0123456789abcdef0123456789abcdef")
+				t.Setenv(passphraseEnv, "hunter2")
+			},
+			wantErr: "only one of",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setKey(t)
+			_, err := newStore(t.Logf, store.EncFilePrefix+path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("newStore error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMigrateState(t *testing.T) {
+	setKeyfile(t, "0123456789abcdef0123456789abcdef")
+	storePath := filepath.Join(t.TempDir(), "store")
+
+	initial, err := store.New(t.Logf, storePath)
+	if err != nil {
+		t.Fatalf("store.New failed for new file store: %v", err)
+	}
+	content := map[ipn.StateKey][]byte{
+		"_machinekey": []byte("mkey"),
+		"foo":         []byte("bar"),
+	}
+	for k, v := range content {
+		if err := initial.WriteState(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keysPlaintext := []string{"_machinekey", "foo"}
+	keysEncFile := []string{"version", "kdf", "salt", "nonce", "data"}
+
+	for _, tt := range []struct {
+		desc     string
+		path     string
+		wantKeys []string
+	}{
+		{
+			desc:     "plaintext-to-encfile",
+			path:     store.EncFilePrefix + storePath,
+			wantKeys: keysEncFile,
+		},
+		{
+			desc:     "encfile-to-encfile",
+			path:     store.EncFilePrefix + storePath,
+			wantKeys: keysEncFile,
+		},
+		{
+			desc:     "encfile-to-plaintext",
+			path:     storePath,
+			wantKeys: keysPlaintext,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			s, err := store.New(t.Logf, tt.path)
+			if err != nil {
+				t.Fatalf("migration failed: %v", err)
+			}
+			gotContent := maps.Collect(s.(store.ExportableStore).All())
+			if diff := cmp.Diff(content, gotContent); diff != "" {
+				t.Errorf("unexpected content after migration, diff:\n%s", diff)
+			}
+
+			buf, err := os.ReadFile(storePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var data map[string]any
+			if err := json.Unmarshal(buf, &data); err != nil {
+				t.Fatal(err)
+			}
+			gotKeys := slices.Sorted(maps.Keys(data))
+			slices.Sort(tt.wantKeys)
+			if diff := cmp.Diff(gotKeys, tt.wantKeys); diff != "" {
+				t.Errorf("unexpected content keys after migration, diff:\n%s", diff)
+			}
+		})
+	}
+}
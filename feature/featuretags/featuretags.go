@@ -147,9 +147,11 @@ type FeatureMeta struct {
 		Desc: "portmapper debug support",
 		Deps: []FeatureTag{"portmapper"},
 	},
+	"derpquic":         {Sym: "DERPQUIC", Desc: "Experimental DERP-over-QUIC client support"},
 	"desktop_sessions": {Sym: "DesktopSessions", Desc: "Desktop sessions support"},
 	"doctor":           {Sym: "Doctor", Desc: "Diagnose possible issues with Tailscale and its host environment"},
 	"drive":            {Sym: "Drive", Desc: "Tailscale Drive (file server) support"},
+	"encfile":          {Sym: "EncFile", Desc: "Keyfile or passphrase encrypted state file support"},
 	"gro": {
 		Sym:  "GRO",
 		Desc: "Generic Receive Offload support (performance)",
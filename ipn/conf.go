@@ -50,6 +50,27 @@ type ConfigVAlpha struct {
 	// should advertise amongst its wireguard endpoints.
 	StaticEndpoints []netip.AddrPort `json:",omitempty"`
 
+	// DNSOverrides are node-local DNS records answered by 100.100.100.100,
+	// and DNSBlocklists paths of files listing domains it blocks.
+	// See the Prefs fields of the same names.
+	DNSOverrides  []tailcfg.DNSRecord `json:",omitempty"`
+	DNSBlocklists []string            `json:",omitempty"`
+
+	// ValidateDNSSEC and DNSSECExemptDomains control the DNSSEC validation
+	// of responses forwarded by 100.100.100.100. See the Prefs fields of
+	// the same names.
+	ValidateDNSSEC      opt.Bool `json:",omitempty"`
+	DNSSECExemptDomains []string `json:",omitempty"`
+
+	// PortMappings are additional port mappings to request from the LAN
+	// gateway. See the Prefs field of the same name.
+	PortMappings []string `json:",omitempty"`
+
+	// BindInterface and BindInterfaceFallback pin tailscaled's traffic
+	// to a network interface. See the Prefs fields of the same names.
+	BindInterface         *string  `json:",omitempty"`
+	BindInterfaceFallback opt.Bool `json:",omitempty"`
+
 	// TODO(bradfitz,maisem): future something like:
 	// Profile map[string]*Config // keyed by alice@gmail.com, corp.com (TailnetSID)
 }
@@ -145,6 +166,34 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 		mp.AppConnector = *c.AppConnector
 		mp.AppConnectorSet = true
 	}
+	if c.DNSOverrides != nil {
+		mp.DNSOverrides = c.DNSOverrides
+		mp.DNSOverridesSet = true
+	}
+	if c.DNSBlocklists != nil {
+		mp.DNSBlocklists = c.DNSBlocklists
+		mp.DNSBlocklistsSet = true
+	}
+	if v, ok := c.ValidateDNSSEC.Get(); ok {
+		mp.ValidateDNSSEC = v
+		mp.ValidateDNSSECSet = true
+	}
+	if c.DNSSECExemptDomains != nil {
+		mp.DNSSECExemptDomains = c.DNSSECExemptDomains
+		mp.DNSSECExemptDomainsSet = true
+	}
+	if c.PortMappings != nil {
+		mp.PortMappings = c.PortMappings
+		mp.PortMappingsSet = true
+	}
+	if c.BindInterface != nil {
+		mp.BindInterface = *c.BindInterface
+		mp.BindInterfaceSet = true
+	}
+	if v, ok := c.BindInterfaceFallback.Get(); ok {
+		mp.BindInterfaceFallback = v
+		mp.BindInterfaceFallbackSet = true
+	}
 	// Configfile should be the source of truth for whether this node
 	// advertises any services.  We need to ensure that each reload updates
 	// currently advertised services as else the transition from 'some
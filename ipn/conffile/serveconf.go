@@ -16,6 +16,7 @@
 	jsonv2 "github.com/go-json-experiment/json"
 	"github.com/go-json-experiment/json/jsontext"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/opt"
 	"tailscale.com/util/mak"
 )
@@ -81,6 +82,29 @@ type Target struct {
 	// If Protocol is not ProtoFile or ProtoTUN, then DestinationPorts is the
 	// set of ports on which to connect to the host referred to by Destination.
 	DestinationPorts tailcfg.PortRange
+
+	// HTTP, if non-nil, are options for a target with Protocol ProtoHTTP,
+	// ProtoHTTPS or ProtoHTTPSInsecure. A target with options is written
+	// as an object, such as {"target": "http://localhost:3000",
+	// "keepPrefix": true}, rather than a string.
+	HTTP *HTTPOptions
+}
+
+// HTTPOptions are options for HTTP proxy targets. They correspond to the
+// fields of the same names in ipn.HTTPHandler.
+type HTTPOptions struct {
+	SetRequestHeaders  map[string]string `json:"setRequestHeaders,omitzero"`
+	SetResponseHeaders map[string]string `json:"setResponseHeaders,omitzero"`
+	KeepPrefix         bool              `json:"keepPrefix,omitzero"`
+	Timeout            tstime.GoDuration `json:"timeout,omitzero"` // such as "30s"
+	RateLimit          float64           `json:"rateLimit,omitzero"`
+	RateBurst          int               `json:"rateBurst,omitzero"`
+}
+
+// targetObject is the JSON object form of a Target with HTTP options.
+type targetObject struct {
+	Target      string `json:"target"`
+	HTTPOptions `json:",inline"`
 }
 
 // UnmarshalJSON implements [jsonv1.Unmarshaler].
@@ -90,11 +114,32 @@ func (t *Target) UnmarshalJSON(buf []byte) error {
 
 // UnmarshalJSONFrom implements [jsonv2.UnmarshalerFrom].
 func (t *Target) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
+	if dec.PeekKind() == '{' {
+		var obj targetObject
+		if err := jsonv2.UnmarshalDecode(dec, &obj); err != nil {
+			return err
+		}
+		if err := t.parse(obj.Target); err != nil {
+			return err
+		}
+		switch t.Protocol {
+		case ProtoHTTP, ProtoHTTPS, ProtoHTTPSInsecure:
+		default:
+			return fmt.Errorf("target %q: options are only supported for HTTP targets", obj.Target)
+		}
+		t.HTTP = &obj.HTTPOptions
+		return nil
+	}
+
 	var str string
 	if err := jsonv2.UnmarshalDecode(dec, &str); err != nil {
 		return err
 	}
+	return t.parse(str)
+}
 
+// parse sets t from its string form.
+func (t *Target) parse(str string) error {
 	// The TUN case does not look like a standard <url>://<proto> arrangement,
 	// so handled separately.
 	if str == "TUN" {
@@ -145,6 +190,27 @@ func (t *Target) MarshalText() ([]byte, error) {
 	return []byte(out), nil
 }
 
+// MarshalJSON implements [jsonv1.Marshaler].
+func (t *Target) MarshalJSON() ([]byte, error) {
+	return jsonv2.Marshal(t)
+}
+
+// MarshalJSONTo implements [jsonv2.MarshalerTo]. Targets with HTTP options
+// are marshaled as objects, and others as strings.
+func (t *Target) MarshalJSONTo(enc *jsontext.Encoder) error {
+	text, err := t.MarshalText()
+	if err != nil {
+		return err
+	}
+	if t.HTTP == nil {
+		return enc.WriteToken(jsontext.String(string(text)))
+	}
+	return jsonv2.MarshalEncode(enc, &targetObject{
+		Target:      string(text),
+		HTTPOptions: *t.HTTP,
+	})
+}
+
 func LoadServicesConfig(filename string, forService string) (*ServicesConfigFile, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
@@ -11,6 +11,7 @@
 
 	"tailscale.com/drive"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
@@ -64,6 +65,10 @@ func (src *Prefs) Clone() *Prefs {
 	if dst.RelayServerPort != nil {
 		dst.RelayServerPort = ptr.To(*src.RelayServerPort)
 	}
+	dst.DNSOverrides = append(src.DNSOverrides[:0:0], src.DNSOverrides...)
+	dst.DNSBlocklists = append(src.DNSBlocklists[:0:0], src.DNSBlocklists...)
+	dst.DNSSECExemptDomains = append(src.DNSSECExemptDomains[:0:0], src.DNSSECExemptDomains...)
+	dst.PortMappings = append(src.PortMappings[:0:0], src.PortMappings...)
 	dst.Persist = src.Persist.Clone()
 	return dst
 }
@@ -101,6 +106,13 @@ func (src *Prefs) Clone() *Prefs {
 	NetfilterKind          string
 	DriveShares            []*drive.Share
 	RelayServerPort        *int
+	DNSOverrides           []tailcfg.DNSRecord
+	DNSBlocklists          []string
+	ValidateDNSSEC         bool
+	DNSSECExemptDomains    []string
+	PortMappings           []string
+	BindInterface          string
+	BindInterfaceFallback  bool
 	AllowSingleHosts       marshalAsTrueInJSON
 	Persist                *persist.Persist
 }{})
@@ -123,6 +135,16 @@ func (src *ServeConfig) Clone() *ServeConfig {
 			}
 		}
 	}
+	if dst.UDP != nil {
+		dst.UDP = map[uint16]*UDPPortHandler{}
+		for k, v := range src.UDP {
+			if v == nil {
+				dst.UDP[k] = nil
+			} else {
+				dst.UDP[k] = ptr.To(*v)
+			}
+		}
+	}
 	if dst.Web != nil {
 		dst.Web = map[HostPort]*WebServerConfig{}
 		for k, v := range src.Web {
@@ -160,6 +182,7 @@ func (src *ServeConfig) Clone() *ServeConfig {
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ServeConfigCloneNeedsRegeneration = ServeConfig(struct {
 	TCP         map[uint16]*TCPPortHandler
+	UDP         map[uint16]*UDPPortHandler
 	Web         map[HostPort]*WebServerConfig
 	Services    map[tailcfg.ServiceName]*ServiceConfig
 	AllowFunnel map[HostPort]bool
@@ -218,10 +241,27 @@ func (src *TCPPortHandler) Clone() *TCPPortHandler {
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _TCPPortHandlerCloneNeedsRegeneration = TCPPortHandler(struct {
-	HTTPS        bool
-	HTTP         bool
-	TCPForward   string
-	TerminateTLS string
+	HTTPS         bool
+	HTTP          bool
+	TCPForward    string
+	TerminateTLS  string
+	ProxyProtocol int
+}{})
+
+// Clone makes a deep copy of UDPPortHandler.
+// The result aliases no memory with the original.
+func (src *UDPPortHandler) Clone() *UDPPortHandler {
+	if src == nil {
+		return nil
+	}
+	dst := new(UDPPortHandler)
+	*dst = *src
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _UDPPortHandlerCloneNeedsRegeneration = UDPPortHandler(struct {
+	UDPForward string
 }{})
 
 // Clone makes a deep copy of HTTPHandler.
@@ -233,16 +273,24 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 	dst := new(HTTPHandler)
 	*dst = *src
 	dst.AcceptAppCaps = append(src.AcceptAppCaps[:0:0], src.AcceptAppCaps...)
+	dst.SetRequestHeaders = maps.Clone(src.SetRequestHeaders)
+	dst.SetResponseHeaders = maps.Clone(src.SetResponseHeaders)
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerCloneNeedsRegeneration = HTTPHandler(struct {
-	Path          string
-	Proxy         string
-	Text          string
-	AcceptAppCaps []tailcfg.PeerCapability
-	Redirect      string
+	Path               string
+	Proxy              string
+	Text               string
+	AcceptAppCaps      []tailcfg.PeerCapability
+	Redirect           string
+	SetRequestHeaders  map[string]string
+	SetResponseHeaders map[string]string
+	KeepPrefix         bool
+	Timeout            tstime.GoDuration
+	RateLimit          float64
+	RateBurst          int
 }{})
 
 // Clone makes a deep copy of WebServerConfig.
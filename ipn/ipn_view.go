@@ -14,13 +14,14 @@
 	"github.com/go-json-experiment/json/jsontext"
 	"tailscale.com/drive"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
 	"tailscale.com/types/views"
 )
 
-//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=LoginProfile,Prefs,ServeConfig,ServiceConfig,TCPPortHandler,HTTPHandler,WebServerConfig
+//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=LoginProfile,Prefs,ServeConfig,ServiceConfig,TCPPortHandler,UDPPortHandler,HTTPHandler,WebServerConfig
 
 // View returns a read-only view of LoginProfile.
 func (p *LoginProfile) View() LoginProfileView {
@@ -442,6 +443,55 @@ func (v PrefsView) RelayServerPort() views.ValuePointer[int] {
 	return views.ValuePointerOf(v.ж.RelayServerPort)
 }
 
+// DNSOverrides are node-local DNS records that the internal DNS
+// resolver (100.100.100.100) answers before MagicDNS names and before
+// forwarding queries. Their Type is "A", "AAAA", "CNAME" or "TXT", or
+// empty for A or AAAA depending on the Value.
+func (v PrefsView) DNSOverrides() views.Slice[tailcfg.DNSRecord] {
+	return views.SliceOf(v.ж.DNSOverrides)
+}
+
+// DNSBlocklists are the paths of local files listing domains that the
+// internal DNS resolver blocks, in hosts file or adblock list format.
+// Queries for blocked names are answered with an unspecified address
+// (0.0.0.0 or ::) instead of being forwarded.
+func (v PrefsView) DNSBlocklists() views.Slice[string] {
+	return views.SliceOf(v.ж.DNSBlocklists)
+}
+
+// ValidateDNSSEC is whether the internal DNS resolver validates the
+// DNSSEC signatures of the responses it forwards upstream, answering
+// SERVFAIL for bogus ones and setting the AD bit on secure ones.
+func (v PrefsView) ValidateDNSSEC() bool { return v.ж.ValidateDNSSEC }
+
+// DNSSECExemptDomains are the domains whose forwarded responses
+// aren't validated even if ValidateDNSSEC is set, such as split DNS
+// domains served by resolvers that strip DNSSEC records.
+func (v PrefsView) DNSSECExemptDomains() views.Slice[string] {
+	return views.SliceOf(v.ж.DNSSECExemptDomains)
+}
+
+// PortMappings are port mappings to request from the LAN gateway over
+// NAT-PMP, PCP or UPnP, in addition to the one for WireGuard, to make
+// services on this machine reachable from outside the LAN. Each is of
+// the form "[EXTERNAL:]INTERNAL/PROTO", such as "443:8443/tcp"; see
+// portmappertype.ParseStaticMapping.
+func (v PrefsView) PortMappings() views.Slice[string] {
+	return views.SliceOf(v.ж.PortMappings)
+}
+
+// BindInterface, if non-empty, is the name of the network interface
+// (such as "wlan0") that tailscaled's own traffic to peers, DERP
+// servers, STUN servers and the LAN gateway must use, instead of the
+// one with the default route. If empty, the tailscaled
+// --bind-interface flag applies.
+func (v PrefsView) BindInterface() string { return v.ж.BindInterface }
+
+// BindInterfaceFallback is whether tailscaled's traffic uses the
+// default route while BindInterface is missing or down. If false, it
+// goes offline instead until the interface comes back.
+func (v PrefsView) BindInterfaceFallback() bool { return v.ж.BindInterfaceFallback }
+
 // AllowSingleHosts was a legacy field that was always true
 // for the past 4.5 years. It controlled whether Tailscale
 // peers got /32 or /127 routes for each other.
@@ -493,6 +543,13 @@ func (v PrefsView) Persist() persist.PersistView { return v.ж.Persist.View() }
 	NetfilterKind          string
 	DriveShares            []*drive.Share
 	RelayServerPort        *int
+	DNSOverrides           []tailcfg.DNSRecord
+	DNSBlocklists          []string
+	ValidateDNSSEC         bool
+	DNSSECExemptDomains    []string
+	PortMappings           []string
+	BindInterface          string
+	BindInterfaceFallback  bool
 	AllowSingleHosts       marshalAsTrueInJSON
 	Persist                *persist.Persist
 }{})
@@ -572,6 +629,14 @@ func (v ServeConfigView) TCP() views.MapFn[uint16, *TCPPortHandler, TCPPortHandl
 	})
 }
 
+// UDP are the list of UDP port numbers that tailscaled should handle for
+// the Tailscale IP addresses. (not subnet routers, etc)
+func (v ServeConfigView) UDP() views.MapFn[uint16, *UDPPortHandler, UDPPortHandlerView] {
+	return views.MapFnOf(v.ж.UDP, func(t *UDPPortHandler) UDPPortHandlerView {
+		return t.View()
+	})
+}
+
 // Web maps from "$SNI_NAME:$PORT" to a set of HTTP handlers
 // keyed by mount point ("/", "/foo", etc)
 func (v ServeConfigView) Web() views.MapFn[HostPort, *WebServerConfig, WebServerConfigView] {
@@ -615,6 +680,7 @@ func (v ServeConfigView) ETag() string { return v.ж.ETag }
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ServeConfigViewNeedsRegeneration = ServeConfig(struct {
 	TCP         map[uint16]*TCPPortHandler
+	UDP         map[uint16]*UDPPortHandler
 	Web         map[HostPort]*WebServerConfig
 	Services    map[tailcfg.ServiceName]*ServiceConfig
 	AllowFunnel map[HostPort]bool
@@ -807,12 +873,98 @@ func (v TCPPortHandlerView) TCPForward() string { return v.ж.TCPForward }
 // (the HTTPS mode uses ServeConfig.Web)
 func (v TCPPortHandlerView) TerminateTLS() string { return v.ж.TerminateTLS }
 
+// ProxyProtocol, if non-zero, is the version of the HAProxy PROXY
+// protocol (1 or 2) whose header tailscaled sends to TCPForward at the
+// start of each connection, so that the backend learns the address of
+// the peer that made it. Version 2 headers also carry the peer's
+// identity; see package tailscale.com/net/proxyproto. It is only used
+// if TCPForward is non-empty.
+func (v TCPPortHandlerView) ProxyProtocol() int { return v.ж.ProxyProtocol }
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _TCPPortHandlerViewNeedsRegeneration = TCPPortHandler(struct {
-	HTTPS        bool
-	HTTP         bool
-	TCPForward   string
-	TerminateTLS string
+	HTTPS         bool
+	HTTP          bool
+	TCPForward    string
+	TerminateTLS  string
+	ProxyProtocol int
+}{})
+
+// View returns a read-only view of UDPPortHandler.
+func (p *UDPPortHandler) View() UDPPortHandlerView {
+	return UDPPortHandlerView{ж: p}
+}
+
+// UDPPortHandlerView provides a read-only view over UDPPortHandler.
+//
+// Its methods should only be called if `Valid()` returns true.
+type UDPPortHandlerView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *UDPPortHandler
+}
+
+// Valid reports whether v's underlying value is non-nil.
+func (v UDPPortHandlerView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v UDPPortHandlerView) AsStruct() *UDPPortHandler {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+// MarshalJSON implements [jsonv1.Marshaler].
+func (v UDPPortHandlerView) MarshalJSON() ([]byte, error) {
+	return jsonv1.Marshal(v.ж)
+}
+
+// MarshalJSONTo implements [jsonv2.MarshalerTo].
+func (v UDPPortHandlerView) MarshalJSONTo(enc *jsontext.Encoder) error {
+	return jsonv2.MarshalEncode(enc, v.ж)
+}
+
+// UnmarshalJSON implements [jsonv1.Unmarshaler].
+func (v *UDPPortHandlerView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x UDPPortHandler
+	if err := jsonv1.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// UnmarshalJSONFrom implements [jsonv2.UnmarshalerFrom].
+func (v *UDPPortHandlerView) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	var x UDPPortHandler
+	if err := jsonv2.UnmarshalDecode(dec, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// UDPForward is the IP:port to forward UDP packets to. Each peer
+// address and port forwarding to it gets its own session, which is
+// closed after it has been idle for a while.
+func (v UDPPortHandlerView) UDPForward() string { return v.ж.UDPForward }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _UDPPortHandlerViewNeedsRegeneration = UDPPortHandler(struct {
+	UDPForward string
 }{})
 
 // View returns a read-only view of HTTPHandler.
@@ -905,13 +1057,53 @@ func (v HTTPHandlerView) AcceptAppCaps() views.Slice[tailcfg.PeerCapability] {
 //   - ${REQUEST_URI}: replaced with the request's full URI (path and query string)
 func (v HTTPHandlerView) Redirect() string { return v.ж.Redirect }
 
+// SetRequestHeaders are headers to set on requests forwarded to Proxy,
+// replacing any sent by the client. A header with an empty value is
+// removed instead.
+func (v HTTPHandlerView) SetRequestHeaders() views.Map[string, string] {
+	return views.MapOf(v.ж.SetRequestHeaders)
+}
+
+// SetResponseHeaders are headers to set on responses from Proxy,
+// replacing any sent by the backend. A header with an empty value is
+// removed instead.
+func (v HTTPHandlerView) SetResponseHeaders() views.Map[string, string] {
+	return views.MapOf(v.ж.SetResponseHeaders)
+}
+
+// KeepPrefix, if true, means that the handler's mount point is kept in
+// the request path forwarded to Proxy. By default, it's stripped, so
+// that a request for /app/foo on a handler mounted at /app is forwarded
+// as /foo.
+func (v HTTPHandlerView) KeepPrefix() bool { return v.ж.KeepPrefix }
+
+// Timeout, if non-zero, is how long to wait for Proxy to send response
+// headers before failing the request with 504 Gateway Timeout.
+func (v HTTPHandlerView) Timeout() tstime.GoDuration { return v.ж.Timeout }
+
+// RateLimit, if non-zero, is the number of requests per second that
+// each client (a tailnet peer or, over Funnel, an internet address) may
+// make to this handler in the long run. Requests over the limit fail
+// with 429 Too Many Requests.
+func (v HTTPHandlerView) RateLimit() float64 { return v.ж.RateLimit }
+
+// RateBurst is the number of requests a client may make at once before
+// RateLimit applies. If zero, it's RateLimit rounded up.
+func (v HTTPHandlerView) RateBurst() int { return v.ж.RateBurst }
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerViewNeedsRegeneration = HTTPHandler(struct {
-	Path          string
-	Proxy         string
-	Text          string
-	AcceptAppCaps []tailcfg.PeerCapability
-	Redirect      string
+	Path               string
+	Proxy              string
+	Text               string
+	AcceptAppCaps      []tailcfg.PeerCapability
+	Redirect           string
+	SetRequestHeaders  map[string]string
+	SetResponseHeaders map[string]string
+	KeepPrefix         bool
+	Timeout            tstime.GoDuration
+	RateLimit          float64
+	RateBurst          int
 }{})
 
 // View returns a read-only view of WebServerConfig.
@@ -10,8 +10,10 @@
 	"reflect"
 	"testing"
 
+	"golang.org/x/net/dns/dnsmessage"
 	"tailscale.com/ipn"
 	"tailscale.com/net/dns"
+	"tailscale.com/net/dns/resolver"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
 	"tailscale.com/types/dnstype"
@@ -328,6 +330,57 @@ func TestDNSConfigForNetmap(t *testing.T) {
 				Routes: map[dnsname.FQDN][]*dnstype.Resolver{},
 			},
 		},
+		{
+			name: "local_overrides_and_blocklists",
+			nm:   &netmap.NetworkMap{},
+			prefs: &ipn.Prefs{
+				CorpDNS: true,
+				DNSOverrides: []tailcfg.DNSRecord{
+					{Name: "printer.lan", Value: "192.168.1.10"},
+					{Name: "alias.lan", Type: "CNAME", Value: "printer.lan"},
+					{Name: "bad.lan", Type: "MX", Value: "printer.lan"},
+				},
+				DNSBlocklists: []string{"/etc/tailscale/blocklist.txt"},
+			},
+			want: &dns.Config{
+				Hosts:  map[dnsname.FQDN][]netip.Addr{},
+				Routes: map[dnsname.FQDN][]*dnstype.Resolver{},
+				LocalRecords: []resolver.LocalRecord{
+					{Name: "printer.lan.", Type: dnsmessage.TypeA, Value: "192.168.1.10"},
+					{Name: "alias.lan.", Type: dnsmessage.TypeCNAME, Value: "printer.lan."},
+				},
+				Blocklists: []string{"/etc/tailscale/blocklist.txt"},
+			},
+			wantLog: "ignoring DNS override: unsupported record type \"MX\" for bad.lan\n",
+		},
+		{
+			name: "local_overrides_need_corp_dns",
+			nm:   &netmap.NetworkMap{},
+			prefs: &ipn.Prefs{
+				DNSOverrides:  []tailcfg.DNSRecord{{Name: "printer.lan", Value: "192.168.1.10"}},
+				DNSBlocklists: []string{"/etc/tailscale/blocklist.txt"},
+			},
+			want: &dns.Config{
+				Hosts:  map[dnsname.FQDN][]netip.Addr{},
+				Routes: map[dnsname.FQDN][]*dnstype.Resolver{},
+			},
+		},
+		{
+			name: "validate_dnssec",
+			nm:   &netmap.NetworkMap{},
+			prefs: &ipn.Prefs{
+				CorpDNS:             true,
+				ValidateDNSSEC:      true,
+				DNSSECExemptDomains: []string{"corp.example", "bad..example"},
+			},
+			want: &dns.Config{
+				Hosts:               map[dnsname.FQDN][]netip.Addr{},
+				Routes:              map[dnsname.FQDN][]*dnstype.Resolver{},
+				ValidateDNSSEC:      true,
+				DNSSECExemptDomains: []dnsname.FQDN{"corp.example."},
+			},
+			wantLog: "ignoring invalid DNSSEC exempt domain \"bad..example\": \"\" is not a valid DNS label\n",
+		},
 		{
 			name: "self_expired",
 			nm: &netmap.NetworkMap{
@@ -23,6 +23,7 @@
 	"net/netip"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"slices"
@@ -55,6 +56,7 @@
 	"tailscale.com/log/sockstatlog"
 	"tailscale.com/logpolicy"
 	"tailscale.com/net/dns"
+	"tailscale.com/net/dns/resolver"
 	"tailscale.com/net/dnscache"
 	"tailscale.com/net/dnsfallback"
 	"tailscale.com/net/ipset"
@@ -64,6 +66,7 @@
 	"tailscale.com/net/netns"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/packet"
+	"tailscale.com/net/portmapper/portmappertype"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/paths"
@@ -78,6 +81,7 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
+	"tailscale.com/types/nettype"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
@@ -231,6 +235,7 @@ type LocalBackend struct {
 
 	containsViaIPFuncAtomic                 syncs.AtomicValue[func(netip.Addr) bool]     // TODO(nickkhyl): move to nodeBackend
 	shouldInterceptTCPPortAtomic            syncs.AtomicValue[func(uint16) bool]         // TODO(nickkhyl): move to nodeBackend
+	shouldInterceptUDPPortAtomic            syncs.AtomicValue[func(uint16) bool]         // TODO(nickkhyl): move to nodeBackend
 	shouldInterceptVIPServicesTCPPortAtomic syncs.AtomicValue[func(netip.AddrPort) bool] // TODO(nickkhyl): move to nodeBackend
 	numClientStatusCalls                    atomic.Uint32                                // TODO(nickkhyl): move to nodeBackend
 
@@ -304,6 +309,13 @@ type LocalBackend struct {
 	// to use, unless overridden locally.
 	capForcedNetfilter string // TODO(nickkhyl): move to nodeBackend
 
+	// flagBindInterface and flagBindInterfaceFallback are the netns bind
+	// interface settings from before any prefs were applied, as set by
+	// the tailscaled --bind-interface flags. They apply when the
+	// BindInterface pref is empty.
+	flagBindInterface         string
+	flagBindInterfaceFallback bool
+
 	// ServeConfig fields. (also guarded by mu)
 	lastServeConfJSON mem.RO                   // last JSON that was parsed into serveConfig
 	serveConfig       ipn.ServeConfigView      // or !Valid if none
@@ -314,6 +326,7 @@ type LocalBackend struct {
 
 	serveListeners     map[netip.AddrPort]*localListener // listeners for local serve traffic
 	serveProxyHandlers sync.Map                          // string (HTTPHandler.Proxy) => *reverseProxy
+	serveUDPSessions   map[*serveUDPSession]bool         // active UDP forwarding sessions
 
 	// mu must be held before calling statusChanged.Wait() or
 	// statusChanged.Broadcast().
@@ -517,6 +530,8 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 		needsCaptiveDetection: make(chan bool),
 	}
 
+	b.flagBindInterface, b.flagBindInterfaceFallback = netns.BindInterface()
+
 	nb := newNodeBackend(ctx, b.logf, b.sys.Bus.Get())
 	b.currentNodeAtomic.Store(nb)
 	nb.ready()
@@ -559,6 +574,7 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 	b.e.SetJailedFilter(noneFilter)
 
 	b.setTCPPortsIntercepted(nil)
+	b.setUDPPortsIntercepted(nil)
 
 	b.statusChanged = sync.NewCond(&b.mu)
 	b.e.SetStatusCallback(b.setWgengineStatus)
@@ -810,6 +826,76 @@ func (b *LocalBackend) QueryDNS(name string, queryType dnsmessage.Type) (res []b
 	return res, rr, nil
 }
 
+// DNSCacheStatus returns a snapshot of the built-in DNS resolver's cache of
+// upstream responses.
+func (b *LocalBackend) DNSCacheStatus() (resolver.CacheStatus, error) {
+	if !buildfeatures.HasDNS {
+		return resolver.CacheStatus{}, feature.ErrUnavailable
+	}
+	manager, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return resolver.CacheStatus{}, errors.New("DNS manager not available")
+	}
+	return manager.Resolver().CacheStatus(), nil
+}
+
+// FlushDNSCache removes all entries from the built-in DNS resolver's cache
+// of upstream responses.
+func (b *LocalBackend) FlushDNSCache() error {
+	if !buildfeatures.HasDNS {
+		return feature.ErrUnavailable
+	}
+	manager, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return errors.New("DNS manager not available")
+	}
+	manager.Resolver().FlushCache()
+	return nil
+}
+
+// SetDNSQueryLogEnabled sets whether the built-in DNS resolver keeps a log
+// of recent queries.
+func (b *LocalBackend) SetDNSQueryLogEnabled(enabled bool) error {
+	if !buildfeatures.HasDNS {
+		return feature.ErrUnavailable
+	}
+	manager, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return errors.New("DNS manager not available")
+	}
+	manager.Resolver().SetQueryLogEnabled(enabled)
+	return nil
+}
+
+// DNSQueryLog reports whether the built-in DNS resolver's query log is
+// enabled, and returns its entries, from oldest to newest.
+func (b *LocalBackend) DNSQueryLog() (enabled bool, entries []resolver.QueryLogEntry, err error) {
+	if !buildfeatures.HasDNS {
+		return false, nil, feature.ErrUnavailable
+	}
+	manager, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return false, nil, errors.New("DNS manager not available")
+	}
+	r := manager.Resolver()
+	return r.QueryLogEnabled(), r.QueryLog(), nil
+}
+
+// WatchDNSQueryLog returns the entries of the built-in DNS resolver's query
+// log and a channel of the queries it answers after them, as described in
+// [resolver.Resolver.WatchQueryLog]. The caller must call stop when done.
+func (b *LocalBackend) WatchDNSQueryLog() (backlog []resolver.QueryLogEntry, entries <-chan resolver.QueryLogEntry, stop func(), err error) {
+	if !buildfeatures.HasDNS {
+		return nil, nil, nil, feature.ErrUnavailable
+	}
+	manager, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return nil, nil, nil, errors.New("DNS manager not available")
+	}
+	backlog, entries, stop = manager.Resolver().WatchQueryLog()
+	return backlog, entries, stop, nil
+}
+
 // GetComponentDebugLogging gets the time that component's debug logging is
 // enabled until, or the zero time if component's time is not currently
 // enabled.
@@ -1152,6 +1238,13 @@ func (b *LocalBackend) Shutdown() {
 	b.extHost.Shutdown()
 	b.e.Close()
 	<-b.e.Done()
+	if mc, ok := b.sys.MagicSock.GetOK(); ok {
+		if h := mc.NetcheckHistory(); h != nil {
+			if err := h.Flush(); err != nil {
+				b.logf("persisting netcheck history: %v", err)
+			}
+		}
+	}
 	b.awaitNoGoroutinesInTest()
 }
 
@@ -3633,6 +3726,13 @@ func (b *LocalBackend) setTCPPortsIntercepted(ports []uint16) {
 	b.shouldInterceptTCPPortAtomic.Store(generateInterceptTCPPortFunc(ports))
 }
 
+// setUDPPortsIntercepted populates b.shouldInterceptUDPPortAtomic with an
+// efficient func for ShouldInterceptUDPPort to use, which is called on every
+// incoming packet.
+func (b *LocalBackend) setUDPPortsIntercepted(ports []uint16) {
+	b.shouldInterceptUDPPortAtomic.Store(generateInterceptTCPPortFunc(ports))
+}
+
 func generateInterceptVIPServicesTCPPortFunc(svcAddrPorts map[netip.Addr]func(uint16) bool) func(netip.AddrPort) bool {
 	return func(ap netip.AddrPort) bool {
 		if f, ok := svcAddrPorts[ap.Addr()]; ok {
@@ -3644,14 +3744,18 @@ func generateInterceptVIPServicesTCPPortFunc(svcAddrPorts map[netip.Addr]func(ui
 
 // setAtomicValuesFromPrefsLocked populates sshAtomicBool, containsViaIPFuncAtomic,
 // shouldInterceptTCPPortAtomic, and exposeRemoteWebClientAtomicBool from the prefs p,
-// which may be !Valid().
+// which may be !Valid(). It also updates the static port mappings and the
+// interface that sockets are bound to.
 func (b *LocalBackend) setAtomicValuesFromPrefsLocked(p ipn.PrefsView) {
 	b.sshAtomicBool.Store(p.Valid() && p.RunSSH() && envknob.CanSSHD())
 	b.setExposeRemoteWebClientAtomicBoolLocked(p)
+	b.setStaticPortMappingsLocked(p)
+	b.setBindInterfaceLocked(p)
 
 	if !p.Valid() {
 		b.containsViaIPFuncAtomic.Store(ipset.FalseContainsIPFunc())
 		b.setTCPPortsIntercepted(nil)
+		b.setUDPPortsIntercepted(nil)
 		if f, ok := hookServeClearVIPServicesTCPPortsInterceptedLocked.GetOk(); ok {
 			f(b)
 		}
@@ -4075,9 +4179,133 @@ func (b *LocalBackend) checkPrefsLocked(p *ipn.Prefs) error {
 	if err := b.checkAutoUpdatePrefsLocked(p); err != nil {
 		errs = append(errs, err)
 	}
+	if err := checkDNSPrefs(p, b.dnsBlocklistDir()); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkPortMappingPrefs(p); err != nil {
+		errs = append(errs, err)
+	}
+	if p.BindInterface != "" && !netns.CanBindInterface() {
+		errs = append(errs, fmt.Errorf("binding to a network interface is not supported on %s", runtime.GOOS))
+	}
+	return errors.Join(errs...)
+}
+
+// dnsBlocklistDir returns the directory that DNS blocklist files must be
+// in, or the empty string if there's no state directory to put it in.
+//
+// tailscaled reads blocklists as root, and their paths can be set by
+// anyone allowed to change prefs, so they're limited to a directory only
+// root can write to.
+func (b *LocalBackend) dnsBlocklistDir() string {
+	root := b.TailscaleVarRoot()
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(root, "dns-blocklists")
+}
+
+// checkDNSPrefs reports whether p's DNS overrides, blocklists and DNSSEC
+// exempt domains are valid. Blocklists must be files in blocklistDir.
+func checkDNSPrefs(p *ipn.Prefs, blocklistDir string) error {
+	if !buildfeatures.HasDNS {
+		if len(p.DNSOverrides) > 0 || len(p.DNSBlocklists) > 0 || p.ValidateDNSSEC {
+			return errors.New("DNS support is disabled in this build")
+		}
+		return nil
+	}
+	var errs []error
+	for _, rec := range p.DNSOverrides {
+		if _, err := resolver.ParseLocalRecord(rec.Name, rec.Type, rec.Value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid DNS override: %w", err))
+		}
+	}
+	for _, path := range p.DNSBlocklists {
+		switch {
+		case !filepath.IsAbs(path):
+			errs = append(errs, fmt.Errorf("DNS blocklist path %q is not absolute", path))
+		case blocklistDir == "":
+			errs = append(errs, errors.New("DNS blocklists are not supported without a state directory"))
+		case filepath.Dir(filepath.Clean(path)) != blocklistDir:
+			errs = append(errs, fmt.Errorf("DNS blocklist %q is not in %s", path, blocklistDir))
+		}
+	}
+	for _, dom := range p.DNSSECExemptDomains {
+		if _, err := dnsname.ToFQDN(dom); err != nil {
+			errs = append(errs, fmt.Errorf("invalid DNSSEC exempt domain %q: %w", dom, err))
+		}
+	}
 	return errors.Join(errs...)
 }
 
+// checkPortMappingPrefs reports whether p's port mappings are valid.
+func checkPortMappingPrefs(p *ipn.Prefs) error {
+	if len(p.PortMappings) == 0 {
+		return nil
+	}
+	if !buildfeatures.HasPortMapper {
+		return errors.New("port mapping support is disabled in this build")
+	}
+	var errs []error
+	for _, s := range p.PortMappings {
+		if _, err := portmappertype.ParseStaticMapping(s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setStaticPortMappingsLocked requests the port mappings in p from the
+// portmapper while p wants Tailscale running, and releases them otherwise.
+// Invalid mappings, which checkPortMappingPrefs rejects, are ignored.
+func (b *LocalBackend) setStaticPortMappingsLocked(p ipn.PrefsView) {
+	if !buildfeatures.HasPortMapper {
+		return
+	}
+	mc, ok := b.sys.MagicSock.GetOK()
+	if !ok {
+		return
+	}
+	var mappings []portmappertype.StaticMapping
+	if p.Valid() && p.WantRunning() {
+		for _, s := range p.PortMappings().All() {
+			if m, err := portmappertype.ParseStaticMapping(s); err == nil {
+				mappings = append(mappings, m)
+			}
+		}
+	}
+	mc.SetStaticPortMappings(mappings)
+}
+
+// setBindInterfaceLocked makes tailscaled's sockets bind to the interface
+// named by p's BindInterface, or by the --bind-interface flag if that's
+// empty, rebinding magicsock's sockets and DERP connections if that
+// changed.
+func (b *LocalBackend) setBindInterfaceLocked(p ipn.PrefsView) {
+	name, fallback := b.flagBindInterface, b.flagBindInterfaceFallback
+	if p.Valid() && p.BindInterface() != "" {
+		name, fallback = p.BindInterface(), p.BindInterfaceFallback()
+	}
+	if curName, curFallback := netns.BindInterface(); name == curName && fallback == curFallback {
+		return
+	}
+	b.logf("binding sockets to interface %q (fallback=%v)", name, fallback)
+	netns.SetBindInterface(name, fallback)
+	if mc, ok := b.sys.MagicSock.GetOK(); ok {
+		go mc.RebindAll("bind-interface-change")
+	}
+}
+
+// StaticPortMappings returns the state of the port mappings requested by
+// the PortMappings pref.
+func (b *LocalBackend) StaticPortMappings() []portmappertype.StaticMappingStatus {
+	mc, ok := b.sys.MagicSock.GetOK()
+	if !ok {
+		return nil
+	}
+	return mc.StaticPortMappings()
+}
+
 func (b *LocalBackend) checkSSHPrefsLocked(p *ipn.Prefs) error {
 	if !p.RunSSH {
 		return nil
@@ -4733,6 +4961,7 @@ func (b *LocalBackend) isLocalIP(ip netip.Addr) bool {
 	hookServeTCPHandlerForVIPService                     feature.Hook[func(b *LocalBackend, dst netip.AddrPort, src netip.AddrPort) (handler func(c net.Conn) error)]
 	hookTCPHandlerForServe                               feature.Hook[func(b *LocalBackend, dport uint16, srcAddr netip.AddrPort, f *funnelFlow) (handler func(net.Conn) error)]
 	hookServeUpdateServeTCPPortNetMapAddrListenersLocked feature.Hook[func(b *LocalBackend, ports []uint16)]
+	hookUDPHandlerForServe                               feature.Hook[func(b *LocalBackend, dport uint16, srcAddr netip.AddrPort) (handler func(nettype.ConnPacketConn))]
 
 	hookServeSetTCPPortsInterceptedFromNetmapAndPrefsLocked feature.Hook[func(b *LocalBackend, prefs ipn.PrefsView) (handlePorts []uint16)]
 	hookServeClearVIPServicesTCPPortsInterceptedLocked      feature.Hook[func(*LocalBackend)]
@@ -5117,6 +5346,36 @@ func (b *LocalBackend) SetTCPHandlerForFunnelFlow(h func(src netip.AddrPort, dst
 // It should only be called before the LocalBackend is used.
 func (b *LocalBackend) SetVarRoot(dir string) {
 	b.varRoot = dir
+	if dir == "" {
+		return
+	}
+	if mc, ok := b.sys.MagicSock.GetOK(); ok {
+		h := netcheck.NewHistory(b.logf, filepath.Join(dir, netcheckHistoryFile), netcheckHistorySize)
+		if err := h.Load(); err != nil {
+			b.logf("loading netcheck history: %v", err)
+		}
+		mc.SetNetcheckHistory(h)
+	}
+}
+
+// The file in the var root that netcheck reports are persisted to, and how
+// many it holds. See netcheck.History.
+const (
+	netcheckHistoryFile = "netcheck-history.json"
+	netcheckHistorySize = 500
+)
+
+// NetcheckHistory returns the recorded netcheck reports, oldest first, or
+// nil if they're not being recorded.
+func (b *LocalBackend) NetcheckHistory() []*netcheck.Report {
+	mc, ok := b.sys.MagicSock.GetOK()
+	if !ok {
+		return nil
+	}
+	if h := mc.NetcheckHistory(); h != nil {
+		return h.Reports()
+	}
+	return nil
 }
 
 // SetLogFlusher sets a func to be called to flush log uploads.
@@ -6852,6 +7111,13 @@ func (b *LocalBackend) ShouldInterceptTCPPort(port uint16) bool {
 	return b.shouldInterceptTCPPortAtomic.Load()(port)
 }
 
+// ShouldInterceptUDPPort reports whether the given UDP port number to a
+// Tailscale IP (not a subnet router, service IP, etc) should be intercepted by
+// Tailscaled and handled in-process.
+func (b *LocalBackend) ShouldInterceptUDPPort(port uint16) bool {
+	return b.shouldInterceptUDPPortAtomic.Load()(port)
+}
+
 // ShouldInterceptVIPServiceTCPPort reports whether the given TCP port number
 // to a VIP service should be intercepted by Tailscaled and handled in-process.
 func (b *LocalBackend) ShouldInterceptVIPServiceTCPPort(ap netip.AddrPort) bool {
@@ -7027,6 +7293,24 @@ func (b *LocalBackend) GetPeerEndpointChanges(ctx context.Context, ip netip.Addr
 	return chs, nil
 }
 
+// GetPeerPaths returns the measured quality of each direct path to the
+// peer with Tailscale IP ip.
+func (b *LocalBackend) GetPeerPaths(ctx context.Context, ip netip.Addr) ([]ipnstate.PeerPath, error) {
+	pip, ok := b.e.PeerForIP(ip)
+	if !ok {
+		return nil, fmt.Errorf("no matching peer")
+	}
+	if pip.IsSelf {
+		return nil, fmt.Errorf("%v is local Tailscale IP", ip)
+	}
+
+	paths, err := b.MagicConn().GetPeerPaths(pip.Node)
+	if err != nil {
+		return nil, fmt.Errorf("getting peer paths: %w", err)
+	}
+	return paths, nil
+}
+
 var breakTCPConns func() error
 
 func (b *LocalBackend) DebugBreakTCPConns() error {
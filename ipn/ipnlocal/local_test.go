@@ -7121,3 +7121,28 @@ func eqUpdate(want appctype.RouteUpdate) func(appctype.RouteUpdate) error {
 		return nil
 	}
 }
+
+func TestCheckDNSBlocklistPrefs(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dns-blocklists")
+	tests := []struct {
+		name    string
+		dir     string
+		path    string
+		wantErr bool
+	}{
+		{name: "in-dir", dir: dir, path: filepath.Join(dir, "ads.txt")},
+		{name: "relative", dir: dir, path: "ads.txt", wantErr: true},
+		{name: "outside-dir", dir: dir, path: filepath.Join(filepath.Dir(dir), "ads.txt"), wantErr: true},
+		{name: "escapes-dir", dir: dir, path: filepath.Join(dir, "..", "..", "ads.txt"), wantErr: true},
+		{name: "subdir", dir: dir, path: filepath.Join(dir, "sub", "ads.txt"), wantErr: true},
+		{name: "no-state-dir", path: filepath.Join(dir, "ads.txt"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDNSPrefs(&ipn.Prefs{DNSBlocklists: []string{tt.path}}, tt.dir)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkDNSPrefs(%q) = %v; want error: %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
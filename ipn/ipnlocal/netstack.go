@@ -11,6 +11,7 @@
 	"time"
 
 	"gvisor.dev/gvisor/pkg/tcpip"
+	"tailscale.com/types/nettype"
 	"tailscale.com/types/ptr"
 )
 
@@ -72,3 +73,16 @@ func (b *LocalBackend) TCPHandlerForDst(src, dst netip.AddrPort) (handler func(c
 	}
 	return nil, nil
 }
+
+// UDPHandlerForDst returns a handler for the UDP flow from src to dst, or nil
+// if the flow isn't handled in-process. Only flows to our node's local IPs
+// are handled.
+func (b *LocalBackend) UDPHandlerForDst(src, dst netip.AddrPort) (handler func(c nettype.ConnPacketConn)) {
+	if !b.isLocalIP(dst.Addr()) {
+		return nil
+	}
+	if f, ok := hookUDPHandlerForServe.GetOk(); ok {
+		return f(b, dst.Port(), src)
+	}
+	return nil
+}
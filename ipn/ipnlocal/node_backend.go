@@ -15,6 +15,7 @@
 	"tailscale.com/feature/buildfeatures"
 	"tailscale.com/ipn"
 	"tailscale.com/net/dns"
+	"tailscale.com/net/dns/resolver"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/dnstype"
@@ -662,6 +663,23 @@ func useWithExitNodeRoutes(routes map[string][]*dnstype.Resolver) map[string][]*
 	return filtered
 }
 
+// setDNSSECConfig sets whether dcfg validates the DNSSEC signatures of
+// forwarded responses, and the domains exempt from validation, from prefs.
+func setDNSSECConfig(dcfg *dns.Config, prefs ipn.PrefsView, logf logger.Logf) {
+	if !prefs.ValidateDNSSEC() {
+		return
+	}
+	dcfg.ValidateDNSSEC = true
+	for _, dom := range prefs.DNSSECExemptDomains().All() {
+		fqdn, err := dnsname.ToFQDN(dom)
+		if err != nil {
+			logf("ignoring invalid DNSSEC exempt domain %q: %v", dom, err)
+			continue
+		}
+		dcfg.DNSSECExemptDomains = append(dcfg.DNSSECExemptDomains, fqdn)
+	}
+}
+
 // dnsConfigForNetmap returns a *dns.Config for the given netmap,
 // prefs, client OS version, and cloud hosting environment.
 //
@@ -696,6 +714,7 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 	selfV6Only := nm.GetAddresses().ContainsFunc(tsaddr.PrefixIs6) &&
 		!nm.GetAddresses().ContainsFunc(tsaddr.PrefixIs4)
 	dcfg.OnlyIPv6 = selfV6Only
+	setDNSSECConfig(dcfg, prefs, logf)
 
 	wantAAAA := nm.AllCaps.Contains(tailcfg.NodeAttrMagicDNSPeerAAAA)
 
@@ -770,6 +789,16 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 		return dcfg
 	}
 
+	for _, rec := range prefs.DNSOverrides().All() {
+		lr, err := resolver.ParseLocalRecord(rec.Name, rec.Type, rec.Value)
+		if err != nil {
+			logf("ignoring DNS override: %v", err)
+			continue
+		}
+		dcfg.LocalRecords = append(dcfg.LocalRecords, lr)
+	}
+	dcfg.Blocklists = prefs.DNSBlocklists().AsSlice()
+
 	for _, dom := range nm.DNS.Domains {
 		fqdn, err := dnsname.ToFQDN(dom)
 		if err != nil {
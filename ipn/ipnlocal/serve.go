@@ -17,6 +17,7 @@
 	"fmt"
 	"io"
 	"maps"
+	"math"
 	"mime"
 	"net"
 	"net/http"
@@ -36,14 +37,18 @@
 	"go4.org/mem"
 	"tailscale.com/ipn"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/proxyproto"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime/rate"
 	"tailscale.com/types/lazy"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/nettype"
 	"tailscale.com/types/views"
 	"tailscale.com/util/backoff"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/ctxkey"
+	"tailscale.com/util/lru"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/slicesx"
 	"tailscale.com/version"
@@ -52,6 +57,7 @@
 func init() {
 	hookServeTCPHandlerForVIPService.Set((*LocalBackend).tcpHandlerForVIPService)
 	hookTCPHandlerForServe.Set((*LocalBackend).tcpHandlerForServe)
+	hookUDPHandlerForServe.Set((*LocalBackend).udpHandlerForServe)
 	hookServeUpdateServeTCPPortNetMapAddrListenersLocked.Set((*LocalBackend).updateServeTCPPortNetMapAddrListenersLocked)
 
 	hookServeSetTCPPortsInterceptedFromNetmapAndPrefsLocked.Set(serveSetTCPPortsInterceptedFromNetmapAndPrefsLocked)
@@ -307,6 +313,12 @@ func (b *LocalBackend) setServeConfigLocked(config *ipn.ServeConfig, etag string
 		if err := config.CheckValidServicesConfig(); err != nil {
 			return err
 		}
+		if err := config.CheckValidProxyProtocol(); err != nil {
+			return err
+		}
+		if err := config.CheckValidHTTPHandlers(); err != nil {
+			return err
+		}
 	}
 
 	nm := b.NetMap()
@@ -563,6 +575,12 @@ func (b *LocalBackend) tcpHandlerForVIPService(dstAddr, srcAddr netip.AddrPort)
 				return nil
 			}
 			defer backConn.Close()
+			if v := tcph.ProxyProtocol(); v != 0 {
+				if err := b.writeServeProxyHeader(backConn, v, srcAddr, dstAddr, false); err != nil {
+					b.logf("localbackend: failed to send PROXY header to %s: %v", backDst, err)
+					return nil
+				}
+			}
 			if sni := tcph.TerminateTLS(); sni != "" {
 				conn = tls.Server(conn, &tls.Config{
 					GetCertificate: func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
@@ -650,6 +668,13 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort,
 				return nil
 			}
 			defer backConn.Close()
+			if v := tcph.ProxyProtocol(); v != 0 {
+				dstAddr := netip.AddrPortFrom(b.serveLocalAddr(srcAddr.Addr()), dport)
+				if err := b.writeServeProxyHeader(backConn, v, srcAddr, dstAddr, f != nil); err != nil {
+					b.logf("localbackend: failed to send PROXY header to %s: %v", backDst, err)
+					return nil
+				}
+			}
 			if sni := tcph.TerminateTLS(); sni != "" {
 				conn = tls.Server(conn, &tls.Config{
 					GetCertificate: func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
@@ -686,6 +711,181 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort,
 	return nil
 }
 
+// serveLocalAddr returns the node's Tailscale IP of the same address family
+// as the peer address src, or the first one if there is none. Serve handlers
+// for the node use it as the destination of connections in PROXY protocol
+// headers, as they don't know which of the node's addresses was dialed.
+func (b *LocalBackend) serveLocalAddr(src netip.Addr) netip.Addr {
+	addrs := b.currentNode().Self().Addresses()
+	var first netip.Addr
+	for _, pfx := range addrs.All() {
+		if !pfx.IsSingleIP() {
+			continue
+		}
+		a := pfx.Addr()
+		if a.Is4() == src.Unmap().Is4() {
+			return a
+		}
+		if !first.IsValid() {
+			first = a
+		}
+	}
+	return first
+}
+
+// writeServeProxyHeader writes a PROXY protocol header of the given version
+// for a connection from srcAddr to dstAddr to backConn. Unless the
+// connection came over Funnel, version 2 headers also identify the tailnet
+// peer that made it.
+func (b *LocalBackend) writeServeProxyHeader(backConn net.Conn, version int, srcAddr, dstAddr netip.AddrPort, funnel bool) error {
+	h := &proxyproto.Header{Src: srcAddr, Dst: dstAddr}
+	if version == 2 && !funnel {
+		if node, user, ok := b.WhoIs("tcp", srcAddr); ok {
+			addTLV := func(typ byte, v string) {
+				if v != "" {
+					h.TLVs = append(h.TLVs, proxyproto.TLV{Type: typ, Value: []byte(v)})
+				}
+			}
+			addTLV(proxyproto.TypeTailscaleNode, strings.TrimSuffix(node.Name(), "."))
+			// As with the identity headers for HTTP, tagged nodes
+			// have no user identity.
+			if !node.IsTagged() {
+				addTLV(proxyproto.TypeTailscaleUser, user.LoginName)
+			}
+			addTLV(proxyproto.TypeTailscaleNodeID, string(node.StableID()))
+		}
+	}
+	hdr, err := h.Format(version)
+	if err != nil {
+		return err
+	}
+	_, err = backConn.Write(hdr)
+	return err
+}
+
+// serveUDPIdleTimeout is how long a UDP forwarding session may go without
+// packets in either direction before it's closed.
+const serveUDPIdleTimeout = 2 * time.Minute
+
+// serveUDPSession is a UDP flow from a peer's address and port, forwarded
+// to a local backend.
+type serveUDPSession struct {
+	dport    uint16
+	backend  string // the ipn.UDPPortHandler.UDPForward address
+	conn     nettype.ConnPacketConn
+	backConn net.Conn
+
+	closeOnce sync.Once
+}
+
+func (s *serveUDPSession) close() {
+	s.closeOnce.Do(func() {
+		s.conn.Close()
+		s.backConn.Close()
+	})
+}
+
+// run copies packets between the peer and the backend until either side
+// fails or the session is idle for serveUDPIdleTimeout.
+func (s *serveUDPSession) run() {
+	defer s.close()
+	idle := time.AfterFunc(serveUDPIdleTimeout, s.close)
+	defer idle.Stop()
+
+	errc := make(chan error, 2)
+	copyPackets := func(dst io.Writer, src io.Reader) {
+		buf := make([]byte, 1<<16)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				errc <- err
+				return
+			}
+			idle.Reset(serveUDPIdleTimeout)
+			if _, err := dst.Write(buf[:n]); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}
+	go copyPackets(s.backConn, s.conn)
+	go copyPackets(s.conn, s.backConn)
+	<-errc
+}
+
+// udpHandlerForServe returns a handler for a UDP flow from srcAddr to be
+// served via the ipn.ServeConfig, or nil if dport isn't served.
+func (b *LocalBackend) udpHandlerForServe(dport uint16, srcAddr netip.AddrPort) (handler func(nettype.ConnPacketConn)) {
+	b.mu.Lock()
+	backDst := b.serveUDPBackendLocked(dport)
+	b.mu.Unlock()
+	if backDst == "" {
+		return nil
+	}
+
+	return func(conn nettype.ConnPacketConn) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		backConn, err := b.dialer.SystemDial(ctx, "udp", backDst)
+		cancel()
+		if err != nil {
+			b.logf("localbackend: failed to UDP proxy port %v (from %v) to %s: %v", dport, srcAddr, backDst, err)
+			conn.Close()
+			return
+		}
+		s := &serveUDPSession{
+			dport:    dport,
+			backend:  backDst,
+			conn:     conn,
+			backConn: backConn,
+		}
+
+		b.mu.Lock()
+		if b.serveUDPBackendLocked(dport) != backDst {
+			// The serve config changed while we were dialing.
+			b.mu.Unlock()
+			s.close()
+			return
+		}
+		mak.Set(&b.serveUDPSessions, s, true)
+		b.mu.Unlock()
+		metricServeUDPSessions.Add(1)
+
+		s.run()
+
+		b.mu.Lock()
+		delete(b.serveUDPSessions, s)
+		b.mu.Unlock()
+		metricServeUDPSessions.Add(-1)
+	}
+}
+
+// serveUDPBackendLocked returns the address UDP packets to dport are
+// forwarded to, or the empty string if dport isn't served.
+//
+// b.mu must be held.
+func (b *LocalBackend) serveUDPBackendLocked(dport uint16) string {
+	if !b.serveConfig.Valid() {
+		return ""
+	}
+	udph, ok := b.serveConfig.FindUDP(dport)
+	if !ok {
+		return ""
+	}
+	return udph.UDPForward()
+}
+
+// closeStaleServeUDPSessionsLocked closes the UDP forwarding sessions whose
+// port is no longer forwarded to the same backend.
+//
+// b.mu must be held.
+func (b *LocalBackend) closeStaleServeUDPSessionsLocked() {
+	for s := range b.serveUDPSessions {
+		if b.serveUDPBackendLocked(s.dport) != s.backend {
+			s.close()
+		}
+	}
+}
+
 func (b *LocalBackend) getServeHandler(r *http.Request) (_ ipn.HTTPHandlerView, at string, ok bool) {
 	var z ipn.HTTPHandlerView // zero value
 
@@ -767,8 +967,55 @@ type reverseProxy struct {
 	h2cTransport  lazy.SyncValue[*http.Transport] // transport for h2c backends
 	// closed tracks whether proxy is closed/currently closing.
 	closed atomic.Bool
+
+	limitersMu sync.Mutex
+	limiters   lru.Cache[serveRateLimitKey, *rate.Limiter] // for handlers with a RateLimit
+}
+
+// serveRateLimitKey identifies the rate limiter of a client of a handler
+// with a RateLimit. The limits are part of the key, so that changing them
+// starts new limiters.
+type serveRateLimitKey struct {
+	mount  string
+	client netip.Addr
+	limit  rate.Limit
+	burst  int
+}
+
+// maxServeRateLimiters is the number of rate limiters a reverseProxy keeps
+// before forgetting the least recently used.
+const maxServeRateLimiters = 4096
+
+// allowRequest reports whether the handler h mounted at mount may serve a
+// request from client under its RateLimit.
+func (rp *reverseProxy) allowRequest(h ipn.HTTPHandlerView, mount string, client netip.Addr) bool {
+	if h.RateLimit() <= 0 {
+		return true
+	}
+	k := serveRateLimitKey{
+		mount:  mount,
+		client: client,
+		limit:  rate.Limit(h.RateLimit()),
+		burst:  h.RateBurst(),
+	}
+	if k.burst <= 0 {
+		k.burst = int(math.Ceil(h.RateLimit()))
+	}
+	rp.limitersMu.Lock()
+	lim, ok := rp.limiters.GetOk(k)
+	if !ok {
+		rp.limiters.MaxEntries = maxServeRateLimiters
+		lim = rate.NewLimiter(k.limit, k.burst)
+		rp.limiters.Set(k, lim)
+	}
+	rp.limitersMu.Unlock()
+	return lim.Allow()
 }
 
+// errServeProxyTimeout is the cause of the cancellation of a proxied
+// request whose handler's Timeout expired.
+var errServeProxyTimeout = errors.New("timeout waiting for backend response")
+
 // close ensures that any open backend connections get closed.
 func (rp *reverseProxy) close() {
 	rp.closed.Store(true)
@@ -783,6 +1030,12 @@ func (rp *reverseProxy) close() {
 }
 
 func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rp.serveHTTP(w, r, ipn.HTTPHandlerView{})
+}
+
+// serveHTTP proxies r to the backend, applying the options of the handler
+// h, if valid.
+func (rp *reverseProxy) serveHTTP(w http.ResponseWriter, r *http.Request, h ipn.HTTPHandlerView) {
 	if closed := rp.closed.Load(); closed {
 		rp.logf("received a request for a proxy that's being closed or has been closed")
 		http.Error(w, "proxy is closed", http.StatusServiceUnavailable)
@@ -810,6 +1063,9 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if h.Valid() {
+			setHeaders(r.Out.Header, h.SetRequestHeaders())
+		}
 	}} // There is no way to autodetect h2c as per RFC 9113
 	// https://datatracker.ietf.org/doc/html/rfc9113#name-starting-http-2.
 	// However, we assume that http:// proxy prefix in combination with the
@@ -821,9 +1077,56 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	} else {
 		p.Transport = rp.getTransport()
 	}
+	if !h.Valid() {
+		p.ServeHTTP(w, r)
+		return
+	}
+
+	if hdrs := h.SetResponseHeaders(); hdrs.Len() > 0 {
+		p.ModifyResponse = func(res *http.Response) error {
+			setHeaders(res.Header, hdrs)
+			return nil
+		}
+	}
+	if d := h.Timeout().Duration; d > 0 {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+		timer := time.AfterFunc(d, func() { cancel(errServeProxyTimeout) })
+		defer timer.Stop()
+		r = r.WithContext(ctx)
+
+		modifyResponse := p.ModifyResponse
+		p.ModifyResponse = func(res *http.Response) error {
+			timer.Stop()
+			if modifyResponse != nil {
+				return modifyResponse(res)
+			}
+			return nil
+		}
+		p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			if context.Cause(ctx) == errServeProxyTimeout {
+				rp.logf("serve: timed out after %v waiting for %s", d, rp.backend)
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			rp.logf("serve: proxy error for %s: %v", rp.backend, err)
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}
 	p.ServeHTTP(w, r)
 }
 
+// setHeaders sets the headers hdrs in hh, deleting those with empty values.
+func setHeaders(hh http.Header, hdrs views.Map[string, string]) {
+	for k, v := range hdrs.All() {
+		if v == "" {
+			hh.Del(k)
+		} else {
+			hh.Set(k, v)
+		}
+	}
+}
+
 // getTransport returns the Transport used for regular (non-GRPC) requests
 // to the backend. The Transport gets created lazily, at most once.
 func (rp *reverseProxy) getTransport() *http.Transport {
@@ -1015,12 +1318,19 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		c.AppCapabilities = h.AcceptAppCaps()
-		h := p.(http.Handler)
+		rp := p.(*reverseProxy)
+		if !rp.allowRequest(h, mountPoint, c.SrcAddr.Addr()) {
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		var ph http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rp.serveHTTP(w, r, h)
+		})
 		// Trim the mount point from the URL path before proxying. (#6571)
-		if r.URL.Path != "/" {
-			h = http.StripPrefix(strings.TrimSuffix(mountPoint, "/"), h)
+		if r.URL.Path != "/" && !h.KeepPrefix() {
+			ph = http.StripPrefix(strings.TrimSuffix(mountPoint, "/"), ph)
 		}
-		h.ServeHTTP(w, r)
+		ph.ServeHTTP(w, r)
 		return
 	}
 
@@ -1233,7 +1543,10 @@ func handleC2NVIPServicesGet(b *LocalBackend, w http.ResponseWriter, r *http.Req
 	json.NewEncoder(w).Encode(res)
 }
 
-var metricIngressCalls = clientmetric.NewCounter("peerapi_ingress")
+var (
+	metricIngressCalls     = clientmetric.NewCounter("peerapi_ingress")
+	metricServeUDPSessions = clientmetric.NewGauge("serve_udp_sessions")
+)
 
 func init() {
 	RegisterPeerAPIHandler("/v0/ingress", handleServeIngress)
@@ -1332,9 +1645,16 @@ func (b *LocalBackend) shouldWireInactiveIngressLocked() bool {
 
 func serveSetTCPPortsInterceptedFromNetmapAndPrefsLocked(b *LocalBackend, prefs ipn.PrefsView) (handlePorts []uint16) {
 	var vipServicesPorts map[tailcfg.ServiceName][]uint16
+	var udpPorts []uint16
 
 	b.reloadServeConfigLocked(prefs)
 	if b.serveConfig.Valid() {
+		for port, h := range b.serveConfig.UDPs() {
+			if port > 0 && h.UDPForward() != "" {
+				udpPorts = append(udpPorts, port)
+			}
+		}
+
 		servePorts := make([]uint16, 0, 3)
 		for port := range b.serveConfig.TCPs() {
 			if port > 0 {
@@ -1366,6 +1686,8 @@ func serveSetTCPPortsInterceptedFromNetmapAndPrefsLocked(b *LocalBackend, prefs
 	}
 
 	b.setVIPServicesTCPPortsInterceptedLocked(vipServicesPorts)
+	b.setUDPPortsIntercepted(udpPorts)
+	b.closeStaleServeUDPSessionsLocked()
 
 	return handlePorts
 }
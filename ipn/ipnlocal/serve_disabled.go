@@ -20,6 +20,8 @@
 
 type localListener = struct{}
 
+type serveUDPSession = struct{}
+
 func (b *LocalBackend) DeleteForegroundSession(sessionID string) error {
 	return nil
 }
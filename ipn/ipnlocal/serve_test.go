@@ -17,6 +17,7 @@
 	"fmt"
 	"io"
 	"mime"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
@@ -32,9 +33,11 @@
 	"tailscale.com/health"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
+	"tailscale.com/net/proxyproto"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/tstest"
+	"tailscale.com/tstime"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
@@ -345,6 +348,198 @@ func TestServeConfigForeground(t *testing.T) {
 	}
 }
 
+func TestServeTCPForwardProxyProtocol(t *testing.T) {
+	b := newTestBackend(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	tests := []struct {
+		name    string
+		version int
+		src     netip.AddrPort
+		want    proxyproto.Header
+	}{
+		{
+			name:    "v1",
+			version: 1,
+			src:     netip.MustParseAddrPort("100.150.151.152:1234"),
+			want: proxyproto.Header{
+				Src: netip.MustParseAddrPort("100.150.151.152:1234"),
+				Dst: netip.MustParseAddrPort("100.150.151.151:5432"),
+			},
+		},
+		{
+			name:    "v2_user",
+			version: 2,
+			src:     netip.MustParseAddrPort("100.150.151.152:1234"),
+			want: proxyproto.Header{
+				Src: netip.MustParseAddrPort("100.150.151.152:1234"),
+				Dst: netip.MustParseAddrPort("100.150.151.151:5432"),
+				TLVs: []proxyproto.TLV{
+					{Type: proxyproto.TypeTailscaleUser, Value: []byte("someone@example.com")},
+				},
+			},
+		},
+		{
+			name:    "v2_tagged",
+			version: 2,
+			src:     netip.MustParseAddrPort("100.150.151.153:1234"),
+			want: proxyproto.Header{
+				Src: netip.MustParseAddrPort("100.150.151.153:1234"),
+				Dst: netip.MustParseAddrPort("100.150.151.151:5432"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := b.SetServeConfig(&ipn.ServeConfig{
+				TCP: map[uint16]*ipn.TCPPortHandler{
+					5432: {
+						TCPForward:    ln.Addr().String(),
+						ProxyProtocol: tt.version,
+					},
+				},
+			}, ""); err != nil {
+				t.Fatal(err)
+			}
+			h := b.tcpHandlerForServe(5432, tt.src, nil)
+			if h == nil {
+				t.Fatal("no handler for served port")
+			}
+			client, server := net.Pipe()
+			defer client.Close()
+			go h(server)
+
+			back, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer back.Close()
+			want, err := tt.want.Format(tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			back.SetReadDeadline(time.Now().Add(5 * time.Second))
+			got := make([]byte, len(want))
+			if _, err := io.ReadFull(back, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got header %q; want %q", got, want)
+			}
+
+			// The connection's data follows the header.
+			go client.Write([]byte("hello"))
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(back, buf); err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != "hello" {
+				t.Errorf("got %q; want %q", buf, "hello")
+			}
+		})
+	}
+
+	if err := b.SetServeConfig(&ipn.ServeConfig{
+		TCP: map[uint16]*ipn.TCPPortHandler{
+			5432: {TCPForward: ln.Addr().String(), ProxyProtocol: 3},
+		},
+	}, ""); err == nil {
+		t.Error("unsupported PROXY protocol version accepted")
+	}
+}
+
+func TestServeUDPForward(t *testing.T) {
+	b := newTestBackend(t)
+
+	// A backend that echoes packets back.
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	if err := b.SetServeConfig(&ipn.ServeConfig{
+		UDP: map[uint16]*ipn.UDPPortHandler{
+			27015: {UDPForward: backend.LocalAddr().String()},
+		},
+	}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !b.ShouldInterceptUDPPort(27015) {
+		t.Error("UDP port 27015 not intercepted")
+	}
+	if b.ShouldInterceptUDPPort(27016) {
+		t.Error("UDP port 27016 intercepted")
+	}
+	if h := b.udpHandlerForServe(27016, netip.MustParseAddrPort("100.150.151.152:1234")); h != nil {
+		t.Fatal("got handler for unserved port")
+	}
+	h := b.udpHandlerForServe(27015, netip.MustParseAddrPort("100.150.151.152:1234"))
+	if h == nil {
+		t.Fatal("no handler for served port")
+	}
+
+	// Stand in for netstack's connected UDP endpoint with a pair of
+	// loopback sockets.
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	conn, err := net.DialUDP("udp", nil, peer.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h(conn)
+	}()
+
+	peer.SetDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1500)
+	for _, msg := range []string{"ping", "pong"} {
+		if _, err := peer.WriteTo([]byte(msg), conn.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := peer.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != msg {
+			t.Errorf("got %q; want %q", got, msg)
+		}
+	}
+
+	// Removing the port from the serve config closes the session.
+	if err := b.SetServeConfig(&ipn.ServeConfig{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("UDP session not closed after serve config change")
+	}
+	if b.ShouldInterceptUDPPort(27015) {
+		t.Error("UDP port 27015 still intercepted")
+	}
+}
+
 // TestServeConfigServices tests the side effects of setting the
 // Services field in a ServeConfig. The Services field is a map
 // of all services the current service host is serving. Unlike what we
@@ -806,6 +1001,112 @@ type headerCheck struct {
 	}
 }
 
+func TestServeHTTPProxyOptions(t *testing.T) {
+	b := newTestBackend(t)
+
+	testServ := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow/" || r.URL.Path == "/slow" {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+				return
+			}
+			w.Header().Set("Path", r.URL.Path)
+			w.Header().Set("Got-Dashboard-Token", r.Header.Get("Dashboard-Token"))
+			w.Header().Set("Got-Cookie", r.Header.Get("Cookie"))
+			w.Header().Set("Server", "backend")
+		},
+	))
+	defer testServ.Close()
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/app/": {
+					Proxy:              testServ.URL,
+					SetRequestHeaders:  map[string]string{"Dashboard-Token": "secret", "Cookie": ""},
+					SetResponseHeaders: map[string]string{"X-Frame-Options": "DENY", "Server": ""},
+				},
+				"/keep/": {
+					Proxy:      testServ.URL,
+					KeepPrefix: true,
+				},
+				"/slow/": {
+					Proxy:      testServ.URL,
+					KeepPrefix: true,
+					Timeout:    tstime.GoDuration{Duration: 50 * time.Millisecond},
+				},
+				"/limited/": {
+					Proxy:     testServ.URL,
+					RateLimit: 0.001,
+					RateBurst: 2,
+				},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(path, srcIP string) *http.Response {
+		t.Helper()
+		req := &http.Request{
+			URL:    &url.URL{Path: path},
+			Header: http.Header{"Cookie": {"session=1"}},
+			TLS:    &tls.ConnectionState{ServerName: "example.ts.net"},
+		}
+		req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+			DestPort: 443,
+			SrcAddr:  netip.MustParseAddrPort(srcIP + ":1234"),
+		}))
+		w := httptest.NewRecorder()
+		b.serveWebHandler(w, req)
+		return w.Result()
+	}
+
+	res := do("/app/foo", "100.150.151.152")
+	for k, want := range map[string]string{
+		"Path":                "/foo",
+		"Got-Dashboard-Token": "secret",
+		"Got-Cookie":          "",
+		"X-Frame-Options":     "DENY",
+		"Server":              "",
+	} {
+		if got := res.Header.Get(k); got != want {
+			t.Errorf("/app/foo: %s = %q; want %q", k, got, want)
+		}
+	}
+
+	res = do("/keep/foo", "100.150.151.152")
+	if got := res.Header.Get("Path"); got != "/keep/foo" {
+		t.Errorf("/keep/foo: Path = %q; want %q", got, "/keep/foo")
+	}
+	if got := res.Header.Get("Got-Cookie"); got != "session=1" {
+		t.Errorf("/keep/foo: Got-Cookie = %q; want %q", got, "session=1")
+	}
+
+	if res := do("/slow/", "100.150.151.152"); res.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("/slow/: status = %v; want %v", res.StatusCode, http.StatusGatewayTimeout)
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if res := do("/limited/", "100.150.151.152"); res.StatusCode != want {
+			t.Errorf("/limited/ request %d: status = %v; want %v", i, res.StatusCode, want)
+		}
+	}
+	// Each client has its own limit.
+	if res := do("/limited/", "100.150.151.153"); res.StatusCode != http.StatusOK {
+		t.Errorf("/limited/ from another client: status = %v; want %v", res.StatusCode, http.StatusOK)
+	}
+
+	conf.Web["example.ts.net:443"].Handlers["/text/"] = &ipn.HTTPHandler{Text: "hi", KeepPrefix: true}
+	if err := b.SetServeConfig(conf, ""); err == nil {
+		t.Error("proxy options on a text handler accepted")
+	}
+}
+
 func TestServeHTTPProxyGrantHeader(t *testing.T) {
 	b := newTestBackend(t)
 
@@ -19,6 +19,7 @@
 
 	"tailscale.com/tailcfg"
 	"tailscale.com/tka"
+	"tailscale.com/tstime"
 	"tailscale.com/types/key"
 	"tailscale.com/types/ptr"
 	"tailscale.com/types/views"
@@ -256,6 +257,10 @@ type PeerStatus struct {
 	Relay     string // DERP region
 	PeerRelay string // peer relay address (ip:port:vni)
 
+	// Paths is the measured quality of each direct path to the peer
+	// that's been pinged recently, sorted by address.
+	Paths []PeerPath `json:",omitempty"`
+
 	RxBytes        int64
 	TxBytes        int64
 	Created        time.Time // time registered with tailcontrol
@@ -330,6 +335,33 @@ type PeerStatus struct {
 	Location *tailcfg.Location `json:",omitempty"`
 }
 
+// PeerPath is the quality of one candidate path to a peer, measured
+// from the disco pings sent on it over a recent sliding window.
+type PeerPath struct {
+	Addr string // ip:port of the path
+
+	// Active is whether the path is the one currently used to send to
+	// the peer.
+	Active bool `json:",omitempty"`
+
+	// Demoted is whether the path is avoided because its loss rate is
+	// too high, in favor of DERP or another path.
+	Demoted bool `json:",omitempty"`
+
+	Pings    int     // pings sent in the window, answered or not
+	LossRate float64 // fraction of Pings that went unanswered, 0 to 1
+
+	// RTTP50, RTTP90, and RTTP99 are the percentiles of round-trip
+	// time over the answered pings.
+	RTTP50 tstime.GoDuration `json:",omitzero"`
+	RTTP90 tstime.GoDuration `json:",omitzero"`
+	RTTP99 tstime.GoDuration `json:",omitzero"`
+
+	// Jitter is the mean difference in round-trip time between
+	// consecutive answered pings.
+	Jitter tstime.GoDuration `json:",omitzero"`
+}
+
 type TaildropTargetStatus int
 
 const (
@@ -485,6 +517,9 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if v := st.CurAddr; v != "" {
 		e.CurAddr = v
 	}
+	if v := st.Paths; v != nil {
+		e.Paths = v
+	}
 	if v := st.RxBytes; v != 0 {
 		e.RxBytes = v
 	}
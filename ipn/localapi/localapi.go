@@ -34,6 +34,8 @@
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/logtail"
+	"tailscale.com/net/dns/resolver"
+	"tailscale.com/net/netcheck"
 	"tailscale.com/net/netutil"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstime"
@@ -76,6 +78,7 @@
 	"goroutines":        (*Handler).serveGoroutines,
 	"login-interactive": (*Handler).serveLoginInteractive,
 	"logout":            (*Handler).serveLogout,
+	"netcheck-history":  (*Handler).serveNetcheckHistory,
 	"ping":              (*Handler).servePing,
 	"prefs":             (*Handler).servePrefs,
 	"reload-config":     (*Handler).reloadConfig,
@@ -120,6 +123,8 @@ func init() {
 		Register("watch-ipn-bus", (*Handler).serveWatchIPNBus)
 	}
 	if buildfeatures.HasDNS {
+		Register("dns-cache", (*Handler).serveDNSCache)
+		Register("dns-log", (*Handler).serveDNSLog)
 		Register("dns-osconfig", (*Handler).serveDNSOSConfig)
 		Register("dns-query", (*Handler).serveDNSQuery)
 	}
@@ -1059,6 +1064,27 @@ func (h *Handler) serveSetDNS(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(struct{}{})
 }
 
+// serveNetcheckHistory serves the recorded netcheck reports, oldest first,
+// as a JSON array.
+func (h *Handler) serveNetcheckHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != httpm.GET {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	if !h.PermitRead {
+		http.Error(w, "netcheck history access denied", http.StatusForbidden)
+		return
+	}
+	reports := h.b.NetcheckHistory()
+	if reports == nil {
+		reports = []*netcheck.Report{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(reports)
+}
+
 func (h *Handler) serveDERPMap(w http.ResponseWriter, r *http.Request) {
 	if r.Method != httpm.GET {
 		http.Error(w, "want GET", http.StatusBadRequest)
@@ -1613,6 +1639,168 @@ func (h *Handler) serveDNSQuery(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// serveDNSCache serves the contents of the internal DNS forwarder's cache of
+// upstream responses on GET, as an apitype.DNSCacheStatus JSON object, and
+// flushes the cache on POST.
+func (h *Handler) serveDNSCache(w http.ResponseWriter, r *http.Request) {
+	if !buildfeatures.HasDNS {
+		http.Error(w, feature.ErrUnavailable.Error(), http.StatusNotImplemented)
+		return
+	}
+	// Require write access for privacy reasons.
+	if !h.PermitWrite {
+		http.Error(w, "dns-cache access denied", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case httpm.GET:
+		st, err := h.b.DNSCacheStatus()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		now := time.Now()
+		res := apitype.DNSCacheStatus{
+			Entries: make([]apitype.DNSCacheEntry, 0, len(st.Entries)),
+			Hits:    st.Hits,
+			Misses:  st.Misses,
+		}
+		for _, e := range st.Entries {
+			res.Entries = append(res.Entries, apitype.DNSCacheEntry{
+				Name:  string(e.Name),
+				Type:  strings.TrimPrefix(e.Type.String(), "Type"),
+				Class: strings.TrimPrefix(e.Class.String(), "Class"),
+				Route: string(e.Route),
+				RCode: strings.TrimPrefix(e.RCode.String(), "RCode"),
+				Size:  e.Size,
+				TTL:   int(e.Expires.Sub(now).Round(time.Second) / time.Second),
+
+				DNSSECOK:         e.DNSSECOK,
+				CheckingDisabled: e.CheckingDisabled,
+				Validated:        e.Validated,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	case httpm.POST:
+		if err := h.b.FlushDNSCache(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "only GET or POST allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveDNSLog serves the query log of the internal DNS resolver.
+//
+// On GET, it serves the log as an apitype.DNSQueryLog JSON object, or, with
+// "follow=true", streams the entries of the log followed by new queries as
+// they are answered, as a sequence of apitype.DNSQueryLogEntry JSON objects.
+// On POST, it enables or disables the log per the "enabled" parameter.
+func (h *Handler) serveDNSLog(w http.ResponseWriter, r *http.Request) {
+	if !buildfeatures.HasDNS {
+		http.Error(w, feature.ErrUnavailable.Error(), http.StatusNotImplemented)
+		return
+	}
+	// Require write access for privacy reasons.
+	if !h.PermitWrite {
+		http.Error(w, "dns-log access denied", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case httpm.GET:
+		if defBool(r.URL.Query().Get("follow"), false) {
+			h.serveDNSLogFollow(w, r)
+			return
+		}
+		enabled, entries, err := h.b.DNSQueryLog()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res := apitype.DNSQueryLog{
+			Enabled: enabled,
+			Entries: make([]apitype.DNSQueryLogEntry, 0, len(entries)),
+		}
+		for _, e := range entries {
+			res.Entries = append(res.Entries, dnsQueryLogEntry(e))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	case httpm.POST:
+		enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if err != nil {
+			http.Error(w, "invalid 'enabled' parameter", http.StatusBadRequest)
+			return
+		}
+		if err := h.b.SetDNSQueryLogEnabled(enabled); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "only GET or POST allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveDNSLogFollow streams the internal DNS resolver's query log to w
+// until the request is done.
+func (h *Handler) serveDNSLogFollow(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	backlog, entries, stop, err := h.b.WatchDNSQueryLog()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer stop()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	for _, e := range backlog {
+		if err := enc.Encode(dnsQueryLogEntry(e)); err != nil {
+			return
+		}
+	}
+	f.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-entries:
+			if err := enc.Encode(dnsQueryLogEntry(e)); err != nil {
+				return
+			}
+			f.Flush()
+		}
+	}
+}
+
+// dnsQueryLogEntry converts e to its LocalAPI representation.
+func dnsQueryLogEntry(e resolver.QueryLogEntry) apitype.DNSQueryLogEntry {
+	ret := apitype.DNSQueryLogEntry{
+		Time:       e.Time,
+		Name:       string(e.Name),
+		Type:       strings.TrimPrefix(e.Type.String(), "Type"),
+		Client:     e.From.String(),
+		ClientName: string(e.FromName),
+		Forwarded:  e.Forwarded,
+		Upstream:   e.Upstream,
+		Cached:     e.Cached,
+		Error:      e.Err,
+		Latency:    e.Latency,
+	}
+	if e.Err == "" {
+		ret.RCode = strings.TrimPrefix(e.RCode.String(), "RCode")
+	}
+	return ret
+}
+
 // dnsMessageTypeForString returns the dnsmessage.Type for the given string.
 // For example, DNSMessageTypeForString("A") returns dnsmessage.TypeA.
 func dnsMessageTypeForString(s string) (t dnsmessage.Type, err error) {
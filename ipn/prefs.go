@@ -282,6 +282,51 @@ type Prefs struct {
 	// non-nil/enabled.
 	RelayServerPort *int `json:",omitempty"`
 
+	// DNSOverrides are node-local DNS records that the internal DNS
+	// resolver (100.100.100.100) answers before MagicDNS names and before
+	// forwarding queries. Their Type is "A", "AAAA", "CNAME" or "TXT", or
+	// empty for A or AAAA depending on the Value. They only take effect
+	// when CorpDNS is true.
+	DNSOverrides []tailcfg.DNSRecord `json:",omitempty"`
+
+	// DNSBlocklists are the paths of local files listing domains that the
+	// internal DNS resolver blocks, in hosts file or adblock list format.
+	// Queries for blocked names are answered with an unspecified address
+	// (0.0.0.0 or ::) instead of being forwarded. Like DNSOverrides, they
+	// only take effect when CorpDNS is true. The files must be in the
+	// dns-blocklists directory of tailscaled's state directory, and are
+	// reread when they change.
+	DNSBlocklists []string `json:",omitempty"`
+
+	// ValidateDNSSEC is whether the internal DNS resolver validates the
+	// DNSSEC signatures of the responses it forwards upstream, answering
+	// SERVFAIL for bogus ones and setting the AD bit on secure ones.
+	ValidateDNSSEC bool `json:",omitempty"`
+
+	// DNSSECExemptDomains are the domains whose forwarded responses
+	// aren't validated even if ValidateDNSSEC is set, such as split DNS
+	// domains served by resolvers that strip DNSSEC records.
+	DNSSECExemptDomains []string `json:",omitempty"`
+
+	// PortMappings are port mappings to request from the LAN gateway over
+	// NAT-PMP, PCP or UPnP, in addition to the one for WireGuard, to make
+	// services on this machine reachable from outside the LAN. Each is of
+	// the form "[EXTERNAL:]INTERNAL/PROTO", such as "443:8443/tcp"; see
+	// portmappertype.ParseStaticMapping.
+	PortMappings []string `json:",omitempty"`
+
+	// BindInterface, if non-empty, is the name of the network interface
+	// (such as "wlan0") that tailscaled's own traffic to peers, DERP
+	// servers, STUN servers and the LAN gateway must use, instead of the
+	// one with the default route. If empty, the tailscaled
+	// --bind-interface flag applies.
+	BindInterface string `json:",omitempty"`
+
+	// BindInterfaceFallback is whether tailscaled's traffic uses the
+	// default route while BindInterface is missing or down. If false, it
+	// goes offline instead until the interface comes back.
+	BindInterfaceFallback bool `json:",omitempty"`
+
 	// AllowSingleHosts was a legacy field that was always true
 	// for the past 4.5 years. It controlled whether Tailscale
 	// peers got /32 or /127 routes for each other.
@@ -375,6 +420,13 @@ type MaskedPrefs struct {
 	NetfilterKindSet          bool                `json:",omitempty"`
 	DriveSharesSet            bool                `json:",omitempty"`
 	RelayServerPortSet        bool                `json:",omitempty"`
+	DNSOverridesSet           bool                `json:",omitempty"`
+	DNSBlocklistsSet          bool                `json:",omitempty"`
+	ValidateDNSSECSet         bool                `json:",omitempty"`
+	DNSSECExemptDomainsSet    bool                `json:",omitempty"`
+	PortMappingsSet           bool                `json:",omitempty"`
+	BindInterfaceSet          bool                `json:",omitempty"`
+	BindInterfaceFallbackSet  bool                `json:",omitempty"`
 }
 
 // SetsInternal reports whether mp has any of the Internal*Set field bools set
@@ -611,6 +663,29 @@ func (p *Prefs) pretty(goos string) string {
 	if buildfeatures.HasRelayServer && p.RelayServerPort != nil {
 		fmt.Fprintf(&sb, "relayServerPort=%d ", *p.RelayServerPort)
 	}
+	if buildfeatures.HasDNS {
+		if len(p.DNSOverrides) > 0 {
+			fmt.Fprintf(&sb, "dnsOverrides=%d ", len(p.DNSOverrides))
+		}
+		if len(p.DNSBlocklists) > 0 {
+			fmt.Fprintf(&sb, "dnsBlocklists=%s ", strings.Join(p.DNSBlocklists, ","))
+		}
+		if p.ValidateDNSSEC {
+			sb.WriteString("validateDNSSEC ")
+			if len(p.DNSSECExemptDomains) > 0 {
+				fmt.Fprintf(&sb, "dnssecExempt=%s ", strings.Join(p.DNSSECExemptDomains, ","))
+			}
+		}
+	}
+	if buildfeatures.HasPortMapper && len(p.PortMappings) > 0 {
+		fmt.Fprintf(&sb, "portMappings=%s ", strings.Join(p.PortMappings, ","))
+	}
+	if p.BindInterface != "" {
+		fmt.Fprintf(&sb, "bindInterface=%s ", p.BindInterface)
+		if p.BindInterfaceFallback {
+			sb.WriteString("bindInterfaceFallback ")
+		}
+	}
 	if p.Persist != nil {
 		sb.WriteString(p.Persist.Pretty())
 	} else {
@@ -674,7 +749,14 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.PostureChecking == p2.PostureChecking &&
 		slices.EqualFunc(p.DriveShares, p2.DriveShares, drive.SharesEqual) &&
 		p.NetfilterKind == p2.NetfilterKind &&
-		compareIntPtrs(p.RelayServerPort, p2.RelayServerPort)
+		compareIntPtrs(p.RelayServerPort, p2.RelayServerPort) &&
+		slices.Equal(p.DNSOverrides, p2.DNSOverrides) &&
+		slices.Equal(p.DNSBlocklists, p2.DNSBlocklists) &&
+		p.ValidateDNSSEC == p2.ValidateDNSSEC &&
+		slices.Equal(p.DNSSECExemptDomains, p2.DNSSECExemptDomains) &&
+		slices.Equal(p.PortMappings, p2.PortMappings) &&
+		p.BindInterface == p2.BindInterface &&
+		p.BindInterfaceFallback == p2.BindInterfaceFallback
 }
 
 func (au AutoUpdatePrefs) Pretty() string {
@@ -68,6 +68,13 @@ func TestPrefsEqual(t *testing.T) {
 		"NetfilterKind",
 		"DriveShares",
 		"RelayServerPort",
+		"DNSOverrides",
+		"DNSBlocklists",
+		"ValidateDNSSEC",
+		"DNSSECExemptDomains",
+		"PortMappings",
+		"BindInterface",
+		"BindInterfaceFallback",
 		"AllowSingleHosts",
 		"Persist",
 	}
@@ -368,6 +375,51 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{RelayServerPort: relayServerPort(1)},
 			false,
 		},
+		{
+			&Prefs{DNSOverrides: []tailcfg.DNSRecord{{Name: "printer.lan", Value: "192.168.1.10"}}},
+			&Prefs{DNSOverrides: []tailcfg.DNSRecord{{Name: "printer.lan", Value: "192.168.1.10"}}},
+			true,
+		},
+		{
+			&Prefs{DNSOverrides: []tailcfg.DNSRecord{{Name: "printer.lan", Value: "192.168.1.10"}}},
+			&Prefs{DNSOverrides: []tailcfg.DNSRecord{{Name: "printer.lan", Value: "192.168.1.11"}}},
+			false,
+		},
+		{
+			&Prefs{DNSBlocklists: []string{"/etc/hosts.block"}},
+			&Prefs{DNSBlocklists: []string{"/etc/hosts.block", "/etc/adblock.txt"}},
+			false,
+		},
+		{
+			&Prefs{ValidateDNSSEC: true},
+			&Prefs{ValidateDNSSEC: false},
+			false,
+		},
+		{
+			&Prefs{DNSSECExemptDomains: []string{"corp.example"}},
+			&Prefs{DNSSECExemptDomains: []string{"corp.example"}},
+			true,
+		},
+		{
+			&Prefs{PortMappings: []string{"443:8443/tcp"}},
+			&Prefs{PortMappings: []string{"443:8443/tcp"}},
+			true,
+		},
+		{
+			&Prefs{PortMappings: []string{"443:8443/tcp"}},
+			&Prefs{PortMappings: []string{"443:8443/udp"}},
+			false,
+		},
+		{
+			&Prefs{BindInterface: "wlan0"},
+			&Prefs{BindInterface: "rmnet_data0"},
+			false,
+		},
+		{
+			&Prefs{BindInterface: "wlan0"},
+			&Prefs{BindInterface: "wlan0", BindInterfaceFallback: true},
+			false,
+		},
 	}
 	for i, tt := range tests {
 		got := tt.a.Equals(tt.b)
@@ -16,6 +16,7 @@
 
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/ipproto"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/set"
@@ -51,6 +52,10 @@ type ServeConfig struct {
 	// the Tailscale IP addresses. (not subnet routers, etc)
 	TCP map[uint16]*TCPPortHandler `json:",omitempty"`
 
+	// UDP are the list of UDP port numbers that tailscaled should handle for
+	// the Tailscale IP addresses. (not subnet routers, etc)
+	UDP map[uint16]*UDPPortHandler `json:",omitempty"`
+
 	// Web maps from "$SNI_NAME:$PORT" to a set of HTTP handlers
 	// keyed by mount point ("/", "/foo", etc)
 	Web map[HostPort]*WebServerConfig `json:",omitempty"`
@@ -149,6 +154,22 @@ type TCPPortHandler struct {
 	// SNI name with this value. It is only used if TCPForward is non-empty.
 	// (the HTTPS mode uses ServeConfig.Web)
 	TerminateTLS string `json:",omitempty"`
+
+	// ProxyProtocol, if non-zero, is the version of the HAProxy PROXY
+	// protocol (1 or 2) whose header tailscaled sends to TCPForward at the
+	// start of each connection, so that the backend learns the address of
+	// the peer that made it. Version 2 headers also carry the peer's
+	// identity; see package tailscale.com/net/proxyproto. It is only used
+	// if TCPForward is non-empty.
+	ProxyProtocol int `json:",omitempty"`
+}
+
+// UDPPortHandler describes what to do when handling a UDP flow.
+type UDPPortHandler struct {
+	// UDPForward is the IP:port to forward UDP packets to. Each peer
+	// address and port forwarding to it gets its own session, which is
+	// closed after it has been idle for a while.
+	UDPForward string `json:",omitempty"`
 }
 
 // HTTPHandler is either a path or a proxy to serve.
@@ -171,6 +192,38 @@ type HTTPHandler struct {
 	//   - ${REQUEST_URI}: replaced with the request's full URI (path and query string)
 	Redirect string `json:",omitempty"`
 
+	// The following fields only apply to Proxy handlers.
+
+	// SetRequestHeaders are headers to set on requests forwarded to Proxy,
+	// replacing any sent by the client. A header with an empty value is
+	// removed instead.
+	SetRequestHeaders map[string]string `json:",omitempty"`
+
+	// SetResponseHeaders are headers to set on responses from Proxy,
+	// replacing any sent by the backend. A header with an empty value is
+	// removed instead.
+	SetResponseHeaders map[string]string `json:",omitempty"`
+
+	// KeepPrefix, if true, means that the handler's mount point is kept in
+	// the request path forwarded to Proxy. By default, it's stripped, so
+	// that a request for /app/foo on a handler mounted at /app is forwarded
+	// as /foo.
+	KeepPrefix bool `json:",omitempty"`
+
+	// Timeout, if non-zero, is how long to wait for Proxy to send response
+	// headers before failing the request with 504 Gateway Timeout.
+	Timeout tstime.GoDuration `json:",omitzero"`
+
+	// RateLimit, if non-zero, is the number of requests per second that
+	// each client (a tailnet peer or, over Funnel, an internet address) may
+	// make to this handler in the long run. Requests over the limit fail
+	// with 429 Too Many Requests.
+	RateLimit float64 `json:",omitempty"`
+
+	// RateBurst is the number of requests a client may make at once before
+	// RateLimit applies. If zero, it's RateLimit rounded up.
+	RateBurst int `json:",omitempty"`
+
 	// TODO(bradfitz): bool to not enumerate directories? TTL on mapping for
 	// temporary ones? Error codes?
 }
@@ -403,8 +456,9 @@ func (sc *ServeConfig) SetWebHandler(handler *HTTPHandler, host string, port uin
 // SetTCPForwarding sets the fwdAddr (IP:port form) to which to forward
 // connections from the given port. If terminateTLS is true, TLS connections
 // are terminated with only the given host name permitted before passing them
-// to the fwdAddr.
-func (sc *ServeConfig) SetTCPForwarding(port uint16, fwdAddr string, terminateTLS bool, host string) {
+// to the fwdAddr. If proxyProtocol is non-zero, a PROXY protocol header of
+// that version is sent to fwdAddr at the start of each connection.
+func (sc *ServeConfig) SetTCPForwarding(port uint16, fwdAddr string, terminateTLS bool, proxyProtocol int, host string) {
 	if sc == nil {
 		sc = new(ServeConfig)
 	}
@@ -417,13 +471,35 @@ func (sc *ServeConfig) SetTCPForwarding(port uint16, fwdAddr string, terminateTL
 		}
 		tcpPortHandler = &svcConfig.TCP
 	}
-	mak.Set(tcpPortHandler, port, &TCPPortHandler{TCPForward: fwdAddr})
+	mak.Set(tcpPortHandler, port, &TCPPortHandler{
+		TCPForward:    fwdAddr,
+		ProxyProtocol: proxyProtocol,
+	})
 
 	if terminateTLS {
 		(*tcpPortHandler)[port].TerminateTLS = host
 	}
 }
 
+// SetUDPForwarding sets the fwdAddr (IP:port form) to which to forward
+// UDP packets from the given port.
+func (sc *ServeConfig) SetUDPForwarding(port uint16, fwdAddr string) {
+	if sc == nil {
+		sc = new(ServeConfig)
+	}
+	mak.Set(&sc.UDP, port, &UDPPortHandler{UDPForward: fwdAddr})
+}
+
+// IsUDPForwardingOnPort reports whether ServeConfig is currently forwarding
+// UDP packets on the given port.
+func (sc *ServeConfig) IsUDPForwardingOnPort(port uint16) bool {
+	if sc == nil {
+		return false
+	}
+	h := sc.UDP[port]
+	return h != nil && h.UDPForward != ""
+}
+
 // SetFunnel sets the sc.AllowFunnel value for the given host and port.
 func (sc *ServeConfig) SetFunnel(host string, port uint16, setOn bool) {
 	if sc == nil {
@@ -524,6 +600,15 @@ func (sc *ServeConfig) RemoveTCPForwarding(svcName tailcfg.ServiceName, port uin
 	}
 }
 
+// RemoveUDPForwarding deletes the UDP forwarding configuration for the given
+// port from the serve config.
+func (sc *ServeConfig) RemoveUDPForwarding(port uint16) {
+	delete(sc.UDP, port)
+	if len(sc.UDP) == 0 {
+		sc.UDP = nil
+	}
+}
+
 // IsFunnelOn reports whether if ServeConfig is currently allowing funnel
 // traffic for any host:port.
 //
@@ -733,6 +818,27 @@ func (v ServeConfigView) TCPs() iter.Seq2[uint16, TCPPortHandlerView] {
 	}
 }
 
+// UDPs returns an iterator over both background and foreground UDP
+// handlers.
+//
+// The key is the port number.
+func (v ServeConfigView) UDPs() iter.Seq2[uint16, UDPPortHandlerView] {
+	return func(yield func(uint16, UDPPortHandlerView) bool) {
+		for k, v := range v.UDP().All() {
+			if !yield(k, v) {
+				return
+			}
+		}
+		for _, conf := range v.Foreground().All() {
+			for k, v := range conf.UDP().All() {
+				if !yield(k, v) {
+					return
+				}
+			}
+		}
+	}
+}
+
 // Webs returns an iterator over both background and foreground Web configurations.
 func (v ServeConfigView) Webs() iter.Seq2[HostPort, WebServerConfigView] {
 	return func(yield func(HostPort, WebServerConfigView) bool) {
@@ -788,6 +894,18 @@ func (v ServeConfigView) FindTCP(port uint16) (res TCPPortHandlerView, ok bool)
 	return v.TCP().GetOk(port)
 }
 
+// FindUDP returns the first UDP that matches with the given port. It
+// prefers a foreground match first followed by a background search if none
+// existed.
+func (v ServeConfigView) FindUDP(port uint16) (res UDPPortHandlerView, ok bool) {
+	for _, conf := range v.Foreground().All() {
+		if res, ok := conf.UDP().GetOk(port); ok {
+			return res, ok
+		}
+	}
+	return v.UDP().GetOk(port)
+}
+
 // FindWeb returns the first Web that matches with the given HostPort. It
 // prefers a foreground match first followed by a background search if none
 // existed.
@@ -839,6 +957,99 @@ func (sc *ServeConfig) CheckValidServicesConfig() error {
 	return nil
 }
 
+// CheckValidProxyProtocol reports whether any of the ServeConfig's TCP
+// handlers, including those of services and foreground configs, has an
+// unsupported ProxyProtocol version or sets one without TCPForward.
+func (sc *ServeConfig) CheckValidProxyProtocol() error {
+	check := func(tcp map[uint16]*TCPPortHandler) error {
+		for port, h := range tcp {
+			if h == nil || h.ProxyProtocol == 0 {
+				continue
+			}
+			if h.ProxyProtocol != 1 && h.ProxyProtocol != 2 {
+				return fmt.Errorf("port %d: unsupported PROXY protocol version %d", port, h.ProxyProtocol)
+			}
+			if h.TCPForward == "" {
+				return fmt.Errorf("port %d: PROXY protocol requires TCPForward", port)
+			}
+		}
+		return nil
+	}
+	if err := check(sc.TCP); err != nil {
+		return err
+	}
+	for svcName, svc := range sc.Services {
+		if svc == nil {
+			continue
+		}
+		if err := check(svc.TCP); err != nil {
+			return fmt.Errorf("service %q: %w", svcName, err)
+		}
+	}
+	for _, fg := range sc.Foreground {
+		if err := fg.CheckValidProxyProtocol(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasProxyOptions reports whether h sets any of the fields that only apply
+// to Proxy handlers.
+func (h *HTTPHandler) hasProxyOptions() bool {
+	return len(h.SetRequestHeaders) > 0 || len(h.SetResponseHeaders) > 0 ||
+		h.KeepPrefix || h.Timeout.Duration != 0 || h.RateLimit != 0 || h.RateBurst != 0
+}
+
+// CheckValidHTTPHandlers reports whether any of the ServeConfig's HTTP
+// handlers, including those of services and foreground configs, has
+// invalid proxy options.
+func (sc *ServeConfig) CheckValidHTTPHandlers() error {
+	check := func(web map[HostPort]*WebServerConfig) error {
+		for hp, wsc := range web {
+			if wsc == nil {
+				continue
+			}
+			for mount, h := range wsc.Handlers {
+				if h == nil || !h.hasProxyOptions() {
+					continue
+				}
+				if h.Proxy == "" {
+					return fmt.Errorf("%s%s: proxy options set on a handler that isn't a proxy", hp, mount)
+				}
+				if h.Timeout.Duration < 0 || h.RateLimit < 0 || h.RateBurst < 0 {
+					return fmt.Errorf("%s%s: timeout and rate limits must not be negative", hp, mount)
+				}
+				for _, hdrs := range []map[string]string{h.SetRequestHeaders, h.SetResponseHeaders} {
+					for k := range hdrs {
+						if k == "" || strings.ContainsAny(k, " \t\r\n:") {
+							return fmt.Errorf("%s%s: invalid header name %q", hp, mount, k)
+						}
+					}
+				}
+			}
+		}
+		return nil
+	}
+	if err := check(sc.Web); err != nil {
+		return err
+	}
+	for _, svc := range sc.Services {
+		if svc == nil {
+			continue
+		}
+		if err := check(svc.Web); err != nil {
+			return err
+		}
+	}
+	for _, fg := range sc.Foreground {
+		if err := fg.CheckValidHTTPHandlers(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ServicePortRange returns the list of tailcfg.ProtoPortRange that represents
 // the proto/ports pairs that are being served by the service.
 //
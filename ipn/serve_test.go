@@ -243,6 +243,27 @@ func TestIsTCPForwardingOnPort(t *testing.T) {
 	}
 }
 
+func TestUDPForwarding(t *testing.T) {
+	var sc ServeConfig
+	if sc.IsUDPForwardingOnPort(27015) {
+		t.Fatal("empty config forwards UDP")
+	}
+	sc.SetUDPForwarding(27015, "127.0.0.1:27015")
+	if !sc.IsUDPForwardingOnPort(27015) {
+		t.Error("port 27015 not forwarded after SetUDPForwarding")
+	}
+	if sc.IsUDPForwardingOnPort(27016) {
+		t.Error("port 27016 forwarded")
+	}
+	if v, ok := sc.View().FindUDP(27015); !ok || v.UDPForward() != "127.0.0.1:27015" {
+		t.Errorf("FindUDP(27015) = %v, %v", v.AsStruct(), ok)
+	}
+	sc.RemoveUDPForwarding(27015)
+	if sc.IsUDPForwardingOnPort(27015) || sc.UDP != nil {
+		t.Errorf("after RemoveUDPForwarding, UDP = %v", sc.UDP)
+	}
+}
+
 func TestExpandProxyTargetDev(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -40,6 +40,10 @@ func init() {
 // TPMPrefix is the path prefix used for TPM-encrypted StateStore.
 const TPMPrefix = "tpmseal:"
 
+// EncFilePrefix is the path prefix used for a StateStore encrypted with a key
+// derived from a keyfile or passphrase.
+const EncFilePrefix = "encfile:"
+
 // New returns a StateStore based on the provided arg
 // and registered stores.
 // The arg is of the form "prefix:rest", where prefix was previously
@@ -55,18 +59,20 @@ func init() {
 //     the suffix is a Kubernetes secret name
 //   - (Linux or Windows) if the string begins with "tpmseal:", the suffix is
 //     filepath that is sealed with the local TPM device.
+//   - if the string begins with "encfile:", the suffix is a filepath that is
+//     encrypted with a key from $TS_STATE_KEYFILE or $TS_STATE_PASSPHRASE.
 //   - In all other cases, the path is treated as a filepath.
 func New(logf logger.Logf, path string) (ipn.StateStore, error) {
 	for prefix, sf := range knownStores {
 		if strings.HasPrefix(path, prefix) {
 			// We can't strip the prefix here as some NewStoreFunc (like arn:)
 			// expect the prefix.
-			if prefix == TPMPrefix {
+			if f, ok := stateFileFormatOf(prefix); ok {
 				if runtime.GOOS == "windows" {
-					path = TPMPrefix + TryWindowsAppDataMigration(logf, strings.TrimPrefix(path, TPMPrefix))
+					path = prefix + TryWindowsAppDataMigration(logf, strings.TrimPrefix(path, prefix))
 				}
 				if err := maybeMigrateLocalStateFile(logf, path); err != nil {
-					return nil, fmt.Errorf("failed to migrate existing state file to TPM-sealed format: %w", err)
+					return nil, fmt.Errorf("failed to migrate existing state file to %s format: %w", f.desc, err)
 				}
 			}
 			return sf(logf, path)
@@ -76,7 +82,7 @@ func New(logf logger.Logf, path string) (ipn.StateStore, error) {
 		path = TryWindowsAppDataMigration(logf, path)
 	}
 	if err := maybeMigrateLocalStateFile(logf, path); err != nil {
-		return nil, fmt.Errorf("failed to migrate existing TPM-sealed state file to plaintext format: %w", err)
+		return nil, fmt.Errorf("failed to migrate existing encrypted state file to plaintext format: %w", err)
 	}
 	return NewFileStore(logf, path)
 }
@@ -236,7 +242,7 @@ func (s *FileStore) All() iter.Seq2[ipn.StateKey, []byte] {
 }
 
 // Ensure FileStore implements ExportableStore for migration to/from
-// tpm.tpmStore.
+// tpm.tpmStore and encfile.encStore.
 var _ ExportableStore = (*FileStore)(nil)
 
 // ExportableStore is an ipn.StateStore that can export all of its contents.
@@ -252,8 +258,53 @@ type ExportableStore interface {
 	All() iter.Seq2[ipn.StateKey, []byte]
 }
 
+// stateFileFormat is a format of local state file that New migrates existing
+// files to and from when the path has (or lacks) its prefix.
+type stateFileFormat struct {
+	prefix string   // store prefix; empty for plaintext files
+	desc   string   // for logs and errors, as in "TPM-sealed format"
+	keys   []string // exact set of top-level JSON keys in files of this format
+}
+
+var plaintextFormat = stateFileFormat{desc: "plaintext"}
+
+// encryptedFormats are the encrypted local state file formats.
+var encryptedFormats = []stateFileFormat{
+	{prefix: TPMPrefix, desc: "TPM-sealed", keys: []string{"key", "nonce", "data"}},
+	{prefix: EncFilePrefix, desc: "encrypted", keys: []string{"version", "kdf", "salt", "nonce", "data"}},
+}
+
+// stateFileFormatOf returns the encrypted state file format using prefix, if
+// any.
+func stateFileFormatOf(prefix string) (stateFileFormat, bool) {
+	for _, f := range encryptedFormats {
+		if f.prefix == prefix {
+			return f, true
+		}
+	}
+	return stateFileFormat{}, false
+}
+
+// open opens the state file at path (without a prefix) in format f.
+func (f stateFileFormat) open(logf logger.Logf, path string) (ipn.StateStore, error) {
+	if f.prefix == "" {
+		return NewFileStore(logf, path)
+	}
+	newStore, ok := knownStores[f.prefix]
+	if !ok {
+		return nil, fmt.Errorf("this build does not support %s state files", f.desc)
+	}
+	return newStore(logf, f.prefix+path)
+}
+
 func maybeMigrateLocalStateFile(logf logger.Logf, path string) error {
-	path, toTPM := strings.CutPrefix(path, TPMPrefix)
+	toFormat := plaintextFormat
+	for _, f := range encryptedFormats {
+		if p, ok := strings.CutPrefix(path, f.prefix); ok {
+			path, toFormat = p, f
+			break
+		}
+	}
 
 	// Extract JSON keys from the file on disk and guess what kind it is.
 	bs, err := os.ReadFile(path)
@@ -268,49 +319,34 @@ func maybeMigrateLocalStateFile(logf logger.Logf, path string) error {
 		return fmt.Errorf("failed to unmarshal %q: %w", path, err)
 	}
 	keys := slices.Sorted(maps.Keys(content))
-	tpmKeys := []string{"key", "nonce", "data"}
-	slices.Sort(tpmKeys)
-	// TPM-sealed files will have exactly these keys.
-	existingFileSealed := slices.Equal(keys, tpmKeys)
+	fromFormat := plaintextFormat
 	// Plaintext files for nodes that registered at least once will have this
-	// key, plus other dynamic ones.
-	_, existingFilePlaintext := content["_machinekey"]
-	isTPM := existingFileSealed && !existingFilePlaintext
+	// key, plus other dynamic ones. Encrypted files will have exactly the
+	// keys of their format.
+	if _, ok := content["_machinekey"]; !ok {
+		for _, f := range encryptedFormats {
+			if slices.Equal(keys, slices.Sorted(slices.Values(f.keys))) {
+				fromFormat = f
+				break
+			}
+		}
+	}
 
-	if isTPM == toTPM {
+	if fromFormat.prefix == toFormat.prefix {
 		// No migration needed.
 		return nil
 	}
 
-	newTPMStore, ok := knownStores[TPMPrefix]
-	if !ok {
-		return errors.New("this build does not support TPM integration")
-	}
-
 	// Open from (old format) and to (new format) stores for migration. The
 	// "to" store will be at tmpPath.
-	var from, to ipn.StateStore
 	tmpPath := path + ".tmp"
-	if toTPM {
-		// Migrate plaintext file to be TPM-sealed.
-		from, err = NewFileStore(logf, path)
-		if err != nil {
-			return fmt.Errorf("NewFileStore(%q): %w", path, err)
-		}
-		to, err = newTPMStore(logf, TPMPrefix+tmpPath)
-		if err != nil {
-			return fmt.Errorf("newTPMStore(%q): %w", tmpPath, err)
-		}
-	} else {
-		// Migrate TPM-selaed file to plaintext.
-		from, err = newTPMStore(logf, TPMPrefix+path)
-		if err != nil {
-			return fmt.Errorf("newTPMStore(%q): %w", path, err)
-		}
-		to, err = NewFileStore(logf, tmpPath)
-		if err != nil {
-			return fmt.Errorf("NewFileStore(%q): %w", tmpPath, err)
-		}
+	from, err := fromFormat.open(logf, path)
+	if err != nil {
+		return fmt.Errorf("opening %s state file %q: %w", fromFormat.desc, path, err)
+	}
+	to, err := toFormat.open(logf, tmpPath)
+	if err != nil {
+		return fmt.Errorf("opening %s state file %q: %w", toFormat.desc, tmpPath, err)
 	}
 	defer os.Remove(tmpPath)
 
@@ -334,10 +370,6 @@ func maybeMigrateLocalStateFile(logf logger.Logf, path string) error {
 		return err
 	}
 
-	if toTPM {
-		logf("migrated %q from plaintext to TPM-sealed format", path)
-	} else {
-		logf("migrated %q from TPM-sealed to plaintext format", path)
-	}
+	logf("migrated %q from %s to %s format", path, fromFormat.desc, toFormat.desc)
 	return nil
 }
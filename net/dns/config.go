@@ -51,6 +51,22 @@ type Config struct {
 	// OnlyIPv6, if true, uses the IPv6 service IP (for MagicDNS)
 	// instead of the IPv4 version (100.100.100.100).
 	OnlyIPv6 bool
+	// ValidateDNSSEC, if true, makes 100.100.100.100 validate the
+	// DNSSEC signatures of responses to the queries it forwards,
+	// answering SERVFAIL to those that fail validation.
+	// It has no effect on queries the OS sends to resolvers directly.
+	ValidateDNSSEC bool
+	// DNSSECExemptDomains are DNS suffixes for which forwarded
+	// responses aren't validated even if ValidateDNSSEC is set, such
+	// as internal zones that a split DNS resolver answers unsigned.
+	DNSSECExemptDomains []dnsname.FQDN
+	// LocalRecords are node-local static records that 100.100.100.100
+	// answers before Hosts and before forwarding queries.
+	LocalRecords []resolver.LocalRecord
+	// Blocklists are the paths of files listing domains, in hosts file or
+	// adblock list format, that 100.100.100.100 answers with an
+	// unspecified address instead of forwarding queries for.
+	Blocklists []string
 }
 
 var magicDNSDualStack = envknob.RegisterBool("TS_DEBUG_MAGIC_DNS_DUAL_STACK")
@@ -88,13 +104,28 @@ func (c *Config) WriteToBufioWriter(w *bufio.Writer) {
 
 	fmt.Fprintf(w, " SearchDomains:%v", c.SearchDomains)
 	fmt.Fprintf(w, " Hosts:%v", len(c.Hosts))
+	if c.ValidateDNSSEC {
+		fmt.Fprintf(w, " ValidateDNSSEC:true DNSSECExemptDomains:%v", c.DNSSECExemptDomains)
+	}
+	if len(c.LocalRecords) > 0 {
+		fmt.Fprintf(w, " LocalRecords:%v", len(c.LocalRecords))
+	}
+	if len(c.Blocklists) > 0 {
+		fmt.Fprintf(w, " Blocklists:%v", c.Blocklists)
+	}
 	w.WriteString("}")
 }
 
 // needsAnyResolvers reports whether c requires a resolver to be set
 // at the OS level.
 func (c Config) needsOSResolver() bool {
-	return c.hasDefaultResolvers() || c.hasRoutes()
+	return c.hasDefaultResolvers() || c.hasRoutes() || c.hasLocalFiltering()
+}
+
+// hasLocalFiltering reports whether c has local records or blocklists,
+// which quad-100 can only apply to the queries that the OS sends it.
+func (c Config) hasLocalFiltering() bool {
+	return len(c.LocalRecords) > 0 || len(c.Blocklists) > 0
 }
 
 func (c Config) hasRoutes() bool {
@@ -165,12 +196,18 @@ func (c Config) singleResolverSet() []*dnstype.Resolver {
 	return prev
 }
 
-// matchDomains returns the list of match suffixes needed by Routes.
+// matchDomains returns the list of match suffixes needed by Routes and
+// LocalRecords.
 func (c Config) matchDomains() []dnsname.FQDN {
 	ret := make([]dnsname.FQDN, 0, len(c.Routes))
 	for suffix := range c.Routes {
 		ret = append(ret, suffix)
 	}
+	for _, rec := range c.LocalRecords {
+		if !slices.Contains(ret, rec.Name) && !c.hasSplitDNSRouteForHost(rec.Name) {
+			ret = append(ret, rec.Name)
+		}
+	}
 	sort.Slice(ret, func(i, j int) bool {
 		return ret[i].WithTrailingDot() < ret[j].WithTrailingDot()
 	})
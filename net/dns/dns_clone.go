@@ -8,6 +8,7 @@
 import (
 	"net/netip"
 
+	"tailscale.com/net/dns/resolver"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/util/dnsname"
 )
@@ -43,16 +44,23 @@ func (src *Config) Clone() *Config {
 			dst.Hosts[k] = append([]netip.Addr{}, src.Hosts[k]...)
 		}
 	}
+	dst.DNSSECExemptDomains = append(src.DNSSECExemptDomains[:0:0], src.DNSSECExemptDomains...)
+	dst.LocalRecords = append(src.LocalRecords[:0:0], src.LocalRecords...)
+	dst.Blocklists = append(src.Blocklists[:0:0], src.Blocklists...)
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ConfigCloneNeedsRegeneration = Config(struct {
-	DefaultResolvers []*dnstype.Resolver
-	Routes           map[dnsname.FQDN][]*dnstype.Resolver
-	SearchDomains    []dnsname.FQDN
-	Hosts            map[dnsname.FQDN][]netip.Addr
-	OnlyIPv6         bool
+	DefaultResolvers    []*dnstype.Resolver
+	Routes              map[dnsname.FQDN][]*dnstype.Resolver
+	SearchDomains       []dnsname.FQDN
+	Hosts               map[dnsname.FQDN][]netip.Addr
+	OnlyIPv6            bool
+	ValidateDNSSEC      bool
+	DNSSECExemptDomains []dnsname.FQDN
+	LocalRecords        []resolver.LocalRecord
+	Blocklists          []string
 }{})
 
 // Clone duplicates src into dst and reports whether it succeeded.
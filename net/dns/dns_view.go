@@ -12,6 +12,7 @@
 
 	jsonv2 "github.com/go-json-experiment/json"
 	"github.com/go-json-experiment/json/jsontext"
+	"tailscale.com/net/dns/resolver"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/types/views"
 	"tailscale.com/util/dnsname"
@@ -125,14 +126,44 @@ func (v ConfigView) Hosts() views.MapSlice[dnsname.FQDN, netip.Addr] {
 
 // OnlyIPv6, if true, uses the IPv6 service IP (for MagicDNS)
 // instead of the IPv4 version (100.100.100.100).
-func (v ConfigView) OnlyIPv6() bool           { return v.ж.OnlyIPv6 }
+func (v ConfigView) OnlyIPv6() bool { return v.ж.OnlyIPv6 }
+
+// ValidateDNSSEC, if true, makes 100.100.100.100 validate the
+// DNSSEC signatures of responses to the queries it forwards,
+// answering SERVFAIL to those that fail validation.
+// It has no effect on queries the OS sends to resolvers directly.
+func (v ConfigView) ValidateDNSSEC() bool { return v.ж.ValidateDNSSEC }
+
+// DNSSECExemptDomains are DNS suffixes for which forwarded
+// responses aren't validated even if ValidateDNSSEC is set, such
+// as internal zones that a split DNS resolver answers unsigned.
+func (v ConfigView) DNSSECExemptDomains() views.Slice[dnsname.FQDN] {
+	return views.SliceOf(v.ж.DNSSECExemptDomains)
+}
+
+// LocalRecords are node-local static records that 100.100.100.100
+// answers before Hosts and before forwarding queries.
+func (v ConfigView) LocalRecords() views.Slice[resolver.LocalRecord] {
+	return views.SliceOf(v.ж.LocalRecords)
+}
+
+// Blocklists are the paths of files listing domains, in hosts file or
+// adblock list format, that 100.100.100.100 answers with an
+// unspecified address instead of forwarding queries for.
+func (v ConfigView) Blocklists() views.Slice[string] {
+	return views.SliceOf(v.ж.Blocklists)
+}
 func (v ConfigView) Equal(v2 ConfigView) bool { return v.ж.Equal(v2.ж) }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ConfigViewNeedsRegeneration = Config(struct {
-	DefaultResolvers []*dnstype.Resolver
-	Routes           map[dnsname.FQDN][]*dnstype.Resolver
-	SearchDomains    []dnsname.FQDN
-	Hosts            map[dnsname.FQDN][]netip.Addr
-	OnlyIPv6         bool
+	DefaultResolvers    []*dnstype.Resolver
+	Routes              map[dnsname.FQDN][]*dnstype.Resolver
+	SearchDomains       []dnsname.FQDN
+	Hosts               map[dnsname.FQDN][]netip.Addr
+	OnlyIPv6            bool
+	ValidateDNSSEC      bool
+	DNSSECExemptDomains []dnsname.FQDN
+	LocalRecords        []resolver.LocalRecord
+	Blocklists          []string
 }{})
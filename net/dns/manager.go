@@ -268,6 +268,10 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	// authoritative suffixes, even if we don't propagate MagicDNS to
 	// the OS.
 	rcfg.Hosts = cfg.Hosts
+	rcfg.ValidateDNSSEC = cfg.ValidateDNSSEC
+	rcfg.DNSSECExemptDomains = cfg.DNSSECExemptDomains
+	rcfg.LocalRecords = cfg.LocalRecords
+	rcfg.Blocklists = cfg.Blocklists
 	routes := map[dnsname.FQDN][]*dnstype.Resolver{} // assigned conditionally to rcfg.Routes below.
 	var propagateHostsToOS bool
 	for suffix, resolvers := range cfg.Routes {
@@ -292,7 +296,7 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 		// case where cfg is entirely zero, in which case these
 		// configs clear all Tailscale DNS settings.
 		return rcfg, ocfg, nil
-	case cfg.hasDefaultIPResolversOnly() && !cfg.hasHostsWithoutSplitDNSRoutes():
+	case cfg.hasDefaultIPResolversOnly() && !cfg.hasHostsWithoutSplitDNSRoutes() && !cfg.hasLocalFiltering():
 		// Trivial CorpDNS configuration, just override the OS resolver.
 		//
 		// If there are hosts (ExtraRecords) that are not covered by an existing
@@ -336,7 +340,7 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	// workaround.
 	isWindows := m.goos == "windows"
 	isApple := (m.goos == "darwin" || m.goos == "ios")
-	if len(cfg.singleResolverSet()) > 0 && m.os.SupportsSplitDNS() && !isWindows && !isApple {
+	if len(cfg.singleResolverSet()) > 0 && m.os.SupportsSplitDNS() && !isWindows && !isApple && !cfg.hasLocalFiltering() {
 		// Split DNS configuration requested, where all split domains
 		// go to the same resolvers. We can let the OS do it.
 		ocfg.Nameservers = toIPsOnly(cfg.singleResolverSet())
@@ -356,7 +360,11 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	// selectively answer ExtraRecords, and ignore other DNS traffic. As a
 	// workaround, we read the existing default resolver configuration and use
 	// that as the forwarder for all DNS traffic that quad-100 doesn't handle.
-	if isApple || !m.os.SupportsSplitDNS() {
+	//
+	// Local records and blocklists need quad-100 to see all DNS traffic
+	// too, so we do the same for them where we can.
+	needBaseCfg := isApple || !m.os.SupportsSplitDNS()
+	if needBaseCfg || cfg.hasLocalFiltering() {
 		// If the OS can't do native split-dns, read out the underlying
 		// resolver config and blend it into our config.  On apple platforms, [OSConfigurator.GetBaseConfig]
 		// has a tendency to temporarily fail if called immediately following
@@ -364,10 +372,19 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 		// indicates that the DNS configuration has changed via [RecompileDNSConfig].
 		cfg, err := m.os.GetBaseConfig()
 		if err == nil {
-			baseCfg = &cfg
+			if needBaseCfg || len(cfg.Nameservers) > 0 {
+				baseCfg = &cfg
+			}
 		} else if isApple && err == ErrGetBaseConfigNotSupported {
 			// This is currently (2022-10-13) expected on certain iOS and macOS
 			// builds.
+		} else if !needBaseCfg {
+			// We only wanted the base config for local records and
+			// blocklists; fall back to answering the local records
+			// with split DNS.
+			if err != ErrGetBaseConfigNotSupported {
+				m.logf("reading OS DNS config for local records and blocklists: %v", err)
+			}
 		} else {
 			m.health.SetUnhealthy(osConfigurationReadWarnable, health.Args{health.ArgError: err.Error()})
 			return resolver.Config{}, OSConfig{}, err
@@ -378,6 +395,12 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	if baseCfg == nil {
 		// If there was no base config, then we need to fallback to SplitDNS mode.
 		ocfg.MatchDomains = cfg.matchDomains()
+		if len(ocfg.MatchDomains) == 0 {
+			// Only blocklists, which we can't apply without the OS
+			// resolvers to forward other queries to.
+			m.logf("can't apply DNS blocklists: the OS DNS configuration is unavailable")
+			ocfg.Nameservers = nil
+		}
 	} else {
 		// On iOS only (for now), check if all route names point to resources inside the tailnet.
 		// If so, we can set those names as MatchDomains to enable a split DNS configuration
@@ -386,7 +409,7 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 		// we have any Routes outside the tailnet. Otherwise when app connectors are enabled,
 		// a query for 'work-laptop' might lead to search domain expansion, resolving
 		// as 'work-laptop.aws.com' for example.
-		if m.goos == "ios" && rcfg.RoutesRequireNoCustomResolvers() {
+		if m.goos == "ios" && rcfg.RoutesRequireNoCustomResolvers() && !cfg.hasLocalFiltering() {
 			if !m.disableSplitDNSOptimization() {
 				for r := range rcfg.Routes {
 					ocfg.MatchDomains = append(ocfg.MatchDomains, r)
@@ -405,6 +405,79 @@ func TestManager(t *testing.T) {
 				MatchDomains:  fqdns("corp.com"),
 			},
 		},
+		{
+			name: "local-records-split",
+			in: Config{
+				Routes:        upstreams("corp.com", "2.2.2.2"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+				LocalRecords:  localRecords("printer.lan.", "192.168.1.10"),
+			},
+			split: true,
+			bs: OSConfig{
+				Nameservers:   mustIPs("8.8.8.8"),
+				SearchDomains: fqdns("coffee.shop"),
+			},
+			os: OSConfig{
+				Nameservers:   mustIPs("100.100.100.100"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf", "coffee.shop"),
+			},
+			rs: resolver.Config{
+				Routes: upstreams(
+					".", "8.8.8.8",
+					"corp.com.", "2.2.2.2"),
+				LocalRecords: localRecords("printer.lan.", "192.168.1.10"),
+			},
+		},
+		{
+			// Without the OS resolvers, local records are answered
+			// using split DNS.
+			name: "local-records-split-no-base-config",
+			in: Config{
+				Routes:        upstreams("corp.com", "2.2.2.2"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+				LocalRecords:  localRecords("printer.lan.", "192.168.1.10"),
+			},
+			split: true,
+			os: OSConfig{
+				Nameservers:   mustIPs("100.100.100.100"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+				MatchDomains:  fqdns("corp.com", "printer.lan"),
+			},
+			rs: resolver.Config{
+				Routes:       upstreams("corp.com.", "2.2.2.2"),
+				LocalRecords: localRecords("printer.lan.", "192.168.1.10"),
+			},
+		},
+		{
+			name: "blocklists-only",
+			in: Config{
+				Blocklists: []string{"/etc/blocklist.txt"},
+			},
+			split: true,
+			bs: OSConfig{
+				Nameservers: mustIPs("8.8.8.8"),
+			},
+			os: OSConfig{
+				Nameservers: mustIPs("100.100.100.100"),
+			},
+			rs: resolver.Config{
+				Routes:     upstreams(".", "8.8.8.8"),
+				Blocklists: []string{"/etc/blocklist.txt"},
+			},
+		},
+		{
+			// Blocklists can't be applied without the OS resolvers to
+			// forward other queries to.
+			name: "blocklists-only-no-base-config",
+			in: Config{
+				Blocklists: []string{"/etc/blocklist.txt"},
+			},
+			split: true,
+			os:    OSConfig{},
+			rs: resolver.Config{
+				Blocklists: []string{"/etc/blocklist.txt"},
+			},
+		},
 		{
 			name: "routes-multi",
 			in: Config{
@@ -1078,3 +1151,18 @@ func TestConfigRecompilation(t *testing.T) {
 		t.Fatalf("Want non nil managerConfig.  Got nil")
 	}
 }
+
+// localRecords returns A and AAAA records for the IPs following each name
+// in strs.
+func localRecords(strs ...string) (ret []resolver.LocalRecord) {
+	for name, ips := range hosts(strs...) {
+		for _, ip := range ips {
+			rec, err := resolver.ParseLocalRecord(string(name), "", ip.String())
+			if err != nil {
+				panic(err)
+			}
+			ret = append(ret, rec)
+		}
+	}
+	return ret
+}
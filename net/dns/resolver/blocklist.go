@@ -0,0 +1,273 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/set"
+)
+
+// blocklist is a list of blocked domains, parsed from a file in hosts file
+// or adblock list format.
+type blocklist struct {
+	names   set.Set[dnsname.FQDN] // blocked names
+	domains set.Set[dnsname.FQDN] // blocked names and their subdomains
+	allowed set.Set[dnsname.FQDN] // names and subdomains exempt from domains
+}
+
+// blocks reports whether b blocks queries for name.
+func (b *blocklist) blocks(name dnsname.FQDN) bool {
+	if b.names.Contains(name) {
+		return true
+	}
+	if len(b.domains) == 0 {
+		return false
+	}
+	// Walk up from name to the root, so that the most specific rule
+	// wins.
+	for s := string(name); s != ""; {
+		d := dnsname.FQDN(s)
+		if b.allowed.Contains(d) {
+			return false
+		}
+		if b.domains.Contains(d) {
+			return true
+		}
+		_, s, _ = strings.Cut(s, ".")
+	}
+	return false
+}
+
+// hostsFileSkipNames are names commonly found in hosts files that are
+// never blocked.
+var hostsFileSkipNames = set.Of[dnsname.FQDN](
+	"localhost.",
+	"localhost.localdomain.",
+	"local.",
+	"broadcasthost.",
+	"ip6-localhost.",
+	"ip6-loopback.",
+	"ip6-localnet.",
+	"ip6-mcastprefix.",
+	"ip6-allnodes.",
+	"ip6-allrouters.",
+	"ip6-allhosts.",
+)
+
+// parseBlocklist parses a blocklist in either of two formats, which may be
+// mixed:
+//
+//   - hosts file lines, such as "0.0.0.0 ads.example.com", which block the
+//     listed names. A line with just a name blocks that name.
+//   - adblock rules of the form "||example.com^", which block a domain and
+//     all its subdomains, and exceptions of the form "@@||example.com^".
+//     Rules with options other than $important are ignored, as they
+//     don't apply to DNS.
+//
+// Comments start with "#" or, in adblock lists, "!".
+func parseBlocklist(r io.Reader) (*blocklist, error) {
+	b := &blocklist{
+		names:   set.Set[dnsname.FQDN]{},
+		domains: set.Set[dnsname.FQDN]{},
+		allowed: set.Set[dnsname.FQDN]{},
+	}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i == 0 || (i > 0 && (line[i-1] == ' ' || line[i-1] == '\t')) {
+			line = line[:i]
+		} else if i > 0 {
+			// An adblock element hiding rule, such as "example.com##.ad".
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+		if rule, ok := strings.CutPrefix(line, "@@||"); ok {
+			if name, ok := parseAdblockRule(rule); ok {
+				b.allowed.Add(name)
+			}
+			continue
+		}
+		if rule, ok := strings.CutPrefix(line, "||"); ok {
+			if name, ok := parseAdblockRule(rule); ok {
+				b.domains.Add(name)
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if _, err := netip.ParseAddr(fields[0]); err == nil {
+			fields = fields[1:]
+		} else if len(fields) > 1 {
+			continue
+		}
+		for _, f := range fields {
+			if name, ok := blocklistName(f); ok && !hostsFileSkipNames.Contains(name) {
+				b.names.Add(name)
+			}
+		}
+	}
+	return b, s.Err()
+}
+
+// parseAdblockRule parses the part of an adblock rule after "||", returning
+// the domain it applies to.
+func parseAdblockRule(rule string) (_ dnsname.FQDN, ok bool) {
+	rule, opts, _ := strings.Cut(rule, "$")
+	if opts != "" && opts != "important" {
+		return "", false
+	}
+	rule, ok = strings.CutSuffix(rule, "^")
+	if !ok {
+		return "", false
+	}
+	return blocklistName(rule)
+}
+
+// blocklistName parses s as a domain name in a blocklist. It rejects the
+// root, IP addresses and anything that isn't a plain hostname, such as
+// adblock rules for URLs.
+func blocklistName(s string) (_ dnsname.FQDN, ok bool) {
+	s = strings.ToLower(strings.TrimSuffix(s, "."))
+	if s == "" {
+		return "", false
+	}
+	for _, c := range []byte(s) {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "", false
+		}
+	}
+	if _, err := netip.ParseAddr(s); err == nil {
+		return "", false
+	}
+	name, err := dnsname.ToFQDN(s)
+	return name, err == nil
+}
+
+// blocklistCheckInterval is how often the blocklist files are checked for
+// changes, as queries come in.
+const blocklistCheckInterval = 30 * time.Second
+
+// blocklistFile is a blocklist loaded from a file.
+type blocklistFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+	list    *blocklist // empty if the file couldn't be parsed
+}
+
+// loadBlocklists loads the blocklists at paths, reusing those in prev that
+// haven't changed since they were loaded. Files that can't be loaded are
+// logged and skipped. If reload is set, files that were already missing
+// from prev aren't logged again.
+func (r *Resolver) loadBlocklists(paths []string, prev []*blocklistFile, reload bool) []*blocklistFile {
+	var ret []*blocklistFile
+	for _, path := range paths {
+		i := slices.IndexFunc(prev, func(f *blocklistFile) bool { return f.path == path })
+		fi, err := os.Stat(path)
+		if err != nil {
+			if !reload || i >= 0 {
+				r.logf("loading DNS blocklist: %v", err)
+			}
+			continue
+		}
+		if i >= 0 && prev[i].modTime.Equal(fi.ModTime()) && prev[i].size == fi.Size() {
+			ret = append(ret, prev[i])
+			continue
+		}
+		list, err := loadBlocklistFile(path)
+		if err != nil {
+			// Remember the file as is, so that it's only retried once
+			// it changes.
+			r.logf("loading DNS blocklist: %v", err)
+			list = &blocklist{}
+		} else {
+			r.logf("loaded DNS blocklist %s: %d names, %d domains", path, len(list.names), len(list.domains))
+		}
+		ret = append(ret, &blocklistFile{
+			path:    path,
+			modTime: fi.ModTime(),
+			size:    fi.Size(),
+			list:    list,
+		})
+	}
+	return ret
+}
+
+// reloadBlocklists reloads the blocklist files that changed since they
+// were last loaded.
+func (r *Resolver) reloadBlocklists() {
+	r.mu.Lock()
+	paths, prev := r.blocklistPaths, r.blocklists
+	r.mu.Unlock()
+
+	lists := r.loadBlocklists(paths, prev, true)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blocklistsReloading = false
+	r.blocklistsChecked = time.Now()
+	if slices.Equal(paths, r.blocklistPaths) {
+		r.blocklists = lists
+	}
+}
+
+func loadBlocklistFile(path string) (*blocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := parseBlocklist(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return b, nil
+}
+
+// isBlocked reports whether a blocklist blocks queries for name.
+//
+// It also starts reloading the blocklist files in the background if they
+// haven't been checked for changes in blocklistCheckInterval.
+func (r *Resolver) isBlocked(name dnsname.FQDN) bool {
+	r.mu.Lock()
+	lists := r.blocklists
+	if len(r.blocklistPaths) > 0 && !r.blocklistsReloading && time.Since(r.blocklistsChecked) >= blocklistCheckInterval {
+		r.blocklistsReloading = true
+		go r.reloadBlocklists()
+	}
+	r.mu.Unlock()
+	for _, f := range lists {
+		if f.list.blocks(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// sinkholeResponse returns the response to a blocked query parsed by p:
+// the unspecified address for address queries, and no records otherwise.
+func sinkholeResponse(p *dnsParser) ([]byte, error) {
+	resp := p.response()
+	switch p.Question.Type {
+	case dns.TypeA:
+		resp.IP = netip.IPv4Unspecified()
+	case dns.TypeAAAA:
+		resp.IP = netip.IPv6Unspecified()
+	case dns.TypeALL:
+		resp.IPs = []netip.Addr{netip.IPv4Unspecified(), netip.IPv6Unspecified()}
+	}
+	return marshalResponse(resp)
+}
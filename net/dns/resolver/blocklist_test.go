@@ -0,0 +1,135 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/util/dnsname"
+)
+
+const testBlocklist = `
+# A hosts file.
+127.0.0.1 localhost
+::1 localhost ip6-localhost
+0.0.0.0 0.0.0.0
+0.0.0.0 ads.example.com tracker.example.com # trailing comment
+plain.example.net
+
+! An adblock list.
+[Adblock Plus 2.0]
+||Doubleclick.example^
+||metrics.example^$important
+@@||ok.doubleclick.example^
+||thirdparty.example^$third-party
+||example.org/banner/*
+example.com##.ad-banner
+||^
+`
+
+func TestParseBlocklist(t *testing.T) {
+	b, err := parseBlocklist(strings.NewReader(testBlocklist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name dnsname.FQDN
+		want bool
+	}{
+		{"ads.example.com.", true},
+		{"tracker.example.com.", true},
+		{"sub.ads.example.com.", false},
+		{"example.com.", false},
+		{"plain.example.net.", true},
+		{"localhost.", false},
+		{"doubleclick.example.", true},
+		{"a.b.doubleclick.example.", true},
+		{"ok.doubleclick.example.", false},
+		{"x.ok.doubleclick.example.", false},
+		{"metrics.example.", true},
+		{"thirdparty.example.", false},
+		{"example.org.", false},
+		{"unrelated.example.", false},
+	}
+	for _, tt := range tests {
+		if got := b.blocks(tt.name); got != tt.want {
+			t.Errorf("blocks(%q) = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBlocklistResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte("0.0.0.0 ads.example.com\n||test1.ipn.dev^\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newResolver(t)
+	defer r.Close()
+	cfg := dnsCfg
+	cfg.Blocklists = []string{path, filepath.Join(t.TempDir(), "missing.txt")}
+	r.SetConfig(cfg)
+
+	tests := []struct {
+		name   dnsname.FQDN
+		typ    dns.Type
+		wantIP netip.Addr // if valid, the query must be blocked
+	}{
+		{"ads.example.com.", dns.TypeA, netip.IPv4Unspecified()},
+		{"ads.example.com.", dns.TypeAAAA, netip.IPv6Unspecified()},
+		{"ADS.example.com.", dns.TypeA, netip.IPv4Unspecified()},
+		{"other.example.com.", dns.TypeA, netip.Addr{}},
+		// MagicDNS names are never blocked.
+		{"test1.ipn.dev.", dns.TypeA, testipv4},
+	}
+	for _, tt := range tests {
+		res, err := r.respond(dnspacket(tt.name, tt.typ, noEdns))
+		if !tt.wantIP.IsValid() {
+			if err != errNotOurName {
+				t.Errorf("%v: err = %v; want errNotOurName", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		resp, err := unpackResponse(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.ip != tt.wantIP {
+			t.Errorf("%v: IP = %v; want %v", tt.name, resp.ip, tt.wantIP)
+		}
+	}
+
+	// Changes to the file are picked up by the next SetConfig.
+	if err := os.WriteFile(path, []byte("0.0.0.0 other.example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r.SetConfig(cfg)
+	if _, err := r.respond(dnspacket("ads.example.com.", dns.TypeA, noEdns)); err != errNotOurName {
+		t.Errorf("ads.example.com still blocked after reload: err = %v", err)
+	}
+	if _, err := r.respond(dnspacket("other.example.com.", dns.TypeA, noEdns)); err != nil {
+		t.Errorf("other.example.com not blocked after reload: err = %v", err)
+	}
+
+	// And by the periodic check for changes, without a SetConfig.
+	if err := os.WriteFile(path, []byte("0.0.0.0 ads.example.com\n0.0.0.0 more.example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r.reloadBlocklists()
+	if _, err := r.respond(dnspacket("ads.example.com.", dns.TypeA, noEdns)); err != nil {
+		t.Errorf("ads.example.com not blocked after periodic reload: err = %v", err)
+	}
+	if _, err := r.respond(dnspacket("other.example.com.", dns.TypeA, noEdns)); err != errNotOurName {
+		t.Errorf("other.example.com still blocked after periodic reload: err = %v", err)
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/envknob"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/lru"
+)
+
+const (
+	// maxCacheEntries is the maximum number of upstream responses to
+	// keep in the forwarder's response cache.
+	maxCacheEntries = 1024
+
+	// maxCacheTTL caps how long a positive response is cached,
+	// regardless of the TTLs in it.
+	maxCacheTTL = time.Hour
+
+	// maxNegativeCacheTTL caps how long a negative (NXDOMAIN or NODATA)
+	// response is cached. RFC 2308 section 5 recommends 1-3 hours; we
+	// use less, as names that don't exist yet often come into existence
+	// on tailnets shortly after being queried.
+	maxNegativeCacheTTL = 5 * time.Minute
+)
+
+var disableDNSCache = envknob.RegisterBool("TS_DEBUG_DNS_DISABLE_CACHE")
+
+// cacheKey is the key of a cached upstream DNS response.
+type cacheKey struct {
+	name  dnsname.FQDN // lowercase
+	typ   dns.Type
+	class dns.Class
+
+	// route is the suffix of the route the query was forwarded on, so
+	// that the same name isn't answered from the cache of a different
+	// set of upstreams (e.g. split DNS vs the default route).
+	route dnsname.FQDN
+
+	// do and cd are the query's DNSSEC OK and Checking Disabled bits,
+	// which change what upstreams include in the response (RFC 4035
+	// section 3.2), so responses aren't shared across them.
+	do, cd bool
+
+	// validated is whether the response was validated by us, in which case
+	// the cached response is the validated one.
+	validated bool
+}
+
+// cacheKeyForQuery returns the cache key for the DNS query q forwarded on
+// the route with the given suffix.
+func cacheKeyForQuery(q []byte, route dnsname.FQDN) (k cacheKey, ok bool) {
+	var msg dns.Message
+	if err := msg.Unpack(q); err != nil || len(msg.Questions) != 1 {
+		return k, false
+	}
+	question := msg.Questions[0]
+	name, err := dnsname.ToFQDN(rawNameToLower(question.Name.Data[:question.Name.Length]))
+	if err != nil {
+		return k, false
+	}
+	k = cacheKey{
+		name:  name,
+		typ:   question.Type,
+		class: question.Class,
+		route: route,
+		cd:    msg.CheckingDisabled,
+	}
+	for _, rr := range msg.Additionals {
+		if rr.Header.Type == dns.TypeOPT {
+			k.do = rr.Header.DNSSECAllowed()
+		}
+	}
+	return k, true
+}
+
+// requesterUDPSize returns the size of the largest UDP response that the
+// requester of query can receive: the UDP payload size it advertised with
+// EDNS, or 512 bytes without EDNS (RFC 6891 section 6.2.3).
+func requesterUDPSize(query []byte) int {
+	var msg dns.Message
+	if err := msg.Unpack(query); err != nil {
+		return 512
+	}
+	for _, rr := range msg.Additionals {
+		if rr.Header.Type == dns.TypeOPT {
+			// Values lower than 512 are treated as 512.
+			return max(int(rr.Header.Class), 512)
+		}
+	}
+	return 512
+}
+
+// truncateForRequester returns res, or if it's too large for the requester
+// of query to receive over UDP, a truncated copy of it with the TC bit set
+// and only its question and OPT record, so that the requester retries over
+// TCP.
+func truncateForRequester(res, query []byte) ([]byte, error) {
+	if len(res) <= requesterUDPSize(query) {
+		return res, nil
+	}
+	var msg dns.Message
+	if err := msg.Unpack(res); err != nil {
+		return nil, err
+	}
+	msg.Truncated = true
+	msg.Answers, msg.Authorities = nil, nil
+	var opt []dns.Resource
+	for _, rr := range msg.Additionals {
+		if rr.Header.Type == dns.TypeOPT {
+			opt = append(opt, rr)
+		}
+	}
+	msg.Additionals = opt
+	return msg.Pack()
+}
+
+// cacheEntry is a cached upstream DNS response.
+type cacheEntry struct {
+	res     []byte    // wire format response, as received
+	rcode   dns.RCode // of res
+	stored  time.Time // when res was received
+	expires time.Time // when to stop using res
+}
+
+// responseCache is a bounded, TTL-respecting cache of upstream DNS
+// responses, including negative responses as described in RFC 2308.
+//
+// The zero value is ready for use.
+type responseCache struct {
+	// now, if non-nil, is used instead of time.Now in tests.
+	now func() time.Time
+
+	mu  sync.Mutex
+	lru lru.Cache[cacheKey, *cacheEntry] // MaxEntries set on first use
+}
+
+func (c *responseCache) timeNow() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// get returns a response to query from the cache, or nil if there is no
+// live entry for k.
+//
+// The returned response has query's DNS ID and question, and TTLs reduced
+// by the time the response has spent in the cache.
+func (c *responseCache) get(k cacheKey, query []byte) []byte {
+	now := c.timeNow()
+	c.mu.Lock()
+	e, ok := c.lru.GetOk(k)
+	if ok && !now.Before(e.expires) {
+		c.lru.Delete(k)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	var q dns.Message
+	if err := q.Unpack(query); err != nil || len(q.Questions) != 1 {
+		return nil
+	}
+	var msg dns.Message
+	if err := msg.Unpack(e.res); err != nil {
+		return nil
+	}
+	msg.ID = q.ID
+	msg.RecursionDesired = q.RecursionDesired
+	msg.Questions = q.Questions // preserve the query's case
+	age := uint32(now.Sub(e.stored) / time.Second)
+	for _, rrs := range [][]dns.Resource{msg.Answers, msg.Authorities, msg.Additionals} {
+		for i := range rrs {
+			h := &rrs[i].Header
+			if h.Type == dns.TypeOPT {
+				// The TTL field of an OPT record holds flags.
+				continue
+			}
+			h.TTL = max(h.TTL, age) - age
+		}
+	}
+	res, err := msg.Pack()
+	if err != nil {
+		return nil
+	}
+	return res
+}
+
+// put adds the upstream response res for k to the cache, if it's
+// cacheable.
+func (c *responseCache) put(k cacheKey, res []byte) {
+	ttl, rcode, ok := cacheTTL(k, res)
+	if !ok {
+		return
+	}
+	now := c.timeNow()
+	e := &cacheEntry{
+		res:     bytes.Clone(res),
+		rcode:   rcode,
+		stored:  now,
+		expires: now.Add(ttl),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.MaxEntries = maxCacheEntries
+	c.lru.Set(k, e)
+}
+
+// flush removes all entries from the cache.
+func (c *responseCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Clear()
+}
+
+// cacheTTL reports how long the upstream response res to the query k may
+// be cached for, and its response code.
+//
+// Only complete successful and NXDOMAIN responses to the question in k
+// are cacheable. Negative responses are only cacheable if they include
+// an SOA record, per RFC 2308.
+func cacheTTL(k cacheKey, res []byte) (ttl time.Duration, rcode dns.RCode, ok bool) {
+	var msg dns.Message
+	if err := msg.Unpack(res); err != nil {
+		return 0, 0, false
+	}
+	if !msg.Response || msg.Truncated || len(msg.Questions) != 1 {
+		return 0, 0, false
+	}
+	q := msg.Questions[0]
+	if q.Type != k.typ || q.Class != k.class {
+		return 0, 0, false
+	}
+	if name, err := dnsname.ToFQDN(rawNameToLower(q.Name.Data[:q.Name.Length])); err != nil || name != k.name {
+		return 0, 0, false
+	}
+
+	var minTTL uint32
+	switch {
+	case msg.RCode == dns.RCodeSuccess && len(msg.Answers) > 0:
+		minTTL = msg.Answers[0].Header.TTL
+		for _, rr := range msg.Answers[1:] {
+			minTTL = min(minTTL, rr.Header.TTL)
+		}
+		ttl = min(time.Duration(minTTL)*time.Second, maxCacheTTL)
+	case msg.RCode == dns.RCodeSuccess || msg.RCode == dns.RCodeNameError:
+		// Negative response (NODATA or NXDOMAIN). RFC 2308 section 5: the
+		// TTL is the minimum of the SOA record's TTL and its MINIMUM field.
+		var soa *dns.SOAResource
+		for _, rr := range msg.Authorities {
+			if s, ok := rr.Body.(*dns.SOAResource); ok {
+				soa = s
+				minTTL = min(rr.Header.TTL, s.MinTTL)
+				break
+			}
+		}
+		if soa == nil {
+			return 0, 0, false
+		}
+		ttl = min(time.Duration(minTTL)*time.Second, maxNegativeCacheTTL)
+	default:
+		return 0, 0, false
+	}
+	if ttl <= 0 {
+		return 0, 0, false
+	}
+	return ttl, msg.RCode, true
+}
+
+// CacheStatus is a snapshot of the Resolver's cache of upstream responses.
+type CacheStatus struct {
+	// Entries are the live cache entries, from most to least recently
+	// used.
+	Entries []CacheEntry
+
+	// Hits and Misses are the number of forwarded queries that were and
+	// weren't answered from the cache since the process started.
+	Hits, Misses int64
+}
+
+// CacheEntry describes a DNS response in the Resolver's cache.
+type CacheEntry struct {
+	Name  dnsname.FQDN
+	Type  dns.Type
+	Class dns.Class
+	Route dnsname.FQDN // suffix of the route the response came from
+
+	// DNSSECOK and CheckingDisabled are the DO and CD bits of the query
+	// the response was for, and Validated is whether we validated it.
+	DNSSECOK, CheckingDisabled, Validated bool
+
+	RCode   dns.RCode
+	Size    int // of the response in bytes
+	Expires time.Time
+}
+
+// entries returns the live entries in the cache, from most to least
+// recently used.
+func (c *responseCache) entries() []CacheEntry {
+	now := c.timeNow()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make([]CacheEntry, 0, c.lru.Len())
+	c.lru.ForEach(func(k cacheKey, e *cacheEntry) {
+		if !now.Before(e.expires) {
+			return
+		}
+		ret = append(ret, CacheEntry{
+			Name:  k.name,
+			Type:  k.typ,
+			Class: k.class,
+			Route: k.route,
+
+			DNSSECOK:         k.do,
+			CheckingDisabled: k.cd,
+			Validated:        k.validated,
+
+			RCode:   e.rcode,
+			Size:    len(e.res),
+			Expires: e.expires,
+		})
+	})
+	return ret
+}
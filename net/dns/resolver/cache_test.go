@@ -0,0 +1,351 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/health"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/tstest"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/eventbus/eventbustest"
+)
+
+// makeNegativeTestResponse returns a response to an A query for domain with
+// the given rcode and no answers. If soaTTL is non-zero, the response
+// includes an SOA record with that TTL and a MINIMUM of soaMin.
+func makeNegativeTestResponse(tb testing.TB, domain string, code dns.RCode, soaTTL, soaMin uint32) []byte {
+	tb.Helper()
+	name := dns.MustNewName(domain)
+	b := dns.NewBuilder(nil, dns.Header{Response: true, RCode: code})
+	b.StartQuestions()
+	b.Question(dns.Question{Name: name, Type: dns.TypeA, Class: dns.ClassINET})
+	if soaTTL != 0 {
+		b.StartAuthorities()
+		b.SOAResource(dns.ResourceHeader{
+			Name:  dns.MustNewName("example.com."),
+			Class: dns.ClassINET,
+			TTL:   soaTTL,
+		}, dns.SOAResource{
+			NS:     dns.MustNewName("ns.example.com."),
+			MBox:   dns.MustNewName("hostmaster.example.com."),
+			MinTTL: soaMin,
+		})
+	}
+	res, err := b.Finish()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return res
+}
+
+func TestCacheTTL(t *testing.T) {
+	const domain = "foo.example.com."
+	key := cacheKey{name: domain, typ: dns.TypeA, class: dns.ClassINET}
+	truncated := makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("1.2.3.4"))
+	truncated[2] |= dnsFlagTruncated >> 8
+
+	tests := []struct {
+		name      string
+		key       cacheKey
+		res       []byte
+		wantTTL   time.Duration
+		wantRCode dns.RCode
+		wantOK    bool
+	}{
+		{
+			name:    "positive",
+			key:     key,
+			res:     makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("1.2.3.4")),
+			wantTTL: 120 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name:      "nxdomain-with-soa",
+			key:       key,
+			res:       makeNegativeTestResponse(t, domain, dns.RCodeNameError, 3600, 60),
+			wantTTL:   60 * time.Second,
+			wantRCode: dns.RCodeNameError,
+			wantOK:    true,
+		},
+		{
+			name:    "nodata-with-soa",
+			key:     key,
+			res:     makeNegativeTestResponse(t, domain, dns.RCodeSuccess, 30, 900),
+			wantTTL: 30 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name:      "negative-ttl-capped",
+			key:       key,
+			res:       makeNegativeTestResponse(t, domain, dns.RCodeNameError, 86400, 86400),
+			wantTTL:   maxNegativeCacheTTL,
+			wantRCode: dns.RCodeNameError,
+			wantOK:    true,
+		},
+		{
+			name: "nxdomain-without-soa",
+			key:  key,
+			res:  makeNegativeTestResponse(t, domain, dns.RCodeNameError, 0, 0),
+		},
+		{
+			name: "servfail",
+			key:  key,
+			res:  makeNegativeTestResponse(t, domain, dns.RCodeServerFailure, 3600, 60),
+		},
+		{
+			name: "truncated",
+			key:  key,
+			res:  truncated,
+		},
+		{
+			name: "other-name",
+			key:  cacheKey{name: "bar.example.com.", typ: dns.TypeA, class: dns.ClassINET},
+			res:  makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("1.2.3.4")),
+		},
+		{
+			name: "other-type",
+			key:  cacheKey{name: domain, typ: dns.TypeAAAA, class: dns.ClassINET},
+			res:  makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("1.2.3.4")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ttl, rcode, ok := cacheTTL(tt.key, tt.res)
+			if ok != tt.wantOK || ttl != tt.wantTTL || rcode != tt.wantRCode {
+				t.Errorf("cacheTTL = %v, %v, %v; want %v, %v, %v", ttl, rcode, ok, tt.wantTTL, tt.wantRCode, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	c := &responseCache{now: clock.Now}
+
+	const domain = "foo.example.com."
+	key := cacheKey{name: domain, typ: dns.TypeA, class: dns.ClassINET, route: "example.com."}
+	c.put(key, makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("1.2.3.4")))
+
+	// Use a query with a different ID and case to check that both are
+	// taken from the query rather than the cached response.
+	b := dns.NewBuilder(nil, dns.Header{ID: 1234, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dns.Question{Name: dns.MustNewName("Foo.Example.COM."), Type: dns.TypeA, Class: dns.ClassINET})
+	query, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := c.get(cacheKey{name: domain, typ: dns.TypeA, class: dns.ClassINET, route: "."}, query); res != nil {
+		t.Fatal("got cached response for a different route")
+	}
+
+	clock.Advance(50 * time.Second)
+	res := c.get(key, query)
+	if res == nil {
+		t.Fatal("no cached response")
+	}
+	var msg dns.Message
+	if err := msg.Unpack(res); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 1234 || !msg.RecursionDesired {
+		t.Errorf("header = %+v; want ID 1234 with RD set", msg.Header)
+	}
+	if got := msg.Questions[0].Name.String(); got != "Foo.Example.COM." {
+		t.Errorf("question name = %q; want query's", got)
+	}
+	if len(msg.Answers) != 1 || msg.Answers[0].Header.TTL != 70 {
+		t.Errorf("answers = %+v; want 1 with TTL 70", msg.Answers)
+	}
+
+	if got := c.entries(); len(got) != 1 || got[0].Name != domain || got[0].Route != "example.com." {
+		t.Errorf("entries = %+v", got)
+	}
+
+	clock.Advance(70 * time.Second)
+	if res := c.get(key, query); res != nil {
+		t.Error("got expired response")
+	}
+	if got := c.entries(); len(got) != 0 {
+		t.Errorf("entries after expiry = %+v", got)
+	}
+}
+
+func TestResponseCacheBounded(t *testing.T) {
+	var c responseCache
+	for i := range maxCacheEntries + 10 {
+		domain := fmt.Sprintf("host%d.example.com.", i)
+		key := cacheKey{name: dnsname.FQDN(domain), typ: dns.TypeA, class: dns.ClassINET}
+		c.put(key, makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("1.2.3.4")))
+	}
+	if got := len(c.entries()); got != maxCacheEntries {
+		t.Errorf("cache has %d entries; want %d", got, maxCacheEntries)
+	}
+}
+
+// newCacheTestForwarder returns a forwarder whose only upstream always
+// answers with response, and a count of the queries the upstream saw.
+func newCacheTestForwarder(t *testing.T, response []byte) (*forwarder, *atomic.Int32) {
+	t.Helper()
+	upstreamQueries := new(atomic.Int32)
+	port := runDNSServer(t, nil, response, func(isTCP bool, _ []byte) {
+		upstreamQueries.Add(1)
+	})
+
+	logf := tstest.WhileTestRunningLogger(t)
+	bus := eventbustest.NewBus(t)
+	netMon, err := netmon.New(bus, logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dialer tsdial.Dialer
+	dialer.SetNetMon(netMon)
+	dialer.SetBus(bus)
+	fwd := newForwarder(logf, netMon, nil, &dialer, health.NewTracker(bus), nil)
+	t.Cleanup(func() { fwd.Close() })
+	fwd.setRoutes(map[dnsname.FQDN][]*dnstype.Resolver{
+		".": {{Addr: fmt.Sprintf("127.0.0.1:%d", port)}},
+	})
+	return fwd, upstreamQueries
+}
+
+// forwardTestQuery forwards the UDP query q with fwd, returning the
+// response.
+func forwardTestQuery(t *testing.T, fwd *forwarder, q []byte) []byte {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rchan := make(chan packet, 1)
+	err := fwd.forwardWithDestChan(ctx, packet{
+		bs:     q,
+		family: "udp",
+		addr:   netip.MustParseAddrPort("127.0.0.1:12345"),
+	}, rchan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := (<-rchan).bs
+	if got := getRCode(res); got != dns.RCodeSuccess {
+		t.Fatalf("rcode = %v", got)
+	}
+	return res
+}
+
+// makeEDNSTestRequest returns a new TypeA request for domain with an OPT
+// record advertising udpSize, and the given DO and CD bits.
+func makeEDNSTestRequest(tb testing.TB, domain string, udpSize uint16, do, cd bool) []byte {
+	tb.Helper()
+	b := dns.NewBuilder(nil, dns.Header{CheckingDisabled: cd})
+	b.StartQuestions()
+	b.Question(dns.Question{Name: dns.MustNewName(domain), Type: dns.TypeA, Class: dns.ClassINET})
+	b.StartAdditionals()
+	var opt dns.ResourceHeader
+	if err := opt.SetEDNS0(int(udpSize), dns.RCodeSuccess, do); err != nil {
+		tb.Fatal(err)
+	}
+	b.OPTResource(opt, dns.OPTResource{})
+	q, err := b.Finish()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return q
+}
+
+func TestForwarderCache(t *testing.T) {
+	const domain = "cached.example.com."
+	fwd, upstreamQueries := newCacheTestForwarder(t, makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("1.2.3.4")))
+
+	query := func() { forwardTestQuery(t, fwd, makeTestRequest(t, domain)) }
+	query()
+	query()
+	if got := upstreamQueries.Load(); got != 1 {
+		t.Errorf("upstream saw %d queries; want 1", got)
+	}
+
+	fwd.cache.flush()
+	query()
+	if got := upstreamQueries.Load(); got != 2 {
+		t.Errorf("upstream saw %d queries after flush; want 2", got)
+	}
+}
+
+func TestCacheKeyDNSSECBits(t *testing.T) {
+	const domain = "cached.example.com."
+	fwd, upstreamQueries := newCacheTestForwarder(t, makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("1.2.3.4")))
+
+	// Queries with different DO or CD bits aren't answered with each
+	// other's responses, which differ in their DNSSEC records.
+	queries := [][]byte{
+		makeTestRequest(t, domain),
+		makeEDNSTestRequest(t, domain, 1232, true, false),
+		makeEDNSTestRequest(t, domain, 1232, false, true),
+		makeEDNSTestRequest(t, domain, 1232, true, true),
+	}
+	for i, q := range queries {
+		forwardTestQuery(t, fwd, q)
+		if got, want := upstreamQueries.Load(), int32(i+1); got != want {
+			t.Errorf("after query %d, upstream saw %d queries; want %d", i, got, want)
+		}
+	}
+	for _, q := range queries {
+		forwardTestQuery(t, fwd, q)
+	}
+	if got, want := upstreamQueries.Load(), int32(len(queries)); got != want {
+		t.Errorf("upstream saw %d queries; want %d", got, want)
+	}
+	if got := len(fwd.cache.entries()); got != len(queries) {
+		t.Errorf("cache has %d entries; want %d", got, len(queries))
+	}
+}
+
+func TestCacheHitTruncated(t *testing.T) {
+	const domain = "big.example.com."
+	var addrs []netip.Addr
+	for i := range 40 {
+		addrs = append(addrs, netip.AddrFrom4([4]byte{192, 0, 2, byte(i)}))
+	}
+	response := makeTestResponse(t, domain, dns.RCodeSuccess, addrs...)
+	if len(response) <= 512 {
+		t.Fatalf("response is only %d bytes", len(response))
+	}
+	fwd, upstreamQueries := newCacheTestForwarder(t, response)
+
+	// Cache the response for a requester that can receive all of it.
+	if res := forwardTestQuery(t, fwd, makeEDNSTestRequest(t, domain, 1232, false, false)); truncatedFlagSet(res) {
+		t.Fatal("response to large EDNS query truncated")
+	}
+
+	// A requester without EDNS gets a truncated response from the cache,
+	// so it retries over TCP.
+	res := forwardTestQuery(t, fwd, makeTestRequest(t, domain))
+	if got := upstreamQueries.Load(); got != 1 {
+		t.Fatalf("upstream saw %d queries; want 1", got)
+	}
+	var msg dns.Message
+	if err := msg.Unpack(res); err != nil {
+		t.Fatal(err)
+	}
+	if !msg.Truncated || len(msg.Answers) != 0 || len(res) > 512 {
+		t.Errorf("cached response for 512 byte requester: TC=%v, %d answers, %d bytes", msg.Truncated, len(msg.Answers), len(res))
+	}
+
+	// As does one advertising a small EDNS payload size.
+	if res := forwardTestQuery(t, fwd, makeEDNSTestRequest(t, domain, 512, false, false)); !truncatedFlagSet(res) {
+		t.Error("cached response for small EDNS requester not truncated")
+	}
+	if res := forwardTestQuery(t, fwd, makeEDNSTestRequest(t, domain, 1232, false, false)); truncatedFlagSet(res) {
+		t.Error("cached response for large EDNS requester truncated")
+	}
+}
@@ -0,0 +1,1194 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/lru"
+)
+
+const (
+	// maxDNSSECZones is the maximum number of zone cuts to remember the
+	// validation state of.
+	maxDNSSECZones = 512
+
+	// maxNSEC3Iterations is the number of NSEC3 hash iterations above
+	// which we treat a zone as insecure rather than spend the CPU to
+	// validate it, as recommended by RFC 9276 section 3.2.
+	maxNSEC3Iterations = 150
+
+	// dnssecUDPSize is the EDNS UDP payload size advertised in queries
+	// sent with the DO bit set, as recommended by DNS Flag Day 2020.
+	dnssecUDPSize = 1232
+)
+
+// rootTrustAnchors are the DS records of the root zone's key-signing keys,
+// as published at https://data.iana.org/root-anchors/root-anchors.xml:
+// KSK-2017 and KSK-2024.
+var rootTrustAnchors = []*ds{
+	{keyTag: 20326, alg: algRSASHA256, digestType: digestSHA256, digest: mustHex("e06d44b80b8f1d39a95c0b0d7c65d08458e880409bbc683457104237c7f8ec8d")},
+	{keyTag: 38696, alg: algRSASHA256, digestType: digestSHA256, digest: mustHex("683d2d0acb8c9b712a1948b27f741219298d0a450d612c483af444a4c0fb2b16")},
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// errBogus is wrapped by the errors of responses that fail DNSSEC
+// validation.
+var errBogus = errors.New("DNSSEC validation failed")
+
+func bogusf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errBogus, fmt.Sprintf(format, args...))
+}
+
+// cutKind is what the DNSSEC chain of trust says about a name.
+type cutKind int
+
+const (
+	notCut      cutKind = iota // the name is within its parent's zone
+	secureCut                  // the name is the apex of a signed zone
+	insecureCut                // the name is the apex of an unsigned zone, or one we can't validate
+)
+
+// zoneKey is the key of a zone cut in a dnssecValidator's cache.
+type zoneKey struct {
+	route dnsname.FQDN // suffix of the route the zone was looked up on
+	name  string       // wireName of the zone cut
+}
+
+// zoneEntry is a cached zone cut.
+type zoneEntry struct {
+	kind    cutKind
+	keys    []*dnskey // validated zone keys, if kind is secureCut
+	expires time.Time
+}
+
+// exchangeFunc sends the DNS query q upstream and returns the response.
+type exchangeFunc func(ctx context.Context, q []byte) ([]byte, error)
+
+// dnssecValidator validates the DNSSEC signatures of DNS responses (RFC
+// 4035 section 5), building the chain of trust from the root trust anchors
+// by querying the same upstream resolvers for DS and DNSKEY records. The
+// zone cuts it learns about are cached.
+//
+// Responses are classified as secure, insecure (provably not signed) or
+// bogus. Negative responses and wildcard expansions in signed zones are
+// only secure with NSEC or NSEC3 records proving them (RFC 4035 section
+// 5.4, RFC 5155 section 8), and bogus without.
+//
+// The zero value is ready for use.
+type dnssecValidator struct {
+	// now, if non-nil, is used instead of time.Now in tests.
+	now func() time.Time
+	// anchors, if non-nil, are used instead of rootTrustAnchors in tests.
+	anchors []*ds
+
+	mu    sync.Mutex
+	zones lru.Cache[zoneKey, *zoneEntry] // MaxEntries set on first use
+}
+
+func (v *dnssecValidator) timeNow() time.Time {
+	if v.now != nil {
+		return v.now()
+	}
+	return time.Now()
+}
+
+// flush forgets all cached zone cuts.
+func (v *dnssecValidator) flush() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.zones.Clear()
+}
+
+func (v *dnssecValidator) getZone(k zoneKey) (*zoneEntry, bool) {
+	now := v.timeNow()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e, ok := v.zones.GetOk(k)
+	if ok && !now.Before(e.expires) {
+		v.zones.Delete(k)
+		return nil, false
+	}
+	return e, ok
+}
+
+func (v *dnssecValidator) putZone(k zoneKey, e *zoneEntry, ttl uint32) {
+	d := min(time.Duration(ttl)*time.Second, maxCacheTTL)
+	if d <= 0 {
+		return
+	}
+	e.expires = v.timeNow().Add(d)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.zones.MaxEntries = maxDNSSECZones
+	v.zones.Set(k, e)
+}
+
+// validate validates the upstream response res to a query forwarded on the
+// route with the given suffix, using exchange to look up the DS and DNSKEY
+// records needed. It reports whether the response is secure. If it is
+// bogus, the error wraps errBogus.
+func (v *dnssecValidator) validate(ctx context.Context, exchange exchangeFunc, route dnsname.FQDN, res []byte) (secure bool, err error) {
+	m, err := parseDNSSECMsg(res)
+	if err != nil {
+		return false, bogusf("parsing response: %v", err)
+	}
+	if m.rcode != dns.RCodeSuccess && m.rcode != dns.RCodeNameError {
+		// Nothing to validate.
+		return false, nil
+	}
+	c := &dnssecChain{v: v, exchange: exchange, route: route}
+
+	secure = true
+	answers, answerSigs := groupRRSets(m.answer)
+	for _, set := range answers {
+		ok, err := c.verify(ctx, set, answerSigs)
+		if err != nil {
+			return false, err
+		}
+		secure = secure && ok
+	}
+	authority, authoritySigs := groupRRSets(m.authority)
+	if secure {
+		// RFC 4035 section 5.3.4: an answer expanded from a wildcard
+		// needs proof that no closer match for the query exists.
+		for _, set := range answers {
+			signer, ce, ok := expansionOf(set, answerSigs)
+			if !ok {
+				continue
+			}
+			p, err := c.denialProof(ctx, authority, authoritySigs, signer)
+			if err != nil {
+				return false, err
+			}
+			ok, err = p.proveNoCloserMatch(set.name(), ce)
+			if err != nil {
+				return false, err
+			}
+			secure = secure && ok
+		}
+	}
+	if m.rcode == dns.RCodeSuccess && len(answers) > 0 && !m.hasDenialFor(answers) {
+		return secure, nil
+	}
+
+	// A negative response (or the negative end of a CNAME chain). Its
+	// SOA and NSEC or NSEC3 records must be signed if the zone is, and
+	// prove the denial.
+	name := m.chainEnd(answers)
+	if len(authoritySigs) == 0 {
+		if _, insecure, err := c.walk(ctx, name); err != nil {
+			return false, err
+		} else if !insecure {
+			return false, bogusf("unsigned negative response for %v in signed zone", name)
+		}
+		return false, nil
+	}
+	for _, set := range authority {
+		switch set.typ() {
+		case dns.TypeSOA, typeNSEC, typeNSEC3:
+		default:
+			continue
+		}
+		ok, err := c.verify(ctx, set, authoritySigs)
+		if err != nil {
+			return false, err
+		}
+		secure = secure && ok
+	}
+	if !secure {
+		return false, nil
+	}
+
+	// DS records, and their absence, are served by the parent zone.
+	zoneOf := name
+	if m.qtype == typeDS {
+		zoneOf = name.parent()
+	}
+	signer, ok := denialSigner(authority, authoritySigs, zoneOf)
+	if !ok {
+		return false, bogusf("negative response for %v without NSEC or NSEC3 records", name)
+	}
+	p, err := c.denialProof(ctx, authority, authoritySigs, signer)
+	if err != nil {
+		return false, err
+	}
+	if m.rcode == dns.RCodeNameError {
+		return p.proveNXDomain(name)
+	}
+	return p.proveNoData(name, m.qtype)
+}
+
+// hasDenialFor reports whether m, which has the answers in answers, is a
+// CNAME chain ending in a name with no records of the queried type.
+func (m *dnssecMsg) hasDenialFor(answers []dnssecRRSet) bool {
+	for _, set := range answers {
+		if set.typ() == m.qtype || m.qtype == dns.TypeALL {
+			return false
+		}
+	}
+	return true
+}
+
+// chainEnd returns the name at the end of the CNAME chain in answers that
+// starts at m's question name.
+func (m *dnssecMsg) chainEnd(answers []dnssecRRSet) wireName {
+	name := m.qname
+	for range answers {
+		next := name
+		for _, set := range answers {
+			if set.typ() != dns.TypeCNAME || !bytes.Equal(set.name(), name) {
+				continue
+			}
+			if target, _, err := readName(set[0].rdata, 0); err == nil {
+				next = target
+			}
+		}
+		if bytes.Equal(next, name) {
+			break
+		}
+		name = next
+	}
+	return name
+}
+
+// dnssecRRSet is an RRset: records with the same owner name, type and
+// class.
+type dnssecRRSet []dnssecRR
+
+func (s dnssecRRSet) name() wireName { return s[0].name }
+func (s dnssecRRSet) typ() dns.Type  { return s[0].typ }
+
+// minTTL returns the smallest TTL of the records in s.
+func (s dnssecRRSet) minTTL() uint32 {
+	ttl := s[0].ttl
+	for _, rr := range s[1:] {
+		ttl = min(ttl, rr.ttl)
+	}
+	return ttl
+}
+
+// groupRRSets groups rrs into RRsets, in order of first appearance, and
+// parses the RRSIG records among them.
+func groupRRSets(rrs []dnssecRR) (sets []dnssecRRSet, sigs []*rrsig) {
+	for _, rr := range rrs {
+		if rr.typ == typeRRSIG {
+			if sig, err := parseRRSIG(rr); err == nil {
+				sigs = append(sigs, sig)
+			}
+			continue
+		}
+		if rr.typ == dns.TypeOPT {
+			continue
+		}
+		found := false
+		for i, set := range sets {
+			if set.typ() == rr.typ && set[0].class == rr.class && bytes.Equal(set.name(), rr.name) {
+				sets[i] = append(set, rr)
+				found = true
+				break
+			}
+		}
+		if !found {
+			sets = append(sets, dnssecRRSet{rr})
+		}
+	}
+	return sets, sigs
+}
+
+// sigsFor returns the RRSIGs in sigs that cover set.
+func sigsFor(set dnssecRRSet, sigs []*rrsig) []*rrsig {
+	var ret []*rrsig
+	for _, sig := range sigs {
+		if sig.typeCovered == set.typ() && bytes.Equal(sig.owner, set.name()) {
+			ret = append(ret, sig)
+		}
+	}
+	return ret
+}
+
+// dnssecChain builds chains of trust for the validation of one response.
+type dnssecChain struct {
+	v        *dnssecValidator
+	exchange exchangeFunc
+	route    dnsname.FQDN
+}
+
+// verify verifies set against the RRSIGs in sigs that cover it. It reports
+// whether set is secure, and returns an error wrapping errBogus if set is
+// bogus.
+func (c *dnssecChain) verify(ctx context.Context, set dnssecRRSet, sigs []*rrsig) (secure bool, err error) {
+	covering := sigsFor(set, sigs)
+	if len(covering) == 0 {
+		_, insecure, err := c.walk(ctx, set.name())
+		if err != nil {
+			return false, err
+		}
+		if !insecure {
+			return false, bogusf("unsigned %v/%v in signed zone", set.name(), set.typ())
+		}
+		return false, nil
+	}
+	var firstErr error
+	for _, sig := range covering {
+		if !supportedAlg(sig.alg) {
+			continue
+		}
+		zone, insecure, err := c.walk(ctx, sig.signer)
+		if err != nil {
+			return false, err
+		}
+		if insecure {
+			return false, nil
+		}
+		if !bytes.Equal(zone.name, sig.signer) {
+			firstErr = cmp.Or(firstErr, bogusf("%v/%v signed by %v, which is not a zone", set.name(), set.typ(), sig.signer))
+			continue
+		}
+		if err := verifyRRSet(sig, set, zone.keys, c.v.timeNow()); err != nil {
+			firstErr = cmp.Or(firstErr, bogusf("%v", err))
+			continue
+		}
+		return true, nil
+	}
+	if firstErr != nil {
+		return false, firstErr
+	}
+	// Only signatures with algorithms we don't support. That makes set
+	// insecure only if its zone isn't signed with any algorithm we
+	// support (RFC 4035 section 5.2, RFC 6840 section 5.2), which walk
+	// reports; otherwise anyone could strip the real signatures and
+	// forge an answer with an unknown algorithm.
+	owner := set.name()
+	if set.typ() == typeDS {
+		// DS records are served by the parent zone.
+		owner = owner.parent()
+	}
+	if _, insecure, err := c.walk(ctx, owner); err != nil {
+		return false, err
+	} else if !insecure {
+		return false, bogusf("%v/%v in signed zone only signed with unsupported algorithms", set.name(), set.typ())
+	}
+	return false, nil
+}
+
+// secureZone is a zone with validated keys.
+type secureZone struct {
+	name wireName
+	keys []*dnskey
+}
+
+// walk follows the chain of trust from the root down to name. It returns
+// the closest enclosing zone of name if it is signed, or reports that name
+// is in an insecure zone.
+func (c *dnssecChain) walk(ctx context.Context, name wireName) (_ secureZone, insecure bool, err error) {
+	keys, err := c.rootKeys(ctx)
+	if err != nil {
+		return secureZone{}, false, err
+	}
+	zone := secureZone{rootWireName, keys}
+	for _, cand := range name.ancestors() {
+		e, err := c.cut(ctx, zone, cand)
+		if err != nil {
+			return secureZone{}, false, err
+		}
+		switch e.kind {
+		case secureCut:
+			zone = secureZone{cand, e.keys}
+		case insecureCut:
+			return secureZone{}, true, nil
+		}
+	}
+	return zone, false, nil
+}
+
+// query looks up the records of type typ at name, returning the response.
+func (c *dnssecChain) query(ctx context.Context, name wireName, typ dns.Type) (*dnssecMsg, error) {
+	q, err := dnssecQuery(name, typ)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.exchange(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("looking up %v/%v: %w", name, typ, err)
+	}
+	m, err := parseDNSSECMsg(res)
+	if err != nil {
+		return nil, bogusf("looking up %v/%v: %v", name, typ, err)
+	}
+	if m.rcode != dns.RCodeSuccess && m.rcode != dns.RCodeNameError {
+		return nil, fmt.Errorf("looking up %v/%v: %v", name, typ, m.rcode)
+	}
+	return m, nil
+}
+
+// dnssecQuery returns a recursive query for the records of type typ at
+// name, with the DO bit set.
+func dnssecQuery(name wireName, typ dns.Type) ([]byte, error) {
+	n, err := dns.NewName(name.String())
+	if err != nil {
+		return nil, err
+	}
+	b := dns.NewBuilder(nil, dns.Header{ID: uint16(rand.N(1 << 16)), RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dns.Question{Name: n, Type: typ, Class: dns.ClassINET})
+	b.StartAdditionals()
+	var opt dns.ResourceHeader
+	if err := opt.SetEDNS0(dnssecUDPSize, dns.RCodeSuccess, true); err != nil {
+		return nil, err
+	}
+	b.OPTResource(opt, dns.OPTResource{})
+	return b.Finish()
+}
+
+// rootKeys returns the validated DNSKEYs of the root zone.
+func (c *dnssecChain) rootKeys(ctx context.Context) ([]*dnskey, error) {
+	k := zoneKey{c.route, string(rootWireName)}
+	if e, ok := c.v.getZone(k); ok {
+		return e.keys, nil
+	}
+	anchors := c.v.anchors
+	if anchors == nil {
+		anchors = rootTrustAnchors
+	}
+	keys, ttl, err := c.zoneKeys(ctx, rootWireName, anchors)
+	if err != nil {
+		return nil, err
+	}
+	c.v.putZone(k, &zoneEntry{kind: secureCut, keys: keys}, ttl)
+	return keys, nil
+}
+
+// zoneKeys looks up the DNSKEYs of the zone with the given apex, and
+// validates them against the zone's DS records dss. It returns the keys
+// and the TTL to cache them for.
+func (c *dnssecChain) zoneKeys(ctx context.Context, apex wireName, dss []*ds) (_ []*dnskey, ttl uint32, err error) {
+	m, err := c.query(ctx, apex, typeDNSKEY)
+	if err != nil {
+		return nil, 0, err
+	}
+	sets, sigs := groupRRSets(m.answer)
+	var set dnssecRRSet
+	for _, s := range sets {
+		if s.typ() == typeDNSKEY && bytes.Equal(s.name(), apex) {
+			set = s
+		}
+	}
+	if set == nil {
+		return nil, 0, bogusf("no DNSKEY records for %v", apex)
+	}
+	var keys, trusted []*dnskey
+	for _, rr := range set {
+		k, err := parseDNSKEY(rr)
+		if err != nil {
+			return nil, 0, bogusf("DNSKEY for %v: %v", apex, err)
+		}
+		if !k.isZoneKey() {
+			continue
+		}
+		keys = append(keys, k)
+		for _, d := range dss {
+			if d.matches(k) {
+				trusted = append(trusted, k)
+				break
+			}
+		}
+	}
+	if len(trusted) == 0 {
+		return nil, 0, bogusf("no DNSKEY for %v matches its DS records", apex)
+	}
+	for _, sig := range sigsFor(set, sigs) {
+		if !bytes.Equal(sig.signer, apex) {
+			continue
+		}
+		if verifyRRSet(sig, set, trusted, c.v.timeNow()) == nil {
+			return keys, min(set.minTTL(), sig.ttl), nil
+		}
+	}
+	return nil, 0, bogusf("DNSKEY records for %v not signed by a trusted key", apex)
+}
+
+// cut determines whether cand, a child of zone or a name below one, is a
+// zone cut, by looking up its DS records.
+func (c *dnssecChain) cut(ctx context.Context, zone secureZone, cand wireName) (*zoneEntry, error) {
+	k := zoneKey{c.route, string(cand)}
+	if e, ok := c.v.getZone(k); ok {
+		return e, nil
+	}
+	m, err := c.query(ctx, cand, typeDS)
+	if err != nil {
+		return nil, err
+	}
+	e, ttl, err := c.cutFromResponse(ctx, zone, cand, m)
+	if err != nil {
+		return nil, err
+	}
+	c.v.putZone(k, e, ttl)
+	return e, nil
+}
+
+// cutFromResponse determines whether cand is a zone cut from the response
+// m to a DS query for it, which must be signed by zone.
+func (c *dnssecChain) cutFromResponse(ctx context.Context, zone secureZone, cand wireName, m *dnssecMsg) (_ *zoneEntry, ttl uint32, err error) {
+	now := c.v.timeNow()
+	verifySet := func(set dnssecRRSet, sigs []*rrsig) (uint32, error) {
+		for _, sig := range sigsFor(set, sigs) {
+			if !bytes.Equal(sig.signer, zone.name) {
+				continue
+			}
+			if err := verifyRRSet(sig, set, zone.keys, now); err == nil {
+				return min(set.minTTL(), sig.ttl), nil
+			}
+		}
+		return 0, bogusf("%v/%v not validly signed by %v", set.name(), set.typ(), zone.name)
+	}
+
+	answers, answerSigs := groupRRSets(m.answer)
+	for _, set := range answers {
+		if !bytes.Equal(set.name(), cand) {
+			continue
+		}
+		switch set.typ() {
+		case typeDS:
+			ttl, err := verifySet(set, answerSigs)
+			if err != nil {
+				return nil, 0, err
+			}
+			var dss []*ds
+			for _, rr := range set {
+				if d, err := parseDS(rr); err == nil && d.supported() {
+					dss = append(dss, d)
+				}
+			}
+			if len(dss) == 0 {
+				// RFC 4035 section 5.2: no DS records with algorithms we
+				// support means we treat the zone as unsigned.
+				return &zoneEntry{kind: insecureCut}, ttl, nil
+			}
+			keys, keysTTL, err := c.zoneKeys(ctx, cand, dss)
+			if err != nil {
+				return nil, 0, err
+			}
+			return &zoneEntry{kind: secureCut, keys: keys}, min(ttl, keysTTL), nil
+		case dns.TypeCNAME:
+			// An alias can't be a zone cut.
+			ttl, err := verifySet(set, answerSigs)
+			if err != nil {
+				return nil, 0, err
+			}
+			return &zoneEntry{kind: notCut}, ttl, nil
+		}
+	}
+
+	// No DS records: they must be proven not to exist by NSEC or NSEC3
+	// records signed by zone.
+	authority, authoritySigs := groupRRSets(m.authority)
+	for _, set := range authority {
+		var kind cutKind
+		var proven bool
+		switch set.typ() {
+		case typeNSEC:
+			kind, proven, err = nsecProvesNoDS(set, cand)
+		case typeNSEC3:
+			kind, proven, err = nsec3ProvesNoDS(set, zone.name, cand)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if !proven {
+			continue
+		}
+		ttl, err := verifySet(set, authoritySigs)
+		if err != nil {
+			return nil, 0, err
+		}
+		return &zoneEntry{kind: kind}, ttl, nil
+	}
+	return nil, 0, bogusf("no proof that %v has no DS records", cand)
+}
+
+// nsecProvesNoDS reports whether the NSEC RRset set proves that cand has
+// no DS records, and if so, whether cand is an insecure delegation.
+func nsecProvesNoDS(set dnssecRRSet, cand wireName) (kind cutKind, proven bool, err error) {
+	for _, rr := range set {
+		n, err := parseNSEC(rr)
+		if err != nil {
+			return 0, false, bogusf("NSEC for %v: %v", rr.name, err)
+		}
+		if bytes.Equal(n.owner, cand) {
+			return kindFromBitmap(n.bitmap, cand)
+		}
+		if n.covers(cand) {
+			// cand doesn't exist, or is an empty non-terminal.
+			return notCut, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// nsec3ProvesNoDS reports whether the NSEC3 RRset set of zone proves that
+// cand has no DS records, and if so, whether cand may be an insecure
+// delegation.
+func nsec3ProvesNoDS(set dnssecRRSet, zone, cand wireName) (kind cutKind, proven bool, err error) {
+	for _, rr := range set {
+		n, err := parseNSEC3(rr)
+		if err != nil {
+			return 0, false, bogusf("NSEC3 for %v: %v", rr.name, err)
+		}
+		if !bytes.Equal(n.zone, zone) {
+			continue
+		}
+		if n.iterations > maxNSEC3Iterations {
+			return insecureCut, true, nil
+		}
+		h := n.hash(cand)
+		if h == nil {
+			continue
+		}
+		if bytes.Equal(n.ownerHash, h) {
+			return kindFromBitmap(n.bitmap, cand)
+		}
+		if n.covers(h) {
+			if n.optOut {
+				// RFC 5155 section 6: an opt-out span may contain
+				// unsigned delegations.
+				return insecureCut, true, nil
+			}
+			return notCut, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// kindFromBitmap returns the kind of zone cut at cand given the type
+// bitmap of its NSEC or NSEC3 record.
+func kindFromBitmap(bitmap []byte, cand wireName) (kind cutKind, proven bool, err error) {
+	if typeBitmapHas(bitmap, typeDS) {
+		return 0, false, bogusf("DS records for %v denied, but exist", cand)
+	}
+	if typeBitmapHas(bitmap, dns.TypeNS) && !typeBitmapHas(bitmap, dns.TypeSOA) {
+		return insecureCut, true, nil
+	}
+	return notCut, true, nil
+}
+
+// typeDNAME is the DNAME resource record type (RFC 6672).
+const typeDNAME dns.Type = 39
+
+// isDelegation reports whether the type bitmap of an NSEC or NSEC3 record
+// is that of the parent side of a zone cut.
+func isDelegation(bitmap []byte) bool {
+	return typeBitmapHas(bitmap, dns.TypeNS) && !typeBitmapHas(bitmap, dns.TypeSOA)
+}
+
+// wildcardOf returns the wildcard name *.ce.
+func wildcardOf(ce wireName) wireName {
+	return append(wireName{1, '*'}, ce...)
+}
+
+// commonAncestor returns the longest name that both a and b are equal to
+// or below.
+func commonAncestor(a, b wireName) wireName {
+	for !b.isSubdomainOf(a) {
+		a = a.parent()
+	}
+	return a
+}
+
+// expansionOf reports whether set was expanded from a wildcard, according
+// to its RRSIGs in sigs (RFC 4035 section 5.3.4). If so, it returns the
+// signer of the wildcard and its closest encloser, the name the wildcard
+// is the child of.
+func expansionOf(set dnssecRRSet, sigs []*rrsig) (signer, ce wireName, ok bool) {
+	owner := set.name()
+	ownerLabels := owner.numLabels()
+	if first, ok := firstLabel(owner); ok && string(first) == "*" {
+		ownerLabels--
+	}
+	for _, sig := range sigsFor(set, sigs) {
+		if int(sig.labels) >= ownerLabels {
+			continue
+		}
+		ce = owner
+		for range owner.numLabels() - int(sig.labels) {
+			ce = ce.parent()
+		}
+		return sig.signer, ce, true
+	}
+	return nil, nil, false
+}
+
+// denialSigner returns the most specific zone that signed NSEC or NSEC3
+// records in authority and that name is in.
+func denialSigner(authority []dnssecRRSet, sigs []*rrsig, name wireName) (signer wireName, ok bool) {
+	for _, set := range authority {
+		if set.typ() != typeNSEC && set.typ() != typeNSEC3 {
+			continue
+		}
+		for _, sig := range sigsFor(set, sigs) {
+			if name.isSubdomainOf(sig.signer) && len(sig.signer) > len(signer) {
+				signer, ok = sig.signer, true
+			}
+		}
+	}
+	return signer, ok
+}
+
+// denialProof is the NSEC or NSEC3 records of a response that are validly
+// signed by one zone, from which denials of names in that zone are proven.
+type denialProof struct {
+	zone   wireName
+	nsecs  []*nsec
+	nsec3s []*nsec3
+
+	// insecure is whether the zone's denials can't be validated, because
+	// its NSEC3 records use an unknown hash algorithm or too many
+	// iterations (RFC 5155 section 8.1, RFC 9276 section 3.2).
+	insecure bool
+}
+
+// denialProof returns the NSEC and NSEC3 records in authority that are
+// validly signed by the zone signer.
+func (c *dnssecChain) denialProof(ctx context.Context, authority []dnssecRRSet, sigs []*rrsig, signer wireName) (*denialProof, error) {
+	zone, insecure, err := c.walk(ctx, signer)
+	if err != nil {
+		return nil, err
+	}
+	p := &denialProof{zone: signer, insecure: insecure}
+	if insecure {
+		return p, nil
+	}
+	if !bytes.Equal(zone.name, signer) {
+		return nil, bogusf("denial signed by %v, which is not a zone", signer)
+	}
+	now := c.v.timeNow()
+	for _, set := range authority {
+		if set.typ() != typeNSEC && set.typ() != typeNSEC3 {
+			continue
+		}
+		if !slices.ContainsFunc(sigsFor(set, sigs), func(sig *rrsig) bool {
+			return bytes.Equal(sig.signer, signer) && verifyRRSet(sig, set, zone.keys, now) == nil
+		}) {
+			continue
+		}
+		for _, rr := range set {
+			if rr.typ == typeNSEC {
+				n, err := parseNSEC(rr)
+				if err != nil {
+					return nil, bogusf("NSEC for %v: %v", rr.name, err)
+				}
+				p.nsecs = append(p.nsecs, n)
+				continue
+			}
+			n, err := parseNSEC3(rr)
+			if err != nil {
+				return nil, bogusf("NSEC3 for %v: %v", rr.name, err)
+			}
+			if !bytes.Equal(n.zone, signer) {
+				continue
+			}
+			if n.hashAlg != 1 || n.iterations > maxNSEC3Iterations {
+				p.insecure = true
+				continue
+			}
+			p.nsec3s = append(p.nsec3s, n)
+		}
+	}
+	return p, nil
+}
+
+// nsecMatching returns the NSEC record owned by name, if any.
+func (p *denialProof) nsecMatching(name wireName) *nsec {
+	for _, n := range p.nsecs {
+		if bytes.Equal(n.owner, name) {
+			return n
+		}
+	}
+	return nil
+}
+
+// nsecCovering returns an NSEC record proving that name doesn't exist, if
+// any.
+func (p *denialProof) nsecCovering(name wireName) *nsec {
+	for _, n := range p.nsecs {
+		if !n.covers(name) {
+			continue
+		}
+		// RFC 6840 section 4.1: the NSEC record at a delegation or a
+		// DNAME says nothing about the names below it.
+		if name.isSubdomainOf(n.owner) && (isDelegation(n.bitmap) || typeBitmapHas(n.bitmap, typeDNAME)) {
+			continue
+		}
+		return n
+	}
+	return nil
+}
+
+// nsecClosestEncloser returns the closest encloser of name, the longest
+// existing ancestor of it, given the NSEC record n covering name.
+func nsecClosestEncloser(n *nsec, name wireName) wireName {
+	a, b := commonAncestor(name, n.owner), commonAncestor(name, n.next)
+	if len(b) > len(a) {
+		return b
+	}
+	return a
+}
+
+// nsec3Matching returns the NSEC3 record for name, if any.
+func (p *denialProof) nsec3Matching(name wireName) *nsec3 {
+	for _, n := range p.nsec3s {
+		if bytes.Equal(n.ownerHash, n.hash(name)) {
+			return n
+		}
+	}
+	return nil
+}
+
+// nsec3Covering returns an NSEC3 record proving that name doesn't exist, if
+// any.
+func (p *denialProof) nsec3Covering(name wireName) *nsec3 {
+	for _, n := range p.nsec3s {
+		if n.covers(n.hash(name)) {
+			return n
+		}
+	}
+	return nil
+}
+
+// nsec3ClosestEncloser returns the closest encloser of name proven by p's
+// NSEC3 records, and the record covering the next closer name, the child
+// of the closest encloser that name is in (RFC 5155 section 8.3).
+func (p *denialProof) nsec3ClosestEncloser(name wireName) (ce wireName, nextCloser *nsec3, ok bool) {
+	for next := name; len(next) > len(p.zone); next = next.parent() {
+		ce := next.parent()
+		n := p.nsec3Matching(ce)
+		if n == nil {
+			continue
+		}
+		if isDelegation(n.bitmap) || typeBitmapHas(n.bitmap, typeDNAME) {
+			return nil, nil, false
+		}
+		nextCloser = p.nsec3Covering(next)
+		return ce, nextCloser, nextCloser != nil
+	}
+	return nil, nil, false
+}
+
+// proveNXDomain reports whether p securely proves that name doesn't exist
+// (RFC 4035 section 5.4, RFC 5155 section 8.4), and returns an error
+// wrapping errBogus if it doesn't prove it.
+func (p *denialProof) proveNXDomain(name wireName) (secure bool, err error) {
+	if p.insecure {
+		return false, nil
+	}
+	if len(p.nsecs) > 0 {
+		n := p.nsecCovering(name)
+		if n == nil {
+			return false, bogusf("no NSEC proves that %v doesn't exist", name)
+		}
+		if wc := wildcardOf(nsecClosestEncloser(n, name)); p.nsecCovering(wc) == nil {
+			return false, bogusf("no NSEC proves that %v doesn't exist", wc)
+		}
+		return true, nil
+	}
+	ce, nextCloser, ok := p.nsec3ClosestEncloser(name)
+	if !ok {
+		return false, bogusf("no NSEC3 closest encloser proof for %v", name)
+	}
+	if wc := wildcardOf(ce); p.nsec3Covering(wc) == nil {
+		return false, bogusf("no NSEC3 proves that %v doesn't exist", wc)
+	}
+	if nextCloser.optOut {
+		// RFC 5155 section 9.2: an opt-out span may contain an
+		// unsigned delegation for name.
+		return false, nil
+	}
+	return true, nil
+}
+
+// proveNoData reports whether p securely proves that name has no records
+// of type typ (RFC 4035 section 5.4, RFC 5155 sections 8.5 to 8.7), and
+// returns an error wrapping errBogus if it doesn't prove it.
+func (p *denialProof) proveNoData(name wireName, typ dns.Type) (secure bool, err error) {
+	if p.insecure {
+		return false, nil
+	}
+	if len(p.nsecs) > 0 {
+		if n := p.nsecMatching(name); n != nil {
+			return noDataFromBitmap(n.bitmap, name, typ)
+		}
+		n := p.nsecCovering(name)
+		if n == nil {
+			return false, bogusf("no NSEC proves that %v has no %v records", name, typ)
+		}
+		if n.next.isSubdomainOf(name) {
+			// name is an empty non-terminal.
+			return true, nil
+		}
+		wc := wildcardOf(nsecClosestEncloser(n, name))
+		if w := p.nsecMatching(wc); w != nil {
+			return noDataFromBitmap(w.bitmap, wc, typ)
+		}
+		return false, bogusf("no NSEC proves that %v has no %v records", wc, typ)
+	}
+	if n := p.nsec3Matching(name); n != nil {
+		return noDataFromBitmap(n.bitmap, name, typ)
+	}
+	ce, nextCloser, ok := p.nsec3ClosestEncloser(name)
+	if !ok {
+		return false, bogusf("no NSEC3 closest encloser proof for %v", name)
+	}
+	if typ == typeDS && nextCloser.optOut {
+		// RFC 5155 section 8.6: name may be an unsigned delegation.
+		return false, nil
+	}
+	wc := wildcardOf(ce)
+	if w := p.nsec3Matching(wc); w != nil {
+		return noDataFromBitmap(w.bitmap, wc, typ)
+	}
+	return false, bogusf("no NSEC3 proves that %v has no %v records", wc, typ)
+}
+
+// noDataFromBitmap reports whether the type bitmap of the NSEC or NSEC3
+// record for name proves that it has no records of type typ.
+func noDataFromBitmap(bitmap []byte, name wireName, typ dns.Type) (secure bool, err error) {
+	if typeBitmapHas(bitmap, typ) || typeBitmapHas(bitmap, dns.TypeCNAME) {
+		return false, bogusf("%v records for %v denied, but exist", typ, name)
+	}
+	if typ != typeDS && isDelegation(bitmap) {
+		return false, bogusf("%v records for %v denied by the parent side of a delegation", typ, name)
+	}
+	return true, nil
+}
+
+// proveNoCloserMatch reports whether p securely proves that name, which an
+// answer was expanded from the wildcard child of ce for, doesn't exist
+// (RFC 4035 section 5.3.4, RFC 5155 section 8.8). It returns an error
+// wrapping errBogus if it doesn't prove it.
+func (p *denialProof) proveNoCloserMatch(name, ce wireName) (secure bool, err error) {
+	if p.insecure {
+		return false, nil
+	}
+	if !ce.isSubdomainOf(p.zone) {
+		return false, bogusf("wildcard for %v outside of zone %v", name, p.zone)
+	}
+	if len(p.nsecs) > 0 {
+		if p.nsecCovering(name) == nil {
+			return false, bogusf("no NSEC proves that %v doesn't exist for wildcard expansion", name)
+		}
+		return true, nil
+	}
+	next := name
+	for len(next.parent()) > len(ce) {
+		next = next.parent()
+	}
+	if p.nsec3Covering(next) == nil {
+		return false, bogusf("no NSEC3 proves that %v doesn't exist for wildcard expansion", next)
+	}
+	return true, nil
+}
+
+// dnssecClientOpts are the DNSSEC-related options of a client's query.
+type dnssecClientOpts struct {
+	edns bool // whether the query had an OPT record
+	do   bool // whether the query had the DO bit set
+	ad   bool // whether the query had the AD bit set
+	udp  bool // whether the query was received over UDP
+}
+
+// withDNSSECOK returns a copy of the query q with the DO bit set, adding an
+// OPT record if needed, and the options of the original query.
+func withDNSSECOK(q []byte, family string) ([]byte, dnssecClientOpts, error) {
+	opts := dnssecClientOpts{udp: family == "udp"}
+	var msg dns.Message
+	if err := msg.Unpack(q); err != nil {
+		return nil, opts, err
+	}
+	opts.ad = msg.AuthenticData
+	for i := range msg.Additionals {
+		h := &msg.Additionals[i].Header
+		if h.Type == dns.TypeOPT {
+			opts.edns = true
+			opts.do = h.DNSSECAllowed()
+			h.TTL |= dnsFlagDNSSECOK
+		}
+	}
+	if !opts.edns {
+		var opt dns.ResourceHeader
+		if err := opt.SetEDNS0(dnssecUDPSize, dns.RCodeSuccess, true); err != nil {
+			return nil, opts, err
+		}
+		msg.Additionals = append(msg.Additionals, dns.Resource{Header: opt, Body: &dns.OPTResource{}})
+	}
+	res, err := msg.Pack()
+	return res, opts, err
+}
+
+// dnsFlagDNSSECOK is the DO bit in the TTL field of an OPT record.
+const dnsFlagDNSSECOK = 1 << 15
+
+// dnsFlagCheckingDisabled is set in the flags word of queries whose
+// sender does its own DNSSEC validation.
+const dnsFlagCheckingDisabled = 0x10
+
+// checkingDisabledFlagSet reports whether the DNS query pkt has the CD bit
+// set, in which case its response is passed on unvalidated (RFC 4035
+// section 3.2.2), leaving bogus responses for the client to judge.
+func checkingDisabledFlagSet(pkt []byte) bool {
+	if len(pkt) < headerBytes {
+		return false
+	}
+	return (binary.BigEndian.Uint16(pkt[2:4]) & dnsFlagCheckingDisabled) != 0
+}
+
+// setAuthenticData returns a copy of the response res with the AD bit set
+// to secure.
+func setAuthenticData(res []byte, secure bool) []byte {
+	res = bytes.Clone(res)
+	if len(res) >= headerBytes {
+		if secure {
+			res[3] |= 0x20
+		} else {
+			res[3] &^= 0x20
+		}
+	}
+	return res
+}
+
+// dnssecResponseForClient adapts the validated response res, whose AD bit
+// reports whether it is secure, to a client whose query had the options
+// opts. Per RFC 3225 and RFC 6840 section 5.8, DNSSEC records are removed
+// for clients that didn't set the DO bit, and the AD bit only kept for
+// clients that set the DO or AD bit.
+func dnssecResponseForClient(res []byte, opts dnssecClientOpts) ([]byte, error) {
+	var msg dns.Message
+	if err := msg.Unpack(res); err != nil {
+		return nil, err
+	}
+	msg.AuthenticData = msg.AuthenticData && (opts.do || opts.ad)
+	if msg.Truncated {
+		// A truncated response can't have been validated, so don't hand
+		// out its records; the client will retry over TCP.
+		msg.Answers, msg.Authorities = nil, nil
+	}
+	var qtype dns.Type
+	if len(msg.Questions) > 0 {
+		qtype = msg.Questions[0].Type
+	}
+	strip := func(rrs []dns.Resource) []dns.Resource {
+		ret := rrs[:0]
+		for _, rr := range rrs {
+			switch t := rr.Header.Type; {
+			case t == dns.TypeOPT && !opts.edns:
+				continue
+			case t == dns.TypeOPT && !opts.do:
+				rr.Header.TTL &^= dnsFlagDNSSECOK
+			case !opts.do && t != qtype && (t == typeRRSIG || t == typeNSEC || t == typeNSEC3):
+				continue
+			}
+			ret = append(ret, rr)
+		}
+		return ret
+	}
+	msg.Answers = strip(msg.Answers)
+	msg.Authorities = strip(msg.Authorities)
+	msg.Additionals = strip(msg.Additionals)
+	out, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if opts.udp && !opts.edns && len(out) > 512 {
+		// The client can't receive a UDP response this large.
+		msg.Truncated = true
+		msg.Answers, msg.Authorities, msg.Additionals = nil, nil, nil
+		return msg.Pack()
+	}
+	return out, nil
+}
+
+// setDNSSEC sets whether to validate responses to queries forwarded using
+// routes, except for queries for names within the exempt domains.
+func (f *forwarder) setDNSSEC(validate bool, exempt []dnsname.FQDN) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.validateDNSSEC = validate
+	f.dnssecExempt = exempt
+}
+
+// shouldValidateDNSSEC reports whether to validate the response to a query
+// for name forwarded using routes.
+func (f *forwarder) shouldValidateDNSSEC(name dnsname.FQDN) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.validateDNSSEC {
+		return false
+	}
+	for _, d := range f.dnssecExempt {
+		if d.Contains(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateDNSSECResponse validates the response res to a query forwarded
+// on the route with the given suffix, which uses resolvers. It returns res
+// with its AD bit set if it is secure, or reports that it is bogus.
+func (f *forwarder) validateDNSSECResponse(ctx context.Context, suffix dnsname.FQDN, resolvers []resolverAndDelay, res []byte) (validated []byte, bogus bool) {
+	if truncatedFlagSet(res) {
+		// Nothing to validate; dnssecResponseForClient strips the
+		// records of truncated responses.
+		return setAuthenticData(res, false), false
+	}
+	exchange := func(ctx context.Context, q []byte) ([]byte, error) {
+		// Send the query as if it came over TCP, so that truncated
+		// responses are retried over TCP.
+		ch := make(chan packet, 1)
+		if err := f.forwardWithDestChan(ctx, packet{bs: q, family: "tcp"}, ch, resolvers...); err != nil {
+			return nil, err
+		}
+		return (<-ch).bs, nil
+	}
+	secure, err := f.dnssec.validate(ctx, exchange, suffix, res)
+	switch {
+	case errors.Is(err, errBogus):
+		metricDNSFwdDNSSECBogus.Add(1)
+		if f.verboseFwd {
+			f.logf("dnssec: %v", err)
+		}
+		return nil, true
+	case err != nil:
+		// We couldn't look up the records needed to validate the
+		// response, so we can't vouch for it.
+		metricDNSFwdDNSSECError.Add(1)
+		if f.verboseFwd {
+			f.logf("dnssec: validating: %v", err)
+		}
+		return nil, true
+	case secure:
+		metricDNSFwdDNSSECSecure.Add(1)
+	default:
+		metricDNSFwdDNSSECInsecure.Add(1)
+	}
+	return setAuthenticData(res, secure), false
+}
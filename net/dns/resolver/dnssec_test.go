@@ -0,0 +1,408 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"crypto"
+	"encoding/hex"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	miekdns "github.com/miekg/dns"
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+)
+
+// testSignedZone is a DNSSEC-signed zone served by a testDNSSECServer.
+type testSignedZone struct {
+	t      *testing.T
+	origin string
+	key    *miekdns.DNSKEY
+	priv   crypto.Signer
+}
+
+func newTestSignedZone(t *testing.T, origin string) *testSignedZone {
+	key := &miekdns.DNSKEY{
+		Hdr:       miekdns.RR_Header{Name: origin, Rrtype: miekdns.TypeDNSKEY, Class: miekdns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: miekdns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSignedZone{t: t, origin: origin, key: key, priv: priv.(crypto.Signer)}
+}
+
+// sign returns the RRset rrs followed by its signature.
+func (z *testSignedZone) sign(rrs ...miekdns.RR) []miekdns.RR {
+	now := time.Now()
+	sig := &miekdns.RRSIG{
+		Hdr:        miekdns.RR_Header{Ttl: rrs[0].Header().Ttl},
+		Algorithm:  z.key.Algorithm,
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.origin,
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		Expiration: uint32(now.Add(time.Hour).Unix()),
+	}
+	if err := sig.Sign(z.priv, rrs); err != nil {
+		z.t.Fatal(err)
+	}
+	return append(rrs, sig)
+}
+
+func (z *testSignedZone) dnskey() []miekdns.RR { return z.sign(z.key) }
+
+func (z *testSignedZone) ds() *miekdns.DS { return z.key.ToDS(miekdns.SHA256) }
+
+func (z *testSignedZone) soa() []miekdns.RR {
+	return z.sign(&miekdns.SOA{
+		Hdr:     miekdns.RR_Header{Name: z.origin, Rrtype: miekdns.TypeSOA, Class: miekdns.ClassINET, Ttl: 300},
+		Ns:      miekdns.Fqdn(strings.TrimSuffix("ns."+z.origin, ".")),
+		Mbox:    miekdns.Fqdn(strings.TrimSuffix("hostmaster."+z.origin, ".")),
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  300,
+	})
+}
+
+func (z *testSignedZone) nsec(name, next string, types ...uint16) []miekdns.RR {
+	return z.sign(&miekdns.NSEC{
+		Hdr:        miekdns.RR_Header{Name: name, Rrtype: miekdns.TypeNSEC, Class: miekdns.ClassINET, Ttl: 300},
+		NextDomain: next,
+		TypeBitMap: slices.Sorted(slices.Values(append(types, miekdns.TypeRRSIG, miekdns.TypeNSEC))),
+	})
+}
+
+// nsec3Chain returns the signed NSEC3 records, with no salt and no extra
+// iterations, of a zone with the names in types, which have the types of
+// records they map to.
+func (z *testSignedZone) nsec3Chain(optOut bool, types map[string][]uint16) []miekdns.RR {
+	hashes := make(map[string]string)
+	for name := range types {
+		hashes[miekdns.HashName(name, miekdns.SHA1, 0, "")] = name
+	}
+	sorted := slices.Sorted(maps.Keys(hashes))
+	var flags uint8
+	if optOut {
+		flags = 1
+	}
+	var rrs []miekdns.RR
+	for i, h := range sorted {
+		rrs = append(rrs, z.sign(&miekdns.NSEC3{
+			Hdr:        miekdns.RR_Header{Name: strings.ToLower(h) + "." + z.origin, Rrtype: miekdns.TypeNSEC3, Class: miekdns.ClassINET, Ttl: 300},
+			Hash:       miekdns.SHA1,
+			Flags:      flags,
+			HashLength: 20,
+			NextDomain: sorted[(i+1)%len(sorted)],
+			TypeBitMap: slices.Sorted(slices.Values(append(types[hashes[h]], miekdns.TypeRRSIG))),
+		})...)
+	}
+	return rrs
+}
+
+// expand returns the signed wildcard RRset rrs as expanded for name.
+func expand(name string, rrs []miekdns.RR) []miekdns.RR {
+	for _, rr := range rrs {
+		rr.Header().Name = name
+	}
+	return rrs
+}
+
+func testA(name string, ip netip.Addr) *miekdns.A {
+	return &miekdns.A{
+		Hdr: miekdns.RR_Header{Name: name, Rrtype: miekdns.TypeA, Class: miekdns.ClassINET, Ttl: 300},
+		A:   ip.AsSlice(),
+	}
+}
+
+// testDNSSECResponse is the response of a testDNSSECServer to a query.
+type testDNSSECResponse struct {
+	rcode  int
+	answer []miekdns.RR
+	ns     []miekdns.RR
+}
+
+type testDNSSECQuestion struct {
+	name  string
+	qtype uint16
+}
+
+// testDNSSECServer serves a root zone that delegates to the signed zones
+// example. (using NSEC) and nsec3. (using NSEC3), and the unsigned zone
+// unsigned.
+func testDNSSECServer(t *testing.T) (upstream string, anchor *ds) {
+	root := newTestSignedZone(t, ".")
+	example := newTestSignedZone(t, "example.")
+	nsec3 := newTestSignedZone(t, "nsec3.")
+	nsec3Types := map[string][]uint16{
+		"nsec3.":     {miekdns.TypeSOA, miekdns.TypeNS, miekdns.TypeDNSKEY, miekdns.TypeNSEC3PARAM},
+		"www.nsec3.": {miekdns.TypeA},
+	}
+	apexNSEC := example.nsec("example.", "bad.example.", miekdns.TypeSOA, miekdns.TypeNS, miekdns.TypeDNSKEY)
+
+	badA := example.sign(testA("bad.example.", netip.MustParseAddr("192.0.2.2")))
+	badA[1].(*miekdns.RRSIG).Signature = example.sign(testA("bad.example.", netip.MustParseAddr("192.0.2.3")))[1].(*miekdns.RRSIG).Signature
+
+	// forgedA is signed with the private algorithm 253, which validators
+	// don't support, in a zone that's signed with one they do.
+	forgedA := example.sign(testA("forged.example.", netip.MustParseAddr("192.0.2.7")))
+	forgedA[1].(*miekdns.RRSIG).Algorithm = miekdns.PRIVATEDNS
+
+	responses := map[testDNSSECQuestion]testDNSSECResponse{
+		{".", miekdns.TypeDNSKEY}:        {answer: root.dnskey()},
+		{"example.", miekdns.TypeDNSKEY}: {answer: example.dnskey()},
+		{"example.", miekdns.TypeDS}:     {answer: root.sign(example.ds())},
+		{"unsigned.", miekdns.TypeDS}: {
+			ns: slices.Concat(root.soa(), root.nsec("unsigned.", ".", miekdns.TypeNS)),
+		},
+		{"www.example.", miekdns.TypeA}: {
+			answer: example.sign(testA("www.example.", netip.MustParseAddr("192.0.2.1"))),
+		},
+		{"bad.example.", miekdns.TypeA}: {answer: badA},
+		{"nosig.example.", miekdns.TypeA}: {
+			answer: []miekdns.RR{testA("nosig.example.", netip.MustParseAddr("192.0.2.4"))},
+		},
+		{"nosig.example.", miekdns.TypeDS}: {
+			ns: slices.Concat(example.soa(), example.nsec("nosig.example.", "www.example.", miekdns.TypeA)),
+		},
+		{"forged.example.", miekdns.TypeA}: {answer: forgedA},
+		{"forged.example.", miekdns.TypeDS}: {
+			ns: slices.Concat(example.soa(), example.nsec("forged.example.", "has-a.example.", miekdns.TypeA)),
+		},
+		{"missing.example.", miekdns.TypeA}: {
+			rcode: miekdns.RcodeNameError,
+			ns:    slices.Concat(example.soa(), apexNSEC, example.nsec("bad.example.", "nosig.example.", miekdns.TypeA)),
+		},
+		{"nowildcard.example.", miekdns.TypeA}: {
+			rcode: miekdns.RcodeNameError,
+			ns:    slices.Concat(example.soa(), example.nsec("nosig.example.", "www.example.", miekdns.TypeA)),
+		},
+		{"zzz.example.", miekdns.TypeA}: {
+			rcode: miekdns.RcodeNameError,
+			ns:    slices.Concat(example.soa(), apexNSEC, example.nsec("bad.example.", "nosig.example.", miekdns.TypeA)),
+		},
+		{"text.example.", miekdns.TypeA}: {
+			ns: slices.Concat(example.soa(), example.nsec("text.example.", "www.example.", miekdns.TypeTXT)),
+		},
+		{"has-a.example.", miekdns.TypeA}: {
+			ns: slices.Concat(example.soa(), example.nsec("has-a.example.", "nosig.example.", miekdns.TypeA)),
+		},
+		{"host.wild.example.", miekdns.TypeA}: {
+			answer: expand("host.wild.example.", example.sign(testA("*.wild.example.", netip.MustParseAddr("192.0.2.6")))),
+			ns:     example.nsec("*.wild.example.", "www.example.", miekdns.TypeA),
+		},
+		{"noproof.wild.example.", miekdns.TypeA}: {
+			answer: expand("noproof.wild.example.", example.sign(testA("*.wild.example.", netip.MustParseAddr("192.0.2.6")))),
+		},
+		{"nsec3.", miekdns.TypeDNSKEY}: {answer: nsec3.dnskey()},
+		{"nsec3.", miekdns.TypeDS}:     {answer: root.sign(nsec3.ds())},
+		{"nsec3.", miekdns.TypeA}: {
+			ns: slices.Concat(nsec3.soa(), nsec3.nsec3Chain(false, nsec3Types)),
+		},
+		{"missing.nsec3.", miekdns.TypeA}: {
+			rcode: miekdns.RcodeNameError,
+			ns:    slices.Concat(nsec3.soa(), nsec3.nsec3Chain(false, nsec3Types)),
+		},
+		{"optout.nsec3.", miekdns.TypeA}: {
+			rcode: miekdns.RcodeNameError,
+			ns:    slices.Concat(nsec3.soa(), nsec3.nsec3Chain(true, nsec3Types)),
+		},
+		{"www.nsec3.", miekdns.TypeA}: {
+			rcode: miekdns.RcodeNameError,
+			ns:    slices.Concat(nsec3.soa(), nsec3.nsec3Chain(false, nsec3Types)),
+		},
+		{"host.unsigned.", miekdns.TypeA}: {
+			answer: []miekdns.RR{testA("host.unsigned.", netip.MustParseAddr("192.0.2.5"))},
+		},
+	}
+	server := serveDNS(t, "127.0.0.1:0", ".", miekdns.HandlerFunc(func(w miekdns.ResponseWriter, req *miekdns.Msg) {
+		m := new(miekdns.Msg)
+		m.SetReply(req)
+		q := req.Question[0]
+		r, ok := responses[testDNSSECQuestion{q.Name, q.Qtype}]
+		if !ok {
+			t.Errorf("unexpected query for %v %v", q.Name, miekdns.TypeToString[q.Qtype])
+			m.Rcode = miekdns.RcodeRefused
+		}
+		m.Rcode = max(m.Rcode, r.rcode)
+		m.Answer = r.answer
+		m.Ns = r.ns
+		if opt := req.IsEdns0(); opt != nil {
+			m.SetEdns0(dnssecUDPSize, opt.Do())
+		}
+		w.WriteMsg(m)
+	}))
+	t.Cleanup(func() { server.Shutdown() })
+
+	d := root.ds()
+	digest, err := hex.DecodeString(d.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchor = &ds{keyTag: d.KeyTag, alg: d.Algorithm, digestType: d.DigestType, digest: digest}
+	return server.PacketConn.LocalAddr().String(), anchor
+}
+
+func TestDNSSECValidation(t *testing.T) {
+	upstream, anchor := testDNSSECServer(t)
+
+	query := func(name string, do, cd bool) []byte {
+		m := new(miekdns.Msg)
+		m.SetQuestion(name, miekdns.TypeA)
+		m.CheckingDisabled = cd
+		if do {
+			m.SetEdns0(1232, true)
+		}
+		b, err := m.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		qname    string
+		do       bool
+		cd       bool
+		exempt   []dnsname.FQDN
+		wantCode int
+		wantAD   bool
+		wantSigs bool
+	}{
+		{name: "secure", qname: "www.example.", do: true, wantAD: true, wantSigs: true},
+		{name: "secure-no-do", qname: "www.example.", wantAD: false, wantSigs: false},
+		{name: "secure-nxdomain", qname: "missing.example.", do: true, wantCode: miekdns.RcodeNameError, wantAD: true, wantSigs: true},
+		{name: "nxdomain-no-wildcard-proof", qname: "nowildcard.example.", do: true, wantCode: miekdns.RcodeServerFailure},
+		{name: "nxdomain-unrelated-nsec", qname: "zzz.example.", do: true, wantCode: miekdns.RcodeServerFailure},
+		{name: "secure-nodata", qname: "text.example.", do: true, wantAD: true, wantSigs: true},
+		{name: "nodata-type-exists", qname: "has-a.example.", do: true, wantCode: miekdns.RcodeServerFailure},
+		{name: "secure-wildcard", qname: "host.wild.example.", do: true, wantAD: true, wantSigs: true},
+		{name: "wildcard-no-proof", qname: "noproof.wild.example.", do: true, wantCode: miekdns.RcodeServerFailure},
+		{name: "nsec3-nodata", qname: "nsec3.", do: true, wantAD: true, wantSigs: true},
+		{name: "nsec3-nxdomain", qname: "missing.nsec3.", do: true, wantCode: miekdns.RcodeNameError, wantAD: true, wantSigs: true},
+		{name: "nsec3-opt-out", qname: "optout.nsec3.", do: true, wantCode: miekdns.RcodeNameError, wantSigs: true},
+		{name: "nsec3-nxdomain-exists", qname: "www.nsec3.", do: true, wantCode: miekdns.RcodeServerFailure},
+		{name: "bad-signature", qname: "bad.example.", do: true, wantCode: miekdns.RcodeServerFailure},
+		{name: "unsupported-algorithm", qname: "forged.example.", do: true, wantCode: miekdns.RcodeServerFailure},
+		{name: "stripped-signature", qname: "nosig.example.", do: true, wantCode: miekdns.RcodeServerFailure},
+		{name: "insecure-delegation", qname: "host.unsigned.", do: true},
+		{name: "checking-disabled", qname: "bad.example.", do: true, cd: true, wantSigs: true},
+		{name: "exempt", qname: "bad.example.", do: true, exempt: []dnsname.FQDN{"bad.example."}, wantSigs: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newResolver(t)
+			defer r.Close()
+			r.forwarder.dnssec.anchors = []*ds{anchor}
+			cfg := dnsCfg
+			cfg.Routes = map[dnsname.FQDN][]*dnstype.Resolver{
+				".": {{Addr: upstream}},
+			}
+			cfg.ValidateDNSSEC = true
+			cfg.DNSSECExemptDomains = tt.exempt
+			r.SetConfig(cfg)
+
+			res, err := r.Query(context.Background(), query(tt.qname, tt.do, tt.cd), "udp", netip.AddrPort{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m miekdns.Msg
+			if err := m.Unpack(res); err != nil {
+				t.Fatal(err)
+			}
+			if m.Rcode != tt.wantCode {
+				t.Fatalf("rcode = %v; want %v", miekdns.RcodeToString[m.Rcode], miekdns.RcodeToString[tt.wantCode])
+			}
+			if m.AuthenticatedData != tt.wantAD {
+				t.Errorf("AD = %v; want %v", m.AuthenticatedData, tt.wantAD)
+			}
+			hasSigs := false
+			for _, rr := range slices.Concat(m.Answer, m.Ns) {
+				if rr.Header().Rrtype == miekdns.TypeRRSIG {
+					hasSigs = true
+				}
+			}
+			if hasSigs != tt.wantSigs {
+				t.Errorf("has RRSIGs = %v; want %v", hasSigs, tt.wantSigs)
+			}
+			if opt := m.IsEdns0(); opt != nil && !tt.do {
+				t.Error("response has an OPT record, but the query didn't")
+			}
+		})
+	}
+}
+
+func TestDNSSECKeyTag(t *testing.T) {
+	z := newTestSignedZone(t, "example.")
+	msg := new(miekdns.Msg)
+	msg.SetQuestion("example.", miekdns.TypeDNSKEY)
+	msg.Answer = []miekdns.RR{z.key}
+	b, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := parseDNSSECMsg(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := parseDNSKEY(m.answer[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := k.keyTag(), z.key.KeyTag(); got != want {
+		t.Errorf("keyTag = %d; want %d", got, want)
+	}
+	d := z.ds()
+	digest, err := hex.DecodeString(d.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(&ds{keyTag: d.KeyTag, alg: d.Algorithm, digestType: d.DigestType, digest: digest}).matches(k) {
+		t.Error("DS doesn't match its DNSKEY")
+	}
+}
+
+func TestCompareCanonical(t *testing.T) {
+	// The example from RFC 4034, section 6.1.
+	names := []dns.Name{
+		dns.MustNewName("example."),
+		dns.MustNewName("a.example."),
+		dns.MustNewName("yljkjljk.a.example."),
+		dns.MustNewName("Z.a.example."),
+		dns.MustNewName("zABC.a.EXAMPLE."),
+		dns.MustNewName("z.example."),
+		dns.MustNewName("\x01.z.example."),
+		dns.MustNewName("*.z.example."),
+		dns.MustNewName("\xc8.z.example."),
+	}
+	for i := 1; i < len(names); i++ {
+		a, b := wireNameOf(names[i-1]), wireNameOf(names[i])
+		if compareCanonical(a, b) >= 0 {
+			t.Errorf("%v not before %v", names[i-1], names[i])
+		}
+		if compareCanonical(b, a) <= 0 {
+			t.Errorf("%v not after %v", names[i], names[i-1])
+		}
+	}
+}
+
+func TestNSEC3Hash(t *testing.T) {
+	n := &nsec3{hashAlg: 1, iterations: 12, salt: []byte{0xaa, 0xbb, 0xcc, 0xdd}}
+	got := nsec3Encoding.EncodeToString(n.hash(wireNameOf(dns.MustNewName("example."))))
+	// From RFC 5155, appendix A.
+	if want := "0P9MHAVEQVM6T7VBL5LOP2U3T2RP3TOM"; got != want {
+		t.Errorf("hash = %q; want %q", got, want)
+	}
+}
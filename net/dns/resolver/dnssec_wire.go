@@ -0,0 +1,736 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSSEC resource record types (RFC 4034 and RFC 5155), which the
+// dnsmessage package doesn't know about.
+const (
+	typeDS     dns.Type = 43
+	typeRRSIG  dns.Type = 46
+	typeNSEC   dns.Type = 47
+	typeDNSKEY dns.Type = 48
+	typeNSEC3  dns.Type = 50
+)
+
+// DNSSEC algorithm numbers (RFC 8624) supported for validation.
+const (
+	algRSASHA256       = 8
+	algRSASHA512       = 10
+	algECDSAP256SHA256 = 13
+	algECDSAP384SHA384 = 14
+	algED25519         = 15
+)
+
+// DS digest types (RFC 4034, RFC 4509, RFC 6605) supported for validation.
+const (
+	digestSHA1   = 1
+	digestSHA256 = 2
+	digestSHA384 = 4
+)
+
+var errDNSSECBadMsg = errors.New("malformed DNS message")
+
+// wireName is a domain name in canonical wire format (RFC 4034 section
+// 6.2): uncompressed and with ASCII letters lowercased.
+type wireName []byte
+
+var rootWireName = wireName{0}
+
+// String returns n in presentation format, for logging.
+func (n wireName) String() string {
+	if len(n) <= 1 {
+		return "."
+	}
+	var sb strings.Builder
+	for l := range n.labels() {
+		sb.Write(l)
+		sb.WriteByte('.')
+	}
+	return sb.String()
+}
+
+// labels iterates over the labels of n, from left to right, excluding the
+// root label.
+func (n wireName) labels() func(func([]byte) bool) {
+	return func(yield func([]byte) bool) {
+		for i := 0; i < len(n) && n[i] != 0; i += 1 + int(n[i]) {
+			if !yield(n[i+1 : i+1+int(n[i])]) {
+				return
+			}
+		}
+	}
+}
+
+// numLabels returns the number of labels in n, excluding the root label.
+func (n wireName) numLabels() int {
+	c := 0
+	for range n.labels() {
+		c++
+	}
+	return c
+}
+
+// parent returns n with its leftmost label removed. The parent of the root
+// is the root.
+func (n wireName) parent() wireName {
+	if len(n) <= 1 {
+		return rootWireName
+	}
+	return n[1+int(n[0]):]
+}
+
+// isSubdomainOf reports whether n is equal to or below ancestor.
+func (n wireName) isSubdomainOf(ancestor wireName) bool {
+	for {
+		if len(n) == len(ancestor) {
+			return bytes.Equal(n, ancestor)
+		}
+		if len(n) < len(ancestor) || len(n) <= 1 {
+			return false
+		}
+		n = n.parent()
+	}
+}
+
+// ancestors returns the names from the child of the root down to n itself,
+// in that order. For the root, it returns nil.
+func (n wireName) ancestors() []wireName {
+	var ret []wireName
+	for m := n; len(m) > 1; m = m.parent() {
+		ret = append(ret, m)
+	}
+	slices.Reverse(ret)
+	return ret
+}
+
+// compareCanonical compares a and b in the canonical DNS name order of RFC
+// 4034 section 6.1: label by label from the right, with each label compared
+// as a lowercase byte string.
+func compareCanonical(a, b wireName) int {
+	al := slices.Collect(a.labels())
+	bl := slices.Collect(b.labels())
+	for i, j := len(al)-1, len(bl)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := bytes.Compare(al[i], bl[j]); c != 0 {
+			return c
+		}
+	}
+	return len(al) - len(bl)
+}
+
+// wireNameOf returns the canonical wire format of the DNS name n.
+func wireNameOf(n dns.Name) wireName {
+	s := strings.ToLower(n.String())
+	var ret wireName
+	for _, l := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if l == "" {
+			continue
+		}
+		ret = append(ret, byte(len(l)))
+		ret = append(ret, l...)
+	}
+	return append(ret, 0)
+}
+
+// toLowerASCII lowercases the ASCII letters of b in place.
+func toLowerASCII(b []byte) {
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+	}
+}
+
+// readName reads the possibly compressed name at msg[off:], returning it in
+// canonical wire format, and the offset just past it in msg.
+func readName(msg []byte, off int) (_ wireName, next int, err error) {
+	var name wireName
+	next = -1
+	ptrs := 0
+	for {
+		if off >= len(msg) {
+			return nil, 0, errDNSSECBadMsg
+		}
+		c := int(msg[off])
+		switch c & 0xC0 {
+		case 0x00:
+			if c == 0 {
+				if next < 0 {
+					next = off + 1
+				}
+				name = append(name, 0)
+				if len(name) > 255 {
+					return nil, 0, errDNSSECBadMsg
+				}
+				toLowerASCII(name)
+				return name, next, nil
+			}
+			if off+1+c > len(msg) {
+				return nil, 0, errDNSSECBadMsg
+			}
+			name = append(name, msg[off:off+1+c]...)
+			off += 1 + c
+		case 0xC0:
+			if off+2 > len(msg) {
+				return nil, 0, errDNSSECBadMsg
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			if ptrs++; ptrs > 64 {
+				return nil, 0, errDNSSECBadMsg
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		default:
+			return nil, 0, errDNSSECBadMsg
+		}
+	}
+}
+
+// dnssecRR is a resource record with its owner name and RDATA in canonical
+// form (RFC 4034 section 6.2).
+type dnssecRR struct {
+	name  wireName
+	typ   dns.Type
+	class dns.Class
+	ttl   uint32
+	rdata []byte
+}
+
+// dnssecMsg is a DNS message parsed for DNSSEC validation.
+type dnssecMsg struct {
+	rcode     dns.RCode
+	qname     wireName
+	qtype     dns.Type
+	answer    []dnssecRR
+	authority []dnssecRR
+}
+
+// parseDNSSECMsg parses the DNS response msg, which must have a single
+// question.
+func parseDNSSECMsg(msg []byte) (*dnssecMsg, error) {
+	if len(msg) < headerBytes {
+		return nil, errDNSSECBadMsg
+	}
+	m := &dnssecMsg{rcode: dns.RCode(msg[3] & 0x0F)}
+	qd := binary.BigEndian.Uint16(msg[4:])
+	an := int(binary.BigEndian.Uint16(msg[6:]))
+	ns := int(binary.BigEndian.Uint16(msg[8:]))
+	if qd != 1 {
+		return nil, errDNSSECBadMsg
+	}
+	qname, off, err := readName(msg, headerBytes)
+	if err != nil {
+		return nil, err
+	}
+	if off+4 > len(msg) {
+		return nil, errDNSSECBadMsg
+	}
+	m.qname = qname
+	m.qtype = dns.Type(binary.BigEndian.Uint16(msg[off:]))
+	off += 4
+	for i := range an + ns {
+		var rr dnssecRR
+		rr, off, err = readRR(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if i < an {
+			m.answer = append(m.answer, rr)
+		} else {
+			m.authority = append(m.authority, rr)
+		}
+	}
+	return m, nil
+}
+
+// readRR reads the resource record at msg[off:], returning it and the
+// offset just past it in msg.
+func readRR(msg []byte, off int) (rr dnssecRR, next int, err error) {
+	rr.name, off, err = readName(msg, off)
+	if err != nil {
+		return rr, 0, err
+	}
+	if off+10 > len(msg) {
+		return rr, 0, errDNSSECBadMsg
+	}
+	rr.typ = dns.Type(binary.BigEndian.Uint16(msg[off:]))
+	rr.class = dns.Class(binary.BigEndian.Uint16(msg[off+2:]))
+	rr.ttl = binary.BigEndian.Uint32(msg[off+4:])
+	rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	end := off + rdlen
+	if end > len(msg) {
+		return rr, 0, errDNSSECBadMsg
+	}
+	rr.rdata, err = canonicalRDATA(msg, off, end, rr.typ)
+	return rr, end, err
+}
+
+// canonicalRDATA returns the canonical form of the RDATA at msg[off:end]
+// of a record of type typ, decompressing and lowercasing embedded names as
+// described in RFC 4034 section 6.2 (as updated by RFC 6840 section 5.1).
+func canonicalRDATA(msg []byte, off, end int, typ dns.Type) ([]byte, error) {
+	// prefix and names are the number of fixed-size bytes before the
+	// first embedded name, and the number of embedded names.
+	var prefix, names int
+	switch typ {
+	case dns.TypeNS, dns.TypeCNAME, dns.TypePTR, 39: // 39 is DNAME
+		names = 1
+	case dns.TypeMX:
+		prefix, names = 2, 1
+	case dns.TypeSRV:
+		prefix, names = 6, 1
+	case dns.TypeSOA:
+		names = 2
+	case typeRRSIG:
+		prefix, names = 18, 1
+	default:
+		return bytes.Clone(msg[off:end]), nil
+	}
+	if off+prefix > end {
+		return nil, errDNSSECBadMsg
+	}
+	ret := bytes.Clone(msg[off : off+prefix])
+	off += prefix
+	for range names {
+		n, next, err := readName(msg, off)
+		if err != nil || next > end {
+			return nil, errDNSSECBadMsg
+		}
+		ret = append(ret, n...)
+		off = next
+	}
+	return append(ret, msg[off:end]...), nil
+}
+
+// rrsig is a parsed RRSIG record (RFC 4034 section 3).
+type rrsig struct {
+	owner       wireName
+	ttl         uint32
+	typeCovered dns.Type
+	alg         uint8
+	labels      uint8
+	origTTL     uint32
+	expiration  uint32
+	inception   uint32
+	keyTag      uint16
+	signer      wireName
+	signature   []byte
+
+	rdataNoSig []byte // RDATA up to and including the signer name
+}
+
+func parseRRSIG(rr dnssecRR) (*rrsig, error) {
+	d := rr.rdata
+	if len(d) < 19 {
+		return nil, errDNSSECBadMsg
+	}
+	signer, next, err := readName(d, 18)
+	if err != nil {
+		return nil, err
+	}
+	return &rrsig{
+		owner:       rr.name,
+		ttl:         rr.ttl,
+		typeCovered: dns.Type(binary.BigEndian.Uint16(d)),
+		alg:         d[2],
+		labels:      d[3],
+		origTTL:     binary.BigEndian.Uint32(d[4:]),
+		expiration:  binary.BigEndian.Uint32(d[8:]),
+		inception:   binary.BigEndian.Uint32(d[12:]),
+		keyTag:      binary.BigEndian.Uint16(d[16:]),
+		signer:      signer,
+		signature:   d[next:],
+		rdataNoSig:  d[:next],
+	}, nil
+}
+
+// dnskey is a parsed DNSKEY record (RFC 4034 section 2).
+type dnskey struct {
+	owner    wireName
+	flags    uint16
+	protocol uint8
+	alg      uint8
+	key      []byte
+	rdata    []byte
+}
+
+// isZoneKey reports whether k has the Zone Key flag set, which is required
+// for it to verify RRSIGs.
+func (k *dnskey) isZoneKey() bool { return k.flags&0x0100 != 0 }
+
+func parseDNSKEY(rr dnssecRR) (*dnskey, error) {
+	d := rr.rdata
+	if len(d) < 4 {
+		return nil, errDNSSECBadMsg
+	}
+	return &dnskey{
+		owner:    rr.name,
+		flags:    binary.BigEndian.Uint16(d),
+		protocol: d[2],
+		alg:      d[3],
+		key:      d[4:],
+		rdata:    d,
+	}, nil
+}
+
+// keyTag returns the key tag of k, as computed in RFC 4034 appendix B.
+func (k *dnskey) keyTag() uint16 {
+	var ac uint32
+	for i, b := range k.rdata {
+		if i&1 == 1 {
+			ac += uint32(b)
+		} else {
+			ac += uint32(b) << 8
+		}
+	}
+	ac += ac >> 16 & 0xFFFF
+	return uint16(ac)
+}
+
+// ds is a parsed DS record (RFC 4034 section 5).
+type ds struct {
+	keyTag     uint16
+	alg        uint8
+	digestType uint8
+	digest     []byte
+}
+
+func parseDS(rr dnssecRR) (*ds, error) {
+	d := rr.rdata
+	if len(d) < 5 {
+		return nil, errDNSSECBadMsg
+	}
+	return &ds{
+		keyTag:     binary.BigEndian.Uint16(d),
+		alg:        d[2],
+		digestType: d[3],
+		digest:     d[4:],
+	}, nil
+}
+
+// supported reports whether d uses an algorithm and digest type that we
+// can validate.
+func (d *ds) supported() bool {
+	return supportedAlg(d.alg) && (d.digestType == digestSHA1 || d.digestType == digestSHA256 || d.digestType == digestSHA384)
+}
+
+// matches reports whether d is the digest of k.
+func (d *ds) matches(k *dnskey) bool {
+	if d.keyTag != k.keyTag() || d.alg != k.alg || k.protocol != 3 {
+		return false
+	}
+	var h crypto.Hash
+	switch d.digestType {
+	case digestSHA1:
+		h = crypto.SHA1
+	case digestSHA256:
+		h = crypto.SHA256
+	case digestSHA384:
+		h = crypto.SHA384
+	default:
+		return false
+	}
+	w := h.New()
+	w.Write(k.owner)
+	w.Write(k.rdata)
+	return bytes.Equal(w.Sum(nil), d.digest)
+}
+
+// supportedAlg reports whether we can verify signatures using the DNSSEC
+// algorithm alg.
+func supportedAlg(alg uint8) bool {
+	switch alg {
+	case algRSASHA256, algRSASHA512, algECDSAP256SHA256, algECDSAP384SHA384, algED25519:
+		return true
+	}
+	return false
+}
+
+// serialLessEq reports whether a <= b in RFC 1982 serial number arithmetic,
+// as used for RRSIG validity times.
+func serialLessEq(a, b uint32) bool {
+	return a == b || int32(b-a) > 0
+}
+
+// verifyRRSet verifies that sig is a valid signature of the RRset set by
+// one of keys at time now.
+//
+// All records in set must have the same owner name, type and class, and
+// keys must all be owned by sig's signer.
+func verifyRRSet(sig *rrsig, set []dnssecRR, keys []*dnskey, now time.Time) error {
+	if len(set) == 0 {
+		return errors.New("empty RRset")
+	}
+	owner := set[0].name
+	if sig.typeCovered != set[0].typ || !owner.isSubdomainOf(sig.signer) {
+		return errors.New("RRSIG doesn't cover RRset")
+	}
+	t := uint32(now.Unix())
+	if !serialLessEq(sig.inception, t) || !serialLessEq(t, sig.expiration) {
+		return errors.New("RRSIG not valid at current time")
+	}
+
+	// RFC 4035 section 5.3.2: if the RRSIG has fewer labels than the
+	// owner name, the RRset was expanded from a wildcard.
+	signedOwner := owner
+	ownerLabels := owner.numLabels()
+	if first, ok := firstLabel(owner); ok && string(first) == "*" {
+		ownerLabels--
+	}
+	switch {
+	case int(sig.labels) > ownerLabels:
+		return errors.New("RRSIG has too many labels")
+	case int(sig.labels) < ownerLabels:
+		signedOwner = owner
+		for range owner.numLabels() - int(sig.labels) {
+			signedOwner = signedOwner.parent()
+		}
+		signedOwner = wildcardOf(signedOwner)
+	}
+
+	// RFC 4034 section 3.1.8.1: the signed data is the RRSIG RDATA
+	// without the signature, followed by the RRs of the RRset in
+	// canonical form and order, with the original TTL.
+	rdatas := make([][]byte, 0, len(set))
+	for _, rr := range set {
+		rdatas = append(rdatas, rr.rdata)
+	}
+	slices.SortFunc(rdatas, bytes.Compare)
+	rdatas = slices.CompactFunc(rdatas, bytes.Equal)
+	signed := bytes.Clone(sig.rdataNoSig)
+	for _, rdata := range rdatas {
+		signed = append(signed, signedOwner...)
+		signed = binary.BigEndian.AppendUint16(signed, uint16(set[0].typ))
+		signed = binary.BigEndian.AppendUint16(signed, uint16(set[0].class))
+		signed = binary.BigEndian.AppendUint32(signed, sig.origTTL)
+		signed = binary.BigEndian.AppendUint16(signed, uint16(len(rdata)))
+		signed = append(signed, rdata...)
+	}
+
+	for _, k := range keys {
+		if k.alg != sig.alg || k.protocol != 3 || !k.isZoneKey() || k.keyTag() != sig.keyTag {
+			continue
+		}
+		if verifySignature(k, signed, sig.signature) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("no key verifies RRSIG with key tag %d for %v/%v", sig.keyTag, owner, set[0].typ)
+}
+
+// firstLabel returns the leftmost label of n, if any.
+func firstLabel(n wireName) (_ []byte, ok bool) {
+	for l := range n.labels() {
+		return l, true
+	}
+	return nil, false
+}
+
+// verifySignature verifies that sig is k's signature of data.
+func verifySignature(k *dnskey, data, sig []byte) error {
+	switch k.alg {
+	case algRSASHA256, algRSASHA512:
+		pub, err := parseRSAKey(k.key)
+		if err != nil {
+			return err
+		}
+		if k.alg == algRSASHA256 {
+			h := sha256.Sum256(data)
+			return rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig)
+		}
+		h := sha512.Sum512(data)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA512, h[:], sig)
+	case algECDSAP256SHA256, algECDSAP384SHA384:
+		curve, size := elliptic.P256(), 32
+		var hash []byte
+		if k.alg == algECDSAP256SHA256 {
+			h := sha256.Sum256(data)
+			hash = h[:]
+		} else {
+			curve, size = elliptic.P384(), 48
+			h := sha512.Sum384(data)
+			hash = h[:]
+		}
+		if len(k.key) != 2*size || len(sig) != 2*size {
+			return errors.New("bad ECDSA key or signature size")
+		}
+		pub, err := ecdsa.ParseUncompressedPublicKey(curve, append([]byte{4}, k.key...))
+		if err != nil {
+			return err
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, hash, r, s) {
+			return errors.New("bad ECDSA signature")
+		}
+		return nil
+	case algED25519:
+		if len(k.key) != ed25519.PublicKeySize {
+			return errors.New("bad Ed25519 key size")
+		}
+		if !ed25519.Verify(ed25519.PublicKey(k.key), data, sig) {
+			return errors.New("bad Ed25519 signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported DNSSEC algorithm %d", k.alg)
+}
+
+// parseRSAKey parses an RSA public key in the format of RFC 3110 section 2.
+func parseRSAKey(b []byte) (*rsa.PublicKey, error) {
+	if len(b) < 1 {
+		return nil, errors.New("empty RSA key")
+	}
+	explen := int(b[0])
+	b = b[1:]
+	if explen == 0 {
+		if len(b) < 2 {
+			return nil, errors.New("short RSA key")
+		}
+		explen = int(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	}
+	if explen == 0 || explen > 4 || len(b) <= explen {
+		return nil, errors.New("bad RSA exponent")
+	}
+	var e int
+	for _, c := range b[:explen] {
+		e = e<<8 | int(c)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(b[explen:]), E: e}, nil
+}
+
+// typeBitmapHas reports whether the NSEC or NSEC3 type bitmap (RFC 4034
+// section 4.1.2) b includes typ.
+func typeBitmapHas(b []byte, typ dns.Type) bool {
+	window, bit := byte(typ>>8), int(typ&0xFF)
+	for len(b) >= 2 {
+		w, n := b[0], int(b[1])
+		if n == 0 || n > 32 || len(b) < 2+n {
+			return false
+		}
+		if w == window {
+			return bit/8 < n && b[2+bit/8]&(0x80>>(bit%8)) != 0
+		}
+		b = b[2+n:]
+	}
+	return false
+}
+
+// nsec is a parsed NSEC record (RFC 4034 section 4).
+type nsec struct {
+	owner  wireName
+	next   wireName
+	bitmap []byte
+}
+
+func parseNSEC(rr dnssecRR) (*nsec, error) {
+	next, off, err := readName(rr.rdata, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &nsec{owner: rr.name, next: next, bitmap: rr.rdata[off:]}, nil
+}
+
+// covers reports whether n proves that no name exists strictly between its
+// owner and next names, and name is such a name.
+func (n *nsec) covers(name wireName) bool {
+	if compareCanonical(n.owner, name) >= 0 {
+		return false
+	}
+	// The last NSEC in a zone points back to the apex.
+	return compareCanonical(name, n.next) < 0 || compareCanonical(n.next, n.owner) <= 0
+}
+
+// nsec3 is a parsed NSEC3 record (RFC 5155 section 3).
+type nsec3 struct {
+	ownerHash  []byte   // decoded from the first label of the owner name
+	zone       wireName // the rest of the owner name
+	hashAlg    uint8
+	optOut     bool
+	iterations uint16
+	salt       []byte
+	nextHash   []byte
+	bitmap     []byte
+}
+
+var nsec3Encoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+func parseNSEC3(rr dnssecRR) (*nsec3, error) {
+	d := rr.rdata
+	label, ok := firstLabel(rr.name)
+	if !ok || len(d) < 5 {
+		return nil, errDNSSECBadMsg
+	}
+	ownerHash, err := nsec3Encoding.DecodeString(strings.ToUpper(string(label)))
+	if err != nil {
+		return nil, errDNSSECBadMsg
+	}
+	n := &nsec3{
+		ownerHash:  ownerHash,
+		zone:       rr.name.parent(),
+		hashAlg:    d[0],
+		optOut:     d[1]&1 != 0,
+		iterations: binary.BigEndian.Uint16(d[2:]),
+	}
+	saltLen := int(d[4])
+	d = d[5:]
+	if len(d) < saltLen+1 {
+		return nil, errDNSSECBadMsg
+	}
+	n.salt, d = d[:saltLen], d[saltLen:]
+	hashLen := int(d[0])
+	d = d[1:]
+	if len(d) < hashLen {
+		return nil, errDNSSECBadMsg
+	}
+	n.nextHash, n.bitmap = d[:hashLen], d[hashLen:]
+	return n, nil
+}
+
+// hash returns the NSEC3 hash of name using n's parameters, as described
+// in RFC 5155 section 5, or nil if n uses an unknown hash algorithm.
+func (n *nsec3) hash(name wireName) []byte {
+	if n.hashAlg != 1 { // SHA-1
+		return nil
+	}
+	h := sha1.Sum(append(bytes.Clone(name), n.salt...))
+	for range n.iterations {
+		h = sha1.Sum(append(h[:], n.salt...))
+	}
+	return h[:]
+}
+
+// covers reports whether the hash h falls strictly between n's owner hash
+// and next hash.
+func (n *nsec3) covers(h []byte) bool {
+	if bytes.Compare(n.ownerHash, n.nextHash) < 0 {
+		return bytes.Compare(n.ownerHash, h) < 0 && bytes.Compare(h, n.nextHash) < 0
+	}
+	// The last NSEC3 in the hash order wraps around to the first.
+	return bytes.Compare(n.ownerHash, h) < 0 || bytes.Compare(h, n.nextHash) < 0
+}
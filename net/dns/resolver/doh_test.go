@@ -5,12 +5,30 @@
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/base64"
 	"flag"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/health"
 	"tailscale.com/net/dns/publicdns"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/tstest"
+	"tailscale.com/tstest/tlstest"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/eventbus/eventbustest"
 )
 
 var testDoH = flag.Bool("test-doh", false, "do real DoH tests against the network")
@@ -53,7 +71,7 @@ func TestDoH(t *testing.T) {
 			if !ok {
 				t.Fatal("expected DoH")
 			}
-			res, err := f.sendDoH(context.Background(), urlBase, c, someDNSQuestion(t))
+			res, err := f.sendDoH(context.Background(), urlBase, c, someDNSQuestion(t), false)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -97,3 +115,206 @@ func TestDoHV6Fallback(t *testing.T) {
 		}
 	}
 }
+
+const testDoHDomain = tlstest.Domain("doh.tstest")
+
+// runDoHServer starts an HTTP/2 DNS-over-HTTPS server on localhost that
+// answers every A query with 127.0.0.1, accepting queries using the
+// method want. It returns the server's port and a counter of accepted
+// connections.
+func runDoHServer(t testing.TB, want string) (port uint16, conns *atomic.Int32) {
+	conns = new(atomic.Int32)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("got %s request; want HTTP/2", r.Proto)
+		}
+		if r.Method != want {
+			t.Errorf("got %s request; want %s", r.Method, want)
+			http.Error(w, "bad method", http.StatusMethodNotAllowed)
+			return
+		}
+		var query []byte
+		var err error
+		switch r.Method {
+		case "GET":
+			query, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+			if err == nil && len(query) >= headerBytes && (query[0] != 0 || query[1] != 0) {
+				t.Errorf("GET query has non-zero DNS ID")
+			}
+		case "POST":
+			if ct := r.Header.Get("Content-Type"); ct != dohType {
+				t.Errorf("Content-Type = %q; want %q", ct, dohType)
+			}
+			query, err = io.ReadAll(r.Body)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q, err := p.Question()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.Response = true
+		b := dnsmessage.NewBuilder(nil, h)
+		b.StartQuestions()
+		b.Question(q)
+		b.StartAnswers()
+		b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: q.Class, TTL: 60}, dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})
+		res, err := b.Finish()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dohType)
+		w.Write(res)
+	}))
+	srv.EnableHTTP2 = true
+	srv.TLS = testDoHDomain.ServerTLSConfig()
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return uint16(srv.Listener.Addr().(*net.TCPAddr).Port), conns
+}
+
+func TestGenericDoH(t *testing.T) {
+	for _, method := range []string{"POST", "GET"} {
+		t.Run(method, func(t *testing.T) {
+			port, conns := runDoHServer(t, method)
+			addr := fmt.Sprintf("https://%s:%d/dns-query", testDoHDomain, port)
+			if method == "GET" {
+				addr += dohGETTemplate
+			}
+			// doh.tstest doesn't resolve, so this also checks that the
+			// bootstrap IPs are used to dial it.
+			r := &dnstype.Resolver{
+				Addr:                addr,
+				BootstrapResolution: []netip.Addr{netip.MustParseAddr("127.0.0.1")},
+			}
+
+			logf := tstest.WhileTestRunningLogger(t)
+			bus := eventbustest.NewBus(t)
+			netMon, err := netmon.New(bus, logf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var dialer tsdial.Dialer
+			dialer.SetNetMon(netMon)
+			dialer.SetBus(bus)
+			fwd := newForwarder(logf, netMon, nil, &dialer, health.NewTracker(bus), nil)
+			defer fwd.Close()
+			fwd.setRoutes(map[dnsname.FQDN][]*dnstype.Resolver{
+				".": {r},
+			})
+
+			c, urlBase, err := fwd.getDoHClient(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(urlBase, "{") {
+				t.Fatalf("urlBase %q contains URI Template", urlBase)
+			}
+			roots := x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(tlstest.TestRootCA()) {
+				t.Fatal("bad test root CA")
+			}
+			c.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// The DNS ID of the query is preserved, even though GET
+			// requests send an ID of 0.
+			res, err := fwd.sendDoH(ctx, urlBase, c, someDNSQuestion(t), method == "GET")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.ID != someDNSID {
+				t.Errorf("response DNS ID = %v; want %v", h.ID, someDNSID)
+			}
+
+			// Queries forwarded over the route reuse the connection.
+			for _, domain := range []string{"a.example.com.", "b.example.com."} {
+				rchan := make(chan packet, 1)
+				err := fwd.forwardWithDestChan(ctx, packet{
+					bs:     makeTestRequest(t, domain),
+					family: "udp",
+					addr:   netip.MustParseAddrPort("127.0.0.1:12345"),
+				}, rchan)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := getRCode((<-rchan).bs); got != dnsmessage.RCodeSuccess {
+					t.Fatalf("rcode = %v", got)
+				}
+			}
+			if got := conns.Load(); got != 1 {
+				t.Errorf("server accepted %d connections; want 1", got)
+			}
+		})
+	}
+}
+
+func TestGetDoHClientInvalid(t *testing.T) {
+	f := &forwarder{logf: t.Logf}
+	for _, addr := range []string{"https://", "https://%zz/dns-query"} {
+		if _, _, err := f.getDoHClient(&dnstype.Resolver{Addr: addr}); err == nil {
+			t.Errorf("getDoHClient(%q) succeeded; want error", addr)
+		}
+	}
+}
+
+func TestDoHClientKeptBySetRoutes(t *testing.T) {
+	for _, addr := range []string{
+		"https://doh.tstest/dns-query",
+		"https://doh.tstest/dns-query" + dohGETTemplate,
+		"https://dns.google/dns-query" + dohGETTemplate,
+	} {
+		t.Run(addr, func(t *testing.T) {
+			logf := tstest.WhileTestRunningLogger(t)
+			bus := eventbustest.NewBus(t)
+			netMon, err := netmon.New(bus, logf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var dialer tsdial.Dialer
+			dialer.SetNetMon(netMon)
+			dialer.SetBus(bus)
+			fwd := newForwarder(logf, netMon, nil, &dialer, health.NewTracker(bus), nil)
+			defer fwd.Close()
+
+			r := &dnstype.Resolver{Addr: addr}
+			routes := map[dnsname.FQDN][]*dnstype.Resolver{".": {r}}
+			fwd.setRoutes(routes)
+			c, _, err := fwd.getDoHClient(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Setting the same routes again keeps the client, and with it
+			// its pooled connections.
+			fwd.setRoutes(routes)
+			if c2, _, err := fwd.getDoHClient(r); err != nil {
+				t.Fatal(err)
+			} else if c2 != c {
+				t.Error("setRoutes with the same routes replaced the DoH client")
+			}
+		})
+	}
+}
@@ -0,0 +1,471 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/net/netx"
+	"tailscale.com/net/sockstats"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/types/logger"
+)
+
+const (
+	// dotDefaultPort is the well-known DNS-over-TLS port (RFC 7858).
+	dotDefaultPort = "853"
+
+	// dotIdleConnTimeout is how long to keep an idle DNS-over-TLS
+	// connection open. See dohIdleConnTimeout for the rationale.
+	dotIdleConnTimeout = dohIdleConnTimeout
+
+	// dotDialTimeout bounds how long it may take to establish a TCP+TLS
+	// connection to a DNS-over-TLS server.
+	dotDialTimeout = 5 * time.Second
+
+	// dotMaxPipelined is the maximum number of queries that may be
+	// outstanding at once on a single DNS-over-TLS connection. It must
+	// not exceed the 16 bit DNS ID space.
+	dotMaxPipelined = 1024
+)
+
+var (
+	errDoTConnClosed  = errors.New("dot: connection closed")
+	errDoTTooManyReqs = errors.New("dot: too many outstanding queries")
+)
+
+// parseDoTAddr parses a "tls://host[:port]" resolver address into the
+// host:port to dial and the server name to verify the certificate against.
+func parseDoTAddr(addr string) (hostPort, serverName string, err error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "tls" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid DNS-over-TLS address %q", addr)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return "", "", fmt.Errorf("invalid DNS-over-TLS address %q: unexpected path", addr)
+	}
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = dotDefaultPort
+	}
+	return net.JoinHostPort(host, port), host, nil
+}
+
+// getDoTClient returns the DNS-over-TLS client for the resolver r,
+// creating it if needed. Clients are cached by resolver address so that
+// connections to the same upstream are shared between queries. explicit
+// is whether r was given explicitly rather than being on a route.
+func (f *forwarder) getDoTClient(r *dnstype.Resolver, explicit bool) (*dotClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.dotClient[r.Addr]; ok {
+		c.explicit = c.explicit || explicit
+		return c, nil
+	}
+	hostPort, serverName, err := parseDoTAddr(r.Addr)
+	if err != nil {
+		return nil, err
+	}
+	dial := f.dialerForHost(serverName, r.BootstrapResolution)
+	c := &dotClient{
+		logf:     f.logf,
+		hostPort: hostPort,
+		dial:     dial,
+		explicit: explicit,
+		tlsConfig: &tls.Config{
+			ServerName: serverName,
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+	if f.dotClient == nil {
+		f.dotClient = map[string]*dotClient{}
+	}
+	f.dotClient[r.Addr] = c
+	return c, nil
+}
+
+// closeDoTClients closes all DNS-over-TLS connections and forgets the
+// clients.
+func (f *forwarder) closeDoTClients() {
+	f.mu.Lock()
+	clients := f.dotClient
+	f.dotClient = nil
+	f.mu.Unlock()
+	for _, c := range clients {
+		c.Close()
+	}
+}
+
+func (f *forwarder) sendDoT(ctx context.Context, fq *forwardQuery, rr resolverAndDelay) ([]byte, error) {
+	ctx = sockstats.WithSockStats(ctx, sockstats.LabelDNSForwarderDoT, f.logf)
+	metricDNSFwdDoT.Add(1)
+	c, err := f.getDoTClient(rr.name, fq.explicit)
+	if err != nil {
+		metricDNSFwdErrorType.Add(1)
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, tcpQueryTimeout)
+	defer cancel()
+
+	out, err := c.exchange(ctx, fq.packet)
+	if err != nil {
+		metricDNSFwdDoTErrorTransport.Add(1)
+		return nil, err
+	}
+	if getTxID(out) != fq.txid {
+		metricDNSFwdDoTErrorTxID.Add(1)
+		return nil, errTxIDMismatch
+	}
+	if rcode := getRCode(out); rcode == dns.RCodeServerFailure {
+		f.logf("sendDoT: response code indicating server failure: %d", rcode)
+		metricDNSFwdDoTErrorServer.Add(1)
+		return nil, errServerFailure
+	}
+	if truncatedFlagSet(out) {
+		metricDNSFwdTruncated.Add(1)
+	}
+	metricDNSFwdDoTSuccess.Add(1)
+	return out, nil
+}
+
+// dotClient is a DNS-over-TLS (RFC 7858) client for a single upstream
+// server.
+//
+// It keeps at most one connection open to the server and pipelines
+// concurrent queries over it, matching out-of-order responses by DNS ID.
+// Idle connections are closed after dotIdleConnTimeout.
+type dotClient struct {
+	logf      logger.Logf
+	hostPort  string        // to dial
+	dial      netx.DialFunc // dials TCP to hostPort
+	tlsConfig *tls.Config   // ServerName is always set; verified as SNI
+
+	// explicit is whether the client was used for a resolver given
+	// explicitly rather than on a route. It's guarded by forwarder.mu.
+	explicit bool
+
+	mu      sync.Mutex
+	conn    *dotConn // current connection, or nil
+	dialing *dotDial // dial in progress, or nil
+	closed  bool
+}
+
+// dotDial is a connection attempt that concurrent queries wait on.
+type dotDial struct {
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the dial finishes
+
+	// Set before done is closed.
+	dc  *dotConn
+	err error
+}
+
+// exchange sends the DNS query msg and returns the response.
+//
+// The returned response has the same DNS ID as msg.
+func (c *dotClient) exchange(ctx context.Context, msg []byte) ([]byte, error) {
+	if len(msg) < headerBytes {
+		return nil, errors.New("dot: query too short")
+	}
+	// A reused connection may have been closed by the server while
+	// idle, which we only find out about when we use it; in that case
+	// retry once on a fresh connection.
+	for attempt := 0; ; attempt++ {
+		dc, reused, err := c.getConn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		res, err := dc.roundTrip(ctx, msg)
+		if errors.Is(err, errDoTConnClosed) && reused && attempt == 0 && ctx.Err() == nil {
+			continue
+		}
+		return res, err
+	}
+}
+
+// getConn returns an open connection to the server, dialing a new one
+// if needed. reused reports whether the connection had been used before.
+//
+// The dial happens without c.mu held, so that a slow or failing server
+// doesn't hold up Close. Concurrent callers share a single dial, but each
+// stops waiting for it when its own ctx is done.
+func (c *dotClient) getConn(ctx context.Context) (dc *dotConn, reused bool, err error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, false, net.ErrClosed
+	}
+	if c.conn != nil && !c.conn.isClosed() {
+		dc := c.conn
+		c.mu.Unlock()
+		return dc, true, nil
+	}
+	d := c.dialing
+	if d == nil {
+		// Keep ctx's values, such as its sockstats label, but not its
+		// cancelation: the dial outlives this caller if others wait on it.
+		dctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dotDialTimeout)
+		d = &dotDial{cancel: cancel, done: make(chan struct{})}
+		c.dialing = d
+		go c.dialConn(dctx, d)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-d.done:
+		return d.dc, false, d.err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// dialConn dials a new connection for d and installs it as c.conn.
+func (c *dotClient) dialConn(ctx context.Context, d *dotDial) {
+	defer close(d.done)
+	defer d.cancel()
+
+	dc, err := c.dialTLS(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dialing = nil
+	if err == nil && c.closed {
+		dc.conn.Close()
+		err = net.ErrClosed
+	}
+	if err != nil {
+		d.err = err
+		return
+	}
+	dc.idle = time.AfterFunc(dotIdleConnTimeout, dc.closeIfIdle)
+	c.conn = dc
+	d.dc = dc
+	go dc.readLoop()
+}
+
+// dialTLS dials the server and completes the TLS handshake.
+func (c *dotClient) dialTLS(ctx context.Context) (*dotConn, error) {
+	tc, err := c.dial(ctx, "tcp", c.hostPort)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(tc, c.tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		tc.Close()
+		return nil, err
+	}
+	return &dotConn{
+		c:       c,
+		conn:    tlsConn,
+		pending: map[uint16]chan dotResult{},
+	}, nil
+}
+
+// busy reports whether c has an open connection or a dial in progress.
+func (c *dotClient) busy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dialing != nil || (c.conn != nil && !c.conn.isClosed())
+}
+
+// Close closes the client's connection, if any, and cancels any dial in
+// progress. Queries in flight fail.
+func (c *dotClient) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	dc := c.conn
+	c.conn = nil
+	if c.dialing != nil {
+		c.dialing.cancel()
+	}
+	c.mu.Unlock()
+	if dc != nil {
+		dc.closeWithError(net.ErrClosed)
+	}
+	return nil
+}
+
+// connDone is called by dc when it's closed.
+func (c *dotClient) connDone(dc *dotConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == dc {
+		c.conn = nil
+	}
+}
+
+// dotResult is the result of a single query on a dotConn.
+type dotResult struct {
+	res []byte
+	err error
+}
+
+// dotConn is a single TLS connection to a DNS-over-TLS server.
+type dotConn struct {
+	c    *dotClient
+	conn *tls.Conn
+
+	wmu sync.Mutex // serializes writes to conn
+
+	mu      sync.Mutex // guards following
+	nextID  uint16
+	pending map[uint16]chan dotResult // by on-the-wire DNS ID
+	idle    *time.Timer               // closes conn after dotIdleConnTimeout with nothing pending
+	err     error                     // non-nil once closed
+}
+
+func (dc *dotConn) isClosed() bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.err != nil
+}
+
+// roundTrip sends msg on dc and waits for its response.
+//
+// Because queries from different clients may share a DNS ID, msg is sent
+// with a connection-unique ID which is replaced with the original ID in
+// the returned response.
+func (dc *dotConn) roundTrip(ctx context.Context, msg []byte) ([]byte, error) {
+	origID := binary.BigEndian.Uint16(msg[0:2])
+
+	dc.mu.Lock()
+	if dc.err != nil {
+		dc.mu.Unlock()
+		return nil, errDoTConnClosed
+	}
+	if len(dc.pending) >= dotMaxPipelined {
+		dc.mu.Unlock()
+		return nil, errDoTTooManyReqs
+	}
+	id := dc.nextID
+	for {
+		if _, ok := dc.pending[id]; !ok {
+			break
+		}
+		id++
+	}
+	dc.nextID = id + 1
+	ch := make(chan dotResult, 1)
+	dc.pending[id] = ch
+	dc.idle.Stop()
+	dc.mu.Unlock()
+
+	defer dc.forget(id)
+
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	binary.BigEndian.PutUint16(buf[2:4], id)
+
+	dc.wmu.Lock()
+	if dl, ok := ctx.Deadline(); ok {
+		dc.conn.SetWriteDeadline(dl)
+	} else {
+		dc.conn.SetWriteDeadline(time.Time{})
+	}
+	_, err := dc.conn.Write(buf)
+	dc.wmu.Unlock()
+	if err != nil {
+		dc.closeWithError(err)
+		return nil, errDoTConnClosed
+	}
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, r.err
+		}
+		binary.BigEndian.PutUint16(r.res[0:2], origID)
+		return r.res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// forget removes the pending query id, arming the idle timer if it was
+// the last one.
+func (dc *dotConn) forget(id uint16) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	delete(dc.pending, id)
+	if len(dc.pending) == 0 && dc.err == nil {
+		dc.idle.Reset(dotIdleConnTimeout)
+	}
+}
+
+func (dc *dotConn) closeIfIdle() {
+	dc.mu.Lock()
+	idle := len(dc.pending) == 0
+	dc.mu.Unlock()
+	if idle {
+		dc.closeWithError(errDoTConnClosed)
+	}
+}
+
+// closeWithError closes dc, failing all pending queries.
+func (dc *dotConn) closeWithError(err error) {
+	dc.mu.Lock()
+	if dc.err != nil {
+		dc.mu.Unlock()
+		return
+	}
+	dc.err = err
+	dc.idle.Stop()
+	pending := dc.pending
+	dc.pending = nil
+	dc.mu.Unlock()
+
+	dc.conn.Close()
+	dc.c.connDone(dc)
+	for _, ch := range pending {
+		ch <- dotResult{err: errDoTConnClosed}
+	}
+}
+
+// readLoop reads responses from dc until it's closed, dispatching them to
+// the pending queries.
+func (dc *dotConn) readLoop() {
+	var lenBuf [2]byte
+	for {
+		if _, err := io.ReadFull(dc.conn, lenBuf[:]); err != nil {
+			dc.closeWithError(err)
+			return
+		}
+		n := binary.BigEndian.Uint16(lenBuf[:])
+		if n < headerBytes {
+			dc.closeWithError(fmt.Errorf("dot: short response (%d bytes)", n))
+			return
+		}
+		res := make([]byte, n)
+		if _, err := io.ReadFull(dc.conn, res); err != nil {
+			dc.closeWithError(err)
+			return
+		}
+		id := binary.BigEndian.Uint16(res[0:2])
+		dc.mu.Lock()
+		ch, ok := dc.pending[id]
+		if ok {
+			delete(dc.pending, id)
+		}
+		dc.mu.Unlock()
+		if !ok {
+			// Most likely a response to a query that timed out.
+			continue
+		}
+		ch <- dotResult{res: res}
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/health"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/tstest"
+	"tailscale.com/tstest/tlstest"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/eventbus/eventbustest"
+)
+
+func TestParseDoTAddr(t *testing.T) {
+	tests := []struct {
+		addr           string
+		wantHostPort   string
+		wantServerName string
+		wantErr        bool
+	}{
+		{addr: "tls://dns.example.net", wantHostPort: "dns.example.net:853", wantServerName: "dns.example.net"},
+		{addr: "tls://dns.example.net:8853", wantHostPort: "dns.example.net:8853", wantServerName: "dns.example.net"},
+		{addr: "tls://1.2.3.4", wantHostPort: "1.2.3.4:853", wantServerName: "1.2.3.4"},
+		{addr: "tls://[2001:db8::1]:853", wantHostPort: "[2001:db8::1]:853", wantServerName: "2001:db8::1"},
+		{addr: "tls://dns.example.net/", wantHostPort: "dns.example.net:853", wantServerName: "dns.example.net"},
+		{addr: "tls://dns.example.net/dns-query", wantErr: true},
+		{addr: "tls://", wantErr: true},
+		{addr: "https://dns.example.net", wantErr: true},
+	}
+	for _, tt := range tests {
+		hostPort, serverName, err := parseDoTAddr(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDoTAddr(%q) error = %v; wantErr %v", tt.addr, err, tt.wantErr)
+			continue
+		}
+		if hostPort != tt.wantHostPort || serverName != tt.wantServerName {
+			t.Errorf("parseDoTAddr(%q) = %q, %q; want %q, %q", tt.addr, hostPort, serverName, tt.wantHostPort, tt.wantServerName)
+		}
+	}
+}
+
+const testDoTDomain = tlstest.Domain("dot.tstest")
+
+// runDoTServer starts a DNS-over-TLS server on localhost that answers
+// every A query with 127.0.0.1. Responses to each batch of queries read
+// off a connection are sent in reverse order, to exercise pipelining.
+// It returns the listener address and a counter of accepted connections.
+func runDoTServer(t testing.TB) (addr string, conns *atomic.Int32) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", testDoTDomain.ServerTLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	conns = new(atomic.Int32)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go serveDoTConn(t, c)
+		}
+	}()
+	return ln.Addr().String(), conns
+}
+
+func serveDoTConn(t testing.TB, c net.Conn) {
+	defer c.Close()
+	var batch [][]byte
+	for {
+		c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		var lenBuf [2]byte
+		_, err := io.ReadFull(c, lenBuf[:])
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				for i := len(batch) - 1; i >= 0; i-- {
+					if _, err := c.Write(batch[i]); err != nil {
+						return
+					}
+				}
+				batch = nil
+				continue
+			}
+			return
+		}
+		c.SetReadDeadline(time.Time{})
+		msg := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+		if _, err := io.ReadFull(c, msg); err != nil {
+			return
+		}
+		var p dns.Parser
+		h, err := p.Start(msg)
+		if err != nil {
+			t.Errorf("parsing query: %v", err)
+			return
+		}
+		q, err := p.Question()
+		if err != nil {
+			t.Errorf("parsing question: %v", err)
+			return
+		}
+		h.Response = true
+		b := dns.NewBuilder(nil, h)
+		b.StartQuestions()
+		b.Question(q)
+		b.StartAnswers()
+		b.AResource(dns.ResourceHeader{Name: q.Name, Class: q.Class, TTL: 60}, dns.AResource{A: [4]byte{127, 0, 0, 1}})
+		res, err := b.Finish()
+		if err != nil {
+			t.Errorf("building response: %v", err)
+			return
+		}
+		out := binary.BigEndian.AppendUint16(nil, uint16(len(res)))
+		batch = append(batch, append(out, res...))
+	}
+}
+
+func newTestDoTClient(t testing.TB, addr, serverName string) *dotClient {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(tlstest.TestRootCA()) {
+		t.Fatal("bad test root CA")
+	}
+	var d net.Dialer
+	c := &dotClient{
+		logf:     t.Logf,
+		hostPort: addr,
+		dial:     d.DialContext,
+		tlsConfig: &tls.Config{
+			ServerName: serverName,
+			RootCAs:    roots,
+		},
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestDoTPipelining(t *testing.T) {
+	addr, conns := runDoTServer(t)
+	c := newTestDoTClient(t, addr, string(testDoTDomain))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			domain := fmt.Sprintf("host%d.example.com.", i)
+			// All queries use the same DNS ID, as unrelated clients might.
+			res, err := c.exchange(ctx, makeTestRequest(t, domain))
+			if err != nil {
+				t.Errorf("exchange(%q): %v", domain, err)
+				return
+			}
+			if id := binary.BigEndian.Uint16(res[0:2]); id != 0 {
+				t.Errorf("exchange(%q): response ID = %d; want 0", domain, id)
+			}
+			var p dns.Parser
+			if _, err := p.Start(res); err != nil {
+				t.Errorf("parsing response: %v", err)
+				return
+			}
+			q, err := p.Question()
+			if err != nil {
+				t.Errorf("parsing response question: %v", err)
+				return
+			}
+			if q.Name.String() != domain {
+				t.Errorf("response for %q has question %q", domain, q.Name.String())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := conns.Load(); got != 1 {
+		t.Errorf("server saw %d connections; want 1", got)
+	}
+}
+
+func TestDoTReconnect(t *testing.T) {
+	addr, conns := runDoTServer(t)
+	c := newTestDoTClient(t, addr, string(testDoTDomain))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := c.exchange(ctx, makeTestRequest(t, "a.example.com.")); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate the connection going away, as if the server had closed it.
+	c.mu.Lock()
+	c.conn.conn.Close()
+	c.mu.Unlock()
+
+	if _, err := c.exchange(ctx, makeTestRequest(t, "b.example.com.")); err != nil {
+		t.Fatal(err)
+	}
+	if got := conns.Load(); got != 2 {
+		t.Errorf("server saw %d connections; want 2", got)
+	}
+}
+
+func TestDoTSlowDial(t *testing.T) {
+	c := newTestDoTClient(t, "192.0.2.1:853", string(testDoTDomain))
+	dialing := make(chan struct{})
+	c.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		close(dialing)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := c.exchange(context.Background(), makeTestRequest(t, "a.example.com."))
+		firstErr <- err
+	}()
+	<-dialing
+
+	// Other queries don't wait beyond their own deadline for the dial.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.exchange(ctx, makeTestRequest(t, "b.example.com.")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("exchange during slow dial: got %v; want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > dotDialTimeout/2 {
+		t.Errorf("exchange during slow dial took %v", d)
+	}
+
+	// Close doesn't wait for the dial either, and cancels it.
+	c.Close()
+	select {
+	case err := <-firstErr:
+		if err == nil {
+			t.Error("exchange succeeded after Close")
+		}
+	case <-time.After(dotDialTimeout / 2):
+		t.Fatal("dial not canceled by Close")
+	}
+}
+
+func TestDoTBadServerName(t *testing.T) {
+	addr, _ := runDoTServer(t)
+	c := newTestDoTClient(t, addr, "wrong.tstest")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := c.exchange(ctx, makeTestRequest(t, "a.example.com.")); err == nil {
+		t.Fatal("unexpected success with mismatched server name")
+	}
+}
+
+func TestForwarderDoT(t *testing.T) {
+	addr, _ := runDoTServer(t)
+	const resolverAddr = "tls://dot.tstest"
+
+	logf := tstest.WhileTestRunningLogger(t)
+	bus := eventbustest.NewBus(t)
+	netMon, err := netmon.New(bus, logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dialer tsdial.Dialer
+	dialer.SetNetMon(netMon)
+	dialer.SetBus(bus)
+	fwd := newForwarder(logf, netMon, nil, &dialer, health.NewTracker(bus), nil)
+	defer fwd.Close()
+
+	fwd.setRoutes(map[dnsname.FQDN][]*dnstype.Resolver{
+		".": {{Addr: resolverAddr}},
+	})
+	// Replace the client that would dial the real dot.tstest.
+	fwd.mu.Lock()
+	fwd.dotClient = map[string]*dotClient{
+		resolverAddr: newTestDoTClient(t, addr, string(testDoTDomain)),
+	}
+	fwd.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rchan := make(chan packet, 1)
+	err = fwd.forwardWithDestChan(ctx, packet{
+		bs:     makeTestRequest(t, "example.com."),
+		family: "udp",
+		addr:   netip.MustParseAddrPort("127.0.0.1:12345"),
+	}, rchan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := <-rchan
+	var p dns.Parser
+	h, err := p.Start(got.bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.Response || h.RCode != dns.RCodeSuccess {
+		t.Fatalf("unexpected response header %+v", h)
+	}
+
+	// Dropping the route closes the now unused client.
+	c := fwd.dotClient[resolverAddr]
+	fwd.setRoutes(nil)
+	if _, _, err := c.getConn(ctx); err == nil {
+		t.Error("DoT client still usable after its route was removed")
+	}
+
+	// Clients of resolvers given explicitly, as for exit node DNS, aren't
+	// on a route but are kept while their connection is open.
+	const explicitAddr = "tls://exit.dot.tstest"
+	c = newTestDoTClient(t, addr, string(testDoTDomain))
+	fwd.mu.Lock()
+	fwd.dotClient = map[string]*dotClient{explicitAddr: c}
+	fwd.mu.Unlock()
+	err = fwd.forwardWithDestChan(ctx, packet{
+		bs:     makeTestRequest(t, "example.com."),
+		family: "tcp",
+		addr:   netip.MustParseAddrPort("127.0.0.1:12345"),
+	}, rchan, resolverAndDelay{name: &dnstype.Resolver{Addr: explicitAddr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-rchan
+	fwd.setRoutes(map[dnsname.FQDN][]*dnstype.Resolver{
+		".": {{Addr: resolverAddr}},
+	})
+	if _, reused, err := c.getConn(ctx); err != nil || !reused {
+		t.Errorf("explicit DoT client after route change: reused=%v, err=%v; want its open connection", reused, err)
+	}
+}
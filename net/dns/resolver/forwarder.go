@@ -32,6 +32,7 @@
 	"tailscale.com/health"
 	"tailscale.com/net/dns/publicdns"
 	"tailscale.com/net/dnscache"
+	"tailscale.com/net/dnsfallback"
 	"tailscale.com/net/neterror"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netx"
@@ -234,6 +235,16 @@ type forwarder struct {
 	mu sync.Mutex // guards following
 
 	dohClient map[string]*http.Client // urlBase -> client
+	dotClient map[string]*dotClient   // "tls://" resolver addr -> client
+
+	// cache holds upstream responses to queries forwarded using routes.
+	cache responseCache
+
+	// dnssec validates responses to queries forwarded using routes, if
+	// validateDNSSEC is set, except for names within dnssecExempt.
+	dnssec         dnssecValidator
+	validateDNSSEC bool
+	dnssecExempt   []dnsname.FQDN
 
 	// routes are per-suffix resolvers to use, with
 	// the most specific routes first.
@@ -272,6 +283,7 @@ func newForwarder(logf logger.Logf, netMon *netmon.Monitor, linkSel ForwardLinkS
 
 func (f *forwarder) Close() error {
 	f.ctxCancel()
+	f.closeDoTClients()
 	return nil
 }
 
@@ -391,10 +403,63 @@ func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolve
 		return routes[i].Suffix.NumLabels() > routes[j].Suffix.NumLabels()
 	})
 
+	// Close connections to DoT and DoH servers that are no longer in use.
+	// DoT clients of explicitly given resolvers aren't on any route, so
+	// they're kept while they have a connection, which closes once idle.
+	var stale []io.Closer
 	f.mu.Lock()
-	defer f.mu.Unlock()
+	for addr, c := range f.dotClient {
+		if !routesUseResolver(routes, addr) && !(c.explicit && c.busy()) {
+			stale = append(stale, c)
+			delete(f.dotClient, addr)
+		}
+	}
+	for urlBase, c := range f.dohClient {
+		if !routesUseDoHURL(routes, urlBase) {
+			stale = append(stale, idleConnCloser{c})
+			delete(f.dohClient, urlBase)
+		}
+	}
 	f.routes = routes
 	f.cloudHostFallback = cloudHostFallback
+	f.mu.Unlock()
+	for _, c := range stale {
+		c.Close()
+	}
+}
+
+// idleConnCloser is an io.Closer that closes the idle connections of an
+// HTTP client.
+type idleConnCloser struct{ c *http.Client }
+
+func (c idleConnCloser) Close() error {
+	c.c.CloseIdleConnections()
+	return nil
+}
+
+// routesUseResolver reports whether any of routes uses the resolver addr.
+func routesUseResolver(routes []route, addr string) bool {
+	for _, r := range routes {
+		for _, rr := range r.Resolvers {
+			if rr.name.Addr == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// routesUseDoHURL reports whether any of routes uses a DoH resolver that
+// sends its queries to urlBase.
+func routesUseDoHURL(routes []route, urlBase string) bool {
+	for _, r := range routes {
+		for _, rr := range r.Resolvers {
+			if strings.HasPrefix(rr.name.Addr, "https://") && dohURLBase(rr.name.Addr) == urlBase {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 var stdNetPacketListener nettype.PacketListenerWithNetIP = nettype.MakePacketListenerWithNetIP(new(net.ListenConfig))
@@ -439,12 +504,20 @@ func (f *forwarder) getKnownDoHClientForProvider(urlBase string) (c *http.Client
 		SingleHostStaticResult: allIPs,
 		Logf:                   f.logf,
 	})
-	tlsConfig := &tls.Config{
-		// Enforce TLS 1.3, as all of our supported DNS-over-HTTPS servers are compatible with it
-		// (see tailscale.com/net/dns/publicdns/publicdns.go).
-		MinVersion: tls.VersionTLS13,
+	// Enforce TLS 1.3, as all of our supported DNS-over-HTTPS servers are compatible with it
+	// (see tailscale.com/net/dns/publicdns/publicdns.go).
+	c = newDoHClient(dialer, tls.VersionTLS13)
+	if f.dohClient == nil {
+		f.dohClient = map[string]*http.Client{}
 	}
-	c = &http.Client{
+	f.dohClient[urlBase] = c
+	return c, true
+}
+
+// newDoHClient returns an HTTP client for a DoH server that dials with dial
+// and requires at least TLS version minTLS.
+func newDoHClient(dial netx.DialFunc, minTLS uint16) *http.Client {
+	return &http.Client{
 		Transport: &http.Transport{
 			ForceAttemptHTTP2: true,
 			IdleConnTimeout:   dohIdleConnTimeout,
@@ -456,28 +529,116 @@ func (f *forwarder) getKnownDoHClientForProvider(urlBase string) (c *http.Client
 				if !strings.HasPrefix(netw, "tcp") {
 					return nil, fmt.Errorf("unexpected network %q", netw)
 				}
-				return dialer(ctx, netw, addr)
+				return dial(ctx, netw, addr)
+			},
+			TLSClientConfig: &tls.Config{
+				MinVersion: minTLS,
 			},
-			TLSClientConfig: tlsConfig,
 		},
 	}
+}
+
+// dialerForHost returns a func to dial the DoH or DoT server named host,
+// which may be an IP address or a hostname.
+//
+// Hostnames are resolved without recursing into ourselves: using the
+// bootstrap IPs from the DNS config if there are any, and otherwise the
+// system resolver with the DERP bootstrap DNS as a fallback.
+func (f *forwarder) dialerForHost(host string, bootstrap []netip.Addr) netx.DialFunc {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return f.getDialerType()
+	}
+	dr := &dnscache.Resolver{
+		Logf:        f.logf,
+		UseLastGood: true,
+	}
+	if len(bootstrap) > 0 {
+		dr.SingleHost = host
+		dr.SingleHostStaticResult = bootstrap
+	} else {
+		dr.LookupIPFallback = dnsfallback.MakeLookupFunc(f.logf, f.netMon)
+	}
+	return dnscache.Dialer(f.getDialerType(), dr)
+}
+
+// dohGETTemplate is the RFC 8484 URI Template variable that, at the end of a
+// DoH resolver URL, selects the GET method rather than POST.
+const dohGETTemplate = "{?dns}"
+
+// dohURLBase returns the URL to send queries to the DoH resolver with the
+// address addr to, without any URI Template.
+func dohURLBase(addr string) string {
+	return strings.TrimSuffix(addr, dohGETTemplate)
+}
+
+// getDoHClient returns an HTTP client for the DoH resolver r, and the URL
+// to send queries to, without any URI Template. Clients are cached by
+// that URL.
+//
+// Well-known providers are dialed at their statically known IPs. Other
+// servers are dialed using r's BootstrapResolution, if any, or else
+// resolved as described in dialerForHost.
+func (f *forwarder) getDoHClient(r *dnstype.Resolver) (c *http.Client, urlBase string, err error) {
+	urlBase = dohURLBase(r.Addr)
+	if c, ok := f.getKnownDoHClientForProvider(urlBase); ok {
+		return c, urlBase, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.dohClient[urlBase]; ok {
+		return c, urlBase, nil
+	}
+	dohURL, err := url.Parse(urlBase)
+	if err != nil {
+		return nil, "", err
+	}
+	if dohURL.Scheme != "https" || dohURL.Host == "" {
+		return nil, "", fmt.Errorf("invalid DNS-over-HTTPS URL %q", r.Addr)
+	}
+	// Unlike the well-known providers, self-hosted DoH servers might not
+	// support TLS 1.3 yet.
+	c = newDoHClient(f.dialerForHost(dohURL.Hostname(), r.BootstrapResolution), tls.VersionTLS12)
 	if f.dohClient == nil {
 		f.dohClient = map[string]*http.Client{}
 	}
 	f.dohClient[urlBase] = c
-	return c, true
+	return c, urlBase, nil
 }
 
 const dohType = "application/dns-message"
 
-func (f *forwarder) sendDoH(ctx context.Context, urlBase string, c *http.Client, packet []byte) ([]byte, error) {
+// sendDoH sends packet to the DoH server at urlBase using c, with a POST
+// request unless useGET is set.
+func (f *forwarder) sendDoH(ctx context.Context, urlBase string, c *http.Client, packet []byte, useGET bool) ([]byte, error) {
 	ctx = sockstats.WithSockStats(ctx, sockstats.LabelDNSForwarderDoH, f.logf)
 	metricDNSFwdDoH.Add(1)
-	req, err := http.NewRequestWithContext(ctx, "POST", urlBase, bytes.NewReader(packet))
+	var req *http.Request
+	var err error
+	var origID uint16
+	if useGET {
+		if len(packet) < headerBytes {
+			return nil, errors.New("DNS query too short")
+		}
+		// RFC 8484 section 4.1: use a DNS ID of 0 in GET requests, to be
+		// friendlier to HTTP caches.
+		origID = binary.BigEndian.Uint16(packet[0:2])
+		q := bytes.Clone(packet)
+		binary.BigEndian.PutUint16(q[0:2], 0)
+		sep := "?"
+		if strings.Contains(urlBase, "?") {
+			sep = "&"
+		}
+		req, err = http.NewRequestWithContext(ctx, "GET", urlBase+sep+"dns="+base64.RawURLEncoding.EncodeToString(q), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, "POST", urlBase, bytes.NewReader(packet))
+	}
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", dohType)
+	if !useGET {
+		req.Header.Set("Content-Type", dohType)
+	}
 	req.Header.Set("Accept", dohType)
 	req.Header.Set("User-Agent", "tailscaled/"+version.Long())
 
@@ -503,6 +664,9 @@ func (f *forwarder) sendDoH(ctx context.Context, urlBase string, c *http.Client,
 	if err != nil {
 		metricDNSFwdDoHErrorBody.Add(1)
 	}
+	if useGET && len(res) >= headerBytes {
+		binary.BigEndian.PutUint16(res[0:2], origID)
+	}
 	if truncatedFlagSet(res) {
 		metricDNSFwdTruncated.Add(1)
 	}
@@ -534,25 +698,18 @@ func (f *forwarder) send(ctx context.Context, fq *forwardQuery, rr resolverAndDe
 		if !buildfeatures.HasPeerAPIClient {
 			return nil, feature.ErrUnavailable
 		}
-		return f.sendDoH(ctx, rr.name.Addr, f.dialer.PeerAPIHTTPClient(), fq.packet)
+		return f.sendDoH(ctx, rr.name.Addr, f.dialer.PeerAPIHTTPClient(), fq.packet, false)
 	}
 	if strings.HasPrefix(rr.name.Addr, "https://") {
-		// Only known DoH providers are supported currently. Specifically, we
-		// only support DoH providers where we can TCP connect to them on port
-		// 443 at the same IP address they serve normal UDP DNS from (1.1.1.1,
-		// 8.8.8.8, 9.9.9.9, etc.) That's why OpenDNS and custom DoH providers
-		// aren't currently supported. There's no backup DNS resolution path for
-		// them.
-		urlBase := rr.name.Addr
-		if hc, ok := f.getKnownDoHClientForProvider(urlBase); ok {
-			return f.sendDoH(ctx, urlBase, hc, fq.packet)
+		hc, urlBase, err := f.getDoHClient(rr.name)
+		if err != nil {
+			metricDNSFwdErrorType.Add(1)
+			return nil, err
 		}
-		metricDNSFwdErrorType.Add(1)
-		return nil, fmt.Errorf("arbitrary https:// resolvers not supported yet")
+		return f.sendDoH(ctx, urlBase, hc, fq.packet, strings.HasSuffix(rr.name.Addr, dohGETTemplate))
 	}
 	if strings.HasPrefix(rr.name.Addr, "tls://") {
-		metricDNSFwdErrorType.Add(1)
-		return nil, fmt.Errorf("tls:// resolvers not supported yet")
+		return f.sendDoT(ctx, fq, rr)
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -869,16 +1026,24 @@ func (f *forwarder) sendTCP(ctx context.Context, fq *forwardQuery, rr resolverAn
 
 // resolvers returns the resolvers to use for domain.
 func (f *forwarder) resolvers(domain dnsname.FQDN) []resolverAndDelay {
+	_, rr := f.route(domain)
+	return rr
+}
+
+// route returns the suffix of the route matching domain and the resolvers
+// to use for it. If no route matches, it returns the cloud host fallback
+// resolvers, if any, with an empty suffix.
+func (f *forwarder) route(domain dnsname.FQDN) (suffix dnsname.FQDN, _ []resolverAndDelay) {
 	f.mu.Lock()
 	routes := f.routes
 	cloudHostFallback := f.cloudHostFallback
 	f.mu.Unlock()
 	for _, route := range routes {
 		if route.Suffix == "." || route.Suffix.Contains(domain) {
-			return route.Resolvers
+			return route.Suffix, route.Resolvers
 		}
 	}
-	return cloudHostFallback // or nil if no fallback
+	return "", cloudHostFallback // or nil if no fallback
 }
 
 // GetUpstreamResolvers returns the resolvers that would be used to resolve
@@ -904,6 +1069,11 @@ type forwardQuery struct {
 	packet []byte
 	family string // "tcp" or "udp"
 
+	// explicit is whether the query is sent to resolvers given by the
+	// caller (e.g. for exit node DNS proxy queries), rather than to those
+	// of a route.
+	explicit bool
+
 	// closeOnCtxDone lets send register values to Close if the
 	// caller's ctx expires. This avoids send from allocating its
 	// own waiting goroutine to interrupt the ReadFrom, as memory
@@ -960,9 +1130,19 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	}
 
 	clampEDNSSize(query.bs, maxResponseBytes)
-
+	explicit := len(resolvers) > 0
+
+	// Responses are only cached and validated for queries forwarded on
+	// our routes, not for those with explicit resolvers (e.g. exit node
+	// DNS proxy queries using the OS resolver).
+	var cacheKey cacheKey
+	useCache := false
+	var suffix dnsname.FQDN
+	var dnssecOpts dnssecClientOpts
+	validate := false
+	clientQuery := query
 	if len(resolvers) == 0 {
-		resolvers = f.resolvers(domain)
+		suffix, resolvers = f.route(domain)
 		if len(resolvers) == 0 {
 			metricDNSFwdErrorNoUpstream.Add(1)
 			f.health.SetUnhealthy(dnsForwarderFailing, health.Args{health.ArgDNSServers: ""})
@@ -981,12 +1161,48 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 		} else {
 			f.health.SetHealthy(dnsForwarderFailing)
 		}
+		if !disableDNSCache() {
+			cacheKey, useCache = cacheKeyForQuery(query.bs, suffix)
+		}
+		if f.shouldValidateDNSSEC(domain) && !checkingDisabledFlagSet(query.bs) {
+			q, opts, err := withDNSSECOK(query.bs, query.family)
+			if err != nil {
+				return err
+			}
+			query.bs, dnssecOpts, validate = q, opts, true
+		}
+		cacheKey.validated = validate
+	}
+	if useCache {
+		if res := f.cache.get(cacheKey, query.bs); res != nil {
+			metricDNSFwdCacheHit.Add(1)
+			if validate {
+				if res, err = dnssecResponseForClient(res, dnssecOpts); err != nil {
+					return err
+				}
+			}
+			if query.family == "udp" {
+				// The cached response may have been for a requester that
+				// could receive a larger one.
+				if res, err = truncateForRequester(res, clientQuery.bs); err != nil {
+					return err
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("waiting to send cached response: %w", ctx.Err())
+			case responseChan <- packet{bs: res, family: query.family, addr: query.addr, cached: true}:
+				return nil
+			}
+		}
+		metricDNSFwdCacheMiss.Add(1)
 	}
 
 	fq := &forwardQuery{
 		txid:           getTxID(query.bs),
 		packet:         query.bs,
 		family:         query.family,
+		explicit:       explicit,
 		closeOnCtxDone: new(closePool),
 	}
 	defer fq.closeOnCtxDone.Close()
@@ -997,7 +1213,7 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 		f.logf("request(%d, %v, %d, %s) %d...", fq.txid, typ, len(domain), domainSig, len(fq.packet))
 	}
 
-	resc := make(chan []byte, 1) // it's fine buffered or not
+	resc := make(chan packet, 1) // it's fine buffered or not
 	errc := make(chan error, 1)  // it's fine buffered or not too
 	for i := range resolvers {
 		go func(rr *resolverAndDelay) {
@@ -1020,7 +1236,7 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 				return
 			}
 			select {
-			case resc <- resb:
+			case resc <- packet{bs: resb, upstream: rr.name.Addr}:
 			case <-ctx.Done():
 			}
 		}(&resolvers[i])
@@ -1031,13 +1247,37 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	for {
 		select {
 		case v := <-resc:
+			res, toCache := v.bs, v.bs
+			if validate {
+				validated, bogus := f.validateDNSSECResponse(ctx, suffix, resolvers, v.bs)
+				if bogus {
+					servfail, err := servfailResponse(clientQuery)
+					if err != nil {
+						return err
+					}
+					select {
+					case <-ctx.Done():
+						metricDNSFwdErrorContext.Add(1)
+						return fmt.Errorf("waiting to send SERVFAIL: %w", ctx.Err())
+					case responseChan <- servfail:
+						return nil
+					}
+				}
+				toCache = validated
+				if res, err = dnssecResponseForClient(validated, dnssecOpts); err != nil {
+					return err
+				}
+			}
 			select {
 			case <-ctx.Done():
 				metricDNSFwdErrorContext.Add(1)
 				return fmt.Errorf("waiting to send response: %w", ctx.Err())
-			case responseChan <- packet{v, query.family, query.addr}:
+			case responseChan <- packet{bs: res, family: query.family, addr: query.addr, upstream: v.upstream}:
 				if f.verboseFwd {
-					f.logf("response(%d, %v, %d) = %d, nil", fq.txid, typ, len(domain), len(v))
+					f.logf("response(%d, %v, %d) = %d, nil", fq.txid, typ, len(domain), len(res))
+				}
+				if useCache {
+					f.cache.put(cacheKey, toCache)
 				}
 				metricDNSFwdSuccess.Add(1)
 				f.health.SetHealthy(dnsForwarderFailing)
@@ -1050,7 +1290,7 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 			numErr++
 			if numErr == len(resolvers) {
 				if errors.Is(firstErr, errServerFailure) {
-					res, err := servfailResponse(query)
+					res, err := servfailResponse(clientQuery)
 					if err != nil {
 						f.logf("building servfail response: %v", err)
 						return firstErr
@@ -0,0 +1,293 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/dnsname"
+)
+
+// maxCNAMEChain is the maximum number of local CNAME records followed
+// when answering a query.
+const maxCNAMEChain = 8
+
+// LocalRecord is a node-local DNS record, which the Resolver answers
+// before looking up names in Hosts or forwarding queries.
+type LocalRecord struct {
+	Name  dnsname.FQDN
+	Type  dns.Type // dns.TypeA, dns.TypeAAAA, dns.TypeCNAME or dns.TypeTXT
+	Value string   // the IP address, target FQDN or text of the record
+}
+
+// ParseLocalRecord parses a record with the given name, type and value.
+// As in tailcfg.DNSRecord, an empty typ means A or AAAA, depending on the
+// value.
+func ParseLocalRecord(name, typ, value string) (LocalRecord, error) {
+	fqdn, err := dnsname.ToFQDN(strings.ToLower(name))
+	if err != nil {
+		return LocalRecord{}, err
+	}
+	rec := LocalRecord{Name: fqdn, Value: value}
+	switch typ := strings.ToUpper(typ); typ {
+	case "", "A", "AAAA":
+		ip, err := netip.ParseAddr(value)
+		if err != nil || ip.Zone() != "" {
+			return LocalRecord{}, fmt.Errorf("invalid IP address %q for %s", value, fqdn.WithoutTrailingDot())
+		}
+		ip = ip.Unmap()
+		rec.Type = dns.TypeA
+		if ip.Is6() {
+			rec.Type = dns.TypeAAAA
+		}
+		if typ != "" && typ != strings.TrimPrefix(rec.Type.String(), "Type") {
+			return LocalRecord{}, fmt.Errorf("%s record for %s has IP address %v", typ, fqdn.WithoutTrailingDot(), ip)
+		}
+		rec.Value = ip.String()
+	case "CNAME":
+		target, err := dnsname.ToFQDN(strings.ToLower(value))
+		if err != nil {
+			return LocalRecord{}, fmt.Errorf("invalid CNAME target %q for %s: %w", value, fqdn.WithoutTrailingDot(), err)
+		}
+		rec.Type = dns.TypeCNAME
+		rec.Value = string(target)
+	case "TXT":
+		if len(value) > 255 {
+			return LocalRecord{}, fmt.Errorf("TXT record for %s is longer than 255 bytes", fqdn.WithoutTrailingDot())
+		}
+		rec.Type = dns.TypeTXT
+	default:
+		return LocalRecord{}, fmt.Errorf("unsupported record type %q for %s", typ, fqdn.WithoutTrailingDot())
+	}
+	return rec, nil
+}
+
+// localRecordSet is the local records for a name.
+type localRecordSet struct {
+	ips   []netip.Addr
+	txt   []string
+	cname dnsname.FQDN // if non-empty, the other fields are empty
+}
+
+// compileLocalRecords groups recs by name. Invalid records are logged and
+// skipped.
+func compileLocalRecords(logf logger.Logf, recs []LocalRecord) map[dnsname.FQDN]*localRecordSet {
+	if len(recs) == 0 {
+		return nil
+	}
+	m := make(map[dnsname.FQDN]*localRecordSet, len(recs))
+	for _, rec := range recs {
+		set := m[rec.Name]
+		if set == nil {
+			set = new(localRecordSet)
+			m[rec.Name] = set
+		}
+		if set.cname != "" || (rec.Type == dns.TypeCNAME && (len(set.ips) > 0 || len(set.txt) > 0)) {
+			logf("ignoring local %v record for %v: a name with a CNAME record can't have other records", rec.Type, rec.Name)
+			continue
+		}
+		switch rec.Type {
+		case dns.TypeA, dns.TypeAAAA:
+			ip, err := netip.ParseAddr(rec.Value)
+			if err != nil {
+				logf("ignoring local %v record for %v: %v", rec.Type, rec.Name, err)
+				continue
+			}
+			set.ips = append(set.ips, ip)
+		case dns.TypeTXT:
+			set.txt = append(set.txt, rec.Value)
+		case dns.TypeCNAME:
+			set.cname = dnsname.FQDN(rec.Value)
+		default:
+			logf("ignoring local %v record for %v: unsupported type", rec.Type, rec.Name)
+		}
+	}
+	return m
+}
+
+// cnameLink is a CNAME record followed while answering a query.
+type cnameLink struct {
+	name, target dnsname.FQDN
+}
+
+// errForwardCNAME is returned by respond for a query for a name with a
+// local CNAME record whose target must be resolved upstream.
+type errForwardCNAME struct {
+	chain []cnameLink
+}
+
+func (e *errForwardCNAME) Error() string {
+	return fmt.Sprintf("CNAME target %v is not local", e.chain[len(e.chain)-1].target)
+}
+
+// respondLocalRecord returns the response to the query parsed by p for
+// name, and true, if name has local records. If name is an alias whose
+// target must be resolved upstream, it returns an *errForwardCNAME.
+func (r *Resolver) respondLocalRecord(p *dnsParser, name dnsname.FQDN) ([]byte, bool, error) {
+	r.mu.Lock()
+	records := r.localRecords
+	r.mu.Unlock()
+	set, ok := records[name]
+	if !ok {
+		return nil, false, nil
+	}
+	metricDNSResolveLocalRecord.Add(1)
+
+	typ := p.Question.Type
+	var chain []cnameLink
+	for set.cname != "" && typ != dns.TypeCNAME {
+		if len(chain) == maxCNAMEChain {
+			resp := p.response()
+			resp.Header.RCode = dns.RCodeServerFailure
+			res, err := marshalResponse(resp)
+			return res, true, err
+		}
+		chain = append(chain, cnameLink{name, set.cname})
+		name = set.cname
+		if set, ok = records[name]; !ok {
+			break
+		}
+	}
+
+	resp := p.response()
+	var ips []netip.Addr
+	switch {
+	case ok && set.cname != "":
+		// A query for the CNAME record itself.
+		resp.CNAME = set.cname.WithTrailingDot()
+	case ok:
+		ips = set.ips
+		if typ == dns.TypeTXT {
+			resp.TXT = set.txt
+		}
+	default:
+		// The end of the chain isn't a local record, but might be
+		// in Hosts.
+		ip, rcode := r.resolveLocal(name, typ)
+		if rcode == dns.RCodeRefused {
+			return nil, true, &errForwardCNAME{chain: chain}
+		}
+		resp.Header.RCode = rcode
+		if ip.IsValid() {
+			ips = []netip.Addr{ip}
+		}
+	}
+	for _, ip := range ips {
+		if typ == dns.TypeALL || (typ == dns.TypeA && ip.Is4()) || (typ == dns.TypeAAAA && ip.Is6()) {
+			resp.IPs = append(resp.IPs, ip)
+		}
+	}
+	res, err := marshalLocalResponse(resp, chain, name)
+	return res, true, err
+}
+
+// marshalLocalResponse serializes resp, whose records belong to name, as
+// the answer to a query that followed the CNAME records in chain to name.
+func marshalLocalResponse(resp *response, chain []cnameLink, name dnsname.FQDN) ([]byte, error) {
+	if len(chain) == 0 {
+		return marshalResponse(resp)
+	}
+	resp.Header.Response = true
+	resp.Header.Authoritative = true
+	resp.Header.RecursionAvailable = resp.Header.RecursionDesired
+	b := dns.NewBuilder(nil, resp.Header)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(resp.Question); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	for _, l := range chain {
+		owner, err := dns.NewName(l.name.WithTrailingDot())
+		if err != nil {
+			return nil, err
+		}
+		if err := marshalCNAME(owner, l.target.WithTrailingDot(), &b); err != nil {
+			return nil, err
+		}
+	}
+	owner, err := dns.NewName(name.WithTrailingDot())
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range resp.IPs {
+		if err := marshalIP(owner, ip, &b); err != nil {
+			return nil, err
+		}
+	}
+	if err := marshalTXT(owner, resp.TXT, &b); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// forwardCNAME answers the query q by forwarding a query of the same type
+// for the target of the local CNAME records in chain, and prepending them
+// to the upstream answer.
+func (r *Resolver) forwardCNAME(ctx context.Context, q []byte, family string, from netip.AddrPort, chain []cnameLink) (packet, error) {
+	var query dns.Message
+	if err := query.Unpack(q); err != nil {
+		return packet{}, err
+	}
+	question := query.Questions[0]
+	target, err := dns.NewName(chain[len(chain)-1].target.WithTrailingDot())
+	if err != nil {
+		return packet{}, err
+	}
+	query.Questions = []dns.Question{{Name: target, Type: question.Type, Class: question.Class}}
+	tq, err := query.Pack()
+	if err != nil {
+		return packet{}, err
+	}
+
+	responses := make(chan packet, 1)
+	ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	defer close(responses)
+	defer cancel()
+	if err := r.forwarder.forwardWithDestChan(ctx, packet{bs: tq, family: family, addr: from}, responses); err != nil {
+		return packet{}, err
+	}
+	res := <-responses
+
+	var msg dns.Message
+	if err := msg.Unpack(res.bs); err != nil {
+		return packet{}, err
+	}
+	answers := make([]dns.Resource, 0, len(chain)+len(msg.Answers))
+	for _, l := range chain {
+		owner, err := dns.NewName(l.name.WithTrailingDot())
+		if err != nil {
+			return packet{}, err
+		}
+		cname, err := dns.NewName(l.target.WithTrailingDot())
+		if err != nil {
+			return packet{}, err
+		}
+		answers = append(answers, dns.Resource{
+			Header: dns.ResourceHeader{
+				Name:  owner,
+				Type:  dns.TypeCNAME,
+				Class: dns.ClassINET,
+				TTL:   uint32(defaultTTL / time.Second),
+			},
+			Body: &dns.CNAMEResource{CNAME: cname},
+		})
+	}
+	msg.Answers = append(answers, msg.Answers...)
+	msg.Questions = []dns.Question{question}
+	msg.Additionals = nil
+	msg.AuthenticData = false // our CNAME records aren't signed
+	res.bs, err = msg.Pack()
+	return res, err
+}
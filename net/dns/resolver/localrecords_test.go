@@ -0,0 +1,135 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"testing"
+
+	miekdns "github.com/miekg/dns"
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+)
+
+func TestParseLocalRecord(t *testing.T) {
+	tests := []struct {
+		name, typ, value string
+		want             LocalRecord
+		wantErr          bool
+	}{
+		{name: "a.example", value: "192.0.2.1", want: LocalRecord{"a.example.", dns.TypeA, "192.0.2.1"}},
+		{name: "A.Example.", typ: "a", value: "192.0.2.1", want: LocalRecord{"a.example.", dns.TypeA, "192.0.2.1"}},
+		{name: "a.example", value: "2001:db8::1", want: LocalRecord{"a.example.", dns.TypeAAAA, "2001:db8::1"}},
+		{name: "a.example", typ: "AAAA", value: "::ffff:192.0.2.1", wantErr: true},
+		{name: "a.example", typ: "A", value: "2001:db8::1", wantErr: true},
+		{name: "a.example", value: "not-an-ip", wantErr: true},
+		{name: "a.example", typ: "CNAME", value: "B.example", want: LocalRecord{"a.example.", dns.TypeCNAME, "b.example."}},
+		{name: "a.example", typ: "TXT", value: "hello", want: LocalRecord{"a.example.", dns.TypeTXT, "hello"}},
+		{name: "a.example", typ: "MX", value: "b.example", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLocalRecord(tt.name, tt.typ, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLocalRecord(%q, %q, %q) error = %v; want error %v", tt.name, tt.typ, tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLocalRecord(%q, %q, %q) = %+v; want %+v", tt.name, tt.typ, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLocalRecords(t *testing.T) {
+	server := serveDNS(t, "127.0.0.1:0", "upstream.site.", resolveToIP(testipv4, testipv6, "dns.test.site."))
+	defer server.Shutdown()
+
+	r := newResolver(t)
+	defer r.Close()
+	cfg := dnsCfg
+	cfg.Routes = map[dnsname.FQDN][]*dnstype.Resolver{
+		".": {{Addr: server.PacketConn.LocalAddr().String()}},
+	}
+	must := func(name, typ, value string) LocalRecord {
+		rec, err := ParseLocalRecord(name, typ, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	cfg.LocalRecords = []LocalRecord{
+		must("printer.lan", "", "192.168.1.10"),
+		must("printer.lan", "", "fd00::10"),
+		must("printer.lan", "TXT", "model=inkjet"),
+		must("test1.ipn.dev", "", "192.168.1.11"), // overrides Hosts
+		must("alias.lan", "CNAME", "printer.lan"),
+		must("magic.lan", "CNAME", "test2.ipn.dev"),
+		must("missing.lan", "CNAME", "nope.ipn.dev"),
+		must("away.lan", "CNAME", "upstream.site"),
+		must("loop1.lan", "CNAME", "loop2.lan"),
+		must("loop2.lan", "CNAME", "loop1.lan"),
+	}
+	r.SetConfig(cfg)
+
+	tests := []struct {
+		qname  dnsname.FQDN
+		qtype  dns.Type
+		rcode  int
+		answer []string // in dig's format, without the TTL
+	}{
+		{"printer.lan.", dns.TypeA, miekdns.RcodeSuccess, []string{"printer.lan. IN A 192.168.1.10"}},
+		{"printer.lan.", dns.TypeAAAA, miekdns.RcodeSuccess, []string{"printer.lan. IN AAAA fd00::10"}},
+		{"printer.lan.", dns.TypeTXT, miekdns.RcodeSuccess, []string{`printer.lan. IN TXT "model=inkjet"`}},
+		{"printer.lan.", dns.TypeMX, miekdns.RcodeSuccess, nil},
+		{"test1.ipn.dev.", dns.TypeA, miekdns.RcodeSuccess, []string{"test1.ipn.dev. IN A 192.168.1.11"}},
+		{"alias.lan.", dns.TypeA, miekdns.RcodeSuccess, []string{
+			"alias.lan. IN CNAME printer.lan.",
+			"printer.lan. IN A 192.168.1.10",
+		}},
+		{"alias.lan.", dns.TypeCNAME, miekdns.RcodeSuccess, []string{"alias.lan. IN CNAME printer.lan."}},
+		{"magic.lan.", dns.TypeAAAA, miekdns.RcodeSuccess, []string{
+			"magic.lan. IN CNAME test2.ipn.dev.",
+			"test2.ipn.dev. IN AAAA 1:203:405:607:809:a0b:c0d:e0f",
+		}},
+		{"missing.lan.", dns.TypeA, miekdns.RcodeNameError, []string{"missing.lan. IN CNAME nope.ipn.dev."}},
+		{"away.lan.", dns.TypeA, miekdns.RcodeSuccess, []string{
+			"away.lan. IN CNAME upstream.site.",
+			"upstream.site. IN A 1.2.3.4",
+		}},
+		{"loop1.lan.", dns.TypeA, miekdns.RcodeServerFailure, nil},
+	}
+	for _, tt := range tests {
+		res, err := r.Query(context.Background(), dnspacket(tt.qname, tt.qtype, noEdns), "udp", netip.AddrPort{})
+		if err != nil {
+			t.Errorf("%v %v: %v", tt.qname, tt.qtype, err)
+			continue
+		}
+		var m miekdns.Msg
+		if err := m.Unpack(res); err != nil {
+			t.Fatal(err)
+		}
+		if m.Rcode != tt.rcode {
+			t.Errorf("%v %v: rcode = %v; want %v", tt.qname, tt.qtype, miekdns.RcodeToString[m.Rcode], miekdns.RcodeToString[tt.rcode])
+		}
+		var answer []string
+		for _, rr := range m.Answer {
+			rr.Header().Ttl = 0
+			answer = append(answer, rr.String())
+		}
+		want := make([]string, len(tt.answer))
+		for i, a := range tt.answer {
+			rr, err := miekdns.NewRR(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr.Header().Ttl = 0
+			want[i] = rr.String()
+		}
+		if !slices.Equal(answer, want) {
+			t.Errorf("%v %v: answer = %q; want %q", tt.qname, tt.qtype, answer, want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/set"
+)
+
+const (
+	// queryLogSize is the number of most recent queries kept in the
+	// query log.
+	queryLogSize = 512
+
+	// queryLogWatchBuffer is the number of entries buffered for each
+	// query log watcher before new entries are dropped for it.
+	queryLogWatchBuffer = 64
+)
+
+// QueryLogEntry is a DNS query answered by the Resolver, as recorded in its
+// query log.
+type QueryLogEntry struct {
+	Time time.Time // when the query was received
+	Name dnsname.FQDN
+	Type dns.Type
+
+	// From is the address that sent the query, and FromName its MagicDNS
+	// name if it is a known Tailscale node.
+	From     netip.AddrPort
+	FromName dnsname.FQDN
+
+	// Forwarded is whether the query was forwarded, rather than answered
+	// from the netmap or dropped locally. If so, Upstream is the address
+	// of the resolver that answered it, or empty if no resolver did, and
+	// Cached is whether the answer came from the response cache.
+	Forwarded bool
+	Upstream  string
+	Cached    bool
+
+	RCode   dns.RCode     // of the response; meaningless if Err is set
+	Err     string        // error resolving the query, if any
+	Latency time.Duration // from receiving the query to responding
+}
+
+// queryLog is a ring buffer of the most recent queries answered by the
+// Resolver, along with any watchers of new queries.
+//
+// Queries are recorded only while the log is enabled or being watched.
+type queryLog struct {
+	mu       sync.Mutex
+	enabled  bool
+	entries  []QueryLogEntry // ring buffer of up to queryLogSize entries
+	next     int             // index in entries of the next entry to overwrite, once full
+	watchers set.Set[chan QueryLogEntry]
+}
+
+// active reports whether queries should be recorded in the log.
+// It is cheap, so callers can avoid building entries that won't be used.
+func (l *queryLog) active() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enabled || len(l.watchers) > 0
+}
+
+// setEnabled sets whether queries are kept in the log when no one is
+// watching it. Disabling the log clears it.
+func (l *queryLog) setEnabled(v bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enabled = v
+	if !v {
+		l.entries = nil
+		l.next = 0
+	}
+}
+
+// isEnabled reports whether the log is enabled.
+func (l *queryLog) isEnabled() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enabled
+}
+
+// add records e in the log and sends it to all watchers. Watchers that
+// aren't keeping up miss entries rather than blocking the resolver.
+func (l *queryLog) add(e QueryLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.enabled {
+		if len(l.entries) < queryLogSize {
+			l.entries = append(l.entries, e)
+		} else {
+			l.entries[l.next] = e
+			l.next = (l.next + 1) % queryLogSize
+		}
+	}
+	for ch := range l.watchers {
+		select {
+		case ch <- e:
+		default:
+			metricDNSQueryLogWatchDropped.Add(1)
+		}
+	}
+}
+
+// snapshotLocked returns the entries in the log, from oldest to newest.
+// l.mu must be held.
+func (l *queryLog) snapshotLocked() []QueryLogEntry {
+	ret := make([]QueryLogEntry, 0, len(l.entries))
+	ret = append(ret, l.entries[l.next:]...)
+	return append(ret, l.entries[:l.next]...)
+}
+
+// snapshot returns the entries in the log, from oldest to newest.
+func (l *queryLog) snapshot() []QueryLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.snapshotLocked()
+}
+
+// watch returns the entries currently in the log, from oldest to newest,
+// and a channel of entries added after them. The caller must call stop
+// when done watching, after which the channel receives no more entries.
+func (l *queryLog) watch() (backlog []QueryLogEntry, ch <-chan QueryLogEntry, stop func()) {
+	c := make(chan QueryLogEntry, queryLogWatchBuffer)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.watchers.Make()
+	l.watchers.Add(c)
+	stop = func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.watchers.Delete(c)
+	}
+	return l.snapshotLocked(), c, stop
+}
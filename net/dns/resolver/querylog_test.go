@@ -0,0 +1,146 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	miekdns "github.com/miekg/dns"
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+)
+
+func TestQueryLogRing(t *testing.T) {
+	var l queryLog
+	if l.active() {
+		t.Fatal("new query log is active")
+	}
+	l.add(QueryLogEntry{Name: "dropped."})
+	if got := l.snapshot(); len(got) != 0 {
+		t.Fatalf("disabled log kept %d entries", len(got))
+	}
+
+	l.setEnabled(true)
+	const n = queryLogSize + 10
+	for i := range n {
+		l.add(QueryLogEntry{Name: dnsname.FQDN(fmt.Sprintf("q%d.", i))})
+	}
+	got := l.snapshot()
+	if len(got) != queryLogSize {
+		t.Fatalf("got %d entries; want %d", len(got), queryLogSize)
+	}
+	for i, e := range got {
+		if want := dnsname.FQDN(fmt.Sprintf("q%d.", n-queryLogSize+i)); e.Name != want {
+			t.Fatalf("entry %d = %q; want %q", i, e.Name, want)
+		}
+	}
+
+	l.setEnabled(false)
+	if got := l.snapshot(); len(got) != 0 {
+		t.Errorf("disabling the log kept %d entries", len(got))
+	}
+}
+
+func TestQueryLogWatch(t *testing.T) {
+	var l queryLog
+	backlog, ch, stop := l.watch()
+	if len(backlog) != 0 {
+		t.Errorf("backlog = %v; want empty", backlog)
+	}
+	if !l.active() {
+		t.Fatal("watched query log is not active")
+	}
+
+	// Watchers that don't keep up miss entries instead of blocking.
+	for i := range queryLogWatchBuffer + 5 {
+		l.add(QueryLogEntry{Name: dnsname.FQDN(fmt.Sprintf("q%d.", i))})
+	}
+	if got := len(ch); got != queryLogWatchBuffer {
+		t.Errorf("watcher got %d entries; want %d", got, queryLogWatchBuffer)
+	}
+	if e := <-ch; e.Name != "q0." {
+		t.Errorf("first entry = %q; want q0.", e.Name)
+	}
+
+	stop()
+	if l.active() {
+		t.Error("query log still active after stop")
+	}
+}
+
+func TestQueryLogResolver(t *testing.T) {
+	// Answer with a non-zero TTL, so that the response is cached.
+	server := serveDNS(t, "127.0.0.1:0", "test.site.", miekdns.HandlerFunc(func(w miekdns.ResponseWriter, req *miekdns.Msg) {
+		m := new(miekdns.Msg)
+		m.SetReply(req)
+		m.Answer = append(m.Answer, &miekdns.A{
+			Hdr: miekdns.RR_Header{
+				Name:   req.Question[0].Name,
+				Rrtype: miekdns.TypeA,
+				Class:  miekdns.ClassINET,
+				Ttl:    60,
+			},
+			A: testipv4.AsSlice(),
+		})
+		w.WriteMsg(m)
+	}))
+	defer server.Shutdown()
+	upstream := server.PacketConn.LocalAddr().String()
+
+	r := newResolver(t)
+	defer r.Close()
+	cfg := dnsCfg
+	cfg.Routes = map[dnsname.FQDN][]*dnstype.Resolver{
+		".": {{Addr: upstream}},
+	}
+	r.SetConfig(cfg)
+
+	// Queries aren't recorded until the log is enabled.
+	from := netip.AddrPortFrom(testipv4, 12345)
+	if _, err := r.Query(context.Background(), dnspacket("test1.ipn.dev.", dns.TypeA, noEdns), "udp", from); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.QueryLog(); len(got) != 0 {
+		t.Fatalf("disabled query log has %d entries", len(got))
+	}
+
+	r.SetQueryLogEnabled(true)
+	for _, name := range []dnsname.FQDN{"test1.ipn.dev.", "nope.ipn.dev.", "test.site.", "test.site."} {
+		if _, err := r.Query(context.Background(), dnspacket(name, dns.TypeA, noEdns), "udp", from); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type result struct {
+		Name      dnsname.FQDN
+		FromName  dnsname.FQDN
+		Forwarded bool
+		Upstream  string
+		Cached    bool
+		RCode     dns.RCode
+	}
+	want := []result{
+		{"test1.ipn.dev.", "test1.ipn.dev.", false, "", false, dns.RCodeSuccess},
+		{"nope.ipn.dev.", "test1.ipn.dev.", false, "", false, dns.RCodeNameError},
+		{"test.site.", "test1.ipn.dev.", true, upstream, false, dns.RCodeSuccess},
+		{"test.site.", "test1.ipn.dev.", true, "", true, dns.RCodeSuccess},
+	}
+	got := r.QueryLog()
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries; want %d: %+v", len(got), len(want), got)
+	}
+	for i, e := range got {
+		if e.Type != dns.TypeA || e.From != from || e.Err != "" || e.Time.IsZero() {
+			t.Errorf("entry %d = %+v", i, e)
+		}
+		res := result{e.Name, e.FromName, e.Forwarded, e.Upstream, e.Cached, e.RCode}
+		if res != want[i] {
+			t.Errorf("entry %d = %+v; want %+v", i, res, want[i])
+		}
+	}
+}
@@ -60,12 +60,21 @@ type packet struct {
 	bs     []byte
 	family string         // either "tcp" or "udp"
 	addr   netip.AddrPort // src for a request, dst for a response
+
+	// For responses to forwarded queries, upstream is the Addr of the
+	// resolver that answered, and cached is whether the answer came from
+	// the response cache instead.
+	upstream string
+	cached   bool
 }
 
 // Config is a resolver configuration.
 // Given a Config, queries are resolved in the following order:
-// If the query is an exact match for an entry in LocalHosts, return that.
+// If the query is an exact match for an entry in LocalRecords, return that.
+// Else if the query is an exact match for an entry in LocalHosts, return that.
 // Else if the query suffix matches an entry in LocalDomains, return NXDOMAIN.
+// Else if the query is blocked by one of the Blocklists, return an
+// unspecified address.
 // Else forward the query to the most specific matching entry in Routes.
 // Else return SERVFAIL.
 type Config struct {
@@ -79,6 +88,21 @@ type Config struct {
 	// LocalDomains is a list of DNS name suffixes that should not be
 	// routed to upstream resolvers.
 	LocalDomains []dnsname.FQDN
+	// LocalRecords are node-local static records, which take precedence
+	// over Hosts.
+	LocalRecords []LocalRecord
+	// Blocklists are the paths of files listing blocked domains, in hosts
+	// file or adblock list format. They are reread if they have changed,
+	// by SetConfig and every 30 seconds as queries come in.
+	Blocklists []string
+	// ValidateDNSSEC is whether to validate the DNSSEC signatures of
+	// responses to queries forwarded using Routes, answering SERVFAIL
+	// instead of responses that fail validation.
+	ValidateDNSSEC bool
+	// DNSSECExemptDomains are DNS name suffixes for which responses are
+	// not validated even if ValidateDNSSEC is set, such as the suffixes
+	// of routes to internal zones that aren't signed.
+	DNSSECExemptDomains []dnsname.FQDN
 }
 
 // WriteToBufioWriter write a debug version of c for logs to w, omitting
@@ -86,7 +110,14 @@ type Config struct {
 func (c *Config) WriteToBufioWriter(w *bufio.Writer) {
 	w.WriteString("{Routes:")
 	WriteRoutes(w, c.Routes)
-	fmt.Fprintf(w, " Hosts:%v LocalDomains:[", len(c.Hosts))
+	fmt.Fprintf(w, " Hosts:%v", len(c.Hosts))
+	if len(c.LocalRecords) > 0 {
+		fmt.Fprintf(w, " LocalRecords:%v", len(c.LocalRecords))
+	}
+	if len(c.Blocklists) > 0 {
+		fmt.Fprintf(w, " Blocklists:%v", c.Blocklists)
+	}
+	w.WriteString(" LocalDomains:[")
 	space := false
 	arpa := 0
 	for _, d := range c.LocalDomains {
@@ -104,6 +135,9 @@ func (c *Config) WriteToBufioWriter(w *bufio.Writer) {
 	if arpa > 0 {
 		fmt.Fprintf(w, "+%darpa", arpa)
 	}
+	if c.ValidateDNSSEC {
+		fmt.Fprintf(w, " ValidateDNSSEC:true DNSSECExemptDomains:%v", c.DNSSECExemptDomains)
+	}
 	if c := cloudenv.Get(); c != "" {
 		fmt.Fprintf(w, ", cloud=%q", string(c))
 	}
@@ -209,6 +243,8 @@ type Resolver struct {
 	saveConfigForTests func(cfg Config) // used in tests to capture resolver config
 	// forwarder forwards requests to upstream nameservers.
 	forwarder *forwarder
+	// queryLog records queries answered by Query.
+	queryLog queryLog
 
 	// closed signals all goroutines to stop.
 	closed chan struct{}
@@ -218,6 +254,12 @@ type Resolver struct {
 	localDomains []dnsname.FQDN
 	hostToIP     map[dnsname.FQDN][]netip.Addr
 	ipToHost     map[netip.Addr]dnsname.FQDN
+	localRecords map[dnsname.FQDN]*localRecordSet
+	blocklists   []*blocklistFile
+
+	blocklistPaths      []string  // from Config.Blocklists
+	blocklistsChecked   time.Time // when blocklists were last loaded
+	blocklistsReloading bool      // whether reloadBlocklists is running
 }
 
 type ForwardLinkSelector interface {
@@ -271,13 +313,28 @@ func (r *Resolver) SetConfig(cfg Config) error {
 		}
 	}
 
+	localRecords := compileLocalRecords(r.logf, cfg.LocalRecords)
+	r.mu.Lock()
+	prevBlocklists := r.blocklists
+	r.mu.Unlock()
+	blocklists := r.loadBlocklists(cfg.Blocklists, prevBlocklists, false)
+
 	r.forwarder.setRoutes(cfg.Routes)
+	r.forwarder.setDNSSEC(cfg.ValidateDNSSEC, cfg.DNSSECExemptDomains)
+	// Any change to the DNS configuration (including the netmap hosts)
+	// may change what upstream answers are valid, so start afresh.
+	r.forwarder.cache.flush()
+	r.forwarder.dnssec.flush()
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.localDomains = cfg.LocalDomains
 	r.hostToIP = cfg.Hosts
 	r.ipToHost = reverse
+	r.localRecords = localRecords
+	r.blocklists = blocklists
+	r.blocklistPaths = cfg.Blocklists
+	r.blocklistsChecked = time.Now()
 	return nil
 }
 
@@ -315,20 +372,116 @@ func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from net
 	default:
 	}
 
+	if r.queryLog.active() {
+		return r.queryAndLog(ctx, bs, family, from)
+	}
+	res, _, err := r.query(ctx, bs, family, from)
+	return res.bs, err
+}
+
+// query answers the DNS query bs, either locally or by forwarding it, and
+// reports which.
+func (r *Resolver) query(ctx context.Context, bs []byte, family string, from netip.AddrPort) (res packet, forwarded bool, err error) {
 	out, err := r.respond(bs)
+	if fwd, ok := err.(*errForwardCNAME); ok {
+		res, err := r.forwardCNAME(ctx, bs, family, from, fwd.chain)
+		return res, true, err
+	}
 	if err == errNotOurName {
 		responses := make(chan packet, 1)
 		ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
 		defer close(responses)
 		defer cancel()
-		err = r.forwarder.forwardWithDestChan(ctx, packet{bs, family, from}, responses)
+		err = r.forwarder.forwardWithDestChan(ctx, packet{bs: bs, family: family, addr: from}, responses)
 		if err != nil {
-			return nil, err
+			return packet{}, true, err
 		}
-		return (<-responses).bs, nil
+		return <-responses, true, nil
+	}
+
+	return packet{bs: out, family: family, addr: from}, false, err
+}
+
+// queryAndLog is like Query, but records the query in r.queryLog.
+func (r *Resolver) queryAndLog(ctx context.Context, bs []byte, family string, from netip.AddrPort) ([]byte, error) {
+	start := time.Now()
+	res, forwarded, err := r.query(ctx, bs, family, from)
+	e := QueryLogEntry{
+		Time:      start,
+		From:      from,
+		Forwarded: forwarded,
+		Upstream:  res.upstream,
+		Cached:    res.cached,
+		Latency:   time.Since(start),
+	}
+	e.Name, e.Type, _ = nameFromQuery(bs)
+	if err != nil {
+		e.Err = err.Error()
+	} else {
+		e.RCode = getRCode(res.bs)
 	}
+	r.mu.Lock()
+	e.FromName = r.ipToHost[from.Addr()]
+	r.mu.Unlock()
+	r.queryLog.add(e)
+	return res.bs, err
+}
 
-	return out, err
+// CacheStatus returns a snapshot of the cache of upstream DNS responses.
+func (r *Resolver) CacheStatus() CacheStatus {
+	if !buildfeatures.HasDNS {
+		return CacheStatus{}
+	}
+	return CacheStatus{
+		Entries: r.forwarder.cache.entries(),
+		Hits:    metricDNSFwdCacheHit.Value(),
+		Misses:  metricDNSFwdCacheMiss.Value(),
+	}
+}
+
+// FlushCache removes all entries from the cache of upstream DNS responses.
+func (r *Resolver) FlushCache() {
+	if !buildfeatures.HasDNS {
+		return
+	}
+	r.forwarder.cache.flush()
+}
+
+// SetQueryLogEnabled sets whether the most recent queries answered by
+// Query are kept in the query log. Disabling it clears the log. Even when
+// disabled, queries are still streamed to any watchers of the log.
+func (r *Resolver) SetQueryLogEnabled(v bool) {
+	if !buildfeatures.HasDNS {
+		return
+	}
+	r.queryLog.setEnabled(v)
+}
+
+// QueryLogEnabled reports whether the query log is enabled.
+func (r *Resolver) QueryLogEnabled() bool {
+	if !buildfeatures.HasDNS {
+		return false
+	}
+	return r.queryLog.isEnabled()
+}
+
+// QueryLog returns the entries of the query log, from oldest to newest.
+func (r *Resolver) QueryLog() []QueryLogEntry {
+	if !buildfeatures.HasDNS {
+		return nil
+	}
+	return r.queryLog.snapshot()
+}
+
+// WatchQueryLog returns the entries of the query log, from oldest to
+// newest, and a channel of the queries answered by Query after them. If
+// the caller doesn't keep up with the channel, it misses entries. The
+// caller must call stop when done watching.
+func (r *Resolver) WatchQueryLog() (backlog []QueryLogEntry, entries <-chan QueryLogEntry, stop func()) {
+	if !buildfeatures.HasDNS {
+		return nil, nil, func() {}
+	}
+	return r.queryLog.watch()
 }
 
 // GetUpstreamResolvers returns the resolvers that would be used to resolve
@@ -416,7 +569,7 @@ func (r *Resolver) HandlePeerDNSQuery(ctx context.Context, q []byte, from netip.
 			}}
 		}
 
-		err = r.forwarder.forwardWithDestChan(ctx, packet{q, "tcp", from}, ch, resolvers...)
+		err = r.forwarder.forwardWithDestChan(ctx, packet{bs: q, family: "tcp", addr: from}, ch, resolvers...)
 		if err != nil {
 			metricDNSExitProxyErrorForward.Add(1)
 			return nil, err
@@ -1307,8 +1460,16 @@ func (r *Resolver) respond(query []byte) ([]byte, error) {
 		return r.respondReverse(query, name, parser.response())
 	}
 
+	if res, ok, err := r.respondLocalRecord(parser, name); ok {
+		return res, err
+	}
+
 	ip, rcode := r.resolveLocal(name, parser.Question.Type)
 	if rcode == dns.RCodeRefused {
+		if r.isBlocked(name) {
+			metricDNSQueryBlocked.Add(1)
+			return sinkholeResponse(parser)
+		}
 		return nil, errNotOurName // sentinel error return value: it requests forwarding
 	}
 
@@ -1373,6 +1534,16 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdErrorContext         = clientmetric.NewCounter("dns_query_fwd_error_context")
 	metricDNSFwdErrorContextGotError = clientmetric.NewCounter("dns_query_fwd_error_context_got_error")
 
+	metricDNSQueryLogWatchDropped = clientmetric.NewCounter("dns_query_log_watch_dropped")
+
+	metricDNSFwdDNSSECSecure   = clientmetric.NewCounter("dns_query_fwd_dnssec_secure")
+	metricDNSFwdDNSSECInsecure = clientmetric.NewCounter("dns_query_fwd_dnssec_insecure")
+	metricDNSFwdDNSSECBogus    = clientmetric.NewCounter("dns_query_fwd_dnssec_bogus")
+	metricDNSFwdDNSSECError    = clientmetric.NewCounter("dns_query_fwd_dnssec_error")
+
+	metricDNSFwdCacheHit  = clientmetric.NewCounter("dns_query_fwd_cache_hit")
+	metricDNSFwdCacheMiss = clientmetric.NewCounter("dns_query_fwd_cache_miss")
+
 	metricDNSFwdErrorType = clientmetric.NewCounter("dns_query_fwd_error_type")
 	metricDNSFwdTruncated = clientmetric.NewCounter("dns_query_fwd_truncated")
 
@@ -1398,7 +1569,15 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdDoHErrorTransport = clientmetric.NewCounter("dns_query_fwd_doh_error_transport")
 	metricDNSFwdDoHErrorBody      = clientmetric.NewCounter("dns_query_fwd_doh_error_body")
 
+	metricDNSFwdDoT               = clientmetric.NewCounter("dns_query_fwd_dot")
+	metricDNSFwdDoTErrorTransport = clientmetric.NewCounter("dns_query_fwd_dot_error_transport")
+	metricDNSFwdDoTErrorServer    = clientmetric.NewCounter("dns_query_fwd_dot_error_server")
+	metricDNSFwdDoTErrorTxID      = clientmetric.NewCounter("dns_query_fwd_dot_error_txid")
+	metricDNSFwdDoTSuccess        = clientmetric.NewCounter("dns_query_fwd_dot_success")
+
 	metricDNSResolveLocal             = clientmetric.NewCounter("dns_resolve_local")
+	metricDNSResolveLocalRecord       = clientmetric.NewCounter("dns_resolve_local_record")
+	metricDNSQueryBlocked             = clientmetric.NewCounter("dns_query_blocked")
 	metricDNSResolveLocalErrorOnion   = clientmetric.NewCounter("dns_resolve_local_error_onion")
 	metricDNSResolveLocalErrorMissing = clientmetric.NewCounter("dns_resolve_local_error_missing")
 	metricDNSResolveLocalErrorRefused = clientmetric.NewCounter("dns_resolve_local_error_refused")
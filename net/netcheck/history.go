@@ -0,0 +1,167 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/types/logger"
+)
+
+// historyInterval is how often a History records a report when nothing
+// notable has changed since the last one it recorded.
+const historyInterval = 5 * time.Minute
+
+// historyWriteDelay is how long a History waits after recording a report
+// before persisting it, so that a burst of reports is written once.
+const historyWriteDelay = 30 * time.Second
+
+// History is a bounded, optionally persisted, history of netcheck reports,
+// used to diagnose how a device's network conditions change over time.
+//
+// To keep the history spanning a useful period of time, and to avoid
+// writing to disk on every netcheck, it only records a report if it differs
+// notably from the last one recorded, or if historyInterval has passed
+// since then. Recorded reports are persisted in the background, at most
+// every historyWriteDelay.
+//
+// It is safe for concurrent use.
+type History struct {
+	logf logger.Logf
+	path string // or empty to not persist
+	max  int
+
+	writeMu sync.Mutex // serializes writes to path, so the last one wins
+
+	mu         sync.Mutex
+	reports    []*Report   // oldest first; len <= max
+	dirty      bool        // whether reports changed since last persisted
+	writeTimer *time.Timer // pending write of reports, or nil
+}
+
+// NewHistory returns a new History holding at most max reports, which must
+// be positive, persisted to the file at path unless it's empty. Errors
+// persisting the reports are logged to logf. It doesn't read the file; see
+// Load.
+func NewHistory(logf logger.Logf, path string, max int) *History {
+	return &History{logf: logf, path: path, max: max}
+}
+
+// Load loads the reports persisted by a previous History using the same
+// file, replacing any already in h. It's not an error if the file doesn't
+// exist.
+func (h *History) Load() error {
+	if h.path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(h.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var reports []*Report
+	if err := json.Unmarshal(b, &reports); err != nil {
+		return err
+	}
+	if len(reports) > h.max {
+		reports = reports[len(reports)-h.max:]
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reports = reports
+	return nil
+}
+
+// Add records r, if it's notable, evicting the oldest report if h is full,
+// and schedules persisting the history. It reports whether r was recorded.
+//
+// r must not be modified after Add is called.
+func (h *History) Add(r *Report) bool {
+	if r == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if n := len(h.reports); n > 0 {
+		last := h.reports[n-1]
+		if r.Now.Sub(last.Now) < historyInterval && !notableChange(last, r) {
+			return false
+		}
+	}
+	if len(h.reports) >= h.max {
+		h.reports = append(h.reports[:0], h.reports[len(h.reports)-h.max+1:]...)
+	}
+	h.reports = append(h.reports, r)
+	if h.path != "" {
+		h.dirty = true
+		if h.writeTimer == nil {
+			h.writeTimer = time.AfterFunc(historyWriteDelay, func() {
+				if err := h.Flush(); err != nil {
+					h.logf("netcheck: persisting history: %v", err)
+				}
+			})
+		}
+	}
+	return true
+}
+
+// Flush persists the reports recorded since they were last persisted, if
+// any, without waiting for the pending background write.
+func (h *History) Flush() error {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	h.mu.Lock()
+	if h.writeTimer != nil {
+		h.writeTimer.Stop()
+		h.writeTimer = nil
+	}
+	if !h.dirty {
+		h.mu.Unlock()
+		return nil
+	}
+	h.dirty = false
+	b, err := json.Marshal(h.reports)
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(h.path, b, 0600)
+}
+
+// Reports returns the recorded reports, oldest first. The caller must not
+// modify them.
+func (h *History) Reports() []*Report {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*Report(nil), h.reports...)
+}
+
+// notableChange reports whether b differs from a in a way worth recording
+// in a History: a change in UDP or IP family availability, NAT type,
+// public IPv4 mapping, preferred DERP region or captive portal detection.
+// Behind a NAT whose mapping varies by destination, the mapped port is
+// expected to change, so only a change of address counts.
+func notableChange(a, b *Report) bool {
+	if a.GlobalV4.Addr() != b.GlobalV4.Addr() {
+		return true
+	}
+	if a.GlobalV4.Port() != b.GlobalV4.Port() && !b.MappingVariesByDestIP.EqualBool(true) {
+		return true
+	}
+	return a.UDP != b.UDP ||
+		a.IPv4 != b.IPv4 ||
+		a.IPv6 != b.IPv6 ||
+		a.MappingVariesByDestIP != b.MappingVariesByDestIP ||
+		a.PreferredDERP != b.PreferredDERP ||
+		a.CaptivePortal != b.CaptivePortal
+}
@@ -0,0 +1,94 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"errors"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tailscale.com/types/opt"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	h := NewHistory(t.Logf, path, 3)
+	if err := h.Load(); err != nil {
+		t.Fatalf("Load of missing file: %v", err)
+	}
+
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	easy := opt.NewBool(false)
+	hard := opt.NewBool(true)
+	report := func(d time.Duration, natType opt.Bool, global string) *Report {
+		return &Report{
+			Now:                   t0.Add(d),
+			UDP:                   true,
+			IPv4:                  true,
+			MappingVariesByDestIP: natType,
+			GlobalV4:              netip.MustParseAddrPort(global),
+			PreferredDERP:         1,
+			RegionLatency:         map[int]time.Duration{1: 10 * time.Millisecond},
+		}
+	}
+
+	tests := []struct {
+		name string
+		r    *Report
+		want bool
+	}{
+		{"first", report(0, easy, "1.2.3.4:1000"), true},
+		{"unchanged", report(time.Minute, easy, "1.2.3.4:1000"), false},
+		{"interval-passed", report(historyInterval, easy, "1.2.3.4:1000"), true},
+		{"port-changed", report(historyInterval+time.Minute, easy, "1.2.3.4:2000"), true},
+		{"nat-type-changed", report(historyInterval+2*time.Minute, hard, "1.2.3.4:2000"), true},
+		{"hard-nat-port-changed", report(historyInterval+3*time.Minute, hard, "1.2.3.4:3000"), false},
+		{"addr-changed", report(historyInterval+4*time.Minute, hard, "5.6.7.8:3000"), true},
+	}
+	for _, tt := range tests {
+		if got := h.Add(tt.r); got != tt.want {
+			t.Errorf("%s: Add = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+
+	wantTimes := []time.Time{
+		tests[3].r.Now,
+		tests[4].r.Now,
+		tests[6].r.Now,
+	}
+	checkTimes := func(what string, reports []*Report) {
+		t.Helper()
+		if len(reports) != len(wantTimes) {
+			t.Fatalf("%s: got %d reports; want %d", what, len(reports), len(wantTimes))
+		}
+		for i, r := range reports {
+			if !r.Now.Equal(wantTimes[i]) {
+				t.Errorf("%s: report %d at %v; want %v", what, i, r.Now, wantTimes[i])
+			}
+		}
+	}
+	checkTimes("Reports", h.Reports())
+
+	// The reports are persisted in the background, not by Add.
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("history file written by Add: %v", err)
+	}
+	if err := h.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	h2 := NewHistory(t.Logf, path, 2)
+	if err := h2.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	wantTimes = wantTimes[1:]
+	checkTimes("loaded Reports", h2.Reports())
+	if got := h2.Reports()[1].GlobalV4; got != netip.MustParseAddrPort("5.6.7.8:3000") {
+		t.Errorf("loaded GlobalV4 = %v; want 5.6.7.8:3000", got)
+	}
+}
@@ -0,0 +1,366 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"tailscale.com/net/netns"
+	"tailscale.com/net/stun"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/nettype"
+)
+
+// NATBehavior is a NAT's mapping or filtering behavior, as classified by
+// RFC 4787.
+type NATBehavior string
+
+const (
+	// NATEndpointIndependent means the NAT maps (or lets in packets
+	// from) every remote IP address and port alike.
+	NATEndpointIndependent NATBehavior = "endpoint-independent"
+
+	// NATAddressDependent means the NAT maps (or filters) packets
+	// differently per remote IP address, but not per remote port.
+	NATAddressDependent NATBehavior = "address-dependent"
+
+	// NATAddressAndPortDependent means the NAT maps (or filters)
+	// packets differently per remote IP address and port. Direct
+	// connections to peers behind such a NAT are the hardest to make.
+	NATAddressAndPortDependent NATBehavior = "address-and-port-dependent"
+)
+
+// short returns a short form of b for concise logging.
+func (b NATBehavior) short() string {
+	switch b {
+	case NATEndpointIndependent:
+		return "ei"
+	case NATAddressDependent:
+		return "ad"
+	case NATAddressAndPortDependent:
+		return "apd"
+	case "":
+		return "?"
+	}
+	return string(b)
+}
+
+// stunMapping is the result of an IPv4 STUN probe.
+type stunMapping struct {
+	node   *tailcfg.DERPNode
+	dst    netip.AddrPort // the STUN server's address
+	mapped netip.AddrPort // our address, as seen by the STUN server
+}
+
+// addMapping records that node's STUN server at dst saw us as mapped.
+func (rs *reportState) addMapping(node *tailcfg.DERPNode, dst, mapped netip.AddrPort) {
+	if !dst.Addr().Is4() || !mapped.Addr().Is4() {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.mapped4 = append(rs.mapped4, stunMapping{node, dst, mapped})
+}
+
+// classifyNATMapping returns the NAT mapping behavior implied by the IPv4
+// STUN results ms, or the empty string if they're not enough to tell.
+func classifyNATMapping(ms []stunMapping) NATBehavior {
+	var ipPairs, portPairs bool       // whether there are results to compare
+	var variesByIP, variesByPort bool // whether the compared results differ
+	for i, a := range ms {
+		for _, b := range ms[i+1:] {
+			switch {
+			case a.dst == b.dst:
+				continue
+			case a.dst.Addr() == b.dst.Addr():
+				portPairs = true
+				variesByPort = variesByPort || a.mapped != b.mapped
+			default:
+				ipPairs = true
+				variesByIP = variesByIP || a.mapped != b.mapped
+			}
+		}
+	}
+	switch {
+	case variesByPort:
+		return NATAddressAndPortDependent
+	case variesByIP && portPairs:
+		return NATAddressDependent
+	case ipPairs && !variesByIP:
+		return NATEndpointIndependent
+	}
+	return ""
+}
+
+// probeNATBehavior probes the IPv4 NAT's filtering behavior, and the
+// mapping behavior of its alternate STUN port, against the nearest DERP
+// node that answered an IPv4 STUN probe and has a STUNAltPort.
+func (rs *reportState) probeNATBehavior(ctx context.Context) {
+	if rs.c.SendPacket == nil {
+		return
+	}
+	var m stunMapping
+	var rtt time.Duration
+	rs.mu.Lock()
+	for _, mm := range rs.mapped4 {
+		if mm.node.STUNAltPort <= 0 || mm.node.STUNAltPort > 1<<16-1 {
+			continue
+		}
+		if d := rs.report.RegionV4Latency[mm.node.RegionID]; m.node == nil || d < rtt {
+			m, rtt = mm, d
+		}
+	}
+	rs.mu.Unlock()
+	if m.node == nil {
+		return
+	}
+	alt := netip.AddrPortFrom(m.dst.Addr(), uint16(m.node.STUNAltPort))
+	timeout := min(3*rtt+100*time.Millisecond, 2*time.Second)
+
+	// Probe filtering first: once we've sent to the alternate port
+	// ourselves, the NAT would let its replies in regardless.
+	_, src, changedPort := rs.stunRoundTrip(ctx, m.dst, true, timeout)
+	mapped, _, ok := rs.stunRoundTrip(ctx, alt, false, timeout)
+	if !ok {
+		// Without an answer from the alternate port, a missing answer
+		// to the change request says nothing about the NAT.
+		rs.c.vlogf("no STUN response from alternate port %v", alt)
+		return
+	}
+	rs.addMapping(m.node, alt, mapped)
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	switch {
+	case !changedPort:
+		rs.report.NATFiltering = NATAddressAndPortDependent
+	case src == alt:
+		rs.report.NATFiltering = NATAddressDependent
+	default:
+		// The server answered from the port we sent to, so it
+		// didn't honor the change request.
+	}
+}
+
+// stunRoundTrip sends a STUN binding request to dst, asking for the
+// response to be sent from the other port if changePort, and waits up to
+// timeout for the response, retransmitting the request once halfway. It
+// returns our mapped address and the address the response came from.
+func (rs *reportState) stunRoundTrip(ctx context.Context, dst netip.AddrPort, changePort bool, timeout time.Duration) (mapped, src netip.AddrPort, ok bool) {
+	txID := stun.NewTxID()
+	req := stun.Request(txID)
+	if changePort {
+		req = stun.RequestChangePort(txID)
+	}
+	type result struct{ mapped, src netip.AddrPort }
+	ch := make(chan result, 1)
+	rs.mu.Lock()
+	rs.inFlight[txID] = func(mapped, src netip.AddrPort) {
+		ch <- result{mapped, src}
+	}
+	rs.mu.Unlock()
+	defer func() {
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		delete(rs.inFlight, txID)
+	}()
+
+	for range 2 {
+		metricSTUNSend4.Add(1)
+		if _, err := rs.c.SendPacket(req, dst); err != nil {
+			rs.c.vlogf("sending STUN to %v: %v", dst, err)
+		}
+		t := time.NewTimer(timeout / 2)
+		select {
+		case r := <-ch:
+			t.Stop()
+			return r.mapped, r.src, true
+		case <-ctx.Done():
+			t.Stop()
+			return netip.AddrPort{}, netip.AddrPort{}, false
+		case <-t.C:
+		}
+	}
+	return netip.AddrPort{}, netip.AddrPort{}, false
+}
+
+// classifyNAT sets the report's NAT mapping behavior from its STUN results.
+// In incremental reports, which don't probe the NAT's behavior, it carries
+// over what the last report found if its results don't contradict it.
+func (rs *reportState) classifyNAT(last *Report) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	r := rs.report
+	r.NATMapping = classifyNATMapping(rs.mapped4)
+	if last == nil || !r.IPv4 {
+		return
+	}
+	if r.NATMapping == "" && r.MappingVariesByDestIP == last.MappingVariesByDestIP {
+		r.NATMapping = last.NATMapping
+	}
+	if r.NATFiltering == "" {
+		r.NATFiltering = last.NATFiltering
+	}
+}
+
+// mappingLifetimeIntervals are the idle periods MeasureMappingLifetime
+// tests whether a NAT mapping survives.
+var mappingLifetimeIntervals = []time.Duration{
+	15 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+}
+
+// MeasureMappingLifetime estimates how long the IPv4 NAT keeps a mapping
+// that sees no traffic, testing idle periods of up to max, which must be
+// at least 15 seconds. It takes as long as the longest period tested.
+//
+// For each period, it creates a mapping with a STUN request from a new
+// socket and checks whether a second STUN request after the period is
+// still mapped to the same address. It returns the longest period the
+// mapping survived, along with all shorter ones, or zero if it didn't
+// survive the shortest. NATs that reuse the same mapped port for a new
+// mapping make it overestimate.
+//
+// It uses its own sockets, so doesn't need SendPacket or Standalone.
+func (c *Client) MeasureMappingLifetime(ctx context.Context, dm *tailcfg.DERPMap, max time.Duration) (time.Duration, error) {
+	if c.NetMon == nil {
+		return 0, errors.New("netcheck: MeasureMappingLifetime: Client.NetMon is nil")
+	}
+	if max < mappingLifetimeIntervals[0] {
+		return 0, fmt.Errorf("netcheck: mapping lifetime must be measured up to at least %v", mappingLifetimeIntervals[0])
+	}
+	dst, err := c.mappingLifetimeServer(ctx, dm)
+	if err != nil {
+		return 0, err
+	}
+
+	var intervals []time.Duration
+	for _, d := range mappingLifetimeIntervals {
+		if d <= max {
+			intervals = append(intervals, d)
+		}
+	}
+	survived := make([]bool, len(intervals))
+	errs := make([]error, len(intervals))
+	var wg sync.WaitGroup
+	for i, d := range intervals {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			survived[i], errs[i] = c.mappingSurvives(ctx, dst, d)
+		}()
+	}
+	wg.Wait()
+
+	var lifetime time.Duration
+	for i, d := range intervals {
+		if errs[i] != nil {
+			return lifetime, errs[i]
+		}
+		if !survived[i] {
+			break
+		}
+		lifetime = d
+	}
+	return lifetime, nil
+}
+
+// mappingLifetimeServer returns the IPv4 address of the STUN server to
+// measure mapping lifetime with: that of the last report's preferred DERP
+// region if any, or else of the first region that has one.
+func (c *Client) mappingLifetimeServer(ctx context.Context, dm *tailcfg.DERPMap) (netip.AddrPort, error) {
+	c.mu.Lock()
+	var preferred int
+	if c.last != nil {
+		preferred = c.last.PreferredDERP
+	}
+	c.mu.Unlock()
+
+	rids := dm.RegionIDs()
+	if reg, ok := dm.Regions[preferred]; ok {
+		rids = append([]int{reg.RegionID}, rids...)
+	}
+	for _, rid := range rids {
+		reg := dm.Regions[rid]
+		if reg.NoMeasureNoHome {
+			continue
+		}
+		for _, n := range reg.Nodes {
+			if ap, ok := c.nodeAddrPort(ctx, n, n.STUNPort, probeIPv4); ok {
+				return ap, nil
+			}
+		}
+	}
+	return netip.AddrPort{}, errors.New("netcheck: no DERP node with an IPv4 STUN server")
+}
+
+// mappingSurvives reports whether a NAT mapping created with a STUN
+// request to dst from a new socket is still in place after idle.
+func (c *Client) mappingSurvives(ctx context.Context, dst netip.AddrPort, idle time.Duration) (bool, error) {
+	pc, err := nettype.MakePacketListenerWithNetIP(netns.Listener(c.logf, c.NetMon)).ListenPacket(ctx, "udp4", ":0")
+	if err != nil {
+		return false, err
+	}
+	defer pc.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		pc.SetReadDeadline(time.Now())
+	}()
+
+	before, err := stunOverConn(pc, dst)
+	if err != nil {
+		return false, fmt.Errorf("netcheck: creating mapping: %w", err)
+	}
+	select {
+	case <-time.After(idle):
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	after, err := stunOverConn(pc, dst)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		return false, fmt.Errorf("netcheck: checking mapping after %v: %w", idle, err)
+	}
+	c.vlogf("mapping lifetime: mapped to %v, then %v after %v", before, after, idle)
+	return before == after, nil
+}
+
+// stunOverConn does a STUN round trip to dst over pc, retrying on loss,
+// and returns the mapped address.
+func stunOverConn(pc nettype.PacketConn, dst netip.AddrPort) (netip.AddrPort, error) {
+	const tries = 3
+	const wait = time.Second
+	var buf [1500]byte
+	for range tries {
+		txID := stun.NewTxID()
+		if _, err := pc.WriteToUDPAddrPort(stun.Request(txID), dst); err != nil {
+			return netip.AddrPort{}, err
+		}
+		pc.SetReadDeadline(time.Now().Add(wait))
+		for {
+			n, _, err := pc.ReadFromUDPAddrPort(buf[:])
+			if err != nil {
+				break // timed out, or pc failed; the next write reports the latter
+			}
+			tx, mapped, err := stun.ParseResponse(buf[:n])
+			if err == nil && tx == txID {
+				return mapped, nil
+			}
+		}
+	}
+	return netip.AddrPort{}, fmt.Errorf("no STUN response from %v", dst)
+}
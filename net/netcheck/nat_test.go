@@ -0,0 +1,156 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/net/stun/stuntest"
+	"tailscale.com/net/stunserver"
+)
+
+func TestClassifyNATMapping(t *testing.T) {
+	m := func(dst, mapped string) stunMapping {
+		return stunMapping{
+			dst:    netip.MustParseAddrPort(dst),
+			mapped: netip.MustParseAddrPort(mapped),
+		}
+	}
+	tests := []struct {
+		name string
+		ms   []stunMapping
+		want NATBehavior
+	}{
+		{"none", nil, ""},
+		{"one", []stunMapping{m("1.1.1.1:3478", "9.9.9.9:1000")}, ""},
+		{"same-dst", []stunMapping{
+			m("1.1.1.1:3478", "9.9.9.9:1000"),
+			m("1.1.1.1:3478", "9.9.9.9:1000"),
+		}, ""},
+		{"endpoint-independent", []stunMapping{
+			m("1.1.1.1:3478", "9.9.9.9:1000"),
+			m("2.2.2.2:3478", "9.9.9.9:1000"),
+		}, NATEndpointIndependent},
+		{"endpoint-independent-with-alt-port", []stunMapping{
+			m("1.1.1.1:3478", "9.9.9.9:1000"),
+			m("2.2.2.2:3478", "9.9.9.9:1000"),
+			m("1.1.1.1:3479", "9.9.9.9:1000"),
+		}, NATEndpointIndependent},
+		{"varies-by-ip-only", []stunMapping{
+			m("1.1.1.1:3478", "9.9.9.9:1000"),
+			m("2.2.2.2:3478", "9.9.9.9:1001"),
+		}, ""},
+		{"same-ip-ports-only", []stunMapping{
+			m("1.1.1.1:3478", "9.9.9.9:1000"),
+			m("1.1.1.1:3479", "9.9.9.9:1000"),
+		}, ""},
+		{"address-dependent", []stunMapping{
+			m("1.1.1.1:3478", "9.9.9.9:1000"),
+			m("2.2.2.2:3478", "9.9.9.9:1001"),
+			m("1.1.1.1:3479", "9.9.9.9:1000"),
+		}, NATAddressDependent},
+		{"address-and-port-dependent", []stunMapping{
+			m("1.1.1.1:3478", "9.9.9.9:1000"),
+			m("2.2.2.2:3478", "9.9.9.9:1001"),
+			m("1.1.1.1:3479", "9.9.9.9:1002"),
+		}, NATAddressAndPortDependent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyNATMapping(tt.ms); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNATFiltering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tests := []struct {
+		name       string
+		listenAlt  bool
+		wantFilter NATBehavior
+	}{
+		// Localhost has no NAT, so the answer from the other port
+		// gets through.
+		{"alt-port", true, NATAddressDependent},
+		// Nothing answers on the advertised alternate port, so
+		// there's nothing to conclude.
+		{"alt-port-down", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := stunserver.New(ctx)
+			if err := s.Listen("127.0.0.1:0"); err != nil {
+				t.Fatal(err)
+			}
+			altPort := 0
+			if tt.listenAlt {
+				if err := s.ListenAlt("127.0.0.1:0"); err != nil {
+					t.Fatal(err)
+				}
+				altPort = s.AltLocalAddr().(*net.UDPAddr).Port
+			} else {
+				pc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+				if err != nil {
+					t.Fatal(err)
+				}
+				altPort = pc.LocalAddr().(*net.UDPAddr).Port
+				pc.Close()
+			}
+			go s.Serve()
+
+			dm := stuntest.DERPMapOf(s.LocalAddr().String())
+			dm.Regions[1].Nodes[0].STUNAltPort = altPort
+
+			c := newTestClient(t)
+			if err := c.Standalone(ctx, "127.0.0.1:0"); err != nil {
+				t.Fatal(err)
+			}
+			r, err := c.GetReport(ctx, dm, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.NATFiltering != tt.wantFilter {
+				t.Errorf("NATFiltering = %q; want %q", r.NATFiltering, tt.wantFilter)
+			}
+			// A single STUN server can't tell address-dependent
+			// mapping from endpoint-independent.
+			if r.NATMapping != "" {
+				t.Errorf("NATMapping = %q; want empty", r.NATMapping)
+			}
+		})
+	}
+}
+
+func TestMeasureMappingLifetime(t *testing.T) {
+	old := mappingLifetimeIntervals
+	mappingLifetimeIntervals = []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond}
+	t.Cleanup(func() { mappingLifetimeIntervals = old })
+
+	stunAddr, cleanup := stuntest.Serve(t)
+	defer cleanup()
+	c := newTestClient(t)
+
+	ctx := context.Background()
+	dm := stuntest.DERPMapOf(stunAddr.String())
+	if _, err := c.MeasureMappingLifetime(ctx, dm, 5*time.Millisecond); err == nil {
+		t.Error("no error for max below the shortest interval")
+	}
+	// Localhost has no NAT, so the mapping survives every interval
+	// tested.
+	got, err := c.MeasureMappingLifetime(ctx, dm, 30*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 20 * time.Millisecond; got != want {
+		t.Errorf("lifetime = %v; want %v", got, want)
+	}
+}
@@ -100,6 +100,27 @@ type Report struct {
 	// STUN server you're talking to (on IPv4).
 	MappingVariesByDestIP opt.Bool
 
+	// NATMapping is the IPv4 NAT's mapping behavior, as defined by
+	// RFC 4787 Section 4.1, or empty if the STUN results weren't enough
+	// to tell. Telling address-dependent from address-and-port-dependent
+	// mapping requires a DERP node with a STUNAltPort.
+	NATMapping NATBehavior
+
+	// NATFiltering is the IPv4 NAT's filtering behavior, as defined by
+	// RFC 4787 Section 5, or empty if unknown. It's only probed in full
+	// reports, against a DERP node with a STUNAltPort.
+	//
+	// Endpoint-independent filtering can't be told apart from
+	// address-dependent filtering without a STUN server that can answer
+	// from a second IP address, so it's reported as address-dependent.
+	NATFiltering NATBehavior
+
+	// MappingLifetime is a lower bound on how long the NAT keeps an idle
+	// IPv4 mapping, as measured by Client.MeasureMappingLifetime, or zero
+	// if not measured. GetReport doesn't measure it, as that takes
+	// minutes.
+	MappingLifetime time.Duration
+
 	// UPnP is whether UPnP appears present on the LAN.
 	// Empty means not checked.
 	UPnP opt.Bool
@@ -327,7 +348,7 @@ func (c *Client) ReceiveSTUNPacket(pkt []byte, src netip.AddrPort) {
 	}
 	rs.mu.Unlock()
 	if ok {
-		onDone(addrPort)
+		onDone(addrPort, src)
 	}
 }
 
@@ -598,9 +619,10 @@ type reportState struct {
 	waitPortMap sync.WaitGroup
 
 	mu       sync.Mutex
-	report   *Report                            // to be returned by GetReport
-	inFlight map[stun.TxID]func(netip.AddrPort) // called without c.mu held
+	report   *Report                                        // to be returned by GetReport
+	inFlight map[stun.TxID]func(mapped, src netip.AddrPort) // called without c.mu held
 	gotEP4   netip.AddrPort
+	mapped4  []stunMapping // IPv4 STUN results, for NAT classification
 	timers   []*time.Timer
 }
 
@@ -836,7 +858,7 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap, opts *GetRe
 		start:       now,
 		opts:        opts,
 		report:      newReport(),
-		inFlight:    map[stun.TxID]func(netip.AddrPort){},
+		inFlight:    map[stun.TxID]func(mapped, src netip.AddrPort){},
 		stopProbeCh: make(chan struct{}, 1),
 	}
 	c.curState = rs
@@ -951,6 +973,13 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap, opts *GetRe
 		captivePortalStop()
 	}
 
+	// In full reports, probe the NAT's behavior further now that we know
+	// which DERP nodes we can reach.
+	if !rs.incremental && (opts == nil || !opts.OnlyTCP443) && ctx.Err() == nil {
+		rs.probeNATBehavior(ctx)
+	}
+	rs.classifyNAT(last)
+
 	if !c.SkipExternalNetwork && c.PortMapper != nil {
 		rs.waitPortMap.Wait()
 		c.vlogf("portMap done")
@@ -1283,6 +1312,9 @@ func (c *Client) logConciseReport(r *Report, dm *tailcfg.DERPMap) {
 			fmt.Fprintf(w, " v6os=%v", r.OSHasIPv6)
 		}
 		fmt.Fprintf(w, " mapvarydest=%v", r.MappingVariesByDestIP)
+		if r.NATMapping != "" || r.NATFiltering != "" {
+			fmt.Fprintf(w, " nat=%v/%v", r.NATMapping.short(), r.NATFiltering.short())
+		}
 		if r.AnyPortMappingChecked() {
 			fmt.Fprintf(w, " portmap=%v%v%v", conciseOptBool(r.UPnP, "U"), conciseOptBool(r.PMP, "M"), conciseOptBool(r.PCP, "C"))
 		} else {
@@ -1539,8 +1571,9 @@ func (rs *reportState) runProbe(ctx context.Context, dm *tailcfg.DERPMap, probe
 	sent := time.Now() // after DNS lookup above
 
 	rs.mu.Lock()
-	rs.inFlight[txID] = func(ipp netip.AddrPort) {
+	rs.inFlight[txID] = func(ipp, _ netip.AddrPort) {
 		rs.addNodeLatency(node, ipp, time.Since(sent))
+		rs.addMapping(node, addr, ipp)
 		cancelSet() // abort other nodes in this set
 	}
 	rs.mu.Unlock()
@@ -153,6 +153,18 @@ func NewStatic() *Monitor {
 	return m
 }
 
+// IsStatic reports whether m was created by NewStatic, and so never
+// reports network changes.
+func (m *Monitor) IsStatic() bool {
+	return m.static
+}
+
+// Done returns a channel that's closed when m is closed. It's nil, and so
+// never closed, for static monitors.
+func (m *Monitor) Done() <-chan struct{} {
+	return m.stop
+}
+
 // InterfaceState returns the latest snapshot of the machine's network
 // interfaces.
 //
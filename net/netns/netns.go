@@ -15,13 +15,18 @@
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"net/netip"
+	"runtime"
+	"sync"
 	"sync/atomic"
 
 	"tailscale.com/net/netknob"
 	"tailscale.com/net/netmon"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
 )
 
 var disabled atomic.Bool
@@ -53,6 +58,138 @@ func SetDisableBindConnToInterface(v bool) {
 	disableBindConnToInterface.Store(v)
 }
 
+var bindIface struct {
+	mu       sync.Mutex
+	name     string
+	fallback bool
+
+	// ifc is the interface named name, or nil if it's missing or down.
+	// It's only valid if ifcValid is set, which is only while a monitor
+	// in watched keeps it up to date on link changes.
+	ifc      *net.Interface
+	ifcValid bool
+	watched  set.Set[*netmon.Monitor]
+}
+
+// ErrBindInterfaceDown is returned when creating a socket while the
+// interface set by SetBindInterface is missing or down, unless falling back
+// to the default behavior is allowed.
+var ErrBindInterfaceDown = errors.New("bind interface missing or down")
+
+// SetBindInterface makes sockets bind to the network interface named name,
+// rather than to the one with the default route, so that traffic only
+// leaves through it. While the interface is missing or down, sockets use
+// the default behavior if fallback is true, or fail with
+// ErrBindInterfaceDown otherwise. An empty name restores the default
+// behavior.
+//
+// It's only supported where CanBindInterface reports true, and makes
+// sockets fail to be created elsewhere.
+func SetBindInterface(name string, fallback bool) {
+	bindIface.mu.Lock()
+	defer bindIface.mu.Unlock()
+	bindIface.name = name
+	bindIface.fallback = fallback
+	bindIface.ifc, bindIface.ifcValid = nil, false
+}
+
+// CanBindInterface reports whether SetBindInterface is supported on this
+// platform.
+func CanBindInterface() bool {
+	switch runtime.GOOS {
+	case "linux", "android", "darwin", "ios", "windows":
+		return true
+	}
+	return false
+}
+
+// BindInterface returns the interface name and fallback policy last set by
+// SetBindInterface.
+func BindInterface() (name string, fallback bool) {
+	bindIface.mu.Lock()
+	defer bindIface.mu.Unlock()
+	return bindIface.name, bindIface.fallback
+}
+
+// boundInterface returns the interface that new sockets should be bound
+// to per SetBindInterface, or nil if they should get the default behavior.
+func boundInterface() (*net.Interface, error) {
+	bindIface.mu.Lock()
+	defer bindIface.mu.Unlock()
+	name := bindIface.name
+	if name == "" {
+		return nil, nil
+	}
+	if !bindIface.ifcValid {
+		ifs, err := netmon.GetInterfaceList()
+		if err != nil {
+			return nil, err
+		}
+		bindIface.ifc = upInterface(ifs, name)
+		bindIface.ifcValid = len(bindIface.watched) > 0
+	}
+	if bindIface.ifc != nil {
+		return bindIface.ifc, nil
+	}
+	if bindIface.fallback {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrBindInterfaceDown, name)
+}
+
+// upInterface returns the interface in ifs named name, or nil if it's
+// missing or down.
+func upInterface(ifs netmon.InterfaceList, name string) *net.Interface {
+	for _, ifc := range ifs {
+		if ifc.Name == name && ifc.IsUp() {
+			return ifc.Interface
+		}
+	}
+	return nil
+}
+
+// watchBindInterface arranges for netMon's link changes to refresh the
+// interface cached by boundInterface, until netMon is closed.
+//
+// Static monitors never report changes, so they're not watched and the
+// interface is looked up for each socket instead, as it is once no
+// monitor is watched.
+func watchBindInterface(netMon *netmon.Monitor) {
+	if netMon.IsStatic() {
+		return
+	}
+	bindIface.mu.Lock()
+	defer bindIface.mu.Unlock()
+	if bindIface.watched.Contains(netMon) {
+		return
+	}
+	bindIface.watched.Make()
+	bindIface.watched.Add(netMon)
+	unregister := netMon.RegisterChangeCallback(bindInterfaceLinkChange)
+	go func() {
+		<-netMon.Done()
+		unregister()
+		bindIface.mu.Lock()
+		defer bindIface.mu.Unlock()
+		bindIface.watched.Delete(netMon)
+		if len(bindIface.watched) == 0 {
+			bindIface.ifc, bindIface.ifcValid = nil, false
+		}
+	}()
+}
+
+// bindInterfaceLinkChange is the netmon.ChangeFunc that refreshes the
+// interface cached by boundInterface from the new network state.
+func bindInterfaceLinkChange(delta *netmon.ChangeDelta) {
+	bindIface.mu.Lock()
+	defer bindIface.mu.Unlock()
+	var ifc *net.Interface
+	if i, ok := delta.New.Interface[bindIface.name]; ok && i.IsUp() {
+		ifc = i.Interface
+	}
+	bindIface.ifc, bindIface.ifcValid = ifc, true
+}
+
 // Listener returns a new net.Listener with its Control hook func
 // initialized as necessary to run in logical network namespace that
 // doesn't route back into Tailscale.
@@ -60,7 +197,8 @@ func Listener(logf logger.Logf, netMon *netmon.Monitor) *net.ListenConfig {
 	if netMon == nil {
 		panic("netns.Listener called with nil netMon")
 	}
-	if disabled.Load() {
+	watchBindInterface(netMon)
+	if disabled.Load() && !bindInterfaceSet() {
 		return new(net.ListenConfig)
 	}
 	return &net.ListenConfig{Control: control(logf, netMon)}
@@ -87,7 +225,8 @@ func FromDialer(logf logger.Logf, netMon *netmon.Monitor, d *net.Dialer) Dialer
 	if netMon == nil {
 		panic("netns.FromDialer called with nil netMon")
 	}
-	if disabled.Load() {
+	watchBindInterface(netMon)
+	if disabled.Load() && !bindInterfaceSet() {
 		return d
 	}
 	d.Control = control(logf, netMon)
@@ -97,6 +236,14 @@ func FromDialer(logf logger.Logf, netMon *netmon.Monitor, d *net.Dialer) Dialer
 	return d
 }
 
+// bindInterfaceSet reports whether SetBindInterface was called with a
+// non-empty name, in which case sockets need their Control hook even if
+// netns is otherwise disabled.
+func bindInterfaceSet() bool {
+	name, _ := BindInterface()
+	return name != ""
+}
+
 // IsSOCKSDialer reports whether d is SOCKS-proxying dialer as returned by
 // NewDialer or FromDialer.
 func IsSOCKSDialer(d Dialer) bool {
@@ -10,6 +10,7 @@
 	"sync"
 	"syscall"
 
+	"golang.org/x/sys/unix"
 	"tailscale.com/net/netmon"
 	"tailscale.com/types/logger"
 )
@@ -17,6 +18,7 @@
 var (
 	androidProtectFuncMu sync.Mutex
 	androidProtectFunc   func(fd int) error
+	androidBindFunc      func(fd int, ifName string) error
 )
 
 // UseSocketMark reports whether SO_MARK is in use. Android does not use SO_MARK.
@@ -50,6 +52,19 @@ func SetAndroidProtectFunc(f func(fd int) error) {
 	androidProtectFunc = f
 }
 
+// SetAndroidBindInterfaceFunc registers a func that Android provides to bind
+// the socket fd to the network of the interface named ifName, such as with
+// https://developer.android.com/reference/android/net/Network#bindSocket(java.io.FileDescriptor),
+// for SetBindInterface.
+//
+// A nil func makes SetBindInterface use SO_BINDTODEVICE instead, which
+// requires privileges that apps don't normally have.
+func SetAndroidBindInterfaceFunc(f func(fd int, ifName string) error) {
+	androidProtectFuncMu.Lock()
+	defer androidProtectFuncMu.Unlock()
+	androidBindFunc = f
+}
+
 func control(logger.Logf, *netmon.Monitor) func(network, address string, c syscall.RawConn) error {
 	return controlC
 }
@@ -59,14 +74,33 @@ func control(logger.Logf, *netmon.Monitor) func(network, address string, c sysca
 // It's intentionally the same signature as net.Dialer.Control
 // and net.ListenConfig.Control.
 func controlC(network, address string, c syscall.RawConn) error {
+	var ifc string
+	if !isLocalhost(address) {
+		bi, err := boundInterface()
+		if err != nil {
+			return err
+		}
+		if bi != nil {
+			ifc = bi.Name
+		}
+	}
+
 	var sockErr error
 	err := c.Control(func(fd uintptr) {
 		androidProtectFuncMu.Lock()
-		f := androidProtectFunc
+		f, bind := androidProtectFunc, androidBindFunc
 		androidProtectFuncMu.Unlock()
 		if f != nil {
 			sockErr = f(int(fd))
 		}
+		if sockErr != nil || ifc == "" {
+			return
+		}
+		if bind != nil {
+			sockErr = bind(int(fd), ifc)
+		} else if err := unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, ifc); err != nil {
+			sockErr = fmt.Errorf("setting SO_BINDTODEVICE: %w", err)
+		}
 	})
 	if err != nil {
 		return fmt.Errorf("RawConn.Control on %T: %w", c, err)
@@ -41,6 +41,14 @@ func controlLogf(logf logger.Logf, netMon *netmon.Monitor, network, address stri
 		return nil
 	}
 
+	ifc, err := boundInterface()
+	if err != nil {
+		return err
+	}
+	if ifc != nil {
+		return bindConnToInterface(c, network, address, ifc.Index, logf)
+	}
+
 	if disableBindConnToInterface.Load() {
 		logf("netns_darwin: binding connection to interfaces disabled")
 		return nil
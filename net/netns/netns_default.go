@@ -6,6 +6,8 @@
 package netns
 
 import (
+	"fmt"
+	"runtime"
 	"syscall"
 
 	"tailscale.com/net/netmon"
@@ -16,7 +18,15 @@ func control(logger.Logf, *netmon.Monitor) func(network, address string, c sysca
 	return controlC
 }
 
-// controlC does nothing to c.
+// controlC does nothing to c, as binding to an interface per
+// SetBindInterface isn't supported, so it fails if that's required.
 func controlC(network, address string, c syscall.RawConn) error {
+	ifc, err := boundInterface()
+	if err != nil {
+		return err
+	}
+	if ifc != nil {
+		return fmt.Errorf("netns: binding to interface %q not supported on %s", ifc.Name, runtime.GOOS)
+	}
 	return nil
 }
@@ -92,17 +92,30 @@ func controlC(network, address string, c syscall.RawConn) error {
 		return nil
 	}
 
-	var sockErr error
-	err := c.Control(func(fd uintptr) {
+	ifc, err := boundInterface()
+	if err != nil {
+		return err
+	}
+
+	var sockErr, bindErr error
+	err = c.Control(func(fd uintptr) {
 		if UseSocketMark() {
 			sockErr = setBypassMark(fd)
-		} else {
+		} else if ifc == nil {
 			sockErr = bindToDevice(fd)
 		}
+		if ifc != nil {
+			bindErr = bindToDeviceName(fd, ifc.Name)
+		}
 	})
 	if err != nil {
 		return fmt.Errorf("RawConn.Control on %T: %w", c, err)
 	}
+	if bindErr != nil {
+		// Unlike the bypass mark, don't ignore failing to bind to the
+		// interface we were asked to use, lest traffic leave another way.
+		return bindErr
+	}
 	if sockErr != nil && ignoreErrors() {
 		// TODO(bradfitz): maybe log once? probably too spammy for e.g. CLI tools like tailscale netcheck.
 		return nil
@@ -126,6 +139,10 @@ func bindToDevice(fd uintptr) error {
 		// a default route anyway, it doesn't matter.
 		ifc = "lo"
 	}
+	return bindToDeviceName(fd, ifc)
+}
+
+func bindToDeviceName(fd uintptr, ifc string) error {
 	if err := unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, ifc); err != nil {
 		return fmt.Errorf("setting SO_BINDTODEVICE: %w", err)
 	}
@@ -14,8 +14,13 @@
 package netns
 
 import (
+	"errors"
 	"flag"
 	"testing"
+	"time"
+
+	"tailscale.com/net/netmon"
+	"tailscale.com/util/eventbus"
 )
 
 var extNetwork = flag.Bool("use-external-network", false, "use the external network in tests")
@@ -76,3 +81,88 @@ func TestIsLocalhost(t *testing.T) {
 		}
 	}
 }
+
+func TestBoundInterface(t *testing.T) {
+	defer SetBindInterface("", false)
+
+	if ifc, err := boundInterface(); ifc != nil || err != nil {
+		t.Fatalf("unset: got %v, %v; want nil, nil", ifc, err)
+	}
+
+	SetBindInterface("no-such-interface0", false)
+	if _, err := boundInterface(); !errors.Is(err, ErrBindInterfaceDown) {
+		t.Errorf("missing interface: got error %v; want ErrBindInterfaceDown", err)
+	}
+
+	SetBindInterface("no-such-interface0", true)
+	if ifc, err := boundInterface(); ifc != nil || err != nil {
+		t.Errorf("missing interface with fallback: got %v, %v; want nil, nil", ifc, err)
+	}
+}
+
+func TestBoundInterfaceLinkChange(t *testing.T) {
+	defer SetBindInterface("", false)
+
+	ifs, err := netmon.GetInterfaceList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var up netmon.Interface
+	for _, ifc := range ifs {
+		if ifc.IsUp() {
+			up = ifc
+			break
+		}
+	}
+	if up.Interface == nil {
+		t.Skip("no interface is up")
+	}
+
+	bus := eventbus.New()
+	defer bus.Close()
+	netMon, err := netmon.New(bus, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netMon.Close()
+	watchBindInterface(netMon)
+
+	SetBindInterface(up.Name, false)
+	if ifc, err := boundInterface(); err != nil || ifc == nil || ifc.Name != up.Name {
+		t.Fatalf("up interface: got %v, %v; want %q", ifc, err, up.Name)
+	}
+	bindIface.mu.Lock()
+	cached := bindIface.ifcValid
+	bindIface.mu.Unlock()
+	if !cached {
+		t.Fatal("interface not cached while watching a monitor")
+	}
+
+	// A link change without the interface makes it down until the next
+	// link change brings it back, without looking it up again.
+	bindInterfaceLinkChange(&netmon.ChangeDelta{New: &netmon.State{}})
+	if _, err := boundInterface(); !errors.Is(err, ErrBindInterfaceDown) {
+		t.Errorf("after interface went away: got error %v; want ErrBindInterfaceDown", err)
+	}
+	bindInterfaceLinkChange(&netmon.ChangeDelta{New: &netmon.State{
+		Interface: map[string]netmon.Interface{up.Name: up},
+	}})
+	if ifc, err := boundInterface(); err != nil || ifc == nil || ifc.Name != up.Name {
+		t.Errorf("after interface came back: got %v, %v; want %q", ifc, err, up.Name)
+	}
+
+	// Closing the monitor stops the watch, and with it the caching.
+	netMon.Close()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		bindIface.mu.Lock()
+		watched, cached := bindIface.watched.Contains(netMon), bindIface.ifcValid
+		bindIface.mu.Unlock()
+		if !watched && !cached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("after closing the monitor: watched=%v, cached=%v; want neither", watched, cached)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -68,6 +68,14 @@ func controlC(logf logger.Logf, network, address string, c syscall.RawConn) (err
 		canV6 = true
 	}
 
+	bound, err := boundInterface()
+	if err != nil {
+		return err
+	}
+	if bound != nil {
+		return bindSockets(c, canV4, canV6, uint32(bound.Index), uint32(bound.Index))
+	}
+
 	var defIfaceIdxV4, defIfaceIdxV6 uint32
 	if canV4 {
 		defIfaceIdxV4, err = defaultInterfaceIndex(windows.AF_INET)
@@ -111,12 +119,17 @@ func controlC(logf logger.Logf, network, address string, c syscall.RawConn) (err
 		ifaceIdxV4, ifaceIdxV6 = defIfaceIdxV4, defIfaceIdxV6
 	}
 
+	return bindSockets(c, canV4, canV6, ifaceIdxV4, ifaceIdxV6)
+}
+
+// bindSockets binds c to the interface ifaceIdxV4 for IPv4 if canV4, and
+// ifaceIdxV6 for IPv6 if canV6.
+func bindSockets(c syscall.RawConn, canV4, canV6 bool, ifaceIdxV4, ifaceIdxV6 uint32) error {
 	if canV4 {
 		if err := bindSocket4(c, ifaceIdxV4); err != nil {
 			return fmt.Errorf("bindSocket4(%d): %w", ifaceIdxV4, err)
 		}
 	}
-
 	if canV6 {
 		if err := bindSocket6(c, ifaceIdxV6); err != nil {
 			return fmt.Errorf("bindSocket6(%d): %w", ifaceIdxV6, err)
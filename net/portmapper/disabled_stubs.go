@@ -30,3 +30,14 @@ func (c *Client) getUPnPPortMapping(
 ) (external netip.AddrPort, ok bool) {
 	return netip.AddrPort{}, false
 }
+
+func (c *Client) createStaticUPnPMapping(
+	ctx context.Context,
+	gw netip.Addr,
+	internal netip.AddrPort,
+	tcp bool,
+	externalPort uint16,
+	old mapping,
+) (mapping, error) {
+	return nil, ErrNoPortMappingServices
+}
@@ -55,6 +55,7 @@ type pcpMapping struct {
 	goodUntil  time.Time
 
 	epoch uint32
+	proto uint8 // pcpUDPMapping or pcpTCPMapping
 }
 
 func (p *pcpMapping) MappingType() string      { return "pcp" }
@@ -73,16 +74,18 @@ func (p *pcpMapping) Release(ctx context.Context) {
 		return
 	}
 	defer uc.Close()
-	pkt := buildPCPRequestMappingPacket(p.internal.Addr(), p.internal.Port(), p.external.Port(), 0, p.external.Addr())
+	pkt := buildPCPRequestMappingPacket(p.internal.Addr(), p.proto, p.internal.Port(), p.external.Port(), 0, p.external.Addr())
 	uc.WriteToUDPAddrPort(pkt, p.gw)
 }
 
-// buildPCPRequestMappingPacket generates a PCP packet with a MAP opcode.
+// buildPCPRequestMappingPacket generates a PCP packet with a MAP opcode,
+// for the protocol proto (pcpUDPMapping or pcpTCPMapping).
 // To create a packet which deletes a mapping, lifetimeSec should be set to 0.
 // If prevPort is not known, it should be set to 0.
 // If prevExternalIP is not known, it should be set to 0.0.0.0.
 func buildPCPRequestMappingPacket(
 	myIP netip.Addr,
+	proto uint8,
 	localPort, prevPort uint16,
 	lifetimeSec uint32,
 	prevExternalIP netip.Addr,
@@ -98,9 +101,9 @@ func buildPCPRequestMappingPacket(
 	mapOp := pkt[24:]
 	rand.Read(mapOp[:12]) // 96 bit mapping nonce
 
-	// TODO: should this be a UDP mapping? It looks like it supports "all protocols" with 0, but
-	// also doesn't support a local port then.
-	mapOp[12] = pcpUDPMapping
+	// PCP also supports mapping "all protocols" with 0, but then doesn't
+	// support a local port.
+	mapOp[12] = proto
 	binary.BigEndian.PutUint16(mapOp[16:18], localPort)
 	binary.BigEndian.PutUint16(mapOp[18:20], prevPort)
 
@@ -140,6 +143,7 @@ func parsePCPMapResponse(resp []byte) (*pcpMapping, error) {
 		renewAfter: now.Add(lifetime / 2),
 		goodUntil:  now.Add(lifetime),
 		epoch:      res.Epoch,
+		proto:      resp[36],
 	}
 
 	return mapping, nil
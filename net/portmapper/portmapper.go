@@ -152,6 +152,16 @@ type Client struct {
 	localPort uint16
 
 	mapping mapping // non-nil if we have a mapping
+
+	// staticMu guards the static mappings. It's separate from mu so
+	// SetStaticMappings and StaticMappingStatus never block on network
+	// I/O done with mu held. If both are held, mu is acquired first.
+	staticMu     sync.Mutex
+	staticWant   []portmappertype.StaticMapping
+	static       map[portmappertype.StaticMapping]*staticMapping
+	staticWake   chan struct{} // wakes runStaticMappings; nil until it starts
+	staticStop   chan struct{} // closed on Close to stop runStaticMappings
+	staticClosed bool
 }
 
 var _ portmappertype.Client = (*Client)(nil)
@@ -202,6 +212,7 @@ type pmpMapping struct {
 	renewAfter time.Time // the time at which we want to renew the mapping
 	goodUntil  time.Time // the mapping's total lifetime
 	epoch      uint32
+	op         uint8 // pmpOpMapUDP or pmpOpMapTCP
 }
 
 // externalValid reports whether m.external is valid, with both its IP and Port populated.
@@ -228,7 +239,7 @@ func (m *pmpMapping) Release(ctx context.Context) {
 		return
 	}
 	defer uc.Close()
-	pkt := buildPMPRequestMappingPacket(m.internal.Port(), m.external.Port(), pmpMapLifetimeDelete)
+	pkt := buildPMPRequestMappingPacket(m.op, m.internal.Port(), m.external.Port(), pmpMapLifetimeDelete)
 	uc.WriteToUDPAddrPort(pkt, m.gw)
 }
 
@@ -297,6 +308,10 @@ func (c *Client) NoteNetworkDown() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.invalidateMappingsLocked(false)
+
+	c.staticMu.Lock()
+	defer c.staticMu.Unlock()
+	c.releaseStaticMappingsLocked(false)
 }
 
 func (c *Client) Close() error {
@@ -307,6 +322,15 @@ func (c *Client) Close() error {
 	}
 	c.closed = true
 	c.invalidateMappingsLocked(true)
+
+	c.staticMu.Lock()
+	c.staticClosed = true
+	if c.staticStop != nil {
+		close(c.staticStop)
+	}
+	c.releaseStaticMappingsLocked(true)
+	c.staticMu.Unlock()
+
 	c.updates.Close()
 	c.pubClient.Close()
 
@@ -656,6 +680,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, exter
 		c:        c,
 		gw:       netip.AddrPortFrom(gw, c.pxpPort()),
 		internal: internalAddr,
+		op:       pmpOpMapUDP,
 	}
 	if haveRecentPMP {
 		m.external = netip.AddrPortFrom(c.pmpPubIP, m.external.Port())
@@ -688,7 +713,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, exter
 	if preferPCP {
 		// TODO replace wildcardIP here with previous external if known.
 		// Only do PCP mapping in the case when PMP did not appear to be available recently.
-		pkt := buildPCPRequestMappingPacket(myIP, localPort, prevPort, pcpMapLifetimeSec, wildcardIP)
+		pkt := buildPCPRequestMappingPacket(myIP, pcpUDPMapping, localPort, prevPort, pcpMapLifetimeSec, wildcardIP)
 		if _, err := uc.WriteToUDPAddrPort(pkt, pxpAddr); err != nil {
 			if neterror.TreatAsLostUDP(err) {
 				err = NoMappingError{ErrNoPortMappingServices}
@@ -706,7 +731,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, exter
 			}
 		}
 
-		pkt := buildPMPRequestMappingPacket(localPort, prevPort, pmpMapLifetimeSec)
+		pkt := buildPMPRequestMappingPacket(pmpOpMapUDP, localPort, prevPort, pmpMapLifetimeSec)
 		if _, err := uc.WriteToUDPAddrPort(pkt, pxpAddr); err != nil {
 			if neterror.TreatAsLostUDP(err) {
 				err = NoMappingError{ErrNoPortMappingServices}
@@ -797,6 +822,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, exter
 	pmpVersion         = 0
 	pmpOpMapPublicAddr = 0
 	pmpOpMapUDP        = 1
+	pmpOpMapTCP        = 2
 	pmpOpReply         = 0x80 // OR'd into request's op code on response
 
 	pmpCodeOK                 pmpResultCode = 0
@@ -807,10 +833,13 @@ func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, exter
 	pmpCodeUnsupportedOpcode  pmpResultCode = 5
 )
 
-func buildPMPRequestMappingPacket(localPort, prevPort uint16, lifetimeSec uint32) (pkt []byte) {
+// buildPMPRequestMappingPacket generates a PMP packet with the op pmpOpMapUDP
+// or pmpOpMapTCP. To create a packet which deletes a mapping, lifetimeSec
+// should be set to pmpMapLifetimeDelete.
+func buildPMPRequestMappingPacket(op uint8, localPort, prevPort uint16, lifetimeSec uint32) (pkt []byte) {
 	pkt = make([]byte, 12)
 
-	pkt[1] = pmpOpMapUDP
+	pkt[1] = op
 	binary.BigEndian.PutUint16(pkt[4:], localPort)
 	binary.BigEndian.PutUint16(pkt[6:], prevPort)
 	binary.BigEndian.PutUint32(pkt[8:], lifetimeSec)
@@ -844,7 +873,7 @@ func parsePMPResponse(pkt []byte) (res pmpResponse, ok bool) {
 	res.ResultCode = pmpResultCode(binary.BigEndian.Uint16(pkt[2:]))
 	res.SecondsSinceEpoch = binary.BigEndian.Uint32(pkt[4:])
 
-	if res.OpCode == pmpOpReply|pmpOpMapUDP {
+	if res.OpCode == pmpOpReply|pmpOpMapUDP || res.OpCode == pmpOpReply|pmpOpMapTCP {
 		if len(pkt) != 16 {
 			return res, false
 		}
@@ -8,7 +8,10 @@
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/netip"
+	"strconv"
+	"strings"
 	"time"
 
 	"tailscale.com/feature"
@@ -75,6 +78,15 @@ type Client interface {
 	// map UDP traffic
 	SetLocalPort(localPort uint16)
 
+	// SetStaticMappings sets the additional port mappings to create and
+	// keep renewed, releasing any previously set ones not in mappings.
+	// It doesn't block on creating them.
+	SetStaticMappings(mappings []StaticMapping)
+
+	// StaticMappingStatus returns the state of each mapping set by
+	// SetStaticMappings.
+	StaticMappingStatus() []StaticMappingStatus
+
 	Close() error
 }
 
@@ -86,3 +98,78 @@ type Mapping struct {
 
 	// TODO(creachadair): Record whether we reused an existing mapping?
 }
+
+// StaticMapping is a request for a port mapping from an external port on the
+// gateway to a port on this machine, maintained in addition to the UDP
+// mapping for the port set by [Client.SetLocalPort].
+type StaticMapping struct {
+	Proto        string // "tcp" or "udp"
+	ExternalPort uint16 // the port to ask the gateway for; it may assign another
+	InternalPort uint16
+}
+
+// ParseStaticMapping parses a StaticMapping of the form
+// "[EXTERNAL:]INTERNAL/PROTO", such as "8080/tcp" or "443:8443/tcp".
+// If EXTERNAL is omitted, it's the same as INTERNAL.
+func ParseStaticMapping(s string) (StaticMapping, error) {
+	ports, proto, ok := strings.Cut(s, "/")
+	if !ok {
+		return StaticMapping{}, fmt.Errorf("invalid port mapping %q: missing /tcp or /udp suffix", s)
+	}
+	m := StaticMapping{Proto: strings.ToLower(proto)}
+	if m.Proto != "tcp" && m.Proto != "udp" {
+		return StaticMapping{}, fmt.Errorf("invalid port mapping %q: protocol must be tcp or udp", s)
+	}
+	ext, in, ok := strings.Cut(ports, ":")
+	if !ok {
+		in = ext
+	}
+	var err error
+	if m.InternalPort, err = parsePort(in); err != nil {
+		return StaticMapping{}, fmt.Errorf("invalid port mapping %q: %w", s, err)
+	}
+	m.ExternalPort = m.InternalPort
+	if ok {
+		if m.ExternalPort, err = parsePort(ext); err != nil {
+			return StaticMapping{}, fmt.Errorf("invalid port mapping %q: %w", s, err)
+		}
+	}
+	return m, nil
+}
+
+func parsePort(s string) (uint16, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return uint16(port), nil
+}
+
+// String returns m in the form accepted by [ParseStaticMapping], omitting
+// the external port if it's the same as the internal one.
+func (m StaticMapping) String() string {
+	if m.ExternalPort == m.InternalPort {
+		return fmt.Sprintf("%d/%s", m.InternalPort, m.Proto)
+	}
+	return fmt.Sprintf("%d:%d/%s", m.ExternalPort, m.InternalPort, m.Proto)
+}
+
+// StaticMappingStatus is the state of a StaticMapping.
+type StaticMappingStatus struct {
+	StaticMapping
+
+	// External is where the mapping can be reached from outside, or the
+	// zero value if there's no current mapping.
+	External netip.AddrPort `json:",omitzero"`
+
+	// Type is the protocol that created the mapping: "pmp", "pcp" or
+	// "upnp", or empty if there's no current mapping.
+	Type string `json:",omitempty"`
+
+	// GoodUntil is when the mapping expires unless it's renewed.
+	GoodUntil time.Time `json:",omitzero"`
+
+	// Err is the most recent error creating or renewing the mapping, if
+	// there's no current mapping.
+	Err string `json:",omitempty"`
+}
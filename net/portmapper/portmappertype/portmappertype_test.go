@@ -0,0 +1,41 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmappertype
+
+import "testing"
+
+func TestParseStaticMapping(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    StaticMapping
+		wantStr string
+		wantErr bool
+	}{
+		{in: "8080/tcp", want: StaticMapping{"tcp", 8080, 8080}, wantStr: "8080/tcp"},
+		{in: "443:8443/TCP", want: StaticMapping{"tcp", 443, 8443}, wantStr: "443:8443/tcp"},
+		{in: "51413:51413/udp", want: StaticMapping{"udp", 51413, 51413}, wantStr: "51413/udp"},
+		{in: "8080", wantErr: true},
+		{in: "8080/sctp", wantErr: true},
+		{in: "0/tcp", wantErr: true},
+		{in: "65536/udp", wantErr: true},
+		{in: "x:80/tcp", wantErr: true},
+		{in: ":80/tcp", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseStaticMapping(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseStaticMapping(%q) error = %v; want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseStaticMapping(%q) = %+v; want %+v", tt.in, got, tt.want)
+		}
+		if s := got.String(); s != tt.wantStr {
+			t.Errorf("ParseStaticMapping(%q).String() = %q; want %q", tt.in, s, tt.wantStr)
+		}
+	}
+}
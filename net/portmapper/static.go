@@ -0,0 +1,335 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"time"
+
+	"tailscale.com/net/netaddr"
+	"tailscale.com/net/portmapper/portmappertype"
+	"tailscale.com/util/mak"
+)
+
+// staticMappingRetryInterval is how long the Client waits to try again
+// after failing to create or renew a static mapping.
+const staticMappingRetryInterval = time.Minute
+
+// staticMappingTimeout is how long the Client spends creating or renewing
+// all static mappings before giving up until the next attempt.
+const staticMappingTimeout = 30 * time.Second
+
+// tsStaticPortMappingDesc is the UPnP label for static port mappings.
+const tsStaticPortMappingDesc = "tailscale-static-portmap"
+
+// staticMapping is the state of a portmappertype.StaticMapping.
+type staticMapping struct {
+	m      mapping    // or nil if there's no current mapping
+	gw     netip.Addr // the gateway m is on
+	selfIP netip.Addr // the IP address m maps to
+	err    error      // the last error creating m, if m is nil
+}
+
+// SetStaticMappings sets the additional port mappings to create and keep
+// renewed, releasing any previously set ones not in mappings. It doesn't
+// block on creating them.
+func (c *Client) SetStaticMappings(mappings []portmappertype.StaticMapping) {
+	c.staticMu.Lock()
+	defer c.staticMu.Unlock()
+	if c.staticClosed || slices.Equal(c.staticWant, mappings) {
+		return
+	}
+	c.staticWant = slices.Clone(mappings)
+	if c.staticWake == nil {
+		if len(mappings) == 0 {
+			return
+		}
+		c.staticWake = make(chan struct{}, 1)
+		c.staticStop = make(chan struct{})
+		go c.runStaticMappings(c.staticWake, c.staticStop)
+	}
+	select {
+	case c.staticWake <- struct{}{}:
+	default:
+	}
+}
+
+// StaticMappingStatus returns the state of each mapping set by
+// SetStaticMappings.
+func (c *Client) StaticMappingStatus() []portmappertype.StaticMappingStatus {
+	c.staticMu.Lock()
+	defer c.staticMu.Unlock()
+	ret := make([]portmappertype.StaticMappingStatus, 0, len(c.staticWant))
+	for _, sm := range c.staticWant {
+		st := portmappertype.StaticMappingStatus{StaticMapping: sm}
+		if s := c.static[sm]; s != nil {
+			if s.m != nil {
+				st.External = s.m.External()
+				st.Type = s.m.MappingType()
+				st.GoodUntil = s.m.GoodUntil()
+			} else if s.err != nil {
+				st.Err = s.err.Error()
+			}
+		}
+		ret = append(ret, st)
+	}
+	return ret
+}
+
+// runStaticMappings creates and renews the static mappings until stop is
+// closed, rechecking them whenever wake receives a value.
+func (c *Client) runStaticMappings(wake, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-wake:
+		case <-t.C:
+		}
+		t.Stop()
+		t.Reset(c.updateStaticMappings(ctx))
+	}
+}
+
+// updateStaticMappings releases the static mappings no longer wanted and
+// creates or renews those that are, as needed. It returns how long to wait
+// before calling it again.
+func (c *Client) updateStaticMappings(ctx context.Context) time.Duration {
+	ctx, cancel := context.WithTimeout(ctx, staticMappingTimeout)
+	defer cancel()
+
+	c.staticMu.Lock()
+	want := c.staticWant
+	var stale []mapping
+	for sm, s := range c.static {
+		if !slices.Contains(want, sm) {
+			if s.m != nil {
+				stale = append(stale, s.m)
+			}
+			delete(c.static, sm)
+		}
+	}
+	c.staticMu.Unlock()
+	for _, m := range stale {
+		m.Release(ctx)
+	}
+	if len(want) == 0 {
+		return trustServiceStillAvailableDuration
+	}
+
+	gw, myIP, err := c.staticMappingGateway(ctx)
+	next := staticMappingRetryInterval
+	for _, sm := range want {
+		c.staticMu.Lock()
+		old := c.static[sm]
+		c.staticMu.Unlock()
+
+		s := &staticMapping{gw: gw, selfIP: myIP, err: err}
+		if old != nil && old.m != nil {
+			if old.gw == gw && old.selfIP == myIP && time.Now().Before(old.m.RenewAfter()) {
+				next = min(next, time.Until(old.m.RenewAfter()))
+				continue
+			}
+			if old.gw != gw || old.selfIP != myIP {
+				old.m.Release(ctx)
+				old = nil
+			}
+		}
+		if err == nil {
+			var oldMapping mapping
+			if old != nil {
+				oldMapping = old.m
+			}
+			s.m, s.err = c.createStaticMapping(ctx, gw, myIP, sm, oldMapping)
+		}
+		switch {
+		case s.m == nil && old != nil && old.m != nil && time.Now().Before(old.m.GoodUntil()):
+			// Failed to renew, but the old mapping is still good for now,
+			// so keep it and try again after the usual retry interval.
+			c.logf("static mapping %v: renewing: %v", sm, s.err)
+			s.m, s.err = old.m, nil
+		case s.m != nil:
+			next = min(next, time.Until(s.m.RenewAfter()))
+			if old == nil || old.m == nil || old.m.External() != s.m.External() {
+				c.logf("static mapping %v: %v over %s", sm, s.m.External(), s.m.MappingType())
+			}
+		case old == nil || fmt.Sprint(old.err) != fmt.Sprint(s.err):
+			c.logf("static mapping %v: %v", sm, s.err)
+		}
+
+		c.staticMu.Lock()
+		if c.staticClosed || !slices.Contains(c.staticWant, sm) {
+			// Closed or removed while we were creating it.
+			c.staticMu.Unlock()
+			if s.m != nil {
+				s.m.Release(context.Background())
+			}
+			continue
+		}
+		mak.Set(&c.static, sm, s)
+		c.staticMu.Unlock()
+	}
+	return max(next, time.Second)
+}
+
+// staticMappingGateway returns the gateway to create static mappings on
+// and this machine's IP address on its network, probing for port mapping
+// services if none have been seen recently.
+func (c *Client) staticMappingGateway(ctx context.Context) (gw, myIP netip.Addr, err error) {
+	if c.debug.disableAll() {
+		return gw, myIP, ErrPortMappingDisabled
+	}
+	gw, myIP, ok := c.gatewayAndSelfIP()
+	if !ok {
+		return gw, myIP, ErrGatewayRange
+	}
+	if gw.Is6() {
+		return gw, myIP, ErrGatewayIPv6
+	}
+	if !c.sawPMPRecently() && !c.sawPCPRecently() && !c.sawUPnPRecently() {
+		res, err := c.Probe(ctx)
+		if err != nil {
+			return gw, myIP, err
+		}
+		if !res.PMP && !res.PCP && !res.UPnP {
+			return gw, myIP, ErrNoPortMappingServices
+		}
+	}
+	return gw, myIP, nil
+}
+
+// createStaticMapping creates or renews the static mapping sm to myIP on
+// the gateway gw, using the port mapping services seen by the last probe in
+// the same order of preference as createOrGetMapping. If non-nil, old is
+// the current mapping for sm, to renew.
+func (c *Client) createStaticMapping(ctx context.Context, gw, myIP netip.Addr, sm portmappertype.StaticMapping, old mapping) (mapping, error) {
+	internal := netip.AddrPortFrom(myIP, sm.InternalPort)
+	tcp := sm.Proto == "tcp"
+	switch {
+	case !c.debug.DisablePMP() && c.sawPMPRecently():
+		op := uint8(pmpOpMapUDP)
+		if tcp {
+			op = pmpOpMapTCP
+		}
+		return c.createStaticPMPMapping(ctx, gw, internal, op, sm.ExternalPort)
+	case !c.debug.DisablePCP() && c.sawPCPRecently():
+		proto := uint8(pcpUDPMapping)
+		if tcp {
+			proto = pcpTCPMapping
+		}
+		return c.createStaticPCPMapping(ctx, gw, internal, proto, sm.ExternalPort)
+	case !c.debug.DisableUPnP() && c.sawUPnPRecently():
+		return c.createStaticUPnPMapping(ctx, gw, internal, tcp, sm.ExternalPort, old)
+	}
+	return nil, ErrNoPortMappingServices
+}
+
+func (c *Client) createStaticPMPMapping(ctx context.Context, gw netip.Addr, internal netip.AddrPort, op uint8, externalPort uint16) (mapping, error) {
+	pkt := buildPMPRequestMappingPacket(op, internal.Port(), externalPort, pmpMapLifetimeSec)
+	resp, err := c.pxpRoundTrip(ctx, gw, pkt, func(b []byte) bool {
+		pres, ok := parsePMPResponse(b)
+		return ok && pres.OpCode == pmpOpReply|op
+	})
+	if err != nil {
+		return nil, err
+	}
+	pres, _ := parsePMPResponse(resp)
+	if pres.ResultCode != pmpCodeOK {
+		return nil, fmt.Errorf("NAT-PMP mapping failed: %v", pres.ResultCode)
+	}
+	c.mu.Lock()
+	pubIP := c.pmpPubIP
+	c.mu.Unlock()
+	if !pubIP.IsValid() {
+		return nil, errors.New("NAT-PMP public address unknown")
+	}
+	d := time.Duration(pres.MappingValidSeconds) * time.Second
+	now := time.Now()
+	return &pmpMapping{
+		c:          c,
+		gw:         netip.AddrPortFrom(gw, c.pxpPort()),
+		external:   netip.AddrPortFrom(pubIP, pres.ExternalPort),
+		internal:   internal,
+		renewAfter: now.Add(d / 2),
+		goodUntil:  now.Add(d),
+		epoch:      pres.SecondsSinceEpoch,
+		op:         op,
+	}, nil
+}
+
+func (c *Client) createStaticPCPMapping(ctx context.Context, gw netip.Addr, internal netip.AddrPort, proto uint8, externalPort uint16) (mapping, error) {
+	pkt := buildPCPRequestMappingPacket(internal.Addr(), proto, internal.Port(), externalPort, pcpMapLifetimeSec, wildcardIP)
+	resp, err := c.pxpRoundTrip(ctx, gw, pkt, func(b []byte) bool {
+		pres, ok := parsePCPResponse(b)
+		return ok && pres.OpCode == pcpOpReply|pcpOpMap && len(b) >= 60 && b[36] == proto
+	})
+	if err != nil {
+		return nil, err
+	}
+	m, err := parsePCPMapResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	m.c = c
+	m.internal = internal
+	m.gw = netip.AddrPortFrom(gw, c.pxpPort())
+	return m, nil
+}
+
+// pxpRoundTrip sends the NAT-PMP or PCP request pkt to the gateway gw and
+// returns the first response from it for which match returns true.
+func (c *Client) pxpRoundTrip(ctx context.Context, gw netip.Addr, pkt []byte, match func([]byte) bool) ([]byte, error) {
+	uc, err := c.listenPacket(ctx, "udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer uc.Close()
+	uc.SetReadDeadline(time.Now().Add(portMapServiceTimeout))
+	defer closeCloserOnContextDone(ctx, uc)()
+
+	pxpAddr := netip.AddrPortFrom(gw, c.pxpPort())
+	if _, err := uc.WriteToUDPAddrPort(pkt, pxpAddr); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, src, err := uc.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, fmt.Errorf("no response from %v", pxpAddr)
+			}
+			return nil, err
+		}
+		if netaddr.Unmap(src) == pxpAddr && match(buf[:n]) {
+			return buf[:n], nil
+		}
+	}
+}
+
+// releaseStaticMappingsLocked releases all static mappings, or just
+// forgets them if !releaseOld, as with invalidateMappingsLocked.
+//
+// c.staticMu must be held.
+func (c *Client) releaseStaticMappingsLocked(releaseOld bool) {
+	for sm, s := range c.static {
+		if s.m != nil && releaseOld {
+			s.m.Release(context.Background())
+		}
+		delete(c.static, sm)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/net/portmapper/portmappertype"
+)
+
+func TestStaticMappings(t *testing.T) {
+	igd, err := NewTestIGD(t, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd, nil)
+	sm := portmappertype.StaticMapping{Proto: "tcp", ExternalPort: 443, InternalPort: 8443}
+	c.SetStaticMappings([]portmappertype.StaticMapping{sm})
+
+	var st portmappertype.StaticMappingStatus
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		sts := c.StaticMappingStatus()
+		if len(sts) != 1 {
+			t.Fatalf("got %d statuses; want 1", len(sts))
+		}
+		if st = sts[0]; st.Type != "" {
+			break
+		}
+	}
+	if st.StaticMapping != sm {
+		t.Errorf("status for %v; want %v", st.StaticMapping, sm)
+	}
+	if want := netip.MustParseAddrPort("127.0.0.1:4242"); st.External != want || st.Type != "pcp" || st.Err != "" {
+		t.Fatalf("status = %+v; want pcp mapping at %v", st, want)
+	}
+	if c.HaveMapping() {
+		t.Errorf("static mapping created the WireGuard port mapping")
+	}
+
+	c.staticMu.Lock()
+	m := c.static[sm].m.(*pcpMapping)
+	c.staticMu.Unlock()
+	if m.proto != pcpTCPMapping || m.internal.Port() != 8443 {
+		t.Errorf("mapping proto=%v internal=%v; want TCP to port 8443", m.proto, m.internal)
+	}
+
+	// Removing the mapping should release it.
+	before := igd.stats().numPCPMapRecv
+	c.SetStaticMappings(nil)
+	for deadline := time.Now().Add(10 * time.Second); igd.stats().numPCPMapRecv == before; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for mapping to be released")
+		}
+	}
+	if sts := c.StaticMappingStatus(); len(sts) != 0 {
+		t.Errorf("status after removal = %+v; want none", sts)
+	}
+}
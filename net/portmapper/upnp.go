@@ -13,6 +13,7 @@
 	"cmp"
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand/v2"
@@ -56,6 +57,8 @@ type upnpMapping struct {
 	// to release an existing mapping; new mappings should be selected from
 	// the rootDev on each attempt.
 	client upnpClient
+	// proto is the mapping's protocol, upnpProtocolUDP or upnpProtocolTCP.
+	proto string
 }
 
 // upnpProtocolUDP represents the protocol name for UDP, to be used in the UPnP
@@ -66,7 +69,10 @@ type upnpMapping struct {
 // protocol as well. See:
 //
 //	https://github.com/tailscale/tailscale/issues/7377
-const upnpProtocolUDP = "UDP"
+const (
+	upnpProtocolUDP = "UDP"
+	upnpProtocolTCP = "TCP"
+)
 
 func (u *upnpMapping) MappingType() string      { return "upnp" }
 func (u *upnpMapping) GoodUntil() time.Time     { return u.goodUntil }
@@ -79,7 +85,7 @@ func (u *upnpMapping) MappingDebug() string {
 		u.loc)
 }
 func (u *upnpMapping) Release(ctx context.Context) {
-	u.client.DeletePortMapping(ctx, "", u.external.Port(), upnpProtocolUDP)
+	u.client.DeletePortMapping(ctx, "", u.external.Port(), u.proto)
 }
 
 // upnpClient is an interface over the multiple different clients exported by goupnp,
@@ -128,11 +134,26 @@ type upnpClient interface {
 // It is not used for anything other than labelling.
 const tsPortMappingDesc = "tailscale-portmap"
 
+// upnpPortRequest is a request for a port mapping over UPnP.
+type upnpPortRequest struct {
+	proto string // upnpProtocolUDP or upnpProtocolTCP
+	desc  string // the mapping's human-readable label
+
+	// externalPort is the external port to ask for, or 0 for any.
+	externalPort uint16
+
+	// exact is whether only externalPort will do. If false, the gateway
+	// may pick another port, and ports below 1024 are never asked for.
+	exact bool
+}
+
 // addAnyPortMapping abstracts over different UPnP client connections, calling
 // the available AddAnyPortMapping call if available for WAN IP connection v2,
 // otherwise picking either the previous port (if one is present) or a random
 // port and trying to obtain a mapping using AddPortMapping.
 //
+// If req.exact, it only tries AddPortMapping with req.externalPort.
+//
 // It returns the new external port (which may not be identical to the external
 // port specified), or an error.
 //
@@ -140,11 +161,27 @@ type upnpClient interface {
 func addAnyPortMapping(
 	ctx context.Context,
 	upnp upnpClient,
-	externalPort uint16,
+	req upnpPortRequest,
 	internalPort uint16,
 	internalClient string,
 	leaseDuration time.Duration,
 ) (newPort uint16, err error) {
+	externalPort := req.externalPort
+	if req.exact {
+		err = upnp.AddPortMapping(
+			ctx,
+			"",
+			externalPort,
+			req.proto,
+			internalPort,
+			internalClient,
+			true,
+			req.desc,
+			uint32(leaseDuration.Seconds()),
+		)
+		return externalPort, err
+	}
+
 	// Some devices don't let clients add a port mapping for privileged
 	// ports (ports below 1024). Additionally, per section 2.3.18 of the
 	// UPnP spec, regarding the ExternalPort field:
@@ -175,11 +212,11 @@ func addAnyPortMapping(
 			ctx,
 			"",
 			externalPort,
-			upnpProtocolUDP,
+			req.proto,
 			internalPort,
 			internalClient,
 			true,
-			tsPortMappingDesc,
+			req.desc,
 			uint32(leaseDuration.Seconds()),
 		)
 	}
@@ -190,11 +227,11 @@ func addAnyPortMapping(
 		ctx,
 		"",
 		externalPort,
-		upnpProtocolUDP,
+		req.proto,
 		internalPort,
 		internalClient,
 		true,
-		tsPortMappingDesc,
+		req.desc,
 		uint32(leaseDuration.Seconds()),
 	)
 	return externalPort, err
@@ -438,10 +475,43 @@ func (c *Client) getUPnPPortMapping(
 		return netip.AddrPort{}, false
 	}
 
+	c.mu.Lock()
+	oldMapping, _ := c.mapping.(*upnpMapping)
+	c.mu.Unlock()
+
+	upnp, err := c.createUPnPMapping(ctx, gw, internal, upnpPortRequest{
+		proto:        upnpProtocolUDP,
+		desc:         tsPortMappingDesc,
+		externalPort: prevPort,
+	}, oldMapping)
+	if err != nil {
+		// TODO(andrew-d): use or log err?
+		return netip.AddrPort{}, false
+	}
+
+	// Cache this mapping and update our local port.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mapping = upnp
+	c.localPort = upnp.external.Port()
+	return upnp.external, true
+}
+
+// createUPnPMapping creates the port mapping req to internal, trying each
+// UPnP device we know of in turn, starting with that of oldMapping, if
+// non-nil, so the mapping is renewed rather than created anew.
+func (c *Client) createUPnPMapping(
+	ctx context.Context,
+	gw netip.Addr,
+	internal netip.AddrPort,
+	req upnpPortRequest,
+	oldMapping *upnpMapping,
+) (*upnpMapping, error) {
 	now := time.Now()
 	upnp := &upnpMapping{
 		gw:       gw,
 		internal: internal,
+		proto:    req.proto,
 	}
 
 	// We can have multiple UPnP "meta" values (which correspond to the
@@ -449,10 +519,9 @@ func (c *Client) getUPnPPortMapping(
 	// obtaining a mapping, but also prefer any existing mapping's root
 	// device (if present), since that will allow us to renew an existing
 	// mapping instead of creating a new one.
-	// Start by grabbing the list of metas, any existing mapping, and
-	// creating a HTTP client for use.
+	// Start by grabbing the list of metas and creating a HTTP client for
+	// use.
 	c.mu.Lock()
-	oldMapping, ok := c.mapping.(*upnpMapping)
 	metas := c.uPnPMetas
 	ctx = goupnp.WithHTTPClient(ctx, c.upnpHTTPClientLocked())
 	c.mu.Unlock()
@@ -468,8 +537,7 @@ type step struct {
 
 	// Now, if we have an existing mapping, swap that mapping's entry to
 	// the first entry in our "metas" list so we try it first.
-	haveOldMapping := ok && oldMapping != nil
-	if haveOldMapping && oldMapping.rootDev != nil {
+	if oldMapping != nil && oldMapping.rootDev != nil {
 		steps = append(steps, step{rootDev: oldMapping.rootDev, loc: oldMapping.loc})
 	}
 	// Note: this includes the meta for a previously-cached mapping, in
@@ -517,14 +585,13 @@ type step struct {
 		//
 		// This is probably sufficiently unlikely that I'm leaving that
 		// as a follow-up task if it's necessary.
-		externalAddrPort, client, err := c.tryUPnPPortmapWithDevice(ctx, internal, prevPort, rootDev, loc)
+		externalAddrPort, client, err := c.tryUPnPPortmapWithDevice(ctx, internal, req, rootDev, loc)
 		if err != nil {
 			errs = append(errs, err)
 			continue
 		}
 
-		// If we get here, we're successful; we can cache this mapping,
-		// update our local port, and then return.
+		// If we get here, we're successful.
 		//
 		// NOTE: this time might not technically be accurate if we created a
 		// permanent lease above, but we should still re-check the presence of
@@ -536,24 +603,47 @@ type step struct {
 		upnp.rootDev = rootDev
 		upnp.loc = loc
 		upnp.client = client
-
-		c.mu.Lock()
-		defer c.mu.Unlock()
-		c.mapping = upnp
-		c.localPort = externalAddrPort.Port()
-		return upnp.external, true
+		return upnp, nil
 	}
 
 	// If we get here, we didn't get anything.
-	// TODO(andrew-d): use or log errs?
-	_ = errs
-	return netip.AddrPort{}, false
+	if len(errs) == 0 {
+		return nil, ErrNoPortMappingServices
+	}
+	return nil, errors.Join(errs...)
+}
+
+// createStaticUPnPMapping creates or renews a static TCP or UDP mapping
+// from externalPort to internal. If non-nil, old is the current mapping,
+// to renew.
+func (c *Client) createStaticUPnPMapping(
+	ctx context.Context,
+	gw netip.Addr,
+	internal netip.AddrPort,
+	tcp bool,
+	externalPort uint16,
+	old mapping,
+) (mapping, error) {
+	req := upnpPortRequest{
+		proto:        upnpProtocolUDP,
+		desc:         tsStaticPortMappingDesc,
+		externalPort: externalPort,
+		exact:        true,
+	}
+	if tcp {
+		req.proto = upnpProtocolTCP
+	}
+	oldMapping, _ := old.(*upnpMapping)
+	m, err := c.createUPnPMapping(ctx, gw, internal, req, oldMapping)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
 // tryUPnPPortmapWithDevice attempts to perform a port forward from the given
-// UPnP device to the 'internal' address. It tries to re-use the previous port,
-// if a non-zero value is provided, and handles retries and errors about
-// unsupported features.
+// UPnP device to the 'internal' address. It tries to use req.externalPort,
+// if non-zero, and handles retries and errors about unsupported features.
 //
 // It returns the external address and port that was mapped (i.e. the
 // address+port that another Tailscale node can use to make a connection to
@@ -561,7 +651,7 @@ type step struct {
 func (c *Client) tryUPnPPortmapWithDevice(
 	ctx context.Context,
 	internal netip.AddrPort,
-	prevPort uint16,
+	req upnpPortRequest,
 	rootDev *goupnp.RootDevice,
 	loc *url.URL,
 ) (netip.AddrPort, upnpClient, error) {
@@ -592,7 +682,7 @@ func (c *Client) tryUPnPPortmapWithDevice(
 	newPort, err = addAnyPortMapping(
 		ctx,
 		client,
-		prevPort,
+		req,
 		internal.Port(),
 		internal.Addr().String(),
 		pmpMapLifetimeSec*time.Second,
@@ -616,7 +706,7 @@ func (c *Client) tryUPnPPortmapWithDevice(
 			newPort, err = addAnyPortMapping(
 				ctx,
 				client,
-				prevPort,
+				req,
 				internal.Port(),
 				internal.Addr().String(),
 				0, // permanent
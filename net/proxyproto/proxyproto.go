@@ -0,0 +1,146 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package proxyproto implements the sending side of the HAProxy PROXY
+// protocol, versions 1 and 2, which conveys the original source and
+// destination of a proxied TCP connection to the backend.
+//
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+package proxyproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+)
+
+// TLV types in the range reserved for custom use by the PROXY protocol
+// specification, used by Tailscale to identify the tailnet peer that made
+// the connection. Their values are UTF-8 strings.
+const (
+	// TypeTailscaleNode is the peer's MagicDNS name, such as
+	// "foo.tailnet-123.ts.net".
+	TypeTailscaleNode byte = 0xE0
+
+	// TypeTailscaleUser is the login name of the peer's owner, such as
+	// "alice@example.com". It's omitted for tagged nodes.
+	TypeTailscaleUser byte = 0xE1
+
+	// TypeTailscaleNodeID is the peer's stable node ID.
+	TypeTailscaleNodeID byte = 0xE2
+)
+
+// v2Signature is the 12 byte signature that starts a version 2 header.
+const v2Signature = "\r\n\r\n\x00\r\nQUIT\n"
+
+// TLV is a type-length-value field of a version 2 header.
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Header is a PROXY protocol header for a TCP connection.
+type Header struct {
+	// Src and Dst are the original source and destination of the
+	// connection.
+	Src, Dst netip.AddrPort
+
+	// TLVs are additional fields. They're only sent by version 2.
+	TLVs []TLV
+}
+
+// addrs returns h's addresses, unmapped if both are IPv4 and as IPv6
+// addresses otherwise, as the protocol requires both to be of the same
+// family.
+func (h *Header) addrs() (src, dst netip.Addr, is4 bool) {
+	src, dst = h.Src.Addr().Unmap(), h.Dst.Addr().Unmap()
+	if src.Is4() && dst.Is4() {
+		return src, dst, true
+	}
+	return netip.AddrFrom16(src.As16()), netip.AddrFrom16(dst.As16()), false
+}
+
+// AppendV1 appends h to b in the human-readable version 1 format.
+func (h *Header) AppendV1(b []byte) ([]byte, error) {
+	if !h.Src.IsValid() || !h.Dst.IsValid() {
+		return append(b, "PROXY UNKNOWN\r\n"...), nil
+	}
+	src, dst, is4 := h.addrs()
+	if is4 {
+		b = append(b, "PROXY TCP4 "...)
+	} else {
+		b = append(b, "PROXY TCP6 "...)
+	}
+	b = src.AppendTo(b)
+	b = append(b, ' ')
+	b = dst.AppendTo(b)
+	b = append(b, ' ')
+	b = strconv.AppendUint(b, uint64(h.Src.Port()), 10)
+	b = append(b, ' ')
+	b = strconv.AppendUint(b, uint64(h.Dst.Port()), 10)
+	return append(b, "\r\n"...), nil
+}
+
+// AppendV2 appends h to b in the binary version 2 format.
+func (h *Header) AppendV2(b []byte) ([]byte, error) {
+	b = append(b, v2Signature...)
+	b = append(b, 0x21) // version 2, PROXY command
+	if !h.Src.IsValid() || !h.Dst.IsValid() {
+		// AF_UNSPEC; the receiver ignores the address block and uses the
+		// connection's real addresses.
+		b = append(b, 0x00)
+		lenOff := len(b)
+		return appendTLVs(append(b, 0, 0), h.TLVs, lenOff)
+	}
+
+	src, dst, is4 := h.addrs()
+	var addrLen int
+	if is4 {
+		b = append(b, 0x11) // AF_INET, STREAM
+		addrLen = 2*4 + 2*2
+	} else {
+		b = append(b, 0x21) // AF_INET6, STREAM
+		addrLen = 2*16 + 2*2
+	}
+	lenOff := len(b)
+	b = append(b, 0, 0) // filled in by appendTLVs
+	b = append(b, src.AsSlice()...)
+	b = append(b, dst.AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, h.Src.Port())
+	b = binary.BigEndian.AppendUint16(b, h.Dst.Port())
+	binary.BigEndian.PutUint16(b[lenOff:], uint16(addrLen))
+	return appendTLVs(b, h.TLVs, lenOff)
+}
+
+// appendTLVs appends tlvs to the version 2 header in b, whose length field
+// is at lenOff, and updates the length.
+func appendTLVs(b []byte, tlvs []TLV, lenOff int) ([]byte, error) {
+	n := int(binary.BigEndian.Uint16(b[lenOff:]))
+	for _, t := range tlvs {
+		if len(t.Value) > 0xffff {
+			return nil, fmt.Errorf("TLV 0x%02x too long", t.Type)
+		}
+		n += 3 + len(t.Value)
+		b = append(b, t.Type)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(t.Value)))
+		b = append(b, t.Value...)
+	}
+	if n > 0xffff {
+		return nil, errors.New("header too long")
+	}
+	binary.BigEndian.PutUint16(b[lenOff:], uint16(n))
+	return b, nil
+}
+
+// Format returns h in the format of the given protocol version, 1 or 2.
+func (h *Header) Format(version int) ([]byte, error) {
+	switch version {
+	case 1:
+		return h.AppendV1(nil)
+	case 2:
+		return h.AppendV2(make([]byte, 0, 64))
+	}
+	return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+}
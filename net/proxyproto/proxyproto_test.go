@@ -0,0 +1,154 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestFormatV1(t *testing.T) {
+	tests := []struct {
+		name string
+		h    Header
+		want string
+	}{
+		{
+			name: "ipv4",
+			h: Header{
+				Src: netip.MustParseAddrPort("100.64.0.1:51234"),
+				Dst: netip.MustParseAddrPort("100.64.0.2:443"),
+			},
+			want: "PROXY TCP4 100.64.0.1 100.64.0.2 51234 443\r\n",
+		},
+		{
+			name: "ipv6",
+			h: Header{
+				Src: netip.MustParseAddrPort("[fd7a:115c:a1e0::1]:51234"),
+				Dst: netip.MustParseAddrPort("[fd7a:115c:a1e0::2]:443"),
+			},
+			want: "PROXY TCP6 fd7a:115c:a1e0::1 fd7a:115c:a1e0::2 51234 443\r\n",
+		},
+		{
+			name: "mapped_ipv4",
+			h: Header{
+				Src: netip.MustParseAddrPort("[::ffff:100.64.0.1]:51234"),
+				Dst: netip.MustParseAddrPort("100.64.0.2:443"),
+			},
+			want: "PROXY TCP4 100.64.0.1 100.64.0.2 51234 443\r\n",
+		},
+		{
+			name: "mixed",
+			h: Header{
+				Src: netip.MustParseAddrPort("100.64.0.1:51234"),
+				Dst: netip.MustParseAddrPort("[fd7a:115c:a1e0::2]:443"),
+			},
+			want: "PROXY TCP6 ::ffff:100.64.0.1 fd7a:115c:a1e0::2 51234 443\r\n",
+		},
+		{
+			name: "unknown",
+			h:    Header{Dst: netip.MustParseAddrPort("100.64.0.2:443")},
+			want: "PROXY UNKNOWN\r\n",
+		},
+		{
+			name: "tlvs_ignored",
+			h: Header{
+				Src:  netip.MustParseAddrPort("100.64.0.1:1"),
+				Dst:  netip.MustParseAddrPort("100.64.0.2:2"),
+				TLVs: []TLV{{TypeTailscaleNode, []byte("foo.ts.net")}},
+			},
+			want: "PROXY TCP4 100.64.0.1 100.64.0.2 1 2\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.h.Format(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatV2(t *testing.T) {
+	unhex := func(s string) []byte {
+		b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	const sig = "0d0a0d0a000d0a515549540a"
+	tests := []struct {
+		name string
+		h    Header
+		want []byte
+	}{
+		{
+			name: "ipv4",
+			h: Header{
+				Src: netip.MustParseAddrPort("100.64.0.1:51234"),
+				Dst: netip.MustParseAddrPort("100.64.0.2:443"),
+			},
+			want: unhex(sig + "21 11 000c 64400001 64400002 c822 01bb"),
+		},
+		{
+			name: "ipv6",
+			h: Header{
+				Src: netip.MustParseAddrPort("[fd7a:115c:a1e0::1]:51234"),
+				Dst: netip.MustParseAddrPort("[fd7a:115c:a1e0::2]:443"),
+			},
+			want: unhex(sig + "21 21 0024" +
+				"fd7a115ca1e000000000000000000001" +
+				"fd7a115ca1e000000000000000000002" +
+				"c822 01bb"),
+		},
+		{
+			name: "tlvs",
+			h: Header{
+				Src: netip.MustParseAddrPort("100.64.0.1:51234"),
+				Dst: netip.MustParseAddrPort("100.64.0.2:443"),
+				TLVs: []TLV{
+					{TypeTailscaleNode, []byte("foo")},
+					{TypeTailscaleUser, []byte("a@b")},
+				},
+			},
+			want: unhex(sig + "21 11 0018 64400001 64400002 c822 01bb" +
+				"e0 0003 666f6f" +
+				"e1 0003 614062"),
+		},
+		{
+			name: "unknown",
+			h: Header{
+				TLVs: []TLV{{TypeTailscaleNodeID, []byte("n1")}},
+			},
+			want: unhex(sig + "21 00 0005 e2 0002 6e31"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.h.Format(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got\n%x\nwant\n%x", got, tt.want)
+			}
+		})
+	}
+
+	h := Header{TLVs: []TLV{{TypeTailscaleNode, make([]byte, 0x10000)}}}
+	if _, err := h.Format(2); err == nil {
+		t.Error("oversized TLV: got nil error")
+	}
+	if _, err := h.Format(3); err == nil {
+		t.Error("version 3: got nil error")
+	}
+}
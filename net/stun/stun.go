@@ -18,6 +18,7 @@
 	attrNumSoftware      = 0x8022
 	attrNumFingerprint   = 0x8028
 	attrMappedAddress    = 0x0001
+	attrChangeRequest    = 0x0003 // RFC 5780 Section 7.2
 	attrXorMappedAddress = 0x0020
 	// This alternative attribute type is not
 	// mentioned in the RFC, but the shift into
@@ -31,6 +32,8 @@
 	magicCookie    = "\x21\x12\xa4\x42"
 	lenFingerprint = 8 // 2+byte header + 2-byte length + 4-byte crc32
 	headerLen      = 20
+
+	changePortFlag = 0x02 // CHANGE-REQUEST "change port" flag
 )
 
 // TxID is a transaction ID.
@@ -48,11 +51,28 @@ func NewTxID() TxID {
 // Request generates a binding request STUN packet.
 // The transaction ID, tID, should be a random sequence of bytes.
 func Request(tID TxID) []byte {
+	return request(tID, false)
+}
+
+// RequestChangePort generates a binding request STUN packet asking the
+// server to send its response from a different port than the one the
+// request was sent to, using the CHANGE-REQUEST attribute of RFC 5780.
+// It's used to discover a NAT's filtering behavior.
+func RequestChangePort(tID TxID) []byte {
+	return request(tID, true)
+}
+
+func request(tID TxID, changePort bool) []byte {
 	// STUN header, RFC5389 Section 6.
 	const lenAttrSoftware = 4 + len(software)
-	b := make([]byte, 0, headerLen+lenAttrSoftware+lenFingerprint)
+	const lenAttrChangeRequest = 8
+	attrsLen := lenAttrSoftware + lenFingerprint
+	if changePort {
+		attrsLen += lenAttrChangeRequest
+	}
+	b := make([]byte, 0, headerLen+attrsLen)
 	b = append(b, bindingRequest...)
-	b = appendU16(b, uint16(lenAttrSoftware+lenFingerprint)) // number of bytes following header
+	b = appendU16(b, uint16(attrsLen)) // number of bytes following header
 	b = append(b, magicCookie...)
 	b = append(b, tID[:]...)
 
@@ -61,6 +81,13 @@ func Request(tID TxID) []byte {
 	b = appendU16(b, uint16(len(software)))
 	b = append(b, software...)
 
+	if changePort {
+		// Attribute CHANGE-REQUEST, RFC 5780 Section 7.2.
+		b = appendU16(b, attrChangeRequest)
+		b = appendU16(b, 4)
+		b = appendU32(b, changePortFlag)
+	}
+
 	// Attribute FINGERPRINT, RFC5389 Section 15.5.
 	fp := fingerPrint(b)
 	b = appendU16(b, attrNumFingerprint)
@@ -121,6 +148,20 @@ func ParseBindingRequest(b []byte) (TxID, error) {
 	return txID, nil
 }
 
+// ChangePortRequested reports whether the binding request b, which must
+// have been successfully parsed by ParseBindingRequest, asks for its
+// response to be sent from a different port. See RequestChangePort.
+func ChangePortRequested(b []byte) bool {
+	var ok bool
+	foreachAttr(b[headerLen:], func(attrType uint16, a []byte) error {
+		if attrType == attrChangeRequest && len(a) == 4 {
+			ok = binary.BigEndian.Uint32(a)&changePortFlag != 0
+		}
+		return nil
+	})
+	return ok
+}
+
 var (
 	ErrNotSTUN            = errors.New("response is not a STUN packet")
 	ErrNotSuccessResponse = errors.New("STUN packet is not a response")
@@ -299,3 +299,21 @@ func TestAttrOrderForXdpDERP(t *testing.T) {
 		t.Fatal("unexpected software attr value")
 	}
 }
+
+func TestRequestChangePort(t *testing.T) {
+	tx := stun.NewTxID()
+	req := stun.RequestChangePort(tx)
+	gotTx, err := stun.ParseBindingRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotTx != tx {
+		t.Errorf("original txID %q != got txID %q", tx, gotTx)
+	}
+	if !stun.ChangePortRequested(req) {
+		t.Error("ChangePortRequested = false for RequestChangePort")
+	}
+	if stun.ChangePortRequested(stun.Request(tx)) {
+		t.Error("ChangePortRequested = true for Request")
+	}
+}
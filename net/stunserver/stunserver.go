@@ -30,17 +30,23 @@
 
 	stunIPv4 = stunAddrFamily.Get("ipv4")
 	stunIPv6 = stunAddrFamily.Get("ipv6")
+
+	// stunChangePort counts responses sent from the alternate port,
+	// as requested by a CHANGE-REQUEST attribute.
+	stunChangePort = new(expvar.Int)
 )
 
 func init() {
 	stats.Set("counter_requests", stunDisposition)
 	stats.Set("counter_addrfamily", stunAddrFamily)
+	stats.Set("counter_change_port_responses", stunChangePort)
 	expvar.Publish("stun", stats)
 }
 
 type STUNServer struct {
-	ctx context.Context // ctx signals service shutdown
-	pc  *net.UDPConn    // pc is the UDP listener
+	ctx   context.Context // ctx signals service shutdown
+	pc    *net.UDPConn    // pc is the UDP listener
+	altPC *net.UDPConn    // altPC is the optional alternate port's UDP listener, or nil
 }
 
 // New creates a new STUN server. The server is shutdown when ctx is done.
@@ -50,25 +56,63 @@ func New(ctx context.Context) *STUNServer {
 
 // Listen binds the listen socket for the server at listenAddr.
 func (s *STUNServer) Listen(listenAddr string) error {
-	uaddr, err := net.ResolveUDPAddr("udp", listenAddr)
+	pc, err := s.listen(listenAddr)
 	if err != nil {
 		return err
 	}
-	s.pc, err = net.ListenUDP("udp", uaddr)
+	s.pc = pc
+	log.Printf("STUN server listening on %v", s.LocalAddr())
+	return nil
+}
+
+// ListenAlt binds the socket for the server's alternate port at listenAddr,
+// which should be the same IP as given to Listen but a different port.
+//
+// The server answers binding requests on both ports. Requests with an
+// RFC 5780 CHANGE-REQUEST attribute asking to change port are answered
+// from the other port, which clients use to discover their NAT's filtering
+// behavior. Without an alternate port, such requests are answered from the
+// port they were sent to.
+//
+// It must be called before Serve.
+func (s *STUNServer) ListenAlt(listenAddr string) error {
+	pc, err := s.listen(listenAddr)
 	if err != nil {
 		return err
 	}
-	log.Printf("STUN server listening on %v", s.LocalAddr())
+	s.altPC = pc
+	log.Printf("STUN server listening on alternate port %v", pc.LocalAddr())
+	return nil
+}
+
+func (s *STUNServer) listen(listenAddr string) (*net.UDPConn, error) {
+	uaddr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenUDP("udp", uaddr)
+	if err != nil {
+		return nil, err
+	}
 	// close the listener on shutdown in order to break out of the read loop
 	go func() {
 		<-s.ctx.Done()
-		s.pc.Close()
+		pc.Close()
 	}()
-	return nil
+	return pc, nil
 }
 
 // Serve starts serving responses to STUN requests. Listen must be called before Serve.
 func (s *STUNServer) Serve() error {
+	if s.altPC != nil {
+		go s.serve(s.altPC, s.pc)
+	}
+	return s.serve(s.pc, s.altPC)
+}
+
+// serve serves STUN requests read from pc, answering those that ask to
+// change port from otherPC, if non-nil.
+func (s *STUNServer) serve(pc, otherPC *net.UDPConn) error {
 	var buf [64 << 10]byte
 	var (
 		n   int
@@ -76,7 +120,7 @@ func (s *STUNServer) Serve() error {
 		err error
 	)
 	for {
-		n, ua, err = s.pc.ReadFromUDP(buf[:])
+		n, ua, err = pc.ReadFromUDP(buf[:])
 		if err != nil {
 			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
 				return nil
@@ -103,7 +147,12 @@ func (s *STUNServer) Serve() error {
 		}
 		addr, _ := netip.AddrFromSlice(ua.IP)
 		res := stun.Response(txid, netip.AddrPortFrom(addr, uint16(ua.Port)))
-		_, err = s.pc.WriteTo(res, ua)
+		from := pc
+		if otherPC != nil && stun.ChangePortRequested(pkt) {
+			from = otherPC
+			stunChangePort.Add(1)
+		}
+		_, err = from.WriteTo(res, ua)
 		if err != nil {
 			stunWriteError.Add(1)
 		} else {
@@ -124,3 +173,12 @@ func (s *STUNServer) ListenAndServe(listenAddr string) error {
 func (s *STUNServer) LocalAddr() net.Addr {
 	return s.pc.LocalAddr()
 }
+
+// AltLocalAddr returns the local address of the STUN server's alternate
+// port, or nil if ListenAlt wasn't called.
+func (s *STUNServer) AltLocalAddr() net.Addr {
+	if s.altPC == nil {
+		return nil
+	}
+	return s.altPC.LocalAddr()
+}
@@ -86,3 +86,46 @@ func BenchmarkServerSTUN(b *testing.B) {
 		}
 	}
 }
+
+func TestSTUNServerChangePort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx)
+	must.Do(s.Listen("127.0.0.1:0"))
+	must.Do(s.ListenAlt("127.0.0.1:0"))
+	go s.Serve()
+
+	pc := must.Get(net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}))
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(5 * time.Second))
+
+	tests := []struct {
+		name     string
+		to       net.Addr
+		req      func(stun.TxID) []byte
+		wantFrom net.Addr
+	}{
+		{"primary", s.LocalAddr(), stun.Request, s.LocalAddr()},
+		{"alt", s.AltLocalAddr(), stun.Request, s.AltLocalAddr()},
+		{"primary-change-port", s.LocalAddr(), stun.RequestChangePort, s.AltLocalAddr()},
+		{"alt-change-port", s.AltLocalAddr(), stun.RequestChangePort, s.LocalAddr()},
+	}
+	for _, tt := range tests {
+		txid := stun.NewTxID()
+		if _, err := pc.WriteTo(tt.req(txid), tt.to); err != nil {
+			t.Fatalf("%s: write: %v", tt.name, err)
+		}
+		var buf [1500]byte
+		n, from, err := pc.ReadFrom(buf[:])
+		if err != nil {
+			t.Fatalf("%s: read: %v", tt.name, err)
+		}
+		tid, _, err := stun.ParseResponse(buf[:n])
+		if err != nil || tid != txid {
+			t.Fatalf("%s: bad response: tx %x, %v", tt.name, tid, err)
+		}
+		if from.String() != tt.wantFrom.String() {
+			t.Errorf("%s: response from %v; want %v", tt.name, from, tt.wantFrom)
+		}
+	}
+}
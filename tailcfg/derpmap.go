@@ -186,6 +186,13 @@ type DERPNode struct {
 	// To disable STUN on this node, use -1.
 	STUNPort int `json:",omitempty"`
 
+	// STUNAltPort, if non-zero, is a second UDP port on which the
+	// node's STUN server listens. The server answers binding requests
+	// that ask it to change port (RFC 5780 CHANGE-REQUEST) from the
+	// other port. Clients use it to classify their NAT's mapping and
+	// filtering behavior.
+	STUNAltPort int `json:",omitempty"`
+
 	// STUNOnly marks a node as only a STUN server and not a DERP
 	// server.
 	STUNOnly bool `json:",omitempty"`
@@ -207,6 +214,11 @@ type DERPNode struct {
 	// CanPort80 specifies whether this DERP node is accessible over HTTP
 	// on port 80 specifically. This is used for captive portal checks.
 	CanPort80 bool `json:",omitempty"`
+
+	// QUICPort, if non-zero, is the UDP port on which the DERP node
+	// accepts experimental DERP-over-QUIC connections. Clients that
+	// support it try QUIC first, falling back to DERPPort over TCP.
+	QUICPort int `json:",omitempty"`
 }
 
 func (n *DERPNode) IsTestNode() bool {
@@ -227,5 +239,32 @@ type DERPAdmitClientRequest struct {
 type DERPAdmitClientResponse struct {
 	Allow bool // whether to permit client
 
-	// TODO(bradfitz,maisem): bandwidth limits, etc?
+	// RateLimit, if non-nil, replaces derper's default per-client rate
+	// limit for this client. A non-nil zero value means no limit.
+	RateLimit *DERPClientRateLimit `json:",omitempty"`
+}
+
+// DERPClientRateLimit is a limit on the rate at which a DERP client may send
+// packets through a DERP server, enforced with token buckets. A zero rate
+// means no limit of that kind.
+type DERPClientRateLimit struct {
+	// BytesPerSecond is the sustained rate of packet payload bytes.
+	BytesPerSecond float64 `json:",omitempty"`
+
+	// BytesBurst is the number of bytes that may be sent in a burst
+	// above BytesPerSecond. If zero, it defaults to one second's worth,
+	// but at least the maximum DERP packet size.
+	BytesBurst int `json:",omitempty"`
+
+	// PacketsPerSecond is the sustained rate of packets.
+	PacketsPerSecond float64 `json:",omitempty"`
+
+	// PacketsBurst is the number of packets that may be sent in a burst
+	// above PacketsPerSecond. If zero, it defaults to one second's worth.
+	PacketsBurst int `json:",omitempty"`
+}
+
+// IsZero reports whether l doesn't limit anything.
+func (l DERPClientRateLimit) IsZero() bool {
+	return l.BytesPerSecond <= 0 && l.PacketsPerSecond <= 0
 }
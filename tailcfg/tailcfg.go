@@ -2722,6 +2722,16 @@ type Oauth2Token struct {
 	// default behavior is to trust the control plane when it claims that a
 	// node is no longer online, but that is not a reliable signal.
 	NodeAttrClientSideReachability = "client-side-reachability"
+
+	// NodeAttrMultipathDuplicate makes the client also send each packet to
+	// a peer over a second path, when one is available, so that loss on
+	// either path alone doesn't drop it.
+	NodeAttrMultipathDuplicate NodeCapability = "multipath-duplicate"
+
+	// NodeAttrMultipathStripe makes the client alternate packets to a peer
+	// between two paths, when a second one is available, to spread its
+	// traffic across both. NodeAttrMultipathDuplicate takes precedence.
+	NodeAttrMultipathStripe NodeCapability = "multipath-stripe"
 )
 
 // SetDNSRequest is a request to add a DNS record.
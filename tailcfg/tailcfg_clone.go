@@ -481,11 +481,13 @@ func (src *DERPNode) Clone() *DERPNode {
 	IPv4             string
 	IPv6             string
 	STUNPort         int
+	STUNAltPort      int
 	STUNOnly         bool
 	DERPPort         int
 	InsecureForTests bool
 	STUNTestIP       string
 	CanPort80        bool
+	QUICPort         int
 }{})
 
 // Clone makes a deep copy of SSHRule.
@@ -1855,6 +1855,13 @@ func (v DERPNodeView) IPv6() string { return v.ж.IPv6 }
 // To disable STUN on this node, use -1.
 func (v DERPNodeView) STUNPort() int { return v.ж.STUNPort }
 
+// STUNAltPort, if non-zero, is a second UDP port on which the
+// node's STUN server listens. The server answers binding requests
+// that ask it to change port (RFC 5780 CHANGE-REQUEST) from the
+// other port. Clients use it to classify their NAT's mapping and
+// filtering behavior.
+func (v DERPNodeView) STUNAltPort() int { return v.ж.STUNAltPort }
+
 // STUNOnly marks a node as only a STUN server and not a DERP
 // server.
 func (v DERPNodeView) STUNOnly() bool { return v.ж.STUNOnly }
@@ -1877,6 +1884,11 @@ func (v DERPNodeView) STUNTestIP() string { return v.ж.STUNTestIP }
 // on port 80 specifically. This is used for captive portal checks.
 func (v DERPNodeView) CanPort80() bool { return v.ж.CanPort80 }
 
+// QUICPort, if non-zero, is the UDP port on which the DERP node
+// accepts experimental DERP-over-QUIC connections. Clients that
+// support it try QUIC first, falling back to DERPPort over TCP.
+func (v DERPNodeView) QUICPort() int { return v.ж.QUICPort }
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _DERPNodeViewNeedsRegeneration = DERPNode(struct {
 	Name             string
@@ -1886,11 +1898,13 @@ func (v DERPNodeView) CanPort80() bool { return v.ж.CanPort80 }
 	IPv4             string
 	IPv6             string
 	STUNPort         int
+	STUNAltPort      int
 	STUNOnly         bool
 	DERPPort         int
 	InsecureForTests bool
 	STUNTestIP       string
 	CanPort80        bool
+	QUICPort         int
 }{})
 
 // View returns a read-only view of SSHRule.
@@ -50,6 +50,7 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/proxymux"
 	"tailscale.com/net/socks5"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/tsd"
 	"tailscale.com/types/bools"
@@ -57,10 +58,12 @@
 	"tailscale.com/types/logid"
 	"tailscale.com/types/nettype"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/eventbus"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/set"
 	"tailscale.com/util/testenv"
 	"tailscale.com/wgengine"
+	"tailscale.com/wgengine/magicsock"
 	"tailscale.com/wgengine/netstack"
 )
 
@@ -71,13 +74,17 @@ type Server struct {
 	// Dir specifies the name of the directory to use for
 	// state. If empty, a directory is selected automatically
 	// under os.UserConfigDir (https://golang.org/pkg/os/#UserConfigDir).
-	// based on the name of the binary.
+	// based on the name of the binary, and on Hostname if Underlay is set.
 	//
 	// If you want to use multiple tsnet services in the same
 	// binary, you will need to make sure that Dir is set uniquely
 	// for each service. A good pattern for this is to have a
 	// "base" directory (such as your mutable storage folder) and
-	// then append the hostname on the end of it.
+	// then append the hostname on the end of it. The automatic
+	// directory of Servers sharing an Underlay is only unique if
+	// their Hostnames are; Servers without a Hostname need a Dir.
+	// Starting a Server fails if another one in this process
+	// already uses its directory.
 	Dir string
 
 	// Store specifies the state store to use.
@@ -125,15 +132,47 @@ type Server struct {
 
 	// Port is the UDP port to listen on for WireGuard and peer-to-peer
 	// traffic. If zero, a port is automatically selected. Leave this
-	// field at zero unless you know what you are doing.
+	// field at zero unless you know what you are doing. It is ignored if
+	// Underlay is set.
 	Port uint16
 
+	// Underlay optionally specifies the network resources to share with
+	// the other Servers in this process that use it, instead of each
+	// Server having its own.
+	Underlay *Underlay
+
 	// AdvertiseTags specifies tags that should be applied to this node, for
 	// purposes of ACL enforcement. These can be referenced from the ACL policy
 	// document. Note that advertising a tag on the client doesn't guarantee
 	// that the control server will allow the node to adopt that tag.
 	AdvertiseTags []string
 
+	// AdvertiseRoutes specifies subnet routes to offer to the tailnet,
+	// making this node a subnet router. Connections from the tailnet to
+	// addresses in these routes that no listener or FallbackTCPHandler
+	// handles are forwarded to the network outside the tailnet. Note that
+	// the routes still need to be approved in the control server.
+	AdvertiseRoutes []netip.Prefix
+
+	// AdvertiseExitNode, if true, specifies that this node should offer to
+	// be an exit node for internet traffic for the tailnet. As with
+	// AdvertiseRoutes, connections through it are forwarded outside the
+	// tailnet, and the control server needs to approve it.
+	AdvertiseExitNode bool
+
+	// AcceptRoutes, if true, specifies that Dial and the other outbound
+	// methods of Server should use the subnet routes advertised by other
+	// nodes. If false, the platform's default is used, which is to accept
+	// them only on Windows, macOS, iOS and Android.
+	AcceptRoutes bool
+
+	// UseExitNode, if non-empty, specifies the exit node through which Dial
+	// and the other outbound methods of Server send internet traffic. It is
+	// either the Tailscale IP of the exit node or an "auto:" expression such
+	// as "auto:any". To choose an exit node by name, or change it while
+	// running, use SetExitNode.
+	UseExitNode string
+
 	getCertForTesting func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 
 	initOnce         sync.Once
@@ -141,8 +180,10 @@ type Server struct {
 	lb               *ipnlocal.LocalBackend
 	sys              *tsd.System
 	netstack         *netstack.Impl
-	netMon           *netmon.Monitor
-	rootPath         string // the state directory
+	netMon           *netmon.Monitor // owned by Underlay, if set
+	unregisterNetMon func()          // or nil; stops forwarding Underlay's network changes
+	rootPath         string          // the state directory
+	releaseRootPath  func()          // or nil; lets another Server use rootPath
 	hostname         string
 	shutdownCtx      context.Context
 	shutdownCancel   context.CancelFunc
@@ -163,6 +204,78 @@ type Server struct {
 	closed              bool
 }
 
+// Underlay is the network underlay that several Servers in a process can
+// share, to save the memory and UDP ports of giving each its own: a network
+// monitor, and a UDP socket for WireGuard and peer-to-peer traffic along
+// with its NAT port mapping. Packets arriving on the socket are passed to
+// the Server they're for by their WireGuard and disco keys.
+//
+// Its exported fields may be changed until a Server using it is started.
+// It must be closed after the Servers using it.
+type Underlay struct {
+	// Port is the UDP port to listen on. If zero, a port is automatically
+	// selected.
+	Port uint16
+
+	// Logf, if set, is used for logs generated by the underlay.
+	// If unset, logs are discarded.
+	Logf logger.Logf
+
+	initOnce sync.Once
+	initErr  error
+	bus      *eventbus.Bus
+	netMon   *netmon.Monitor
+	sock     *magicsock.SharedSocket
+}
+
+func (u *Underlay) init() error {
+	u.initOnce.Do(func() {
+		if err := u.start(); err != nil {
+			u.initErr = fmt.Errorf("tsnet: underlay: %w", err)
+		}
+	})
+	return u.initErr
+}
+
+func (u *Underlay) start() (reterr error) {
+	var closePool closeOnErrorPool
+	defer closePool.closeAllIfError(&reterr)
+
+	logf := u.Logf
+	if logf == nil {
+		logf = logger.Discard
+	}
+	u.bus = eventbus.New()
+	closePool.addFunc(u.bus.Close)
+
+	var err error
+	u.netMon, err = netmon.New(u.bus, logf)
+	if err != nil {
+		return err
+	}
+	closePool.add(u.netMon)
+	u.netMon.Start()
+
+	u.sock, err = magicsock.NewSharedSocket(logf, u.bus, u.netMon, u.Port)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close releases the resources of u. The Servers using it must be closed
+// first.
+func (u *Underlay) Close() error {
+	u.initOnce.Do(func() { u.initErr = fmt.Errorf("tsnet: underlay: %w", net.ErrClosed) })
+	if u.sock == nil {
+		return nil // never started, or failed to
+	}
+	err := u.sock.Close()
+	u.netMon.Close()
+	u.bus.Close()
+	return err
+}
+
 // FallbackTCPHandler describes the callback which
 // conditionally handles an incoming TCP flow for the
 // provided (src/port, dst/port) 4-tuple. These are registered
@@ -434,7 +547,9 @@ func (s *Server) Close() error {
 	if s.lb != nil {
 		s.lb.Shutdown()
 	}
-	if s.netMon != nil {
+	if s.unregisterNetMon != nil {
+		s.unregisterNetMon()
+	} else if s.netMon != nil {
 		s.netMon.Close()
 	}
 	if s.dialer != nil {
@@ -452,10 +567,41 @@ func (s *Server) Close() error {
 	}
 	wg.Wait()
 	s.sys.Bus.Get().Close()
+	if s.releaseRootPath != nil {
+		s.releaseRootPath()
+	}
 	s.closed = true
 	return nil
 }
 
+// rootPaths are the state directories of the started Servers in this
+// process, which mustn't share one.
+var rootPaths struct {
+	mu    sync.Mutex
+	inUse set.Set[string]
+}
+
+// claimRootPath claims the state directory dir for a Server, returning a
+// func to release it once the Server is closed. It fails if another
+// Server in this process uses dir.
+func claimRootPath(dir string) (release func(), err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	rootPaths.mu.Lock()
+	defer rootPaths.mu.Unlock()
+	if rootPaths.inUse.Contains(dir) {
+		return nil, fmt.Errorf("tsnet: state directory %s is already used by another Server in this process; set Dir or Hostname to tell them apart", dir)
+	}
+	mak.Set(&rootPaths.inUse, dir, struct{}{})
+	return sync.OnceFunc(func() {
+		rootPaths.mu.Lock()
+		defer rootPaths.mu.Unlock()
+		rootPaths.inUse.Delete(dir)
+	}), nil
+}
+
 func (s *Server) doInit() {
 	s.shutdownCtx, s.shutdownCancel = context.WithCancel(context.Background())
 	if err := s.start(); err != nil {
@@ -560,6 +706,11 @@ func (s *Server) start() (reterr error) {
 			return err
 		}
 		s.rootPath = filepath.Join(confDir, "tsnet-"+prog)
+		if s.Underlay != nil {
+			// Several Servers in this process are meant to share the
+			// underlay, so don't have them share their state too.
+			s.rootPath += "-" + s.hostname
+		}
 	}
 	if err := os.MkdirAll(s.rootPath, 0700); err != nil {
 		return err
@@ -569,6 +720,11 @@ func (s *Server) start() (reterr error) {
 	} else if !fi.IsDir() {
 		return fmt.Errorf("%v is not a directory", s.rootPath)
 	}
+	s.releaseRootPath, err = claimRootPath(s.rootPath)
+	if err != nil {
+		return err
+	}
+	closePool.addFunc(s.releaseRootPath)
 
 	tsLogf := func(format string, a ...any) {
 		if s.logtail != nil {
@@ -586,17 +742,40 @@ func (s *Server) start() (reterr error) {
 		return err
 	}
 
-	s.netMon, err = netmon.New(sys.Bus.Get(), tsLogf)
-	if err != nil {
-		return err
+	var sharedSocket *magicsock.SharedSocket
+	if u := s.Underlay; u != nil {
+		if err := u.init(); err != nil {
+			return err
+		}
+		s.netMon = u.netMon
+		sharedSocket = u.sock
+
+		// Our subsystems learn of network changes on our own bus, so
+		// forward those of the shared monitor there.
+		ec := sys.Bus.Get().Client("tsnet.Underlay")
+		pub := eventbus.Publish[netmon.ChangeDelta](ec)
+		unregister := u.netMon.RegisterChangeCallback(func(delta *netmon.ChangeDelta) {
+			pub.Publish(*delta)
+		})
+		s.unregisterNetMon = func() {
+			unregister()
+			ec.Close()
+		}
+		closePool.addFunc(s.unregisterNetMon)
+	} else {
+		s.netMon, err = netmon.New(sys.Bus.Get(), tsLogf)
+		if err != nil {
+			return err
+		}
+		closePool.add(s.netMon)
 	}
-	closePool.add(s.netMon)
 
 	s.dialer = &tsdial.Dialer{Logf: tsLogf} // mutated below (before used)
 	s.dialer.SetBus(sys.Bus.Get())
 	eng, err := wgengine.NewUserspaceEngine(tsLogf, wgengine.Config{
 		EventBus:      sys.Bus.Get(),
 		ListenPort:    s.Port,
+		SharedSocket:  sharedSocket,
 		NetMon:        s.netMon,
 		Dialer:        s.dialer,
 		SetSubsystem:  sys.Set,
@@ -684,6 +863,20 @@ func (s *Server) start() (reterr error) {
 	prefs.ControlURL = s.ControlURL
 	prefs.RunWebClient = s.RunWebClient
 	prefs.AdvertiseTags = s.AdvertiseTags
+	prefs.AdvertiseRoutes = s.AdvertiseRoutes
+	prefs.SetAdvertiseExitNode(s.AdvertiseExitNode)
+	if s.AcceptRoutes {
+		prefs.RouteAll = true
+	}
+	if expr, ok := ipn.ParseAutoExitNodeString(s.UseExitNode); ok {
+		prefs.AutoExitNode = expr
+	} else if s.UseExitNode != "" {
+		ip, err := netip.ParseAddr(s.UseExitNode)
+		if err != nil {
+			return fmt.Errorf("UseExitNode %q is not an IP address or auto: expression; use SetExitNode to choose an exit node by name", s.UseExitNode)
+		}
+		prefs.ExitNodeIP = ip
+	}
 	authKey := s.getAuthKey()
 	// Try to use an OAuth secret to generate an auth key if that functionality
 	// is available.
@@ -913,27 +1106,123 @@ func (s *Server) getTCPHandlerForFunnelFlow(src netip.AddrPort, dstPort uint16)
 func (s *Server) getTCPHandlerForFlow(src, dst netip.AddrPort) (handler func(net.Conn), intercept bool) {
 	ln, ok := s.listenerForDstAddr("tcp", dst, false)
 	if !ok {
-		s.mu.Lock()
-		defer s.mu.Unlock()
-		for _, handler := range s.fallbackTCPHandlers {
-			connHandler, intercept := handler(src, dst)
-			if intercept {
-				return connHandler, intercept
-			}
+		if connHandler, intercept := s.fallbackTCPHandler(src, dst); intercept {
+			return connHandler, intercept
+		}
+		// Not under s.mu, as shouldForward reads the LocalBackend's prefs.
+		if s.shouldForward(dst.Addr()) {
+			return nil, false // let netstack forward it
 		}
 		return nil, true // don't handle, don't forward to localhost
 	}
 	return ln.handle, true
 }
 
+// fallbackTCPHandler returns the handler of the first FallbackTCPHandler
+// that intercepts a flow from src to dst, if any.
+func (s *Server) fallbackTCPHandler(src, dst netip.AddrPort) (handler func(net.Conn), intercept bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, handler := range s.fallbackTCPHandlers {
+		connHandler, intercept := handler(src, dst)
+		if intercept {
+			return connHandler, intercept
+		}
+	}
+	return nil, false
+}
+
 func (s *Server) getUDPHandlerForFlow(src, dst netip.AddrPort) (handler func(nettype.ConnPacketConn), intercept bool) {
 	ln, ok := s.listenerForDstAddr("udp", dst, false)
 	if !ok {
+		if s.shouldForward(dst.Addr()) {
+			return nil, false // let netstack forward it
+		}
 		return nil, true // don't handle, don't forward to localhost
 	}
 	return func(c nettype.ConnPacketConn) { ln.handle(c) }, true
 }
 
+// shouldForward reports whether netstack should forward a flow to dst that
+// no listener handles out of the tailnet, as dst is in a subnet route or
+// exit node route that s advertises.
+func (s *Server) shouldForward(dst netip.Addr) bool {
+	if tsaddr.IsTailscaleIP(dst) || dst.IsLoopback() || dst.IsUnspecified() {
+		return false
+	}
+	for _, r := range s.lb.Prefs().AdvertiseRoutes().All() {
+		if r.Contains(dst) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAdvertiseRoutes changes the subnet routes s offers to the tailnet, and
+// whether it offers to be an exit node, as AdvertiseRoutes and
+// AdvertiseExitNode do at start.
+//
+// It will start the server if it has not been started yet.
+func (s *Server) SetAdvertiseRoutes(routes []netip.Prefix, exitNode bool) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	mp := &ipn.MaskedPrefs{
+		Prefs:              ipn.Prefs{AdvertiseRoutes: slices.Clone(routes)},
+		AdvertiseRoutesSet: true,
+	}
+	mp.Prefs.SetAdvertiseExitNode(exitNode)
+	_, err := s.lb.EditPrefs(mp)
+	return err
+}
+
+// SetAcceptRoutes changes whether s uses the subnet routes advertised by
+// other nodes, as AcceptRoutes does at start.
+//
+// It will start the server if it has not been started yet.
+func (s *Server) SetAcceptRoutes(accept bool) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	_, err := s.lb.EditPrefs(&ipn.MaskedPrefs{
+		Prefs:       ipn.Prefs{RouteAll: accept},
+		RouteAllSet: true,
+	})
+	return err
+}
+
+// SetExitNode changes the exit node through which Dial and the other
+// outbound methods of Server send internet traffic. exitNode is the exit
+// node's Tailscale IP, its MagicDNS name, or an "auto:" expression such as
+// "auto:any". If exitNode is empty, internet traffic is sent directly.
+//
+// It will start the server if it has not been started yet. To look up a
+// node by name, it waits for the server to be running.
+func (s *Server) SetExitNode(ctx context.Context, exitNode string) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	mp := &ipn.MaskedPrefs{
+		ExitNodeIDSet:   true,
+		ExitNodeIPSet:   true,
+		AutoExitNodeSet: true,
+	}
+	if expr, ok := ipn.ParseAutoExitNodeString(exitNode); ok {
+		mp.AutoExitNode = expr
+	} else if exitNode != "" {
+		if _, err := netip.ParseAddr(exitNode); err != nil {
+			if err := s.awaitRunning(ctx); err != nil {
+				return err
+			}
+		}
+		if err := mp.SetExitNodeIP(exitNode, s.lb.Status()); err != nil {
+			return err
+		}
+	}
+	_, err := s.lb.EditPrefs(mp)
+	return err
+}
+
 // Listen announces only on the Tailscale network.
 // It will start the server if it has not been started yet.
 //
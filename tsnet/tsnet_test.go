@@ -41,6 +41,7 @@
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/net/netns"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
 	"tailscale.com/tstest/deptest"
@@ -790,6 +791,275 @@ func TestFallbackTCPHandler(t *testing.T) {
 	}
 }
 
+func TestRoutePrefs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	controlURL, _ := startControl(t)
+
+	s := &Server{
+		Dir:               t.TempDir(),
+		ControlURL:        controlURL,
+		Hostname:          "router",
+		Store:             new(mem.Store),
+		Ephemeral:         true,
+		AdvertiseRoutes:   []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+		AdvertiseExitNode: true,
+		AcceptRoutes:      true,
+		UseExitNode:       "auto:any",
+	}
+	defer s.Close()
+	if _, err := s.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	p := s.lb.Prefs()
+	if got := p.AdvertiseRoutes().Len(); got != 3 || !p.AdvertiseRoutes().ContainsFunc(func(r netip.Prefix) bool { return r.String() == "192.0.2.0/24" }) {
+		t.Errorf("AdvertiseRoutes = %v; want 192.0.2.0/24 and exit routes", p.AdvertiseRoutes())
+	}
+	if !p.RouteAll() {
+		t.Error("RouteAll = false; want true")
+	}
+	if got := p.AutoExitNode(); got != "any" {
+		t.Errorf("AutoExitNode = %q; want any", got)
+	}
+
+	bad := &Server{
+		Dir:         t.TempDir(),
+		ControlURL:  controlURL,
+		Store:       new(mem.Store),
+		Ephemeral:   true,
+		UseExitNode: "some-node",
+	}
+	defer bad.Close()
+	if err := bad.Start(); err == nil {
+		t.Error("Start with exit node name succeeded; want error")
+	}
+}
+
+func TestSubnetRouterAndExitNode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	controlURL, c := startControl(t)
+	s1, _, s1PubKey := startServer(t, ctx, controlURL, "s1")
+	s2, _, _ := startServer(t, ctx, controlURL, "s2")
+
+	subnet := netip.MustParsePrefix("192.0.2.0/24")
+	if err := s1.SetAdvertiseRoutes([]netip.Prefix{subnet}, true); err != nil {
+		t.Fatal(err)
+	}
+	c.SetSubnetRoutes(s1PubKey, []netip.Prefix{subnet, tsaddr.AllIPv4(), tsaddr.AllIPv6()})
+	if err := s2.SetAcceptRoutes(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flows to the advertised routes are forwarded unless something
+	// handles them.
+	src := netip.MustParseAddrPort("100.64.0.2:1234")
+	for _, dst := range []string{"192.0.2.1:80", "198.51.100.1:80"} {
+		if _, intercept := s1.getTCPHandlerForFlow(src, netip.MustParseAddrPort(dst)); intercept {
+			t.Errorf("flow to %v intercepted; want forwarded", dst)
+		}
+	}
+	if _, intercept := s1.getTCPHandlerForFlow(src, netip.MustParseAddrPort("127.0.0.1:80")); !intercept {
+		t.Error("flow to loopback forwarded")
+	}
+	if _, intercept := s2.getTCPHandlerForFlow(src, netip.MustParseAddrPort("192.0.2.1:80")); !intercept {
+		t.Error("flow to route s2 doesn't advertise forwarded")
+	}
+
+	gotDst := make(chan netip.AddrPort, 1)
+	s1.RegisterFallbackTCPHandler(func(src, dst netip.AddrPort) (handler func(net.Conn), intercept bool) {
+		return func(c net.Conn) {
+			gotDst <- dst
+			c.Close()
+		}, true
+	})
+	dial := func(addr string) {
+		t.Helper()
+		conn, err := s2.Dial(ctx, "tcp", addr)
+		if err != nil {
+			t.Fatalf("dialing %v: %v", addr, err)
+		}
+		defer conn.Close()
+		select {
+		case dst := <-gotDst:
+			if dst.String() != addr {
+				t.Errorf("s1 got flow to %v; want %v", dst, addr)
+			}
+		case <-ctx.Done():
+			t.Fatalf("s1 didn't get flow to %v", addr)
+		}
+	}
+
+	s1.lb.DebugForceNetmapUpdate()
+	s2.lb.DebugForceNetmapUpdate()
+	lc2, err := s2.LocalClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForCondition(t, "s1's routes available on s2", 90*time.Second, func() bool {
+		st, err := lc2.Status(ctx)
+		if err != nil {
+			return false
+		}
+		ps, ok := st.Peer[s1PubKey]
+		return ok && ps.ExitNodeOption && ps.PrimaryRoutes != nil && ps.PrimaryRoutes.ContainsFunc(func(r netip.Prefix) bool { return r == subnet })
+	})
+
+	dial("192.0.2.1:80")
+
+	if err := s2.SetExitNode(ctx, "s1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s2.lb.Prefs().ExitNodeID(); got.IsZero() {
+		t.Fatal("exit node not set")
+	}
+	dial("198.51.100.1:80")
+
+	if err := s2.SetExitNode(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := s2.lb.Prefs().ExitNodeID(); !got.IsZero() {
+		t.Errorf("exit node %v still set", got)
+	}
+}
+
+func TestDirInUse(t *testing.T) {
+	tstest.ResourceCheck(t)
+	controlURL, _ := startControl(t)
+
+	dir := t.TempDir()
+	newServer := func() *Server {
+		s := &Server{
+			Dir:        dir,
+			ControlURL: controlURL,
+			Store:      new(mem.Store),
+			Ephemeral:  true,
+		}
+		if *verboseNodes {
+			s.Logf = t.Logf
+		}
+		return s
+	}
+	s1 := newServer()
+	if err := s1.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := newServer().Start(); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Fatalf("starting second Server with the same Dir: got %v; want error", err)
+	}
+	s1.Close()
+
+	s3 := newServer()
+	defer s3.Close()
+	if err := s3.Start(); err != nil {
+		t.Fatalf("starting Server after the first closed: %v", err)
+	}
+}
+
+func TestUnderlay(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	controlURL, _ := startControl(t)
+
+	u := &Underlay{Logf: t.Logf}
+	t.Cleanup(func() { u.Close() }) // after the Servers using it
+	startShared := func(hostname string) (*Server, netip.Addr) {
+		s := &Server{
+			Dir:        filepath.Join(t.TempDir(), hostname),
+			ControlURL: controlURL,
+			Hostname:   hostname,
+			Store:      new(mem.Store),
+			Ephemeral:  true,
+			Underlay:   u,
+		}
+		if *verboseNodes {
+			s.Logf = t.Logf
+		}
+		t.Cleanup(func() { s.Close() })
+		status, err := s.Up(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s, status.TailscaleIPs[0]
+	}
+	s1, s1ip := startShared("s1")
+	s2, s2ip := startShared("s2")
+	s3, s3ip, _ := startServer(t, ctx, controlURL, "s3")
+
+	port1, port2 := s1.sys.MagicSock.Get().LocalPort(), s2.sys.MagicSock.Get().LocalPort()
+	if port1 != port2 {
+		t.Errorf("shared Servers listen on ports %d and %d", port1, port2)
+	}
+	if port3 := s3.sys.MagicSock.Get().LocalPort(); port3 == port1 {
+		t.Errorf("separate Server listens on shared port %d", port1)
+	}
+
+	lc1, err := s1.LocalClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc2, err := s2.LocalClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc3, err := s3.LocalClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		from   *Server
+		to     *Server
+		toIP   netip.Addr
+		toPort int
+	}{
+		{"s1-to-s2", s1, s2, s2ip, 8081},
+		{"s2-to-s1", s2, s1, s1ip, 8082},
+		{"s3-to-s1", s3, s1, s1ip, 8083},
+		{"s2-to-s3", s2, s3, s3ip, 8084},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := tt.to.Listen("tcp", fmt.Sprintf(":%d", tt.toPort))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+
+			w, err := tt.from.Dial(ctx, "tcp", netip.AddrPortFrom(tt.toIP, uint16(tt.toPort)).String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			r, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			want := "hello " + tt.name
+			if _, err := io.WriteString(w, want); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, len(want))
+			if _, err := io.ReadFull(r, got); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+
+	// The traffic above went over the shared socket, not DERP.
+	mustDirect(t, t.Logf, lc1, lc2)
+	mustDirect(t, t.Logf, lc2, lc1)
+	mustDirect(t, t.Logf, lc1, lc3)
+	mustDirect(t, t.Logf, lc3, lc2)
+}
+
 func TestCapturePcap(t *testing.T) {
 	const timeLimit = 120
 	ctx, cancel := context.WithTimeout(context.Background(), timeLimit*time.Second)
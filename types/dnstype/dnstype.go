@@ -17,13 +17,17 @@ type Resolver struct {
 	//  - A plain IP address for a "classic" UDP+TCP DNS resolver.
 	//    This is the common format as sent by the control plane.
 	//  - An IP:port, for tests.
-	//  - "https://resolver.com/path" for DNS over HTTPS; currently
-	//    as of 2022-09-08 only used for certain well-known resolvers
-	//    (see the publicdns package) for which the IP addresses to dial DoH are
-	//    known ahead of time, so bootstrap DNS resolution is not required.
+	//  - "https://resolver.com/path" for DNS over HTTPS (RFC 8484), sent
+	//    using POST requests. A "{?dns}" URI Template suffix, as in
+	//    "https://resolver.com/dns-query{?dns}", selects GET requests instead.
+	//    The IP addresses of well-known resolvers (see the publicdns package)
+	//    are known ahead of time; other hostnames are dialed using
+	//    BootstrapResolution, if set.
 	//  - "http://node-address:port/path" for DNS over HTTP over WireGuard. This
 	//    is implemented in the PeerAPI for exit nodes and app connectors.
-	//  - [TODO] "tls://resolver.com" for DNS over TCP+TLS
+	//  - "tls://resolver.com[:port]" for DNS over TCP+TLS (RFC 7858).
+	//    The port defaults to 853. If resolver.com is a hostname,
+	//    BootstrapResolution is used to dial it, if set.
 	Addr string `json:",omitempty"`
 
 	// BootstrapResolution is an optional suggested resolution for the
@@ -33,7 +37,7 @@ type Resolver struct {
 	// look up the DoT/DoH server using their local "classic" DNS
 	// resolver.
 	//
-	// As of 2022-09-08, BootstrapResolution is not yet used.
+	// BootstrapResolution is only used for DoT and DoH resolvers.
 	BootstrapResolution []netip.Addr `json:",omitempty"`
 
 	// UseWithExitNode designates that this resolver should continue to be used when an
@@ -88,13 +88,17 @@ func (v *ResolverView) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
 //   - A plain IP address for a "classic" UDP+TCP DNS resolver.
 //     This is the common format as sent by the control plane.
 //   - An IP:port, for tests.
-//   - "https://resolver.com/path" for DNS over HTTPS; currently
-//     as of 2022-09-08 only used for certain well-known resolvers
-//     (see the publicdns package) for which the IP addresses to dial DoH are
-//     known ahead of time, so bootstrap DNS resolution is not required.
+//   - "https://resolver.com/path" for DNS over HTTPS (RFC 8484), sent
+//     using POST requests. A "{?dns}" URI Template suffix, as in
+//     "https://resolver.com/dns-query{?dns}", selects GET requests instead.
+//     The IP addresses of well-known resolvers (see the publicdns package)
+//     are known ahead of time; other hostnames are dialed using
+//     BootstrapResolution, if set.
 //   - "http://node-address:port/path" for DNS over HTTP over WireGuard. This
 //     is implemented in the PeerAPI for exit nodes and app connectors.
-//   - [TODO] "tls://resolver.com" for DNS over TCP+TLS
+//   - "tls://resolver.com[:port]" for DNS over TCP+TLS (RFC 7858).
+//     The port defaults to 853. If resolver.com is a hostname,
+//     BootstrapResolution is used to dial it, if set.
 func (v ResolverView) Addr() string { return v.ж.Addr }
 
 // BootstrapResolution is an optional suggested resolution for the
@@ -104,7 +108,7 @@ func (v ResolverView) Addr() string { return v.ж.Addr }
 // look up the DoT/DoH server using their local "classic" DNS
 // resolver.
 //
-// As of 2022-09-08, BootstrapResolution is not yet used.
+// BootstrapResolution is only used for DoT and DoH resolvers.
 func (v ResolverView) BootstrapResolution() views.Slice[netip.Addr] {
 	return views.SliceOf(v.ж.BootstrapResolution)
 }
@@ -65,6 +65,9 @@
 	// debugNeverDirectUDP disables the use of direct UDP connections, forcing
 	// all peer communication over DERP or peer relay.
 	debugNeverDirectUDP = envknob.RegisterBool("TS_DEBUG_NEVER_DIRECT_UDP")
+	// debugMultipath, if "duplicate" or "stripe", sends to peers over a
+	// second path as if the corresponding multipath node attribute were set.
+	debugMultipath = envknob.RegisterString("TS_DEBUG_MULTIPATH")
 	// Hey you! Adding a new debugknob? Make sure to stub it out in the
 	// debugknobs_stubs.go file too.
 )
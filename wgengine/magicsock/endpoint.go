@@ -5,6 +5,7 @@
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"encoding/binary"
 	"errors"
@@ -88,6 +89,7 @@ type endpoint struct {
 	sentPing           map[stun.TxID]sentPing
 	endpointState      map[netip.AddrPort]*endpointState // netip.AddrPort type for key (instead of [epAddr]) as [endpointState] is irrelevant for Geneve-encapsulated paths
 	isCallMeMaybeEP    map[netip.AddrPort]bool
+	multipathSeq       uint64 // packets sent while multipath is on, for alternating paths
 
 	// The following fields are related to the new "silent disco"
 	// implementation that's a WIP as of 2022-10-20.
@@ -401,6 +403,8 @@ type endpointState struct {
 	recentPongs []pongReply // ring buffer up to pongHistoryCount entries
 	recentPong  uint16      // index into recentPongs of most recent; older before, wrapped
 
+	quality pathQuality // loss and latency of recent pings, for demoting lossy paths
+
 	index int16 // index in nodecfg.Node.Endpoints; meaningless if lastGotPing non-zero
 }
 
@@ -857,6 +861,13 @@ func (de *endpoint) heartbeat() {
 	if udpAddr.ap.IsValid() {
 		// We have a preferred path. Ping that every 'heartbeatInterval'.
 		de.startDiscoPingLocked(udpAddr, now, pingHeartbeat, 0, nil)
+
+		// Keep a second path warm too if we might send over it.
+		if de.c.multipathMode() != multipathOff {
+			if second := de.multipathAddrLocked(now, udpAddr, multipathStandbyTimeout); second.ap.IsValid() {
+				de.startDiscoPingLocked(second, now, pingHeartbeat, 0, nil)
+			}
+		}
 	}
 
 	if de.wantFullPingLocked(now) {
@@ -1035,6 +1046,9 @@ func (de *endpoint) discoPing(res *ipnstate.PingResult, size int, cb func(*ipnst
 )
 
 func (de *endpoint) send(buffs [][]byte, offset int) error {
+	if s := de.c.sharedSocket; s != nil {
+		s.noteHandshakesSent(de.c, buffs, offset)
+	}
 	de.mu.Lock()
 	if de.expired {
 		de.mu.Unlock()
@@ -1044,6 +1058,23 @@ func (de *endpoint) send(buffs [][]byte, offset int) error {
 	now := mono.Now()
 	udpAddr, derpAddr, startWGPing := de.addrForSendLocked(now)
 
+	// With multipath on, also use a second direct path if we have one.
+	var (
+		mode      multipathMode
+		secondary epAddr
+		seq       uint64
+	)
+	if udpAddr.ap.IsValid() && !derpAddr.IsValid() {
+		if mode = de.c.multipathMode(); mode != multipathOff {
+			secondary = de.multipathAddrLocked(now, udpAddr, trustUDPAddrDuration)
+			if !secondary.ap.IsValid() {
+				metricMultipathNoSecondary.Add(1)
+			}
+			seq = de.multipathSeq
+			de.multipathSeq += uint64(len(buffs))
+		}
+	}
+
 	if de.isWireguardOnly {
 		if startWGPing {
 			de.sendWireGuardOnlyPingsLocked(now)
@@ -1071,43 +1102,29 @@ func (de *endpoint) send(buffs [][]byte, offset int) error {
 	}
 	var err error
 	if udpAddr.ap.IsValid() {
-		_, err = de.c.sendUDPBatch(udpAddr, buffs, offset)
-
-		// If the error is known to indicate that the endpoint is no longer
-		// usable, clear the endpoint statistics so that the next send will
-		// re-evaluate the best endpoint.
-		if err != nil && isBadEndpointErr(err) {
-			de.noteBadEndpoint(udpAddr)
-		}
-
-		var txBytes int
-		for _, b := range buffs {
-			txBytes += len(b[offset:])
-		}
-
 		switch {
-		case udpAddr.ap.Addr().Is4():
-			if udpAddr.vni.IsSet() {
-				de.c.metrics.outboundPacketsPeerRelayIPv4Total.Add(int64(len(buffs)))
-				de.c.metrics.outboundBytesPeerRelayIPv4Total.Add(int64(txBytes))
-			} else {
-				de.c.metrics.outboundPacketsIPv4Total.Add(int64(len(buffs)))
-				de.c.metrics.outboundBytesIPv4Total.Add(int64(txBytes))
+		case !secondary.ap.IsValid():
+			err = de.sendDataUDP(udpAddr, buffs, offset)
+		case mode == multipathDuplicate:
+			err = de.sendDataUDP(udpAddr, buffs, offset)
+			metricMultipathSendDuplicated.Add(int64(len(buffs)))
+			if err2 := de.sendDataUDP(secondary, buffs, offset); err2 != nil {
+				metricMultipathSendError.Add(1)
+			} else if err != nil {
+				// The copy on the secondary path made it out.
+				metricMultipathSecondarySaved.Add(1)
+				err = nil
 			}
-		case udpAddr.ap.Addr().Is6():
-			if udpAddr.vni.IsSet() {
-				de.c.metrics.outboundPacketsPeerRelayIPv6Total.Add(int64(len(buffs)))
-				de.c.metrics.outboundBytesPeerRelayIPv6Total.Add(int64(txBytes))
-			} else {
-				de.c.metrics.outboundPacketsIPv6Total.Add(int64(len(buffs)))
-				de.c.metrics.outboundBytesIPv6Total.Add(int64(txBytes))
+		default:
+			first, second := splitStripeBuffs(buffs, seq)
+			err = de.sendDataUDP(udpAddr, first, offset)
+			metricMultipathSendStriped.Add(int64(len(second)))
+			if err2 := de.sendDataUDP(secondary, second, offset); err2 != nil {
+				// Fall back to the primary path for this share.
+				metricMultipathSendError.Add(1)
+				err = cmp.Or(de.sendDataUDP(udpAddr, second, offset), err)
 			}
 		}
-
-		// TODO(raggi): needs updating for accuracy, as in error conditions we may have partial sends.
-		if update := de.c.connCounter.Load(); err == nil && update != nil {
-			update(0, netip.AddrPortFrom(de.nodeAddr, 0), udpAddr.ap, len(buffs), txBytes, false)
-		}
 	}
 	if derpAddr.IsValid() {
 		allOk := true
@@ -1133,6 +1150,52 @@ func (de *endpoint) send(buffs [][]byte, offset int) error {
 	return err
 }
 
+// sendDataUDP sends the WireGuard packets in buffs to udpAddr, updating
+// metrics and noting udpAddr as bad if the error says so.
+func (de *endpoint) sendDataUDP(udpAddr epAddr, buffs [][]byte, offset int) error {
+	if len(buffs) == 0 {
+		return nil
+	}
+	_, err := de.c.sendUDPBatch(udpAddr, buffs, offset)
+
+	// If the error is known to indicate that the endpoint is no longer
+	// usable, clear the endpoint statistics so that the next send will
+	// re-evaluate the best endpoint.
+	if err != nil && isBadEndpointErr(err) {
+		de.noteBadEndpoint(udpAddr)
+	}
+
+	var txBytes int
+	for _, b := range buffs {
+		txBytes += len(b[offset:])
+	}
+
+	switch {
+	case udpAddr.ap.Addr().Is4():
+		if udpAddr.vni.IsSet() {
+			de.c.metrics.outboundPacketsPeerRelayIPv4Total.Add(int64(len(buffs)))
+			de.c.metrics.outboundBytesPeerRelayIPv4Total.Add(int64(txBytes))
+		} else {
+			de.c.metrics.outboundPacketsIPv4Total.Add(int64(len(buffs)))
+			de.c.metrics.outboundBytesIPv4Total.Add(int64(txBytes))
+		}
+	case udpAddr.ap.Addr().Is6():
+		if udpAddr.vni.IsSet() {
+			de.c.metrics.outboundPacketsPeerRelayIPv6Total.Add(int64(len(buffs)))
+			de.c.metrics.outboundBytesPeerRelayIPv6Total.Add(int64(txBytes))
+		} else {
+			de.c.metrics.outboundPacketsIPv6Total.Add(int64(len(buffs)))
+			de.c.metrics.outboundBytesIPv6Total.Add(int64(txBytes))
+		}
+	}
+
+	// TODO(raggi): needs updating for accuracy, as in error conditions we may have partial sends.
+	if update := de.c.connCounter.Load(); err == nil && update != nil {
+		update(0, netip.AddrPortFrom(de.nodeAddr, 0), udpAddr.ap, len(buffs), txBytes, false)
+	}
+	return err
+}
+
 // probeUDPLifetimeCliffDoneLocked is called when a disco
 // pingHeartbeatForUDPLifetime is being cleaned up. result contains the reason
 // for the cleanup, txid contains the ping's txid.
@@ -1181,6 +1244,7 @@ func (de *endpoint) discoPingTimeout(txid stun.TxID) {
 		// TODO(jwhited): consider applying this to direct UDP paths as well
 		de.clearBestAddrLocked()
 	}
+	de.notePathPingLocked(sp, mono.Now(), 0, true)
 	if debugDisco() || !de.bestAddr.ap.IsValid() || bestUntrusted {
 		de.c.dlogf("[v1] magicsock: disco: timeout waiting for pong %x from %v (%v, %v)", txid[:6], sp.to, de.publicKey.ShortString(), de.discoShort())
 	}
@@ -1736,6 +1800,7 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src epAdd
 			from:    src.ap,
 			pongSrc: m.Src,
 		})
+		de.notePathPingLocked(sp, now, latency, false)
 	}
 
 	if sp.purpose != pingHeartbeat && sp.purpose != pingHeartbeatForUDPLifetime {
@@ -1767,7 +1832,7 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src epAdd
 		//  get stuck with a forever untrusted bestAddr that blackholes, since
 		//  we don't clear direct UDP paths on disco ping timeout (see
 		//  discoPingTimeout).
-		if betterAddr(thisPong, de.bestAddr) {
+		if betterAddr(thisPong, de.bestAddr) && !de.pathLossyLocked(sp.to, now) {
 			de.c.logf("magicsock: disco: node %v %v now using %v mtu=%v tx=%x", de.publicKey.ShortString(), de.discoShort(), sp.to, thisPong.wireMTU, m.TxID[:6])
 			de.debugUpdates.Add(EndpointChange{
 				When: time.Now(),
@@ -2006,21 +2071,24 @@ func (de *endpoint) populatePeerStatus(ps *ipnstate.PeerStatus) {
 
 	ps.Relay = de.c.derpRegionCodeOfIDLocked(int(de.derpAddr.Port()))
 
+	now := mono.Now()
 	if de.lastSendExt.IsZero() {
+		ps.Paths = de.peerPathsLocked(now, epAddr{})
 		return
 	}
 
-	now := mono.Now()
 	ps.LastWrite = de.lastSendExt.WallTime()
 	ps.Active = now.Sub(de.lastSendExt) < sessionActiveTimeout
 
-	if udpAddr, derpAddr, _ := de.addrForSendLocked(now); udpAddr.ap.IsValid() && !derpAddr.IsValid() {
+	udpAddr, derpAddr, _ := de.addrForSendLocked(now)
+	if udpAddr.ap.IsValid() && !derpAddr.IsValid() {
 		if udpAddr.vni.IsSet() {
 			ps.PeerRelay = udpAddr.String()
 		} else {
 			ps.CurAddr = udpAddr.String()
 		}
 	}
+	ps.Paths = de.peerPathsLocked(now, udpAddr)
 }
 
 // stopAndReset stops timers associated with de and resets its state back to zero.
@@ -205,6 +205,11 @@ type Conn struct {
 	// If nil, the portmapper is disabled.
 	portMapper portmappertype.Client
 
+	// sharedSocket, if non-nil, is the socket c shares with other Conns.
+	// c.portMapper is then its port mapping, which c doesn't own and only
+	// reads from; see ownPortMapper.
+	sharedSocket *SharedSocket
+
 	// derpRecvCh is used by receiveDERP to read DERP messages.
 	// It must have buffer size > 0; see issue 3736.
 	derpRecvCh chan derpReadResult
@@ -253,6 +258,9 @@ type Conn struct {
 
 	lastNetCheckReport atomic.Pointer[netcheck.Report]
 
+	// netcheckHistory, if non-nil, records netcheck reports over time.
+	netcheckHistory atomic.Pointer[netcheck.History]
+
 	// port is the preferred port from opts.Port; 0 means auto.
 	port atomic.Uint32
 
@@ -498,6 +506,12 @@ type Options struct {
 	// DisablePortMapper, if true, disables the portmapper.
 	// This is primarily useful in tests.
 	DisablePortMapper bool
+
+	// SharedSocket optionally specifies a socket to share with other Conns
+	// in this process, instead of binding one. If set, Port and
+	// TestOnlyPacketListener are ignored, and the SharedSocket's port
+	// mapping is used instead of creating one.
+	SharedSocket *SharedSocket
 }
 
 func (o *Options) logf() logger.Logf {
@@ -707,9 +721,15 @@ func NewConn(opts Options) (*Conn, error) {
 	c.connCtx, c.connCtxCancel = context.WithCancel(context.Background())
 	c.donec = c.connCtx.Done()
 
+	c.sharedSocket = opts.SharedSocket
+
 	// Don't log the same log messages possibly every few seconds in our
 	// portmapper.
-	if buildfeatures.HasPortMapper && !opts.DisablePortMapper {
+	if c.sharedSocket != nil {
+		if !opts.DisablePortMapper {
+			c.portMapper = c.sharedSocket.portMapper
+		}
+	} else if buildfeatures.HasPortMapper && !opts.DisablePortMapper {
 		portmapperLogf := logger.WithPrefix(c.logf, "portmapper: ")
 		portmapperLogf = netmon.LinkChangeLogLimiter(c.connCtx, portmapperLogf, opts.NetMon)
 		var disableUPnP func() bool
@@ -747,17 +767,21 @@ func NewConn(opts Options) (*Conn, error) {
 
 	c.metrics = registerMetrics(opts.Metrics)
 
-	if d4, err := c.listenRawDisco("ip4"); err == nil {
-		c.logf("[v1] using BPF disco receiver for IPv4")
-		c.closeDisco4 = d4
-	} else if !errors.Is(err, errors.ErrUnsupported) {
-		c.logf("[v1] couldn't create raw v4 disco listener, using regular listener instead: %v", err)
-	}
-	if d6, err := c.listenRawDisco("ip6"); err == nil {
-		c.logf("[v1] using BPF disco receiver for IPv6")
-		c.closeDisco6 = d6
-	} else if !errors.Is(err, errors.ErrUnsupported) {
-		c.logf("[v1] couldn't create raw v6 disco listener, using regular listener instead: %v", err)
+	// Disco packets arriving on a shared socket are demultiplexed by it,
+	// which the raw disco listeners would bypass.
+	if c.sharedSocket == nil {
+		if d4, err := c.listenRawDisco("ip4"); err == nil {
+			c.logf("[v1] using BPF disco receiver for IPv4")
+			c.closeDisco4 = d4
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			c.logf("[v1] couldn't create raw v4 disco listener, using regular listener instead: %v", err)
+		}
+		if d6, err := c.listenRawDisco("ip6"); err == nil {
+			c.logf("[v1] using BPF disco receiver for IPv6")
+			c.closeDisco6 = d6
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			c.logf("[v1] couldn't create raw v6 disco listener, using regular listener instead: %v", err)
+		}
 	}
 
 	c.logf("magicsock: disco key = %v", c.discoShort)
@@ -1072,6 +1096,9 @@ func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
 	}
 
 	c.lastNetCheckReport.Store(report)
+	if h := c.netcheckHistory.Load(); h != nil {
+		h.Add(report)
+	}
 	c.noV4.Store(!report.IPv4)
 	c.noV6.Store(!report.IPv6)
 	c.noV4Send.Store(!report.IPv4CanSend)
@@ -1237,6 +1264,28 @@ func (c *Conn) GetEndpointChanges(peer tailcfg.NodeView) ([]EndpointChange, erro
 	return ep.debugUpdates.GetAll(), nil
 }
 
+// GetPeerPaths returns the measured quality of each direct path to peer
+// that's been pinged recently.
+func (c *Conn) GetPeerPaths(peer tailcfg.NodeView) ([]ipnstate.PeerPath, error) {
+	c.mu.Lock()
+	if c.privateKey.IsZero() {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("tailscaled stopped")
+	}
+	ep, ok := c.peerMap.endpointForNodeKey(peer.Key())
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown peer")
+	}
+
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	now := mono.Now()
+	udpAddr, _, _ := ep.addrForSendLocked(now)
+	return ep.peerPathsLocked(now, udpAddr), nil
+}
+
 // DiscoPublicKey returns the discovery public key.
 func (c *Conn) DiscoPublicKey() key.DiscoPublic {
 	return c.discoPublic
@@ -2668,8 +2717,8 @@ func (c *Conn) SetNetworkUp(up bool) {
 	if up {
 		c.startDerpHomeConnectLocked()
 	} else {
-		if c.portMapper != nil {
-			c.portMapper.NoteNetworkDown()
+		if pm := c.ownPortMapper(); pm != nil {
+			pm.NoteNetworkDown()
 		}
 		c.closeAllDerpLocked("network-down")
 	}
@@ -3335,8 +3384,8 @@ func (c *Conn) Close() error {
 		c.derpCleanupTimer.Stop()
 	}
 	c.stopPeriodicReSTUNTimerLocked()
-	if c.portMapper != nil {
-		c.portMapper.Close()
+	if pm := c.ownPortMapper(); pm != nil {
+		pm.Close()
 	}
 
 	c.peerMap.forEachEndpoint(func(ep *endpoint) {
@@ -3470,6 +3519,9 @@ func (c *Conn) listenPacket(network string, port uint16) (nettype.PacketConn, er
 	} else {
 		ctx = sockstats.WithSockStats(ctx, sockstats.LabelMagicsockConnUDP6, c.logf)
 	}
+	if c.sharedSocket != nil {
+		return c.sharedSocket.listen(c, network)
+	}
 	addr := net.JoinHostPort("", fmt.Sprint(port))
 	if c.testOnlyPacketListener != nil {
 		return nettype.MakePacketListenerWithNetIP(c.testOnlyPacketListener).ListenPacket(ctx, network, addr)
@@ -3593,8 +3645,8 @@ func (c *Conn) rebind(curPortFate currentPortFate) error {
 	if err := c.bindSocket(&c.pconn4, "udp4", curPortFate); err != nil {
 		return fmt.Errorf("magicsock: Rebind IPv4 failed: %w", err)
 	}
-	if c.portMapper != nil {
-		c.portMapper.SetLocalPort(c.LocalPort())
+	if pm := c.ownPortMapper(); pm != nil {
+		pm.SetLocalPort(c.LocalPort())
 	}
 	c.UpdatePMTUD()
 	return nil
@@ -3613,6 +3665,10 @@ func (c *Conn) Rebind() {
 	if c.netMon != nil {
 		st := c.netMon.InterfaceState()
 		defIf := st.DefaultRouteInterface
+		if name, _ := netns.BindInterface(); name != "" && st.InterfaceIPs[name] != nil {
+			// Our sockets are pinned to this interface instead.
+			defIf = name
+		}
 		ifIPs = st.InterfaceIPs[defIf]
 		c.logf("Rebind; defIf=%q, ips=%v", defIf, ifIPs)
 	}
@@ -3623,6 +3679,22 @@ func (c *Conn) Rebind() {
 	c.resetEndpointStates()
 }
 
+// RebindAll is like Rebind, but also reconnects to DERP and forgets any
+// port mapping, so that all of magicsock's traffic moves to new sockets
+// after a change to how they're created, such as by
+// [netns.SetBindInterface].
+func (c *Conn) RebindAll(why string) {
+	c.Rebind()
+	c.mu.Lock()
+	if pm := c.ownPortMapper(); pm != nil {
+		pm.NoteNetworkDown()
+	}
+	c.closeAllDerpLocked(why)
+	c.startDerpHomeConnectLocked()
+	c.mu.Unlock()
+	c.ReSTUN(why)
+}
+
 // resetEndpointStates resets the preferred address for all peers.
 // This is called when connectivity changes enough that we no longer
 // trust the old routes.
@@ -4103,6 +4175,49 @@ func (c *Conn) GetLastNetcheckReport(ctx context.Context) *netcheck.Report {
 	return c.lastNetCheckReport.Load()
 }
 
+// SetNetcheckHistory sets the history to record netcheck reports in, or
+// nil to not record them.
+func (c *Conn) SetNetcheckHistory(h *netcheck.History) {
+	c.netcheckHistory.Store(h)
+}
+
+// NetcheckHistory returns the history netcheck reports are recorded in, or
+// nil if they're not.
+func (c *Conn) NetcheckHistory() *netcheck.History {
+	return c.netcheckHistory.Load()
+}
+
+// ownPortMapper returns c's portmapper if c owns it, or nil if the
+// portmapper is disabled or belongs to c's SharedSocket. A shared
+// portmapper is driven by the SharedSocket, not by any one of its Conns.
+func (c *Conn) ownPortMapper() portmappertype.Client {
+	if c.sharedSocket != nil {
+		return nil
+	}
+	return c.portMapper
+}
+
+// SetStaticPortMappings sets the port mappings, beyond the one for its own
+// UDP port, that c's portmapper should create and keep renewed. It's a no-op
+// if the portmapper is disabled or shared with other Conns.
+func (c *Conn) SetStaticPortMappings(mappings []portmappertype.StaticMapping) {
+	if pm := c.ownPortMapper(); pm != nil {
+		pm.SetStaticMappings(mappings)
+	} else if c.sharedSocket != nil && len(mappings) > 0 {
+		c.logf("magicsock: ignoring static port mappings on shared socket")
+	}
+}
+
+// StaticPortMappings returns the state of the port mappings set by
+// SetStaticPortMappings.
+func (c *Conn) StaticPortMappings() []portmappertype.StaticMappingStatus {
+	pm := c.ownPortMapper()
+	if pm == nil {
+		return nil
+	}
+	return pm.StaticMappingStatus()
+}
+
 // SetLastNetcheckReportForTest sets the magicsock conn's last netcheck report.
 // Used for testing purposes.
 func (c *Conn) SetLastNetcheckReportForTest(ctx context.Context, report *netcheck.Report) {
@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"time"
+
+	"tailscale.com/tstime/mono"
+	"tailscale.com/util/clientmetric"
+)
+
+// multipathMode is how an endpoint uses a second path to a peer alongside
+// its best one.
+type multipathMode int
+
+const (
+	multipathOff       multipathMode = iota // only use the best path
+	multipathDuplicate                      // send every packet on both paths
+	multipathStripe                         // alternate packets between the paths
+)
+
+// multipathStandbyTimeout is how long after its last pong a secondary path
+// keeps being heartbeated, so it's ready for use again as soon as it
+// answers.
+const multipathStandbyTimeout = 30 * time.Second
+
+// multipathMode returns how endpoints should use a second path to their
+// peer, as set by control or TS_DEBUG_MULTIPATH.
+func (c *Conn) multipathMode() multipathMode {
+	switch debugMultipath() {
+	case "duplicate":
+		return multipathDuplicate
+	case "stripe":
+		return multipathStripe
+	}
+	if c.controlKnobs == nil {
+		return multipathOff
+	}
+	switch {
+	case c.controlKnobs.MultipathDuplicate.Load():
+		return multipathDuplicate
+	case c.controlKnobs.MultipathStripe.Load():
+		return multipathStripe
+	}
+	return multipathOff
+}
+
+// multipathAddrLocked returns the direct path to use alongside the best
+// one, primary, or the zero value if there's none. Candidates must have
+// answered a ping within the last within and not be lossy. Those on a
+// different IP from primary are preferred, as they're more likely to be
+// on a different network, followed by the lowest latency.
+//
+// de.mu must be held.
+func (de *endpoint) multipathAddrLocked(now mono.Time, primary epAddr, within time.Duration) epAddr {
+	if !primary.isDirect() || de.isWireguardOnly {
+		return epAddr{}
+	}
+	var (
+		best        epAddr
+		bestOtherIP bool
+		bestLatency time.Duration
+	)
+	for ap, st := range de.endpointState {
+		if ap == primary.ap || len(st.recentPongs) == 0 {
+			continue
+		}
+		pong := st.recentPongs[st.recentPong]
+		if now.Sub(pong.pongAt) > within || st.quality.summary(now).lossy() {
+			continue
+		}
+		otherIP := ap.Addr() != primary.ap.Addr()
+		if best.ap.IsValid() && (bestOtherIP && !otherIP || bestOtherIP == otherIP && pong.latency >= bestLatency) {
+			continue
+		}
+		best, bestOtherIP, bestLatency = epAddr{ap: ap}, otherIP, pong.latency
+	}
+	return best
+}
+
+// splitStripeBuffs splits buffs between the primary and secondary paths,
+// alternating starting with the packet number seq.
+func splitStripeBuffs(buffs [][]byte, seq uint64) (primary, secondary [][]byte) {
+	for i, b := range buffs {
+		if (seq+uint64(i))%2 == 0 {
+			primary = append(primary, b)
+		} else {
+			secondary = append(secondary, b)
+		}
+	}
+	return primary, secondary
+}
+
+var (
+	metricMultipathSendDuplicated = clientmetric.NewCounter("magicsock_multipath_send_duplicated")
+	metricMultipathSendStriped    = clientmetric.NewCounter("magicsock_multipath_send_striped")
+	metricMultipathSendError      = clientmetric.NewCounter("magicsock_multipath_send_error")
+	metricMultipathNoSecondary    = clientmetric.NewCounter("magicsock_multipath_no_secondary")
+	metricMultipathSecondarySaved = clientmetric.NewCounter("magicsock_multipath_secondary_saved")
+)
@@ -0,0 +1,122 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/control/controlknobs"
+	"tailscale.com/tstime/mono"
+)
+
+func TestMultipathMode(t *testing.T) {
+	c := &Conn{}
+	if got := c.multipathMode(); got != multipathOff {
+		t.Errorf("without knobs: got %v; want off", got)
+	}
+	c.controlKnobs = new(controlknobs.Knobs)
+	if got := c.multipathMode(); got != multipathOff {
+		t.Errorf("with no knobs set: got %v; want off", got)
+	}
+	c.controlKnobs.MultipathStripe.Store(true)
+	if got := c.multipathMode(); got != multipathStripe {
+		t.Errorf("with stripe: got %v; want stripe", got)
+	}
+	c.controlKnobs.MultipathDuplicate.Store(true)
+	if got := c.multipathMode(); got != multipathDuplicate {
+		t.Errorf("with both: got %v; want duplicate", got)
+	}
+}
+
+func TestMultipathAddrLocked(t *testing.T) {
+	now := mono.Now()
+	primary := epAddr{ap: netip.MustParseAddrPort("1.1.1.1:1")}
+	pongedState := func(ago, latency time.Duration) *endpointState {
+		st := &endpointState{}
+		st.addPongReplyLocked(pongReply{pongAt: now.Add(-ago), latency: latency})
+		return st
+	}
+	lossyState := pongedState(0, time.Millisecond)
+	for range lossyPathMinPings {
+		lossyState.quality.add(pingOutcome{at: now, lost: true})
+	}
+
+	tests := []struct {
+		name   string
+		states map[string]*endpointState
+		want   string
+	}{
+		{
+			name: "none",
+			states: map[string]*endpointState{
+				"1.1.1.1:1": pongedState(0, time.Millisecond),
+			},
+		},
+		{
+			name: "stale",
+			states: map[string]*endpointState{
+				"2.2.2.2:2": pongedState(time.Minute, time.Millisecond),
+				"3.3.3.3:3": {},
+			},
+		},
+		{
+			name: "lossy",
+			states: map[string]*endpointState{
+				"2.2.2.2:2": lossyState,
+			},
+		},
+		{
+			name: "prefer_other_ip",
+			states: map[string]*endpointState{
+				"1.1.1.1:2": pongedState(0, time.Millisecond),
+				"2.2.2.2:2": pongedState(0, 50*time.Millisecond),
+			},
+			want: "2.2.2.2:2",
+		},
+		{
+			name: "prefer_lower_latency",
+			states: map[string]*endpointState{
+				"2.2.2.2:2": pongedState(0, 50*time.Millisecond),
+				"3.3.3.3:3": pongedState(0, 10*time.Millisecond),
+			},
+			want: "3.3.3.3:3",
+		},
+		{
+			name: "same_ip",
+			states: map[string]*endpointState{
+				"1.1.1.1:2": pongedState(0, time.Millisecond),
+			},
+			want: "1.1.1.1:2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			de := &endpoint{endpointState: map[netip.AddrPort]*endpointState{}}
+			for k, st := range tt.states {
+				de.endpointState[netip.MustParseAddrPort(k)] = st
+			}
+			got := de.multipathAddrLocked(now, primary, trustUDPAddrDuration)
+			var want epAddr
+			if tt.want != "" {
+				want.ap = netip.MustParseAddrPort(tt.want)
+			}
+			if got != want {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSplitStripeBuffs(t *testing.T) {
+	buffs := [][]byte{{0}, {1}, {2}, {3}, {4}}
+	first, second := splitStripeBuffs(buffs, 1)
+	if len(first) != 2 || first[0][0] != 1 || first[1][0] != 3 {
+		t.Errorf("first = %v; want [[1] [3]]", first)
+	}
+	if len(second) != 3 || second[0][0] != 0 || second[1][0] != 2 || second[2][0] != 4 {
+		t.Errorf("second = %v; want [[0] [2] [4]]", second)
+	}
+}
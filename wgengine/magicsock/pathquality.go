@@ -0,0 +1,197 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"maps"
+	"net/netip"
+	"slices"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tstime"
+	"tailscale.com/tstime/mono"
+)
+
+// pathQualityWindow is how far back disco ping outcomes count toward the
+// quality of a path.
+const pathQualityWindow = 2 * time.Minute
+
+// pathQualityHistoryCount is how many ping outcomes we keep per path.
+const pathQualityHistoryCount = 64
+
+// lossyPathMinPings is the fewest ping outcomes within pathQualityWindow
+// needed to judge whether a path is lossy.
+const lossyPathMinPings = 8
+
+// lossyPathThreshold is the loss rate at or above which a direct path is
+// demoted, so we fall back to DERP or another path until it recovers.
+const lossyPathThreshold = 0.25
+
+// pingOutcome is the result of a disco ping sent on a path.
+type pingOutcome struct {
+	at      mono.Time     // when the ping was sent
+	latency time.Duration // round-trip time; zero if lost
+	lost    bool          // whether the ping timed out unanswered
+}
+
+// pathQuality is a sliding window of the disco ping outcomes on a path.
+type pathQuality struct {
+	outcomes []pingOutcome // ring buffer up to pathQualityHistoryCount entries
+	next     int           // index into outcomes to overwrite once it's full
+}
+
+// add records the ping outcome o.
+func (q *pathQuality) add(o pingOutcome) {
+	if len(q.outcomes) < pathQualityHistoryCount {
+		q.outcomes = append(q.outcomes, o)
+		return
+	}
+	q.outcomes[q.next] = o
+	q.next = (q.next + 1) % len(q.outcomes)
+}
+
+// recent returns the outcomes within pathQualityWindow of now, oldest
+// first.
+func (q *pathQuality) recent(now mono.Time) []pingOutcome {
+	var ret []pingOutcome
+	for i := range q.outcomes {
+		o := q.outcomes[(q.next+i)%len(q.outcomes)]
+		if now.Sub(o.at) <= pathQualityWindow {
+			ret = append(ret, o)
+		}
+	}
+	return ret
+}
+
+// pathQualitySummary is the summarized state of a pathQuality.
+type pathQualitySummary struct {
+	pings         int
+	lossRate      float64
+	p50, p90, p99 time.Duration
+	jitter        time.Duration
+}
+
+// summary summarizes the outcomes within pathQualityWindow of now.
+func (q *pathQuality) summary(now mono.Time) pathQualitySummary {
+	recent := q.recent(now)
+	s := pathQualitySummary{pings: len(recent)}
+	if len(recent) == 0 {
+		return s
+	}
+	var (
+		lost      int
+		latencies []time.Duration
+		jitterSum time.Duration
+	)
+	for _, o := range recent {
+		if o.lost {
+			lost++
+			continue
+		}
+		if len(latencies) > 0 {
+			d := o.latency - latencies[len(latencies)-1]
+			jitterSum += max(d, -d)
+		}
+		latencies = append(latencies, o.latency)
+	}
+	s.lossRate = float64(lost) / float64(len(recent))
+	if len(latencies) > 1 {
+		s.jitter = jitterSum / time.Duration(len(latencies)-1)
+	}
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		s.p50 = percentile(latencies, 50)
+		s.p90 = percentile(latencies, 90)
+		s.p99 = percentile(latencies, 99)
+	}
+	return s
+}
+
+// lossy reports whether enough of the pings summarized by s were lost
+// that the path shouldn't be used.
+func (s pathQualitySummary) lossy() bool {
+	return s.pings >= lossyPathMinPings && s.lossRate >= lossyPathThreshold
+}
+
+// percentile returns the nearest-rank pth percentile of sorted, which must
+// be non-empty and in ascending order.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p + 99) / 100
+	return sorted[max(i-1, 0)]
+}
+
+// pingCountsForPathQuality reports whether a disco ping of the given size
+// counts toward the quality of its path. Larger MTU probes are expected to
+// go unanswered on paths that don't support them, which isn't loss.
+func pingCountsForPathQuality(size int) bool {
+	return size == 0 || size == mtuProbePingSizesV4[0] || size == mtuProbePingSizesV6[0]
+}
+
+// notePathPingLocked records the outcome of the disco ping sp toward the
+// quality of its path, demoting the path if it's now the best address
+// and lossy.
+//
+// de.mu must be held.
+func (de *endpoint) notePathPingLocked(sp sentPing, now mono.Time, latency time.Duration, lost bool) {
+	if !sp.to.isDirect() || !pingCountsForPathQuality(sp.size) {
+		return
+	}
+	st, ok := de.endpointState[sp.to.ap]
+	if !ok {
+		return
+	}
+	st.quality.add(pingOutcome{at: sp.at, latency: latency, lost: lost})
+	if !lost || sp.to != de.bestAddr.epAddr {
+		return
+	}
+	if s := st.quality.summary(now); s.lossy() {
+		de.c.logf("magicsock: disco: node %v %v demoting lossy path %v (loss=%.0f%% of %d pings)", de.publicKey.ShortString(), de.discoShort(), sp.to, s.lossRate*100, s.pings)
+		de.debugUpdates.Add(EndpointChange{
+			When: time.Now(),
+			What: "notePathPingLocked-lossy-demotion",
+			From: de.bestAddr,
+		})
+		de.clearBestAddrLocked()
+	}
+}
+
+// pathLossyLocked reports whether ep is a direct path that's been demoted
+// for being lossy.
+//
+// de.mu must be held.
+func (de *endpoint) pathLossyLocked(ep epAddr, now mono.Time) bool {
+	if !ep.isDirect() {
+		return false
+	}
+	st, ok := de.endpointState[ep.ap]
+	return ok && st.quality.summary(now).lossy()
+}
+
+// peerPathsLocked returns the quality of each direct path to de that's
+// been pinged within pathQualityWindow, sorted by address. active is the
+// path currently in use, if any.
+//
+// de.mu must be held.
+func (de *endpoint) peerPathsLocked(now mono.Time, active epAddr) []ipnstate.PeerPath {
+	var ret []ipnstate.PeerPath
+	for _, ap := range slices.SortedFunc(maps.Keys(de.endpointState), netip.AddrPort.Compare) {
+		s := de.endpointState[ap].quality.summary(now)
+		if s.pings == 0 {
+			continue
+		}
+		ret = append(ret, ipnstate.PeerPath{
+			Addr:     ap.String(),
+			Active:   epAddr{ap: ap} == active,
+			Demoted:  s.lossy(),
+			Pings:    s.pings,
+			LossRate: s.lossRate,
+			RTTP50:   tstime.GoDuration{Duration: s.p50},
+			RTTP90:   tstime.GoDuration{Duration: s.p90},
+			RTTP99:   tstime.GoDuration{Duration: s.p99},
+			Jitter:   tstime.GoDuration{Duration: s.jitter},
+		})
+	}
+	return ret
+}
@@ -0,0 +1,107 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/tstime/mono"
+)
+
+func TestPathQualitySummary(t *testing.T) {
+	now := mono.Now()
+	var q pathQuality
+
+	// Outside the window, so ignored.
+	q.add(pingOutcome{at: now.Add(-pathQualityWindow - time.Second), lost: true})
+	if got := q.summary(now); got.pings != 0 {
+		t.Fatalf("pings = %d; want 0", got.pings)
+	}
+
+	for i, ms := range []int{10, 30, 20, 0, 40} {
+		at := now.Add(time.Duration(i-5) * time.Second)
+		if ms == 0 {
+			q.add(pingOutcome{at: at, lost: true})
+			continue
+		}
+		q.add(pingOutcome{at: at, latency: time.Duration(ms) * time.Millisecond})
+	}
+	got := q.summary(now)
+	want := pathQualitySummary{
+		pings:    5,
+		lossRate: 0.2,
+		p50:      20 * time.Millisecond,
+		p90:      40 * time.Millisecond,
+		p99:      40 * time.Millisecond,
+		jitter:   (20 + 10 + 20) * time.Millisecond / 3,
+	}
+	if got != want {
+		t.Errorf("summary = %+v; want %+v", got, want)
+	}
+	if got.lossy() {
+		t.Errorf("lossy with %d pings; want not lossy", got.pings)
+	}
+
+	// The ring buffer only keeps the newest pathQualityHistoryCount.
+	for range pathQualityHistoryCount {
+		q.add(pingOutcome{at: now, latency: time.Millisecond})
+	}
+	got = q.summary(now)
+	if got.pings != pathQualityHistoryCount || got.lossRate != 0 || got.p99 != time.Millisecond {
+		t.Errorf("summary after filling = %+v; want %d lossless 1ms pings", got, pathQualityHistoryCount)
+	}
+}
+
+func TestLossyPathDemotion(t *testing.T) {
+	ap := netip.MustParseAddrPort("1.2.3.4:41641")
+	best := epAddr{ap: ap}
+	de := &endpoint{
+		c: &Conn{logf: t.Logf},
+		endpointState: map[netip.AddrPort]*endpointState{
+			ap: {},
+		},
+		bestAddr: addrQuality{epAddr: best, latency: time.Millisecond},
+	}
+
+	now := mono.Now()
+	ping := func(lost bool) {
+		t.Helper()
+		sp := sentPing{to: best, at: now, purpose: pingHeartbeat}
+		var latency time.Duration
+		if !lost {
+			latency = time.Millisecond
+		}
+		de.notePathPingLocked(sp, now, latency, lost)
+	}
+
+	for range lossyPathMinPings - 2 {
+		ping(false)
+	}
+	ping(true)
+	if de.bestAddr.epAddr != best {
+		t.Fatalf("bestAddr cleared after a single loss")
+	}
+	// Oversized MTU probes going unanswered isn't loss.
+	de.notePathPingLocked(sentPing{to: best, at: now, size: mtuProbePingSizesV4[len(mtuProbePingSizesV4)-1]}, now, 0, true)
+	if de.bestAddr.epAddr != best {
+		t.Fatalf("bestAddr cleared after a lost MTU probe")
+	}
+	ping(true)
+	if de.bestAddr.ap.IsValid() {
+		t.Fatalf("bestAddr = %v; want cleared after 2 of %d pings lost", de.bestAddr, lossyPathMinPings)
+	}
+	if !de.pathLossyLocked(best, now) {
+		t.Errorf("pathLossyLocked = false; want true")
+	}
+
+	paths := de.peerPathsLocked(now, epAddr{})
+	if len(paths) != 1 {
+		t.Fatalf("got %d paths; want 1", len(paths))
+	}
+	if p := paths[0]; p.Addr != ap.String() || !p.Demoted || p.Active || p.Pings != lossyPathMinPings || p.LossRate != 0.25 {
+		t.Errorf("path = %+v; want demoted %v with %d pings and 25%% loss", p, ap, lossyPathMinPings)
+	}
+}
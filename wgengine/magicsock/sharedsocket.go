@@ -0,0 +1,513 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"syscall"
+	"time"
+
+	"go4.org/mem"
+	"golang.org/x/crypto/blake2s"
+	"tailscale.com/disco"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/netns"
+	"tailscale.com/net/packet"
+	"tailscale.com/net/portmapper/portmappertype"
+	"tailscale.com/net/stun"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/nettype"
+	"tailscale.com/util/eventbus"
+)
+
+const (
+	// sharedRecvQueueLen is how many packets a sharedConn queues for its
+	// Conn before dropping them, like a full socket receive buffer.
+	sharedRecvQueueLen = 256
+
+	// sharedRouteLifetime is how long a SharedSocket remembers which Conn
+	// sent a WireGuard handshake or STUN request, to route the replies. It
+	// exceeds WireGuard's RejectAfterTime (3 minutes), after which a
+	// session made by a handshake can no longer be used.
+	sharedRouteLifetime = 5 * time.Minute
+
+	// sharedReadBufSize is the size of a SharedSocket's read buffer, which
+	// fits the largest UDP payload.
+	sharedReadBufSize = 1<<16 - 1
+)
+
+// WireGuard message types and sizes, from the WireGuard whitepaper.
+const (
+	wgTypeInitiation  = 1
+	wgTypeResponse    = 2
+	wgTypeCookieReply = 3
+	wgTypeTransport   = 4
+
+	wgInitiationLen  = 148
+	wgResponseLen    = 92
+	wgCookieReplyLen = 64
+	wgTransportMin   = 32
+
+	wgMAC1Offset = wgInitiationLen - 32
+)
+
+// SharedSocket is a UDP socket, and the port mapping for it, that several
+// Conns in one process share for their WireGuard, disco and STUN traffic
+// instead of each binding their own. Set it as the Options.SharedSocket of
+// each Conn.
+//
+// Inbound packets are passed to the Conn they're for:
+//   - WireGuard handshake initiations by their MAC1, which is keyed by the
+//     recipient's node key;
+//   - other WireGuard messages by their receiver index, which the recipient
+//     chose in the handshake message it sent;
+//   - disco messages by their sender, which the recipient has as a peer;
+//   - STUN responses by the transaction ID of the request.
+//
+// Packets that can't be attributed are passed to every Conn, which drop
+// those that aren't theirs. Unlike the sockets of a Conn, a SharedSocket
+// doesn't batch reads and writes, and isn't rebound on network changes.
+//
+// The port mapping is the SharedSocket's own: it tells the portmapper
+// when the network goes down, and the Conns sharing it only use the
+// mapping, without rebinding it or setting static mappings.
+type SharedSocket struct {
+	logf        logger.Logf
+	eventClient *eventbus.Client
+	unregister  func() // or nil; stops watching for network changes
+	pconn4      nettype.PacketConn
+	pconn6      nettype.PacketConn    // or nil if IPv6 is unavailable
+	portMapper  portmappertype.Client // or nil
+
+	mu        sync.Mutex
+	closed    bool
+	conns4    map[*Conn]*sharedConn // the current "udp4" sharedConn of each Conn
+	conns6    map[*Conn]*sharedConn // the current "udp6" sharedConn of each Conn
+	wgIndexes map[uint32]sharedRoute
+	stunTxIDs map[stun.TxID]sharedRoute
+	lastPrune mono.Time
+}
+
+// sharedRoute records which Conn sent a packet that replies are expected
+// to.
+type sharedRoute struct {
+	c    *Conn
+	sent mono.Time
+}
+
+// NewSharedSocket returns a new SharedSocket listening on port, or on a
+// port picked automatically if zero. Port mapping changes are published to
+// bus.
+func NewSharedSocket(logf logger.Logf, bus *eventbus.Bus, netMon *netmon.Monitor, port uint16) (*SharedSocket, error) {
+	s := &SharedSocket{
+		logf:      logger.WithPrefix(logf, "magicsock: shared socket: "),
+		conns4:    make(map[*Conn]*sharedConn),
+		conns6:    make(map[*Conn]*sharedConn),
+		wgIndexes: make(map[uint32]sharedRoute),
+		stunTxIDs: make(map[stun.TxID]sharedRoute),
+	}
+	ln := nettype.MakePacketListenerWithNetIP(netns.Listener(s.logf, netMon))
+	addr := net.JoinHostPort("", fmt.Sprint(port))
+	var err error
+	s.pconn4, err = ln.ListenPacket(context.Background(), "udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	// Use the same port for IPv6 as for IPv4, if we can.
+	addr6 := net.JoinHostPort("", fmt.Sprint(s.pconn4.LocalAddr().(*net.UDPAddr).Port))
+	if s.pconn6, err = ln.ListenPacket(context.Background(), "udp6", addr6); err != nil {
+		s.logf("ignoring IPv6 bind failure: %v", err)
+		s.pconn6 = nil
+	}
+	trySetUDPSocketOptions(s.pconn4, s.logf)
+	if s.pconn6 != nil {
+		trySetUDPSocketOptions(s.pconn6, s.logf)
+	}
+
+	s.eventClient = bus.Client("magicsock.SharedSocket")
+	eventbus.SubscribeFunc(s.eventClient, s.onPortMapChanged)
+	if newPortMapper, ok := portmappertype.HookNewPortMapper.GetOk(); ok {
+		s.portMapper = newPortMapper(logger.WithPrefix(s.logf, "portmapper: "), bus, netMon, nil, nil)
+		s.portMapper.SetLocalPort(s.LocalPort())
+		s.unregister = netMon.RegisterChangeCallback(s.onLinkChange)
+	}
+
+	go s.receive(s.pconn4, s.conns4)
+	if s.pconn6 != nil {
+		go s.receive(s.pconn6, s.conns6)
+	}
+	return s, nil
+}
+
+// LocalPort returns the port s is listening on.
+func (s *SharedSocket) LocalPort() uint16 {
+	return uint16(s.pconn4.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// Close closes s. The Conns sharing it should be closed first.
+func (s *SharedSocket) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	s.eventClient.Close()
+	if s.unregister != nil {
+		s.unregister()
+	}
+	if s.portMapper != nil {
+		s.portMapper.Close()
+	}
+	err := s.pconn4.Close()
+	if s.pconn6 != nil {
+		s.pconn6.Close()
+	}
+	return err
+}
+
+// onLinkChange invalidates the port mapping when the network goes down, as
+// Conn.SetNetworkUp does for a Conn's own portmapper.
+func (s *SharedSocket) onLinkChange(delta *netmon.ChangeDelta) {
+	if delta.New != nil && !delta.New.AnyInterfaceUp() {
+		s.portMapper.NoteNetworkDown()
+	}
+}
+
+func (s *SharedSocket) onPortMapChanged(portmappertype.Mapping) {
+	s.mu.Lock()
+	conns := make([]*Conn, 0, len(s.conns4))
+	for c := range s.conns4 {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.ReSTUN("portmap-changed")
+	}
+}
+
+// listen returns a PacketConn for c on the network ("udp4" or "udp6") of
+// s, replacing any previous one.
+func (s *SharedSocket) listen(c *Conn, network string) (nettype.PacketConn, error) {
+	pconn, conns := s.pconn4, s.conns4
+	if network == "udp6" {
+		pconn, conns = s.pconn6, s.conns6
+	}
+	if pconn == nil {
+		return nil, fmt.Errorf("shared socket has no %s socket", network)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, net.ErrClosed
+	}
+	sc := &sharedConn{
+		s:      s,
+		c:      c,
+		pconn:  pconn,
+		conns:  conns,
+		recv:   make(chan sharedPacket, sharedRecvQueueLen),
+		closed: make(chan struct{}),
+	}
+	conns[c] = sc
+	return sc, nil
+}
+
+// receive reads packets from pconn and passes them to the sharedConns in
+// conns they're for, until pconn is closed.
+func (s *SharedSocket) receive(pconn nettype.PacketConn, conns map[*Conn]*sharedConn) {
+	buf := make([]byte, sharedReadBufSize)
+	for {
+		n, src, err := pconn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logf("read: %v", err)
+			time.Sleep(100 * time.Millisecond) // don't spin on persistent errors
+			continue
+		}
+		b := buf[:n]
+		c, ok := s.route(b)
+		s.mu.Lock()
+		if ok {
+			if sc := conns[c]; sc != nil {
+				sc.deliver(b, src)
+			}
+		} else {
+			for _, sc := range conns {
+				sc.deliver(b, src)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// route returns the Conn that the inbound packet b is for. It reports
+// false if b can't be attributed to a single Conn.
+func (s *SharedSocket) route(b []byte) (_ *Conn, ok bool) {
+	t, isGeneveEncap := packetLooksLike(b)
+	if isGeneveEncap {
+		b = b[packet.GeneveFixedHeaderLength:]
+	}
+	switch t {
+	case packetLooksLikeSTUNBinding:
+		txID, _, err := stun.ParseResponse(b)
+		if err != nil {
+			return nil, false
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		r, ok := s.stunTxIDs[txID]
+		return r.c, ok
+	case packetLooksLikeDisco:
+		sender := key.DiscoPublicFromRaw32(mem.B(b[len(disco.Magic):discoHeaderLen]))
+		var found *Conn
+		for _, c := range s.currentConns() {
+			if c.knowsPeerDiscoKey(sender) {
+				if found != nil {
+					// Several of our Conns have the same peer. Only the
+					// recipient can open the message.
+					return nil, false
+				}
+				found = c
+			}
+		}
+		return found, found != nil
+	}
+
+	switch msgType, ok := wireGuardType(b); {
+	case !ok:
+		return nil, false
+	case msgType == wgTypeInitiation:
+		for _, c := range s.currentConns() {
+			if wgMAC1Matches(b, c.nodePublicKey()) {
+				return c, true
+			}
+		}
+		return nil, false
+	case msgType == wgTypeResponse:
+		return s.wgIndexOwner(binary.LittleEndian.Uint32(b[8:12]))
+	default:
+		return s.wgIndexOwner(binary.LittleEndian.Uint32(b[4:8]))
+	}
+}
+
+// currentConns returns the Conns sharing s.
+func (s *SharedSocket) currentConns() []*Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]*Conn, 0, len(s.conns4))
+	for c := range s.conns4 {
+		conns = append(conns, c)
+	}
+	for c := range s.conns6 {
+		if _, ok := s.conns4[c]; !ok {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+func (s *SharedSocket) wgIndexOwner(idx uint32) (_ *Conn, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.wgIndexes[idx]
+	return r.c, ok
+}
+
+// noteSent records that c sent b over the shared socket, so that replies
+// to it can be routed to c.
+//
+// WireGuard handshakes are recorded by noteHandshakesSent instead, as they
+// may be sent over DERP.
+func (s *SharedSocket) noteSent(c *Conn, b []byte) {
+	t, isGeneveEncap := packetLooksLike(b)
+	if isGeneveEncap {
+		b = b[packet.GeneveFixedHeaderLength:]
+	}
+	if t != packetLooksLikeSTUNBinding || len(b) < 20 || b[0] != 0x00 { // not a STUN request
+		return
+	}
+	var txID stun.TxID
+	copy(txID[:], b[8:20])
+	now := mono.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stunTxIDs[txID] = sharedRoute{c, now}
+	s.pruneLocked(now)
+}
+
+// noteHandshakesSent records the sender indexes of the WireGuard handshake
+// messages among the packets buffs[i][offset:] that c is sending to a
+// peer. The sender index of our handshake messages is the receiver index
+// of the replies, and of the session's transport messages, which must be
+// routed to c whether the handshake went over DERP or UDP.
+func (s *SharedSocket) noteHandshakesSent(c *Conn, buffs [][]byte, offset int) {
+	for _, b := range buffs {
+		b = b[offset:]
+		if msgType, ok := wireGuardType(b); !ok || (msgType != wgTypeInitiation && msgType != wgTypeResponse) {
+			continue
+		}
+		now := mono.Now()
+		s.mu.Lock()
+		s.wgIndexes[binary.LittleEndian.Uint32(b[4:8])] = sharedRoute{c, now}
+		s.pruneLocked(now)
+		s.mu.Unlock()
+	}
+}
+
+// pruneLocked forgets the routes older than sharedRouteLifetime, at most
+// once a minute.
+func (s *SharedSocket) pruneLocked(now mono.Time) {
+	if now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+	s.lastPrune = now
+	for idx, r := range s.wgIndexes {
+		if now.Sub(r.sent) > sharedRouteLifetime {
+			delete(s.wgIndexes, idx)
+		}
+	}
+	for txID, r := range s.stunTxIDs {
+		if now.Sub(r.sent) > sharedRouteLifetime {
+			delete(s.stunTxIDs, txID)
+		}
+	}
+}
+
+// wireGuardType returns the type of the WireGuard message b. It reports
+// false if b isn't a well-formed WireGuard message.
+func wireGuardType(b []byte) (msgType uint32, ok bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	msgType = binary.LittleEndian.Uint32(b)
+	switch msgType {
+	case wgTypeInitiation:
+		return msgType, len(b) == wgInitiationLen
+	case wgTypeResponse:
+		return msgType, len(b) == wgResponseLen
+	case wgTypeCookieReply:
+		return msgType, len(b) == wgCookieReplyLen
+	case wgTypeTransport:
+		return msgType, len(b) >= wgTransportMin
+	}
+	return 0, false
+}
+
+// wgMAC1Matches reports whether the WireGuard handshake initiation msg has
+// a valid MAC1 for the recipient with node key pub.
+func wgMAC1Matches(msg []byte, pub key.NodePublic) bool {
+	if pub.IsZero() {
+		return false
+	}
+	raw := pub.Raw32()
+	h, _ := blake2s.New256(nil)
+	h.Write([]byte("mac1----"))
+	h.Write(raw[:])
+	mac, _ := blake2s.New128(h.Sum(nil))
+	mac.Write(msg[:wgMAC1Offset])
+	return bytes.Equal(mac.Sum(nil), msg[wgMAC1Offset:wgMAC1Offset+blake2s.Size128])
+}
+
+// knowsPeerDiscoKey reports whether dk is the disco key of one of c's peers.
+func (c *Conn) knowsPeerDiscoKey(dk key.DiscoPublic) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peerMap.knownPeerDiscoKey(dk)
+}
+
+// nodePublicKey returns c's node key, which is zero until it's set.
+func (c *Conn) nodePublicKey() key.NodePublic {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.privateKey.IsZero() {
+		return key.NodePublic{}
+	}
+	return c.privateKey.Public()
+}
+
+// sharedPacket is a packet received on a SharedSocket.
+type sharedPacket struct {
+	b   []byte
+	src netip.AddrPort
+}
+
+// sharedConn is the PacketConn of a Conn for one network of a
+// SharedSocket.
+type sharedConn struct {
+	s     *SharedSocket
+	c     *Conn
+	pconn nettype.PacketConn    // s.pconn4 or s.pconn6
+	conns map[*Conn]*sharedConn // s.conns4 or s.conns6; guarded by s.mu
+
+	recv      chan sharedPacket
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// deliver queues a copy of b from src to be read, dropping it if the queue
+// is full.
+//
+// c.s.mu must be held.
+func (c *sharedConn) deliver(b []byte, src netip.AddrPort) {
+	select {
+	case c.recv <- sharedPacket{bytes.Clone(b), src}:
+	default:
+	}
+}
+
+func (c *sharedConn) ReadFromUDPAddrPort(p []byte) (n int, addr netip.AddrPort, err error) {
+	select {
+	case pkt := <-c.recv:
+		return copy(p, pkt.b), pkt.src, nil
+	case <-c.closed:
+		return 0, netip.AddrPort{}, net.ErrClosed
+	}
+}
+
+func (c *sharedConn) WriteToUDPAddrPort(p []byte, addr netip.AddrPort) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	c.s.noteSent(c.c, p)
+	return c.pconn.WriteToUDPAddrPort(p, addr)
+}
+
+func (c *sharedConn) LocalAddr() net.Addr {
+	return c.pconn.LocalAddr()
+}
+
+func (c *sharedConn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		err = nil
+		close(c.closed)
+		c.s.mu.Lock()
+		defer c.s.mu.Unlock()
+		if c.conns[c.c] == c {
+			delete(c.conns, c.c)
+		}
+	})
+	return err
+}
+
+func (c *sharedConn) SetDeadline(t time.Time) error         { return errors.New("unimplemented") }
+func (c *sharedConn) SetReadDeadline(t time.Time) error     { return errors.New("unimplemented") }
+func (c *sharedConn) SetWriteDeadline(t time.Time) error    { return errors.New("unimplemented") }
+func (c *sharedConn) SyscallConn() (syscall.RawConn, error) { return nil, errUnsupportedConnType }
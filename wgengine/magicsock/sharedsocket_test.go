@@ -0,0 +1,226 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"sync"
+	"testing"
+
+	"github.com/tailscale/wireguard-go/device"
+	"tailscale.com/disco"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/portmapper/portmappertype"
+	"tailscale.com/net/stun"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/util/eventbus/eventbustest"
+	"tailscale.com/util/set"
+	"tailscale.com/util/usermetric"
+)
+
+func newTestSharedSocket() *SharedSocket {
+	return &SharedSocket{
+		conns4:    make(map[*Conn]*sharedConn),
+		conns6:    make(map[*Conn]*sharedConn),
+		wgIndexes: make(map[uint32]sharedRoute),
+		stunTxIDs: make(map[stun.TxID]sharedRoute),
+	}
+}
+
+// wgMsg returns a WireGuard message of the given type and size, with idx
+// at idxOffset.
+func wgMsg(msgType uint32, size int, idxOffset int, idx uint32) []byte {
+	b := make([]byte, size)
+	binary.LittleEndian.PutUint32(b, msgType)
+	binary.LittleEndian.PutUint32(b[idxOffset:], idx)
+	return b
+}
+
+func TestSharedSocketRoute(t *testing.T) {
+	s := newTestSharedSocket()
+	newConn := func() *Conn {
+		c := &Conn{privateKey: key.NewNode(), peerMap: newPeerMap()}
+		s.conns4[c] = &sharedConn{}
+		return c
+	}
+	c1, c2 := newConn(), newConn()
+
+	checkRoute := func(name string, b []byte, want *Conn) {
+		t.Helper()
+		got, ok := s.route(b)
+		if want == nil {
+			if ok {
+				t.Errorf("%s: routed to %p; want all", name, got)
+			}
+			return
+		}
+		if !ok || got != want {
+			t.Errorf("%s: routed to %p, %v; want %p", name, got, ok, want)
+		}
+	}
+
+	// STUN responses go to the Conn that sent the request.
+	txID := stun.NewTxID()
+	s.noteSent(c2, stun.Request(txID))
+	checkRoute("stun", stun.Response(txID, netip.MustParseAddrPort("192.0.2.1:41641")), c2)
+	checkRoute("stun-unknown", stun.Response(stun.NewTxID(), netip.MustParseAddrPort("192.0.2.1:41641")), nil)
+
+	// Handshake initiations go to the Conn whose node key they're MACed
+	// with.
+	init := wgMsg(wgTypeInitiation, wgInitiationLen, 4, 1234)
+	var cg device.CookieGenerator
+	cg.Init(device.NoisePublicKey(c2.nodePublicKey().Raw32()))
+	cg.AddMacs(init)
+	checkRoute("initiation", init, c2)
+	checkRoute("initiation-unknown", wgMsg(wgTypeInitiation, wgInitiationLen, 4, 1234), nil)
+
+	// Replies to handshake messages, and the transport messages of the
+	// session, go to the Conn that chose their receiver index.
+	s.noteHandshakesSent(c1, [][]byte{init}, 0)
+	checkRoute("response", wgMsg(wgTypeResponse, wgResponseLen, 8, 1234), c1)
+	checkRoute("cookie-reply", wgMsg(wgTypeCookieReply, wgCookieReplyLen, 4, 1234), c1)
+	checkRoute("transport", wgMsg(wgTypeTransport, 64, 4, 1234), c1)
+	checkRoute("transport-unknown", wgMsg(wgTypeTransport, 64, 4, 5678), nil)
+
+	// Disco messages go to the Conn that has their sender as a peer, unless
+	// several do.
+	peerDisco := key.NewDisco().Public()
+	discoMsg := append([]byte(disco.Magic), peerDisco.AppendTo(nil)...)
+	discoMsg = append(discoMsg, make([]byte, 32)...)
+	checkRoute("disco-unknown", discoMsg, nil)
+	c1.peerMap.nodesOfDisco[peerDisco] = set.Of(key.NewNode().Public())
+	checkRoute("disco", discoMsg, c1)
+	c2.peerMap.nodesOfDisco[peerDisco] = set.Of(key.NewNode().Public())
+	checkRoute("disco-ambiguous", discoMsg, nil)
+}
+
+// TestSharedSocketHandshakeOverDERP tests that the transport messages of a
+// session whose handshake was sent over DERP are routed to the Conn that
+// sent it when they arrive over UDP.
+func TestSharedSocketHandshakeOverDERP(t *testing.T) {
+	s := newTestSharedSocket()
+	c1, c2 := newConn(t.Logf), newConn(t.Logf)
+	for _, c := range []*Conn{c1, c2} {
+		c.privateKey = key.NewNode()
+		c.sharedSocket = s
+		s.conns4[c] = &sharedConn{}
+	}
+	de := &endpoint{
+		c:         c1,
+		publicKey: key.NewNode().Public(),
+		derpAddr:  netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, 1),
+	}
+
+	const idx = 4321
+	const offset = 16
+	init := append(make([]byte, offset), wgMsg(wgTypeInitiation, wgInitiationLen, 4, idx)...)
+	if err := de.send([][]byte{init}, offset); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s.route(wgMsg(wgTypeTransport, 64, 4, idx)); !ok || got != c1 {
+		t.Errorf("transport routed to %p, %v; want %p", got, ok, c1)
+	}
+}
+
+// countingPortMapper is a portmappertype.Client that counts the calls that
+// change its state.
+type countingPortMapper struct {
+	mu                sync.Mutex
+	networkDown       int
+	setLocalPort      int
+	setStaticMappings int
+	closed            int
+}
+
+func (pm *countingPortMapper) count(n *int) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	*n++
+}
+
+func (pm *countingPortMapper) Probe(context.Context) (portmappertype.ProbeResult, error) {
+	return portmappertype.ProbeResult{}, nil
+}
+func (pm *countingPortMapper) HaveMapping() bool { return false }
+func (pm *countingPortMapper) SetGatewayLookupFunc(func() (gw, myIP netip.Addr, ok bool)) {
+}
+func (pm *countingPortMapper) NoteNetworkDown() { pm.count(&pm.networkDown) }
+func (pm *countingPortMapper) GetCachedMappingOrStartCreatingOne() (netip.AddrPort, bool) {
+	return netip.AddrPort{}, false
+}
+func (pm *countingPortMapper) SetLocalPort(uint16) { pm.count(&pm.setLocalPort) }
+func (pm *countingPortMapper) SetStaticMappings([]portmappertype.StaticMapping) {
+	pm.count(&pm.setStaticMappings)
+}
+func (pm *countingPortMapper) StaticMappingStatus() []portmappertype.StaticMappingStatus {
+	return nil
+}
+func (pm *countingPortMapper) Close() error {
+	pm.count(&pm.closed)
+	return nil
+}
+
+// TestSharedSocketPortMapper tests that only the SharedSocket drives its
+// portmapper, not the Conns sharing it.
+func TestSharedSocketPortMapper(t *testing.T) {
+	bus := eventbustest.NewBus(t)
+	netMon, err := netmon.New(bus, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netMon.Close()
+
+	s, err := NewSharedSocket(t.Logf, bus, netMon, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.portMapper != nil {
+		s.portMapper.Close()
+	}
+	pm := new(countingPortMapper)
+	s.portMapper = pm
+
+	newConn := func() *Conn {
+		c, err := NewConn(Options{
+			EndpointsFunc: func(eps []tailcfg.Endpoint) {},
+			Logf:          t.Logf,
+			NetMon:        netMon,
+			EventBus:      bus,
+			Metrics:       new(usermetric.Registry),
+			SharedSocket:  s,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	c1, c2 := newConn(), newConn()
+
+	c1.SetStaticPortMappings([]portmappertype.StaticMapping{{Proto: "tcp", ExternalPort: 80, InternalPort: 8080}})
+	if got := c1.StaticPortMappings(); got != nil {
+		t.Errorf("StaticPortMappings = %v; want nil", got)
+	}
+	c1.RebindAll("test")
+	c2.SetNetworkUp(false)
+	c2.Rebind()
+	c1.Close()
+	c2.Close()
+	pm.mu.Lock()
+	if pm.networkDown != 0 || pm.setLocalPort != 0 || pm.setStaticMappings != 0 || pm.closed != 0 {
+		t.Errorf("Conns drove the shared portmapper: %d NoteNetworkDown, %d SetLocalPort, %d SetStaticMappings, %d Close",
+			pm.networkDown, pm.setLocalPort, pm.setStaticMappings, pm.closed)
+	}
+	pm.mu.Unlock()
+
+	s.onLinkChange(&netmon.ChangeDelta{New: &netmon.State{}})
+	pm.mu.Lock()
+	if pm.networkDown != 1 {
+		t.Errorf("network down: %d NoteNetworkDown calls; want 1", pm.networkDown)
+	}
+	pm.mu.Unlock()
+}
@@ -1087,6 +1087,10 @@ func (ns *Impl) shouldProcessInbound(p *packet.Parsed, t *tstun.Wrapper) bool {
 			return true
 		}
 	}
+	// Handle UDP flows to the Tailscale IP(s) that are served in-process.
+	if ns.lb != nil && p.IPProto == ipproto.UDP && isLocal && ns.lb.ShouldInterceptUDPPort(p.Dst.Port()) {
+		return true
+	}
 	if buildfeatures.HasServe && isService {
 		if p.IsEchoRequest() {
 			return true
@@ -1653,6 +1657,13 @@ func (ns *Impl) acceptUDP(r *udp.ForwarderRequest) {
 		}
 	}
 
+	if ns.lb != nil {
+		if h := ns.lb.UDPHandlerForDst(srcAddr, dstAddr); h != nil {
+			go h(gonet.NewUDPConn(&wq, ep))
+			return
+		}
+	}
+
 	if get := ns.GetUDPHandlerForFlow; get != nil {
 		h, intercept := get(srcAddr, dstAddr)
 		if intercept {
@@ -220,6 +220,11 @@ type Config struct {
 	// If zero, a port is automatically selected.
 	ListenPort uint16
 
+	// SharedSocket, if non-nil, is the UDP socket to share with other
+	// engines in this process, instead of binding one. ListenPort is then
+	// ignored.
+	SharedSocket *magicsock.SharedSocket
+
 	// RespondToPing determines whether this engine should internally
 	// reply to ICMP pings, without involving the OS.
 	// Used in "fake" mode for development.
@@ -409,6 +414,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		Metrics:        conf.Metrics,
 		ControlKnobs:   conf.ControlKnobs,
 		PeerByKeyFunc:  e.PeerByKey,
+		SharedSocket:   conf.SharedSocket,
 	}
 	if buildfeatures.HasLazyWG {
 		magicsockOpts.NoteRecvActivity = e.noteRecvActivity